@@ -0,0 +1,890 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/ctessum/atmos/seinfeld"
+	"github.com/ctessum/atmos/wesely1989"
+	"github.com/ctessum/cdf"
+	"github.com/ctessum/sparse"
+)
+
+const (
+	// camxPBLKv is the vertical diffusivity [m2/s] below which a layer
+	// interface is considered to be above the planetary boundary layer.
+	camxPBLKv = 1.0
+
+	// camxCloudWater is the cloud water content [g/m3] above which
+	// a grid cell is considered to be filled with cloud.
+	camxCloudWater = 0.05
+
+	// camxMinWind is the minimum wind speed [m/s] used when estimating
+	// surface fluxes, to avoid singularities in calm conditions.
+	camxMinWind = 0.5
+
+	mwH2O = 18.015      // g/mol, molar mass of water
+	cp    = 1006.       // J/kg/K, specific heat of air
+	σSB   = 5.670374e-8 // W m-2 K-4, Stefan–Boltzmann constant
+)
+
+// CAMx is an InMAP preprocessor for CAMx (version 7 or later) average
+// concentration output and meteorological inputs, all in IOAPI-compatible
+// NetCDF format. Chemical species names are those used by the CB6 gas-phase
+// mechanism and the CF aerosol scheme with SOAP secondary organic aerosol.
+//
+// CAMx meteorological inputs do not include boundary layer height,
+// friction velocity, surface heat flux, roughness length, or vertical
+// wind speed, so these are diagnosed from the available fields: boundary
+// layer height from the vertical diffusivity profile, roughness length from
+// land use, friction velocity and heat flux from neutral surface layer
+// similarity, and vertical wind speed from the divergence of the
+// horizontal winds.
+type CAMx struct {
+	aVOC, bVOC, aSOA, bSOA, nox, pNO, sox, pS, nh3, pNH, totalPM25 map[string]float64
+
+	start, end time.Time
+
+	recordDelta time.Duration
+
+	nx, ny, nz int
+
+	// dx and dy are the grid cell edge lengths [m].
+	dx, dy float64
+
+	// landUse is the dominant land use category in each grid cell as
+	// specified in github.com/ctessum/atmos/wesely1989, and z0
+	// is the roughness length [m].
+	landUse, z0 *sparse.DenseArray
+
+	met3D, met2D, kv, avrg string
+
+	msgChan chan string
+
+	*ncfReader
+}
+
+// NewCAMx initializes a CAMx preprocessor from the given
+// configuration information.
+//
+// met3D, met2D, and kv are the locations of the CAMx 3-dimensional
+// meteorology, 2-dimensional surface meteorology, and vertical diffusivity
+// input files, respectively, and avrg is the location of the CAMx average
+// concentration output files.
+// [DATE] should be used as a wild card for the simulation date in each of
+// these, and each file is expected to contain the records for the
+// day in its file name; additional records are ignored.
+// The average concentration files must contain all of the model layers.
+//
+// The 3-dimensional meteorology files must contain the variables ZGRID_M,
+// PRESS_MB, TEMP_K, HUMID_PPM, UWIND_MpS, VWIND_MpS (on the staggered
+// Arakawa C grid), CLWC_GpM3, and PRWC_GpM3. The surface files must contain
+// TSURF_K, SWSFC_WpM2, and either LUCAT11 or LUCAT26 land use
+// fractions, and the vertical diffusivity files must contain KV_M2pS.
+//
+// startDate and endDate are the dates of the beginning and end of the
+// simulation, respectively, in the format "YYYYMMDD".
+// If msgChan is not nil, status messages will be sent to it.
+func NewCAMx(met3D, met2D, kv, avrg, startDate, endDate string, msgChan chan string) (*CAMx, error) {
+	c := CAMx{
+		// These maps contain the CAMx variables that make
+		// up the chemical species groups, as well as the
+		// multiplication factors required to convert gas
+		// concentrations to mass fractions [μg/kg dry air]
+		// and particle concentrations to [μg/m3].
+		// Species that are not in the concentration files are ignored.
+
+		// CB6 SOA precursors and SOAP condensable gases.
+		aVOC: map[string]float64{
+			"BENZ": ppmvToUgKg(78.11), "TOL": ppmvToUgKg(92.14), "XYL": ppmvToUgKg(106.16),
+			"CG1": ppmvToUgKg(150), "CG2": ppmvToUgKg(150),
+		},
+		bVOC: map[string]float64{
+			"ISOP": ppmvToUgKg(68.12), "TERP": ppmvToUgKg(136.23), "SQT": ppmvToUgKg(204.35),
+			"CG3": ppmvToUgKg(180), "CG4": ppmvToUgKg(180),
+		},
+		// SOAP anthropogenic SOA species [μg/m3].
+		aSOA: map[string]float64{"SOA1": 1, "SOA2": 1, "SOPA": 1},
+		// SOAP biogenic SOA species [μg/m3].
+		bSOA: map[string]float64{"SOA3": 1, "SOA4": 1, "SOPB": 1},
+		// NOx species. We are only interested in the mass
+		// of Nitrogen, rather than the mass of the whole molecule, so
+		// we use the molecular weight of Nitrogen.
+		nox: map[string]float64{"NO": ppmvToUgKg(mwN), "NO2": ppmvToUgKg(mwN)},
+		// pNO is the Nitrogen fraction of particulate nitrate [μg/m3].
+		pNO: map[string]float64{"PNO3": mwN / mwNO3},
+		// SOx species. We are only interested in the mass
+		// of Sulfur, rather than the mass of the whole molecule, so
+		// we use the molecular weight of Sulfur.
+		sox: map[string]float64{"SO2": ppmvToUgKg(mwS), "SULF": ppmvToUgKg(mwS)},
+		// pS is the Sulfur fraction of particulate sulfate [μg/m3].
+		pS: map[string]float64{"PSO4": mwS / mwSO4},
+		// NH3 is ammonia. We are only interested in the mass
+		// of Nitrogen, rather than the mass of the whole molecule, so
+		// we use the molecular weight of Nitrogen.
+		nh3: map[string]float64{"NH3": ppmvToUgKg(mwN)},
+		// pNH is the Nitrogen fraction of particulate ammonium [μg/m3].
+		pNH: map[string]float64{"PNH4": mwN / mwNH4},
+		// totalPM25 is the sum of the fine dry aerosol species [μg/m3].
+		totalPM25: map[string]float64{
+			"PSO4": 1, "PNO3": 1, "PNH4": 1, "POA": 1, "PEC": 1,
+			"SOA1": 1, "SOA2": 1, "SOA3": 1, "SOA4": 1, "SOPA": 1, "SOPB": 1,
+			"FPRM": 1, "FCRS": 1, "NA": 1, "PCL": 1,
+		},
+
+		met3D:     met3D,
+		met2D:     met2D,
+		kv:        kv,
+		avrg:      avrg,
+		msgChan:   msgChan,
+		ncfReader: newNCFReader(),
+	}
+
+	var err error
+	c.start, err = time.Parse(inDateFormat, startDate)
+	if err != nil {
+		return nil, fmt.Errorf("inmap: CAMx preprocessor start time: %v", err)
+	}
+	c.end, err = time.Parse(inDateFormat, endDate)
+	if err != nil {
+		return nil, fmt.Errorf("inmap: CAMx preprocessor end time: %v", err)
+	}
+	if !c.end.After(c.start) {
+		return nil, fmt.Errorf("inmap: CAMx preprocessor end time %v is not after start time %v", c.end, c.start)
+	}
+
+	if err := c.checkFiles(); err != nil {
+		return nil, fmt.Errorf("inmap: CAMx preprocessor: %v", err)
+	}
+	return &c, nil
+}
+
+// checkFiles checks that the meteorology and concentration files
+// for the first day of the simulation are on the same grid and
+// contain the required variables. It also sets the grid dimensions,
+// the record interval, and the land use, and removes
+// species that are not in the concentration file from the
+// chemical species groups.
+func (c *CAMx) checkFiles() error {
+	f, met, err := c.ncfFromTemplate(c.met3D, ioapiFormat, c.start)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	f2, sfc, err := c.ncfFromTemplate(c.met2D, ioapiFormat, c.start)
+	if err != nil {
+		return err
+	}
+	defer f2.Close()
+	f3, kv, err := c.ncfFromTemplate(c.kv, ioapiFormat, c.start)
+	if err != nil {
+		return err
+	}
+	defer f3.Close()
+	f4, avrg, err := c.ncfFromTemplate(c.avrg, ioapiFormat, c.start)
+	if err != nil {
+		return err
+	}
+	defer f4.Close()
+
+	c.nx, err = ioapiInt(met, "NCOLS")
+	if err != nil {
+		return err
+	}
+	c.ny, err = ioapiInt(met, "NROWS")
+	if err != nil {
+		return err
+	}
+	c.nz, err = ioapiInt(met, "NLAYS")
+	if err != nil {
+		return err
+	}
+	if gdtyp, err := ioapiInt(met, "GDTYP"); err != nil {
+		return err
+	} else if gdtyp == 1 {
+		return fmt.Errorf("longitude-latitude grids are not supported")
+	}
+	c.dx, err = ioapiFloat(met, "XCELL")
+	if err != nil {
+		return err
+	}
+	c.dy, err = ioapiFloat(met, "YCELL")
+	if err != nil {
+		return err
+	}
+	gridAttrs := []string{"NCOLS", "NROWS", "XORIG", "YORIG", "XCELL", "YCELL"}
+	if err := ioapiSameGrid(met, sfc, gridAttrs...); err != nil {
+		return fmt.Errorf("3-D and surface meteorology files: %v", err)
+	}
+	gridAttrs = append(gridAttrs, "NLAYS")
+	if err := ioapiSameGrid(met, kv, gridAttrs...); err != nil {
+		return fmt.Errorf("3-D meteorology and vertical diffusivity files: %v", err)
+	}
+	if err := ioapiSameGrid(met, avrg, gridAttrs...); err != nil {
+		return fmt.Errorf("meteorology and concentration files: %v", err)
+	}
+
+	for _, v := range []string{"ZGRID_M", "PRESS_MB", "TEMP_K", "HUMID_PPM", "UWIND_MpS", "VWIND_MpS", "CLWC_GpM3", "PRWC_GpM3"} {
+		if met.Header.Lengths(v) == nil {
+			return fmt.Errorf("variable %s is not in 3-D meteorology file", v)
+		}
+	}
+	for _, v := range []string{"TSURF_K", "SWSFC_WpM2"} {
+		if sfc.Header.Lengths(v) == nil {
+			return fmt.Errorf("variable %s is not in surface meteorology file", v)
+		}
+	}
+	if kv.Header.Lengths("KV_M2pS") == nil {
+		return fmt.Errorf("variable KV_M2pS is not in vertical diffusivity file")
+	}
+	if err := c.readLandUse(sfc); err != nil {
+		return err
+	}
+
+	tstep, err := ioapiInt(avrg, "TSTEP")
+	if err != nil {
+		return err
+	}
+	c.recordDelta = ioapiDuration(tstep)
+	if c.recordDelta <= 0 {
+		return fmt.Errorf("invalid concentration file time step %d", tstep)
+	}
+
+	for _, g := range []struct {
+		name  string
+		group map[string]float64
+	}{
+		{"aVOC", c.aVOC}, {"bVOC", c.bVOC}, {"aSOA", c.aSOA}, {"bSOA", c.bSOA},
+		{"NOx", c.nox}, {"pNO", c.pNO}, {"SOx", c.sox}, {"pS", c.pS},
+		{"NH3", c.nh3}, {"pNH", c.pNH}, {"PM2.5", c.totalPM25},
+	} {
+		for v := range g.group {
+			if avrg.Header.Lengths(v) == nil {
+				delete(g.group, v)
+			}
+		}
+		if len(g.group) == 0 {
+			return fmt.Errorf("concentration file does not contain any %s species", g.name)
+		}
+	}
+	for _, v := range []string{"OH", "H2O2"} {
+		if avrg.Header.Lengths(v) == nil {
+			return fmt.Errorf("variable %s is not in concentration file", v)
+		}
+	}
+	return nil
+}
+
+// ioapiFloat returns the value of the given floating point IOAPI global attribute.
+func ioapiFloat(f *cdf.File, name string) (float64, error) {
+	v, ok := f.Header.GetAttribute("", name).([]float64)
+	if !ok || len(v) != 1 {
+		return 0, fmt.Errorf("missing or invalid IOAPI attribute %s", name)
+	}
+	return v[0], nil
+}
+
+// readLandUse reads the land use fractions from the first record of the
+// given surface meteorology file and calculates the dominant
+// land use category and the roughness length in each grid cell.
+// The roughness length is the fraction-weighted geometric mean of the
+// roughness lengths of the land use categories.
+func (c *CAMx) readLandUse(sfc *cdf.File) error {
+	var luVar string
+	var toWesely []wesely1989.LandUseCategory
+	switch {
+	case sfc.Header.Lengths("LUCAT11") != nil:
+		luVar, toWesely = "LUCAT11", camx11Wesely
+	case sfc.Header.Lengths("LUCAT26") != nil:
+		luVar, toWesely = "LUCAT26", camx26Wesely
+	default:
+		return fmt.Errorf("surface meteorology file must contain LUCAT11 or LUCAT26")
+	}
+	frac, err := readNCF(luVar, sfc, 0)
+	if err != nil {
+		return err
+	}
+	if frac.Shape[0] != len(toWesely) {
+		return fmt.Errorf("%s has %d categories; it should have %d", luVar, frac.Shape[0], len(toWesely))
+	}
+	c.landUse = sparse.ZerosDense(c.ny, c.nx)
+	c.z0 = sparse.ZerosDense(c.ny, c.nx)
+	for j := 0; j < c.ny; j++ {
+		for i := 0; i < c.nx; i++ {
+			weselyFrac := make([]float64, len(camxWeselyZ0))
+			var lnZ0, total float64
+			for l, w := range toWesely {
+				f := frac.Get(l, j, i)
+				weselyFrac[w] += f
+				lnZ0 += f * math.Log(camxWeselyZ0[w])
+				total += f
+			}
+			if total <= 0 {
+				return fmt.Errorf("%s fractions sum to %g at row %d, column %d", luVar, total, j, i)
+			}
+			var dominant int
+			for w, f := range weselyFrac {
+				if f > weselyFrac[dominant] {
+					dominant = w
+				}
+			}
+			c.landUse.Set(float64(dominant), j, i)
+			c.z0.Set(math.Exp(lnZ0/total), j, i)
+		}
+	}
+	return nil
+}
+
+// camx11Wesely provides a mapping between the CAMx 11-category land
+// use classification and the land use categories as
+// specified in github.com/ctessum/atmos/wesely1989,
+// which are nearly the same.
+var camx11Wesely = []wesely1989.LandUseCategory{
+	wesely1989.Urban,        // 1 Urban
+	wesely1989.Agricultural, // 2 Agricultural
+	wesely1989.Range,        // 3 Rangeland
+	wesely1989.Deciduous,    // 4 Deciduous forest
+	wesely1989.Coniferous,   // 5 Coniferous forest, including wetland
+	wesely1989.MixedForest,  // 6 Mixed forest
+	wesely1989.Water,        // 7 Water
+	wesely1989.Barren,       // 8 Barren land
+	wesely1989.Wetland,      // 9 Non-forested wetlands
+	wesely1989.RangeAg,      // 10 Mixed agricultural and range
+	wesely1989.RockyShrubs,  // 11 Rocky, with low shrubs
+}
+
+// camx26Wesely provides a mapping between the CAMx 26-category land
+// use classification (from Zhang et al., 2003) and the land use categories as
+// specified in github.com/ctessum/atmos/wesely1989.
+var camx26Wesely = []wesely1989.LandUseCategory{
+	wesely1989.Water,        // 1 Water
+	wesely1989.Barren,       // 2 Ice
+	wesely1989.Water,        // 3 Inland lake
+	wesely1989.Coniferous,   // 4 Evergreen needleleaf trees
+	wesely1989.Deciduous,    // 5 Evergreen broadleaf trees
+	wesely1989.Coniferous,   // 6 Deciduous needleleaf trees
+	wesely1989.Deciduous,    // 7 Deciduous broadleaf trees
+	wesely1989.Deciduous,    // 8 Tropical broadleaf trees
+	wesely1989.Deciduous,    // 9 Drought deciduous trees
+	wesely1989.RockyShrubs,  // 10 Evergreen broadleaf shrubs
+	wesely1989.RockyShrubs,  // 11 Deciduous shrubs
+	wesely1989.RockyShrubs,  // 12 Thorn shrubs
+	wesely1989.Range,        // 13 Short grass and forbs
+	wesely1989.Range,        // 14 Long grass
+	wesely1989.Agricultural, // 15 Crops
+	wesely1989.Agricultural, // 16 Rice
+	wesely1989.Agricultural, // 17 Sugar
+	wesely1989.Agricultural, // 18 Maize
+	wesely1989.Agricultural, // 19 Cotton
+	wesely1989.Agricultural, // 20 Irrigated crops
+	wesely1989.Urban,        // 21 Urban
+	wesely1989.RockyShrubs,  // 22 Tundra
+	wesely1989.Wetland,      // 23 Swamp
+	wesely1989.Barren,       // 24 Desert
+	wesely1989.MixedForest,  // 25 Mixed wood forests
+	wesely1989.MixedForest,  // 26 Transitional forest
+}
+
+// camxWeselyZ0 holds typical summertime roughness lengths [m] for the
+// land use categories specified in github.com/ctessum/atmos/wesely1989.
+var camxWeselyZ0 = []float64{
+	1.0,    // Urban
+	0.15,   // Agricultural
+	0.1,    // Range
+	1.0,    // Deciduous
+	1.0,    // Coniferous
+	1.0,    // MixedForest
+	0.0001, // Water
+	0.002,  // Barren
+	0.15,   // Wetland
+	0.1,    // RangeAg
+	0.1,    // RockyShrubs
+}
+
+// weselySeinfeld provides a mapping between the land use categories
+// specified in github.com/ctessum/atmos/wesely1989 and those
+// specified in github.com/ctessum/atmos/seinfeld.
+var weselySeinfeld = []seinfeld.LandUseCategory{
+	seinfeld.Desert,    // Urban
+	seinfeld.Grass,     // Agricultural
+	seinfeld.Grass,     // Range
+	seinfeld.Deciduous, // Deciduous
+	seinfeld.Evergreen, // Coniferous
+	seinfeld.Deciduous, // MixedForest
+	seinfeld.Desert,    // Water
+	seinfeld.Desert,    // Barren
+	seinfeld.Grass,     // Wetland
+	seinfeld.Grass,     // RangeAg
+	seinfeld.Shrubs,    // RockyShrubs
+}
+
+func (c *CAMx) read(template, varName string) NextData {
+	return c.nextDataIOAPI(template, varName, c.start, c.end, c.recordDelta, c.msgChan)
+}
+
+func (c *CAMx) read2D(varName string) NextData {
+	return ioapi2D(c.read(c.met2D, varName))
+}
+
+func (c *CAMx) readGroup(varGroup map[string]float64) NextData {
+	dataFuncs := make(map[string]NextData)
+	for v := range varGroup {
+		dataFuncs[v] = c.read(c.avrg, v)
+	}
+	return nextDataGroup(dataFuncs, varGroup)
+}
+
+// readGroupAlt reads a group of gas-phase species and converts them
+// from mass fraction to concentration.
+func (c *CAMx) readGroupAlt(varGroup map[string]float64) NextData {
+	return nextDataDivideAlt(c.readGroup(varGroup), c.ALT())
+}
+
+// camxScale multiplies the data returned by f by factor.
+func camxScale(f NextData, factor float64) NextData {
+	return func() (*sparse.DenseArray, error) {
+		data, err := f()
+		if err != nil {
+			return nil, err
+		}
+		o := data.Copy()
+		o.Scale(factor)
+		return o, nil
+	}
+}
+
+// Nx helps fulfill the Preprocessor interface by returning
+// the number of grid cells in the West-East direction.
+func (c *CAMx) Nx() (int, error) { return c.nx, nil }
+
+// Ny helps fulfill the Preprocessor interface by returning
+// the number of grid cells in the South-North direction.
+func (c *CAMx) Ny() (int, error) { return c.ny, nil }
+
+// Nz helps fulfill the Preprocessor interface by returning
+// the number of grid cells in the below-above direction.
+func (c *CAMx) Nz() (int, error) { return c.nz, nil }
+
+// PBLH helps fulfill the Preprocessor interface by returning
+// planetary boundary layer height [m]. The boundary layer
+// top is the lowest layer interface where the vertical diffusivity
+// is less than camxPBLKv.
+func (c *CAMx) PBLH() NextData {
+	kvFunc := c.read(c.kv, "KV_M2pS") // vertical diffusivity at layer tops [m2/s]
+	zFunc := c.read(c.met3D, "ZGRID_M")
+	return func() (*sparse.DenseArray, error) {
+		kv, err := kvFunc()
+		if err != nil {
+			return nil, err
+		}
+		z, err := zFunc()
+		if err != nil {
+			return nil, err
+		}
+		o := sparse.ZerosDense(z.Shape[1:]...)
+		for j := 0; j < z.Shape[1]; j++ {
+			for i := 0; i < z.Shape[2]; i++ {
+				k := 0
+				for ; k < z.Shape[0]-1; k++ {
+					if kv.Get(k, j, i) < camxPBLKv {
+						break
+					}
+				}
+				o.Set(z.Get(k, j, i), j, i)
+			}
+		}
+		return o, nil
+	}
+}
+
+// Height helps fulfill the Preprocessor interface by returning
+// layer heights above ground level. CAMx provides the heights
+// of the layer tops (ZGRID_M), so we add the ground surface as the bottom edge.
+func (c *CAMx) Height() NextData {
+	return addGroundLevel(c.read(c.met3D, "ZGRID_M"))
+}
+
+// ALT helps fulfill the Preprocessor interface by returning
+// inverse air density [m3/kg], calculated from the ideal gas law
+// using virtual temperature.
+func (c *CAMx) ALT() NextData {
+	TFunc := c.T()
+	PFunc := c.P()
+	qFunc := c.read(c.met3D, "HUMID_PPM") // water vapor [ppmv]
+	return func() (*sparse.DenseArray, error) {
+		T, err := TFunc()
+		if err != nil {
+			return nil, err
+		}
+		P, err := PFunc()
+		if err != nil {
+			return nil, err
+		}
+		q, err := qFunc()
+		if err != nil {
+			return nil, err
+		}
+		alt := sparse.ZerosDense(T.Shape...)
+		for i, t := range T.Elements {
+			w := q.Elements[i] * 1.e-6 * mwH2O / MWa // water vapor mixing ratio [kg/kg]
+			alt.Elements[i] = rr * t * (1 + 0.61*w) / P.Elements[i]
+		}
+		return alt, nil
+	}
+}
+
+// U helps fulfill the Preprocessor interface by returning
+// West-East wind speed [m/s].
+func (c *CAMx) U() NextData { return camxStagger(c.read(c.met3D, "UWIND_MpS"), 2) }
+
+// V helps fulfill the Preprocessor interface by returning
+// South-North wind speed [m/s].
+func (c *CAMx) V() NextData { return camxStagger(c.read(c.met3D, "VWIND_MpS"), 1) }
+
+// camxStagger converts CAMx winds, which are at the East or North faces of
+// the grid cells, to winds at all of the faces in dimension
+// staggerDim. Wind speeds at the West or South domain boundary are
+// not available, so they are assumed to be the same as at the opposite
+// face of the boundary cell.
+func camxStagger(f NextData, staggerDim int) NextData {
+	return func() (*sparse.DenseArray, error) {
+		in, err := f()
+		if err != nil {
+			return nil, err
+		}
+		shape := []int{in.Shape[0], in.Shape[1], in.Shape[2]}
+		shape[staggerDim]++
+		o := sparse.ZerosDense(shape...)
+		for k := 0; k < shape[0]; k++ {
+			for j := 0; j < shape[1]; j++ {
+				for i := 0; i < shape[2]; i++ {
+					jj, ii := j, i
+					if staggerDim == 2 && i > 0 {
+						ii--
+					} else if staggerDim == 1 && j > 0 {
+						jj--
+					}
+					o.Set(in.Get(k, jj, ii), k, j, i)
+				}
+			}
+		}
+		return o, nil
+	}
+}
+
+// W helps fulfill the Preprocessor interface by returning
+// below-above wind speed [m/s]. CAMx inputs do not include vertical
+// wind speeds, so they are calculated by integrating the horizontal wind
+// divergence upward from the ground, where the vertical wind speed is zero.
+func (c *CAMx) W() NextData {
+	uFunc := c.U()
+	vFunc := c.V()
+	hFunc := c.Height()
+	return func() (*sparse.DenseArray, error) {
+		u, err := uFunc()
+		if err != nil {
+			return nil, err
+		}
+		v, err := vFunc()
+		if err != nil {
+			return nil, err
+		}
+		h, err := hFunc()
+		if err != nil {
+			return nil, err
+		}
+		w := sparse.ZerosDense(h.Shape...)
+		for j := 0; j < h.Shape[1]; j++ {
+			for i := 0; i < h.Shape[2]; i++ {
+				for k := 0; k < h.Shape[0]-1; k++ {
+					div := (u.Get(k, j, i+1)-u.Get(k, j, i))/c.dx +
+						(v.Get(k, j+1, i)-v.Get(k, j, i))/c.dy
+					dz := h.Get(k+1, j, i) - h.Get(k, j, i)
+					w.Set(w.Get(k, j, i)-div*dz, k+1, j, i)
+				}
+			}
+		}
+		return w, nil
+	}
+}
+
+// AVOC helps fulfill the Preprocessor interface.
+func (c *CAMx) AVOC() NextData { return c.readGroupAlt(c.aVOC) }
+
+// BVOC helps fulfill the Preprocessor interface.
+func (c *CAMx) BVOC() NextData { return c.readGroupAlt(c.bVOC) }
+
+// NOx helps fulfill the Preprocessor interface.
+func (c *CAMx) NOx() NextData { return c.readGroupAlt(c.nox) }
+
+// SOx helps fulfill the Preprocessor interface.
+func (c *CAMx) SOx() NextData { return c.readGroupAlt(c.sox) }
+
+// NH3 helps fulfill the Preprocessor interface.
+func (c *CAMx) NH3() NextData { return c.readGroupAlt(c.nh3) }
+
+// ASOA helps fulfill the Preprocessor interface.
+func (c *CAMx) ASOA() NextData { return c.readGroup(c.aSOA) }
+
+// BSOA helps fulfill the Preprocessor interface.
+func (c *CAMx) BSOA() NextData { return c.readGroup(c.bSOA) }
+
+// PNO helps fulfill the Preprocessor interface.
+func (c *CAMx) PNO() NextData { return c.readGroup(c.pNO) }
+
+// PS helps fulfill the Preprocessor interface.
+func (c *CAMx) PS() NextData { return c.readGroup(c.pS) }
+
+// PNH helps fulfill the Preprocessor interface.
+func (c *CAMx) PNH() NextData { return c.readGroup(c.pNH) }
+
+// TotalPM25 helps fulfill the Preprocessor interface.
+func (c *CAMx) TotalPM25() NextData { return c.readGroup(c.totalPM25) }
+
+// surfaceLayer calculates the wind speed [m/s] at the center of the lowest
+// layer of grid cell (j, i), the height of the center of the
+// layer [m], and the neutral drag coefficient.
+func (c *CAMx) surfaceLayer(u, v, h *sparse.DenseArray, j, i int) (ws, z1, cd float64) {
+	uc := (u.Get(0, j, i) + u.Get(0, j, i+1)) / 2
+	vc := (v.Get(0, j, i) + v.Get(0, j+1, i)) / 2
+	ws = math.Max(math.Sqrt(uc*uc+vc*vc), camxMinWind)
+	z1 = h.Get(1, j, i) / 2
+	z0 := c.z0.Get(j, i)
+	cd = math.Pow(κ/math.Log((z1+z0)/z0), 2)
+	return
+}
+
+// SurfaceHeatFlux helps fulfill the Preprocessor interface
+// by returning heat flux at the surface [W/m2]. It is estimated
+// using a bulk transfer relation with the neutral drag coefficient
+// and the difference between the surface temperature and the
+// potential temperature of the lowest layer.
+func (c *CAMx) SurfaceHeatFlux() NextData {
+	uFunc := c.U()
+	vFunc := c.V()
+	hFunc := c.Height()
+	TFunc := c.T()
+	altFunc := c.ALT()
+	tsFunc := c.read2D("TSURF_K") // surface temperature [K]
+	return func() (*sparse.DenseArray, error) {
+		u, err := uFunc()
+		if err != nil {
+			return nil, err
+		}
+		v, err := vFunc()
+		if err != nil {
+			return nil, err
+		}
+		h, err := hFunc()
+		if err != nil {
+			return nil, err
+		}
+		T, err := TFunc()
+		if err != nil {
+			return nil, err
+		}
+		alt, err := altFunc()
+		if err != nil {
+			return nil, err
+		}
+		ts, err := tsFunc()
+		if err != nil {
+			return nil, err
+		}
+		o := sparse.ZerosDense(ts.Shape...)
+		for j := 0; j < o.Shape[0]; j++ {
+			for i := 0; i < o.Shape[1]; i++ {
+				ws, z1, cd := c.surfaceLayer(u, v, h, j, i)
+				θ1 := T.Get(0, j, i) + g/cp*z1
+				ρ := 1 / alt.Get(0, j, i)
+				o.Set(ρ*cp*cd*ws*(ts.Get(j, i)-θ1), j, i)
+			}
+		}
+		return o, nil
+	}
+}
+
+// UStar helps fulfill the Preprocessor interface
+// by returning friction velocity [m/s], which is estimated
+// from the wind speed in the lowest layer assuming a neutral
+// logarithmic wind profile.
+func (c *CAMx) UStar() NextData {
+	uFunc := c.U()
+	vFunc := c.V()
+	hFunc := c.Height()
+	return func() (*sparse.DenseArray, error) {
+		u, err := uFunc()
+		if err != nil {
+			return nil, err
+		}
+		v, err := vFunc()
+		if err != nil {
+			return nil, err
+		}
+		h, err := hFunc()
+		if err != nil {
+			return nil, err
+		}
+		o := sparse.ZerosDense(c.ny, c.nx)
+		for j := 0; j < c.ny; j++ {
+			for i := 0; i < c.nx; i++ {
+				ws, _, cd := c.surfaceLayer(u, v, h, j, i)
+				o.Set(math.Sqrt(cd)*ws, j, i)
+			}
+		}
+		return o, nil
+	}
+}
+
+// T helps fulfill the Preprocessor interface by
+// returning temperature [K].
+func (c *CAMx) T() NextData { return c.read(c.met3D, "TEMP_K") }
+
+// P helps fulfill the Preprocessor interface
+// by returning pressure [Pa].
+func (c *CAMx) P() NextData { return camxScale(c.read(c.met3D, "PRESS_MB"), 100) }
+
+// HO helps fulfill the Preprocessor interface
+// by returning hydroxyl radical concentration [ppmv].
+func (c *CAMx) HO() NextData { return c.read(c.avrg, "OH") }
+
+// H2O2 helps fulfill the Preprocessor interface
+// by returning hydrogen peroxide concentration [ppmv].
+func (c *CAMx) H2O2() NextData { return c.read(c.avrg, "H2O2") }
+
+// SeinfeldLandUse helps fulfill the Preprocessor interface
+// by returning land use categories as
+// specified in github.com/ctessum/atmos/seinfeld.
+func (c *CAMx) SeinfeldLandUse() NextData {
+	o := sparse.ZerosDense(c.landUse.Shape...)
+	for i, lu := range c.landUse.Elements {
+		o.Elements[i] = float64(weselySeinfeld[f2i(lu)])
+	}
+	return func() (*sparse.DenseArray, error) { return o, nil }
+}
+
+// WeselyLandUse helps fulfill the Preprocessor interface
+// by returning land use categories as
+// specified in github.com/ctessum/atmos/wesely1989.
+func (c *CAMx) WeselyLandUse() NextData {
+	return func() (*sparse.DenseArray, error) { return c.landUse, nil }
+}
+
+// Z0 helps fulfill the Preprocessor interface by
+// returning roughness length [m].
+func (c *CAMx) Z0() NextData {
+	return func() (*sparse.DenseArray, error) { return c.z0, nil }
+}
+
+// camxMassFraction converts water contents [g/m3] returned by f
+// to mass fractions [kg/kg].
+func camxMassFraction(f, altFunc NextData) NextData {
+	return func() (*sparse.DenseArray, error) {
+		data, err := f()
+		if err != nil {
+			return nil, err
+		}
+		alt, err := altFunc()
+		if err != nil {
+			return nil, err
+		}
+		o := sparse.ZerosDense(data.Shape...)
+		for i, v := range data.Elements {
+			o.Elements[i] = v * 1.e-3 * alt.Elements[i]
+		}
+		return o, nil
+	}
+}
+
+// QRain helps fulfill the Preprocessor interface by
+// returning rain mass fraction.
+func (c *CAMx) QRain() NextData {
+	return camxMassFraction(c.read(c.met3D, "PRWC_GpM3"), c.ALT())
+}
+
+// CloudFrac helps fulfill the Preprocessor interface
+// by returning the fraction of each grid cell filled
+// with clouds [volume/volume]. CAMx does not provide cloud
+// fractions, so grid cells with cloud water content greater than
+// camxCloudWater are considered to be completely filled with cloud.
+func (c *CAMx) CloudFrac() NextData {
+	cwFunc := c.read(c.met3D, "CLWC_GpM3") // cloud water content [g/m3]
+	return func() (*sparse.DenseArray, error) {
+		cw, err := cwFunc()
+		if err != nil {
+			return nil, err
+		}
+		o := sparse.ZerosDense(cw.Shape...)
+		for i, v := range cw.Elements {
+			if v > camxCloudWater {
+				o.Elements[i] = 1
+			}
+		}
+		return o, nil
+	}
+}
+
+// QCloud helps fulfill the Preprocessor interface by returning
+// the mass fraction of cloud water in each grid cell [mass/mass].
+func (c *CAMx) QCloud() NextData {
+	return camxMassFraction(c.read(c.met3D, "CLWC_GpM3"), c.ALT())
+}
+
+// RadiationDown helps fulfill the Preprocessor interface by returning
+// total downwelling radiation at ground level [W/m2]. CAMx provides
+// shortwave radiation (SWSFC_WpM2), so the downwelling longwave radiation
+// is estimated from the temperature and humidity in the lowest layer
+// using the clear-sky emissivity of Brutsaert (1975).
+func (c *CAMx) RadiationDown() NextData {
+	swFunc := c.read2D("SWSFC_WpM2") // downwelling short wave radiation at ground level [W/m2]
+	TFunc := c.T()
+	PFunc := c.P()
+	qFunc := c.read(c.met3D, "HUMID_PPM") // water vapor [ppmv]
+	return func() (*sparse.DenseArray, error) {
+		sw, err := swFunc()
+		if err != nil {
+			return nil, err
+		}
+		T, err := TFunc()
+		if err != nil {
+			return nil, err
+		}
+		P, err := PFunc()
+		if err != nil {
+			return nil, err
+		}
+		q, err := qFunc()
+		if err != nil {
+			return nil, err
+		}
+		o := sparse.ZerosDense(sw.Shape...)
+		for j := 0; j < o.Shape[0]; j++ {
+			for i := 0; i < o.Shape[1]; i++ {
+				t := T.Get(0, j, i)
+				e := q.Get(0, j, i) * 1.e-6 * P.Get(0, j, i) / 100 // vapor pressure [hPa]
+				ε := 1.24 * math.Pow(e/t, 1./7.)
+				o.Set(sw.Get(j, i)+ε*σSB*math.Pow(t, 4), j, i)
+			}
+		}
+		return o, nil
+	}
+}
@@ -41,6 +41,7 @@ func init() {
 func TestClient_fake(t *testing.T) {
 	checkConfig := func(cmd []string) {
 		wantCmd := []string{"inmap", "run", "steady",
+			"--DisparityVariables=",
 			"--EmissionMaskGeoJSON=",
 			"--EmissionUnits=tons/year",
 			"--EmissionsShapefiles=file://test/test/test_user/test_job/258bbcefe8c0073d6f323351463be9e9685e74bb92e367ca769b9536ed247213.shp",
@@ -49,17 +50,20 @@ func TestClient_fake(t *testing.T) {
 			"--NumIterations=0",
 			"--OutputFile=file://test/test/test_user/test_job/OutputFile.shp",
 			"--OutputVariables={\"TotalPM25\":\"PrimaryPM25 + pNH4 + pSO4 + pNO3 + SOA\",\"TotalPopD\":\"(exp(log(1.078)/10 * TotalPM25) - 1) * TotalPop * AllCause / 100000\"}\n",
+			"--VarGrid.BaselineHR=",
 			"--VarGrid.CensusFile=file://test/test/test_user/test_job/72f6717ef5f6f9600378fe5b192776ba142b3e93311c3dfd0b67bfecbe399990.shp",
 			"--VarGrid.CensusPopColumns=TotalPop,WhiteNoLat,Black,Native,Asian,Latino",
+			"--VarGrid.CensusProjections={}\n",
 			"--VarGrid.GridProj=+proj=lcc +lat_1=33.000000 +lat_2=45.000000 +lat_0=40.000000 +lon_0=-97.000000 +x_0=0 +y_0=0 +a=6370997.000000 +b=6370997.000000 +to_meter=1",
 			"--VarGrid.HiResLayers=1",
 			"--VarGrid.MortalityRateColumns={\"AllCause\":\"TotalPop\",\"AsianMort\":\"Asian\",\"BlackMort\":\"Black\",\"LatinoMort\":\"Latino\",\"NativeMort\":\"Native\",\"WhNoLMort\":\"WhiteNoLat\"}\n",
 			"--VarGrid.MortalityRateFile=file://test/test/test_user/test_job/764874ad5081665459c67d40607f68df6fc689aa695b4822e012aef84cba5394.shp",
+			"--VarGrid.MortalityRateProjections={}\n",
 			"--VarGrid.PopConcThreshold=1e-09", "--VarGrid.PopDensityThreshold=0.0055",
 			"--VarGrid.PopGridColumn=TotalPop", "--VarGrid.PopThreshold=40000", "--VarGrid.VariableGridDx=4000",
 			"--VarGrid.VariableGridDy=4000", "--VarGrid.VariableGridXo=-4000", "--VarGrid.VariableGridYo=-4000",
 			"--VarGrid.Xnests=2,2,2", "--VarGrid.Ynests=2,2,2",
-			"--VariableGridData=file://test/test/test_user/test_job/26b310adcf36530acdb518bd74b61355b2a2e7825c20a07f3631db412c655881.gob",
+			"--VariableGridData=file://test/test/test_user/test_job/d4ed6f7c544c21eb19d31583983e8d9d50fcc34c5c0d37539d9d7e820ec485b8.gob",
 			"--aep.GridRef=",
 			"--aep.InventoryConfig.COARDSFiles=",
 			"--aep.InventoryConfig.COARDSYear=0",
@@ -74,6 +78,7 @@ func TestClient_fake(t *testing.T) {
 			"--aep.SrgShapefileDirectory=no_default",
 			"--aep.SrgSpecOSM=",
 			"--aep.SrgSpecSMOKE=",
+			"--year=0",
 		}
 		if len(cmd) != len(wantCmd) {
 			t.Errorf("wrong command length: %d != %d", len(cmd), len(wantCmd))
@@ -202,6 +207,7 @@ func TestClient_fake(t *testing.T) {
 func TestClient_fakeCOARDS(t *testing.T) {
 	checkConfig := func(cmd []string) {
 		wantCmd := []string{"inmap", "run", "steady",
+			"--DisparityVariables=",
 			"--EmissionMaskGeoJSON=",
 			"--EmissionUnits=tons/year",
 			"--EmissionsShapefiles=",
@@ -210,17 +216,20 @@ func TestClient_fakeCOARDS(t *testing.T) {
 			"--NumIterations=0",
 			"--OutputFile=file://test/test/test_user/test_job/OutputFile.shp",
 			"--OutputVariables={\"TotalPM25\":\"PrimaryPM25 + pNH4 + pSO4 + pNO3 + SOA\",\"TotalPopD\":\"(exp(log(1.078)/10 * TotalPM25) - 1) * TotalPop * AllCause / 100000\"}\n",
+			"--VarGrid.BaselineHR=",
 			"--VarGrid.CensusFile=file://test/test/test_user/test_job/72f6717ef5f6f9600378fe5b192776ba142b3e93311c3dfd0b67bfecbe399990.shp",
 			"--VarGrid.CensusPopColumns=TotalPop,WhiteNoLat,Black,Native,Asian,Latino",
+			"--VarGrid.CensusProjections={}\n",
 			"--VarGrid.GridProj=+proj=lcc +lat_1=33.000000 +lat_2=45.000000 +lat_0=40.000000 +lon_0=-97.000000 +x_0=0 +y_0=0 +a=6370997.000000 +b=6370997.000000 +to_meter=1",
 			"--VarGrid.HiResLayers=1",
 			"--VarGrid.MortalityRateColumns={\"AllCause\":\"TotalPop\",\"AsianMort\":\"Asian\",\"BlackMort\":\"Black\",\"LatinoMort\":\"Latino\",\"NativeMort\":\"Native\",\"WhNoLMort\":\"WhiteNoLat\"}\n",
 			"--VarGrid.MortalityRateFile=file://test/test/test_user/test_job/764874ad5081665459c67d40607f68df6fc689aa695b4822e012aef84cba5394.shp",
+			"--VarGrid.MortalityRateProjections={}\n",
 			"--VarGrid.PopConcThreshold=1e-09", "--VarGrid.PopDensityThreshold=0.0055",
 			"--VarGrid.PopGridColumn=TotalPop", "--VarGrid.PopThreshold=40000", "--VarGrid.VariableGridDx=4000",
 			"--VarGrid.VariableGridDy=4000", "--VarGrid.VariableGridXo=-4000", "--VarGrid.VariableGridYo=-4000",
 			"--VarGrid.Xnests=2,2,2", "--VarGrid.Ynests=2,2,2",
-			"--VariableGridData=file://test/test/test_user/test_job/26b310adcf36530acdb518bd74b61355b2a2e7825c20a07f3631db412c655881.gob",
+			"--VariableGridData=file://test/test/test_user/test_job/d4ed6f7c544c21eb19d31583983e8d9d50fcc34c5c0d37539d9d7e820ec485b8.gob",
 			"--aep.GridRef=file://test/test/test_user/test_job/d471298031ee531438f90ae92878df0aae1f76fb81424e1f223bf7a602a1864c.txt",
 			"--aep.InventoryConfig.COARDSFiles={\"all\":[\"file://test/test/test_user/test_job/ffe280d818c1549074d0e15cfb74377b891287d7f81a4ad9038d0f65b12f6642.nc\"]}",
 			"--aep.InventoryConfig.COARDSYear=2016",
@@ -235,6 +244,7 @@ func TestClient_fakeCOARDS(t *testing.T) {
 			"--aep.SrgShapefileDirectory=no_default",
 			"--aep.SrgSpecOSM=file://test/test/test_user/test_job/f299df4d61e915c2d415b18ceaa1339a2cd7f8481d7d3b6d13675bc0516a5c00.json",
 			"--aep.SrgSpecSMOKE=",
+			"--year=0",
 		}
 		if len(cmd) != len(wantCmd) {
 			t.Errorf("wrong command length: %d != %d", len(cmd), len(wantCmd))
@@ -56,7 +56,7 @@ func TestRunInputFromViper(t *testing.T) {
 		"--VarGrid.VariableGridDx":            "4000",
 		"--NumIterations":                     "0",
 		"--VarGrid.CensusPopColumns":          "TotalPop,WhiteNoLat,Black,Native,Asian,Latino",
-		"--VariableGridData":                  "26b310adcf36530acdb518bd74b61355b2a2e7825c20a07f3631db412c655881.gob",
+		"--VariableGridData":                  "d4ed6f7c544c21eb19d31583983e8d9d50fcc34c5c0d37539d9d7e820ec485b8.gob",
 		"--OutputVariables":                   "{\"TotalPM25\":\"PrimaryPM25 + pNH4 + pSO4 + pNO3 + SOA\",\"TotalPopD\":\"(exp(log(1.078)/10 * TotalPM25) - 1) * TotalPop * AllCause / 100000\"}\n",
 		"--OutputFile":                        "inmap_output.shp",
 		"--VarGrid.PopThreshold":              "40000",
@@ -84,6 +84,11 @@ func TestRunInputFromViper(t *testing.T) {
 		"--aep.SpatialConfig.InputSR":         "+proj=longlat",
 		"--aep.SpatialConfig.MaxCacheEntries": "10",
 		"--aep.SrgShapefileDirectory":         "no_default",
+		"--DisparityVariables":                "",
+		"--VarGrid.BaselineHR":                "",
+		"--VarGrid.CensusProjections":         "{}\n", // Maps are JSON encoded with a trailing newline.
+		"--VarGrid.MortalityRateProjections":  "{}\n",
+		"--year":                              "0",
 	}
 	if len(js.Args) != len(wantArgs)*2 {
 		t.Errorf("wrong number of arguments: %d != %d", len(js.Args)/2, len(wantArgs))
@@ -112,7 +117,7 @@ func TestRunInputFromViper(t *testing.T) {
 		"764874ad5081665459c67d40607f68df6fc689aa695b4822e012aef84cba5394.shx": 108,
 		"764874ad5081665459c67d40607f68df6fc689aa695b4822e012aef84cba5394.dbf": 341,
 		"764874ad5081665459c67d40607f68df6fc689aa695b4822e012aef84cba5394.prj": 432,
-		"26b310adcf36530acdb518bd74b61355b2a2e7825c20a07f3631db412c655881.gob": 21276,
+		"d4ed6f7c544c21eb19d31583983e8d9d50fcc34c5c0d37539d9d7e820ec485b8.gob": 21320,
 		"434bf26e3fda1ef9cef7e1fa6cc6b5174d11a22b19cbe10d256adc83b2a97d44.ncf": 14284,
 		"ffe280d818c1549074d0e15cfb74377b891287d7f81a4ad9038d0f65b12f6642.nc":  3484,
 	}
@@ -157,13 +162,31 @@ func TestSRPredictInputFromViper(t *testing.T) {
 	}
 
 	wantArgs := map[string]string{
-		"--EmissionMaskGeoJSON": "",
-		"--EmissionUnits":       "tons/year",
-		"--EmissionsShapefiles": "258bbcefe8c0073d6f323351463be9e9685e74bb92e367ca769b9536ed247213.shp",
-		"--OutputFile":          "inmap_output.shp",
-		"--OutputVariables":     "{\"PrimPM25\":\"PrimaryPM25\"}",
-		"--SR.OutputFile":       "${INMAP_ROOT_DIR}/cmd/inmap/testdata/output_${InMAPRunType}.shp",
-		"--VarGrid.GridProj":    "+proj=lcc +lat_1=33.000000 +lat_2=45.000000 +lat_0=40.000000 +lon_0=-97.000000 +x_0=0 +y_0=0 +a=6370997.000000 +b=6370997.000000 +to_meter=1",
+		"--EmissionMaskGeoJSON":               "",
+		"--EmissionUnits":                     "tons/year",
+		"--EmissionsShapefiles":               "258bbcefe8c0073d6f323351463be9e9685e74bb92e367ca769b9536ed247213.shp",
+		"--OutputFile":                        "inmap_output.shp",
+		"--OutputVariables":                   "{\"PrimPM25\":\"PrimaryPM25\"}",
+		"--SR.OutputFile":                     "${INMAP_ROOT_DIR}/cmd/inmap/testdata/output_${InMAPRunType}.shp",
+		"--VarGrid.GridProj":                  "+proj=lcc +lat_1=33.000000 +lat_2=45.000000 +lat_0=40.000000 +lon_0=-97.000000 +x_0=0 +y_0=0 +a=6370997.000000 +b=6370997.000000 +to_meter=1",
+		"--DisparityVariables":                "",
+		"--VarGrid.CensusProjections":         "{}\n",
+		"--VarGrid.MortalityRateProjections":  "{}\n",
+		"--year":                              "0",
+		"--aep.GridRef":                       "",
+		"--aep.InventoryConfig.NEIFiles":      "",
+		"--aep.InventoryConfig.COARDSFiles":   "",
+		"--aep.InventoryConfig.COARDSYear":    "0",
+		"--aep.InventoryConfig.InputUnits":    "no_default",
+		"--aep.SCCExactMatch":                 "true",
+		"--aep.SpatialConfig.GridName":        "inmap",
+		"--aep.SpatialConfig.InputSR":         "+proj=longlat",
+		"--aep.SpatialConfig.MaxCacheEntries": "10",
+		"--aep.SpatialConfig.SpatialCache":    "",
+		"--aep.SpatialConfig.SrgDataCache":    "",
+		"--aep.SrgShapefileDirectory":         "no_default",
+		"--aep.SrgSpecOSM":                    "",
+		"--aep.SrgSpecSMOKE":                  "",
 	}
 	if len(js.Args) != len(wantArgs)*2 {
 		t.Errorf("wrong number of arguments: %d != %d", len(js.Args)/2, len(wantArgs))
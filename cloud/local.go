@@ -0,0 +1,475 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cloud
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/evookelj/inmap"
+	"github.com/evookelj/inmap/cloud/cloudrpc"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"google.golang.org/grpc"
+)
+
+// LocalClient is an implementation of cloudrpc.CloudRPCClient that
+// runs simulations on the local computer rather than on a Kubernetes
+// cluster, for example to create an SR matrix on a single large workstation.
+//
+// The inputs, outputs, and status of each job are stored in a subdirectory
+// of a local directory, so the output of jobs that were run
+// by one LocalClient can be retrieved by another LocalClient
+// that uses the same directory, for example in a different process.
+type LocalClient struct {
+	// Exec runs the InMAP command cmd, e.g. ["inmap", "run", "steady", "--flag", "value"],
+	// in the working directory dir and returns the combined standard output
+	// and standard error. The default runs the command as a subprocess, with
+	// "inmap" replaced by the path of the currently running executable.
+	Exec func(ctx context.Context, dir string, cmd []string) ([]byte, error)
+
+	dir            string
+	root           *cobra.Command
+	outputFileArgs []string
+
+	// concurrency is the maximum number of jobs to run at the same time,
+	// and memoryGB is the maximum total memory requested by the
+	// jobs that are running at the same time. memoryGB is not
+	// enforced if it is zero.
+	concurrency int
+	memoryGB    int32
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	running int
+	usedGB  int32
+	active  map[string]*cloudrpc.JobStatus
+	failed  []string
+	wg      sync.WaitGroup
+}
+
+// NewLocalClient creates a new client that runs InMAP simulations on
+// the local computer. dir is the directory where job inputs and outputs
+// should be stored, root is the root InMAP command, and outputFileArgs lists
+// the names of the configuration arguments that represent output files.
+// concurrency is the maximum number of simulations to run at the same time;
+// if it is less than one, the number of CPUs is used.
+// memoryGB is the total amount of memory available to the simulations
+// that are running at the same time, which is checked against the
+// MemoryGB field of each job specification. If memoryGB is zero, memory use
+// is not limited. A job that requires more memory than memoryGB
+// is run when no other jobs are running.
+func NewLocalClient(dir string, root *cobra.Command, outputFileArgs []string, concurrency int, memoryGB int32) (*LocalClient, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("cloud: creating local job directory: %v", err)
+	}
+	// Simulations are run in their job directories, so
+	// file paths need to be absolute.
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("cloud: creating local job directory: %v", err)
+	}
+	if concurrency < 1 {
+		concurrency = runtime.NumCPU()
+	}
+	if memoryGB < 0 {
+		return nil, fmt.Errorf("cloud: local memory budget must not be negative but is %d GB", memoryGB)
+	}
+	c := &LocalClient{
+		Exec:           execSubprocess,
+		dir:            dir,
+		root:           root,
+		outputFileArgs: outputFileArgs,
+		concurrency:    concurrency,
+		memoryGB:       memoryGB,
+		active:         make(map[string]*cloudrpc.JobStatus),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c, nil
+}
+
+// execSubprocess runs cmd as a subprocess in directory dir, substituting
+// the current executable for "inmap".
+func execSubprocess(ctx context.Context, dir string, cmd []string) ([]byte, error) {
+	if cmd[0] == "inmap" {
+		exe, err := os.Executable()
+		if err != nil {
+			return nil, err
+		}
+		cmd = append([]string{exe}, cmd[1:]...)
+	}
+	xcmd := exec.CommandContext(ctx, cmd[0], cmd[1:]...)
+	xcmd.Dir = dir
+	return xcmd.CombinedOutput()
+}
+
+// Local directory layout. The files for each job are stored in a directory
+// named after the job, and the contents of the input files are stored
+// in localInputDir.
+const (
+	localInputDir   = "input"
+	localOutputDir  = "output"
+	localStatusFile = "status.json"
+	localLogFile    = "log.txt"
+)
+
+// jobDir returns the directory where the files for the named job are stored.
+func (c *LocalClient) jobDir(name string) (string, error) {
+	if name == "" || name == "." || name == ".." || name == localInputDir || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("cloud: invalid local job name '%s'", name)
+	}
+	return filepath.Join(c.dir, name), nil
+}
+
+// RunJob queues the given job to be run on the local computer and returns
+// without waiting for it to finish. Jobs that have already been completed
+// or that are already queued are not run again. Use Wait to wait
+// for all queued jobs to finish.
+func (c *LocalClient) RunJob(ctx context.Context, job *cloudrpc.JobSpec, _ ...grpc.CallOption) (*cloudrpc.JobStatus, error) {
+	if job.Version != inmap.Version {
+		return nil, fmt.Errorf("incorrect InMAP version: %s != %s", job.Version, inmap.Version)
+	}
+	status, err := c.Status(ctx, &cloudrpc.JobName{Name: job.Name, Version: job.Version})
+	if err != nil {
+		return nil, err
+	}
+	if status.Status != cloudrpc.Status_Failed && status.Status != cloudrpc.Status_Missing {
+		// Only run the job if it is missing or failed.
+		return status, nil
+	}
+	dir, err := c.jobDir(job.Name)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, fmt.Errorf("cloud: removing previous local job: %v", err)
+	}
+	cmd, err := c.stageLocal(dir, job)
+	if err != nil {
+		return nil, err
+	}
+
+	status = &cloudrpc.JobStatus{Status: cloudrpc.Status_Waiting}
+	c.mu.Lock()
+	c.active[job.Name] = status
+	c.mu.Unlock()
+	c.wg.Add(1)
+	go c.run(ctx, job.Name, dir, cmd, job.MemoryGB)
+	return &cloudrpc.JobStatus{Status: status.Status}, nil
+}
+
+// stageLocal writes the input files for the given job
+// and returns the command to run, with the input and output file
+// paths replaced by their locations in the local directory.
+// To avoid storing multiple copies of large files such as InMAPData,
+// the input file contents are stored in a directory that is shared among
+// jobs and linked into the job directory. The contents are stored by their
+// own checksums rather than by their names in the job specification,
+// because the names of shapefile sidecar files (e.g., '.dbf') are based on
+// the checksum of the '.shp' file (see JobSpec) and so can be the same
+// for files with different contents.
+func (c *LocalClient) stageLocal(dir string, job *cloudrpc.JobSpec) ([]string, error) {
+	storeDir := filepath.Join(c.dir, localInputDir)
+	inDir := filepath.Join(dir, localInputDir)
+	outDir := filepath.Join(dir, localOutputDir)
+	for _, d := range []string{storeDir, inDir, outDir} {
+		if err := os.MkdirAll(d, os.ModePerm); err != nil {
+			return nil, fmt.Errorf("cloud: creating local job directory: %v", err)
+		}
+	}
+	args := append([]string{}, job.Args...)
+	for fname, data := range job.FileData {
+		stored := filepath.Join(storeDir, fmt.Sprintf("%x", sha256.Sum256(data)))
+		if err := writeIfMissing(stored, data); err != nil {
+			return nil, fmt.Errorf("cloud: staging local input file: %v", err)
+		}
+		path := filepath.Join(inDir, fname)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("cloud: staging local input file: %v", err)
+		}
+		if err := os.Link(stored, path); err != nil {
+			return nil, fmt.Errorf("cloud: staging local input file: %v", err)
+		}
+		for i, arg := range args {
+			args[i] = replaceInputFile(arg, fname, path)
+		}
+	}
+	outputs, err := c.outputPaths(outDir, job.Cmd)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(args)-1; i++ {
+		if path, ok := outputs[strings.TrimLeft(args[i], "-")]; ok {
+			args[i+1] = path
+		}
+	}
+	return append(append([]string{}, job.Cmd...), args...), nil
+}
+
+// replaceInputFile returns arg with the input file name fname replaced by
+// path. Only whole argument values, comma-separated elements, and elements
+// of JSON objects of file lists---the forms created by JobSpec---are
+// replaced, so that file names that contain fname are left unchanged.
+func replaceInputFile(arg, fname, path string) string {
+	if arg == fname {
+		return path
+	}
+	var m map[string][]string
+	if strings.HasPrefix(arg, "{") && json.Unmarshal([]byte(arg), &m) == nil {
+		for _, files := range m {
+			for i, f := range files {
+				if f == fname {
+					files[i] = path
+				}
+			}
+		}
+		b, err := json.Marshal(m)
+		if err != nil {
+			return arg
+		}
+		return string(b)
+	}
+	elems := strings.Split(arg, ",")
+	for i, e := range elems {
+		if e == fname {
+			elems[i] = path
+		}
+	}
+	return strings.Join(elems, ",")
+}
+
+// writeIfMissing writes data to path if path does not already exist.
+// The data are written to a temporary file first so that partially
+// written files are never visible at path.
+func writeIfMissing(path string, data []byte) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// outputPaths returns the locations in outDir where the output files
+// of the given command should be written.
+func (c *LocalClient) outputPaths(outDir string, cmd []string) (map[string]string, error) {
+	outputFiles := make(map[string]struct{})
+	for _, f := range c.outputFileArgs {
+		outputFiles[f] = struct{}{}
+	}
+	execCmd, _, err := c.root.Find(cmd[1:])
+	if err != nil {
+		return nil, fmt.Errorf("cloud: couldn't find command %v: %v", cmd[1:], err)
+	}
+	o := make(map[string]string)
+	flags := execCmd.InheritedFlags()
+	flags.AddFlagSet(execCmd.LocalFlags())
+	flags.VisitAll(func(f *pflag.Flag) {
+		if _, ok := outputFiles[f.Name]; ok {
+			ext := filepath.Ext(f.Value.String())
+			o[f.Name] = filepath.Join(outDir, strings.Replace(f.Name, ".", "_", -1)+ext)
+		}
+	})
+	return o, nil
+}
+
+// run runs the specified job when there are enough resources available
+// and records its status.
+func (c *LocalClient) run(ctx context.Context, name, dir string, cmd []string, memoryGB int32) {
+	defer c.wg.Done()
+	c.mu.Lock()
+	for c.running >= c.concurrency ||
+		(c.memoryGB > 0 && c.running > 0 && c.usedGB+memoryGB > c.memoryGB) {
+		c.cond.Wait()
+	}
+	c.running++
+	c.usedGB += memoryGB
+	status := c.active[name]
+	status.Status = cloudrpc.Status_Running
+	status.StartTime = time.Now().Unix()
+	c.mu.Unlock()
+
+	out, err := c.Exec(ctx, dir, cmd)
+
+	c.mu.Lock()
+	status.CompletionTime = time.Now().Unix()
+	if err != nil {
+		status.Status = cloudrpc.Status_Failed
+		status.Message = fmt.Sprintf("%v: %s", err, lastLines(out, 10))
+		c.failed = append(c.failed, name)
+	} else {
+		status.Status = cloudrpc.Status_Complete
+	}
+	if lerr := ioutil.WriteFile(filepath.Join(dir, localLogFile), out, 0644); lerr != nil && err == nil {
+		err = fmt.Errorf("cloud: writing local job log: %v", lerr)
+		status.Status = cloudrpc.Status_Failed
+		status.Message = err.Error()
+		c.failed = append(c.failed, name)
+	}
+	if werr := writeLocalStatus(dir, status); werr != nil && err == nil {
+		status.Status = cloudrpc.Status_Failed
+		status.Message = werr.Error()
+		c.failed = append(c.failed, name)
+	}
+	delete(c.active, name)
+	c.running--
+	c.usedGB -= memoryGB
+	c.cond.Broadcast()
+	c.mu.Unlock()
+}
+
+// lastLines returns the last n lines of b.
+func lastLines(b []byte, n int) string {
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func writeLocalStatus(dir string, status *cloudrpc.JobStatus) error {
+	b, err := json.Marshal(status)
+	if err != nil {
+		return fmt.Errorf("cloud: writing local job status: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, localStatusFile), b, 0644); err != nil {
+		return fmt.Errorf("cloud: writing local job status: %v", err)
+	}
+	return nil
+}
+
+// Wait waits for all of the jobs queued by this client to finish.
+// It returns an error if any of the jobs failed.
+func (c *LocalClient) Wait() error {
+	c.wg.Wait()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.failed) > 0 {
+		dir, _ := c.jobDir(c.failed[0])
+		return fmt.Errorf("cloud: %d local job(s) failed, including %s; see %s for details",
+			len(c.failed), c.failed[0], filepath.Join(dir, localLogFile))
+	}
+	return nil
+}
+
+// Status returns the status of the given job. Jobs that were started
+// by a different client and did not finish, for example because the
+// process running them was stopped, are reported as failed.
+func (c *LocalClient) Status(ctx context.Context, job *cloudrpc.JobName, _ ...grpc.CallOption) (*cloudrpc.JobStatus, error) {
+	if job.Version != inmap.Version {
+		return nil, fmt.Errorf("incorrect InMAP version: %s != %s", job.Version, inmap.Version)
+	}
+	c.mu.Lock()
+	if s, ok := c.active[job.Name]; ok {
+		o := *s
+		c.mu.Unlock()
+		return &o, nil
+	}
+	c.mu.Unlock()
+
+	dir, err := c.jobDir(job.Name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return &cloudrpc.JobStatus{
+			Status:  cloudrpc.Status_Missing,
+			Message: fmt.Sprintf("cannot find job %s", job.Name),
+		}, nil
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, localStatusFile))
+	if err != nil {
+		return &cloudrpc.JobStatus{
+			Status:  cloudrpc.Status_Failed,
+			Message: fmt.Sprintf("job %s did not finish", job.Name),
+		}, nil
+	}
+	s := new(cloudrpc.JobStatus)
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("cloud: reading local job status: %v", err)
+	}
+	return s, nil
+}
+
+// Output returns the output files of the given job, which must be complete.
+func (c *LocalClient) Output(ctx context.Context, job *cloudrpc.JobName, _ ...grpc.CallOption) (*cloudrpc.JobOutput, error) {
+	status, err := c.Status(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	if status.Status != cloudrpc.Status_Complete {
+		return nil, fmt.Errorf("cloud: job %s is not complete; status: %s %s", job.Name, status.Status, status.Message)
+	}
+	dir, err := c.jobDir(job.Name)
+	if err != nil {
+		return nil, err
+	}
+	outDir := filepath.Join(dir, localOutputDir)
+	files, err := ioutil.ReadDir(outDir)
+	if err != nil {
+		return nil, fmt.Errorf("cloud: reading local job output: %v", err)
+	}
+	o := &cloudrpc.JobOutput{Files: make(map[string][]byte)}
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		if o.Files[f.Name()], err = ioutil.ReadFile(filepath.Join(outDir, f.Name())); err != nil {
+			return nil, fmt.Errorf("cloud: reading local job output: %v", err)
+		}
+	}
+	return o, nil
+}
+
+// Delete deletes the files of the given job. Jobs that are
+// queued or running cannot be deleted.
+func (c *LocalClient) Delete(ctx context.Context, job *cloudrpc.JobName, _ ...grpc.CallOption) (*cloudrpc.JobName, error) {
+	c.mu.Lock()
+	_, active := c.active[job.Name]
+	c.mu.Unlock()
+	if active {
+		return nil, fmt.Errorf("cloud: cannot delete local job %s because it has not finished", job.Name)
+	}
+	dir, err := c.jobDir(job.Name)
+	if err != nil {
+		return nil, err
+	}
+	return job, os.RemoveAll(dir)
+}
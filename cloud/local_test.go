@@ -0,0 +1,327 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cloud_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evookelj/inmap"
+	"github.com/evookelj/inmap/cloud"
+	"github.com/evookelj/inmap/cloud/cloudrpc"
+	"github.com/evookelj/inmap/inmaputil"
+)
+
+// execInProcess runs InMAP commands in the current process
+// rather than as a subprocess so that the inmap command
+// does not need to be installed to run the tests.
+func execInProcess(ctx context.Context, dir string, cmd []string) ([]byte, error) {
+	cfg := inmaputil.InitializeConfig()
+	var b bytes.Buffer
+	cfg.Root.SetOutput(&b)
+	cfg.Root.SetArgs(cmd[1:])
+	err := cfg.Root.Execute()
+	return b.Bytes(), err
+}
+
+func TestLocalClient(t *testing.T) {
+	cfg := inmaputil.InitializeConfig()
+	const dir = "test_local"
+	defer os.RemoveAll(dir)
+
+	c, err := cloud.NewLocalClient(dir, cfg.Root, cfg.OutputFiles(), 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Exec = execInProcess
+
+	jobSpec, err := cloud.JobSpec(cfg.Root, cfg.Viper, "test_job", []string{"run", "steady"}, cfg.InputFiles(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	name := &cloudrpc.JobName{Version: inmap.Version, Name: "test_job"}
+
+	t.Run("RunJob", func(t *testing.T) {
+		status, err := c.RunJob(ctx, jobSpec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Status != cloudrpc.Status_Waiting && status.Status != cloudrpc.Status_Running {
+			t.Errorf("status should be waiting or running but is %s", status.Status)
+		}
+		if err := c.Wait(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Status", func(t *testing.T) {
+		// Use a new client to make sure the status is read from the directory.
+		c2, err := cloud.NewLocalClient(dir, cfg.Root, cfg.OutputFiles(), 1, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		status, err := c2.Status(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Status != cloudrpc.Status_Complete {
+			t.Errorf("status should be complete but is %s: %s", status.Status, status.Message)
+		}
+		if status.CompletionTime < status.StartTime {
+			t.Errorf("completion time %d is before start time %d", status.CompletionTime, status.StartTime)
+		}
+	})
+
+	t.Run("Output", func(t *testing.T) {
+		output, err := c.Output(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range []string{"LogFile", "OutputFile.shp", "OutputFile.dbf", "OutputFile.shx", "OutputFile.prj"} {
+			if len(output.Files[f]) == 0 {
+				t.Errorf("missing file '%s'", f)
+			}
+		}
+	})
+
+	t.Run("rerun", func(t *testing.T) {
+		// Completed jobs should not be run again.
+		c.Exec = func(ctx context.Context, dir string, cmd []string) ([]byte, error) {
+			t.Error("completed job should not be rerun")
+			return nil, nil
+		}
+		status, err := c.RunJob(ctx, jobSpec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Status != cloudrpc.Status_Complete {
+			t.Errorf("status should be complete but is %s", status.Status)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if _, err := c.Delete(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "test_job")); !os.IsNotExist(err) {
+			t.Errorf("job directory should have been deleted: %v", err)
+		}
+		status, err := c.Status(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Status != cloudrpc.Status_Missing {
+			t.Errorf("status should be missing but is %s", status.Status)
+		}
+	})
+}
+
+func TestLocalClient_failed(t *testing.T) {
+	cfg := inmaputil.InitializeConfig()
+	const dir = "test_local_failed"
+	defer os.RemoveAll(dir)
+
+	c, err := cloud.NewLocalClient(dir, cfg.Root, cfg.OutputFiles(), 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Exec = func(ctx context.Context, dir string, cmd []string) ([]byte, error) {
+		return []byte("something went wrong"), fmt.Errorf("exit status 1")
+	}
+	ctx := context.Background()
+	job := &cloudrpc.JobSpec{
+		Version: inmap.Version,
+		Name:    "failed_job",
+		Cmd:     []string{"inmap", "run", "steady"},
+	}
+	if _, err = c.RunJob(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Wait(); err == nil {
+		t.Error("Wait should return an error")
+	}
+	name := &cloudrpc.JobName{Version: inmap.Version, Name: job.Name}
+	status, err := c.Status(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != cloudrpc.Status_Failed {
+		t.Errorf("status should be failed but is %s", status.Status)
+	}
+	if _, err = c.Output(ctx, name); err == nil {
+		t.Error("Output should return an error for a failed job")
+	}
+}
+
+func TestLocalClient_limits(t *testing.T) {
+	cfg := inmaputil.InitializeConfig()
+	const dir = "test_local_limits"
+	defer os.RemoveAll(dir)
+
+	for _, test := range []struct {
+		concurrency       int
+		memoryGB, jobGB   int32
+		wantMaxConcurrent int
+	}{
+		{concurrency: 3, memoryGB: 0, jobGB: 2, wantMaxConcurrent: 3},
+		{concurrency: 3, memoryGB: 5, jobGB: 2, wantMaxConcurrent: 2},
+		{concurrency: 3, memoryGB: 1, jobGB: 2, wantMaxConcurrent: 1},
+	} {
+		t.Run(fmt.Sprintf("%d_%d_%d", test.concurrency, test.memoryGB, test.jobGB), func(t *testing.T) {
+			c, err := cloud.NewLocalClient(dir, cfg.Root, cfg.OutputFiles(), test.concurrency, test.memoryGB)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var mu sync.Mutex
+			var running, maxRunning int
+			c.Exec = func(ctx context.Context, dir string, cmd []string) ([]byte, error) {
+				mu.Lock()
+				running++
+				if running > maxRunning {
+					maxRunning = running
+				}
+				mu.Unlock()
+				time.Sleep(20 * time.Millisecond)
+				mu.Lock()
+				running--
+				mu.Unlock()
+				return nil, nil
+			}
+			ctx := context.Background()
+			for i := 0; i < 8; i++ {
+				job := &cloudrpc.JobSpec{
+					Version:  inmap.Version,
+					Name:     fmt.Sprintf("job%d", i),
+					Cmd:      []string{"inmap", "run", "steady"},
+					MemoryGB: test.jobGB,
+				}
+				if _, err = c.RunJob(ctx, job); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err = c.Wait(); err != nil {
+				t.Fatal(err)
+			}
+			if maxRunning != test.wantMaxConcurrent {
+				t.Errorf("maximum concurrent jobs: %d != %d", maxRunning, test.wantMaxConcurrent)
+			}
+			for i := 0; i < 8; i++ {
+				if _, err = c.Delete(ctx, &cloudrpc.JobName{Version: inmap.Version, Name: fmt.Sprintf("job%d", i)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// Input files with the same names in different jobs can have different
+// contents, e.g., shapefiles with the same geometry but different attributes.
+func TestLocalClient_sameInputName(t *testing.T) {
+	cfg := inmaputil.InitializeConfig()
+	const dir = "test_local_input"
+	defer os.RemoveAll(dir)
+
+	c, err := cloud.NewLocalClient(dir, cfg.Root, cfg.OutputFiles(), 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	have := make(map[string]string)
+	c.Exec = func(ctx context.Context, dir string, cmd []string) ([]byte, error) {
+		b, err := ioutil.ReadFile(strings.TrimSuffix(cmd[len(cmd)-1], ".shp") + ".dbf")
+		if err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		have[filepath.Base(dir)] = string(b)
+		mu.Unlock()
+		return nil, nil
+	}
+	ctx := context.Background()
+	want := map[string]string{"job0": "attributes 0", "job1": "attributes 1"}
+	for name, dbf := range want {
+		job := &cloudrpc.JobSpec{
+			Version: inmap.Version,
+			Name:    name,
+			Cmd:     []string{"inmap", "run", "steady"},
+			Args:    []string{"--EmissionsShapefiles", "abc.shp"},
+			FileData: map[string][]byte{
+				"abc.shp": []byte("geometry"),
+				"abc.dbf": []byte(dbf),
+			},
+		}
+		if _, err = c.RunJob(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = c.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("%v != %v", have, want)
+	}
+}
+
+// Input file names should only be replaced where they are a whole
+// argument value or list element, not where they are part of another name.
+func TestLocalClient_inputArgs(t *testing.T) {
+	cfg := inmaputil.InitializeConfig()
+	const dir = "test_local_args"
+	defer os.RemoveAll(dir)
+
+	c, err := cloud.NewLocalClient(dir, cfg.Root, cfg.OutputFiles(), 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var have, want string
+	c.Exec = func(ctx context.Context, dir string, cmd []string) ([]byte, error) {
+		have = cmd[len(cmd)-1]
+		want = filepath.Join(dir, "input", "abc.shp") + "," + filepath.Join(dir, "input", "xabc.shp")
+		return nil, nil
+	}
+	job := &cloudrpc.JobSpec{
+		Version: inmap.Version,
+		Name:    "job",
+		Cmd:     []string{"inmap", "run", "steady"},
+		Args:    []string{"--EmissionsShapefiles", "abc.shp,xabc.shp"},
+		FileData: map[string][]byte{
+			"abc.shp":  []byte("geometry 0"),
+			"xabc.shp": []byte("geometry 1"),
+		},
+	}
+	if _, err = c.RunJob(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if have != want {
+		t.Errorf("%s != %s", have, want)
+	}
+}
@@ -0,0 +1,658 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/ctessum/cdf"
+	"github.com/ctessum/sparse"
+)
+
+// ioapiFormat is the format of the [DATE] wildcard in the names of
+// IOAPI files, such as those used by CMAQ, MCIP, and CAMx.
+const ioapiFormat = "20060102"
+
+// CMAQ is an InMAP preprocessor for CMAQ output with MCIP meteorology,
+// both in IOAPI NetCDF format. Chemical species names are those used by
+// the CB6 gas-phase and AE6 (or later) aerosol mechanisms.
+type CMAQ struct {
+	aVOC, bVOC, aSOA, bSOA, nox, pNO, sox, pS, nh3, pNH, totalPM25 map[string]float64
+
+	start, end time.Time
+
+	recordDelta time.Duration
+
+	nx, ny, nz int
+
+	// cGrid specifies whether the MCIP dot-point file contains
+	// winds on the Arakawa C grid (UWINDC and VWINDC). If it doesn't,
+	// the Arakawa B grid winds (UWIND and VWIND) are interpolated to
+	// the cell faces.
+	cGrid bool
+
+	metCro3D, metCro2D, metDot3D, gridCro2D, conc string
+
+	msgChan chan string
+
+	*ncfReader
+}
+
+// NewCMAQ initializes a CMAQ preprocessor from the given
+// configuration information.
+//
+// METCRO3D, METCRO2D, and METDOT3D are the locations of the MCIP
+// 3-dimensional cross-point, 2-dimensional cross-point, and 3-dimensional
+// dot-point meteorology files, respectively, and CONC is the location of the
+// CMAQ CONC or ACONC concentration files.
+// [DATE] should be used as a wild card for the simulation date in each of
+// these, and each file is expected to contain hourly records for the
+// day in its file name; additional records are ignored.
+// METCRO3D must contain the WWIND and CFRAC_3D variables, and CONC must
+// contain all of the model layers.
+//
+// GRIDCRO2D is the location of the time-independent MCIP 2-dimensional
+// cross-point grid file. Dominant land use categories (DLUSE) in this
+// file are assumed to be from the 24-category USGS classification.
+//
+// startDate and endDate are the dates of the beginning and end of the
+// simulation, respectively, in the format "YYYYMMDD".
+// If msgChan is not nil, status messages will be sent to it.
+func NewCMAQ(METCRO3D, METCRO2D, METDOT3D, GRIDCRO2D, CONC, startDate, endDate string, msgChan chan string) (*CMAQ, error) {
+	c := CMAQ{
+		// These maps contain the CMAQ variables that make
+		// up the chemical species groups, as well as the
+		// multiplication factors required to convert gas
+		// concentrations to mass fractions [μg/kg dry air]
+		// and particle concentrations to [μg/m3].
+		// Not all species are present in all mechanism versions;
+		// species that are not in the concentration files are ignored.
+
+		// CB6 SOA precursors and AE6 semivolatile SOA vapors.
+		aVOC: map[string]float64{
+			"TOL": ppmvToUgKg(92.14), "XYL": ppmvToUgKg(106.16), "XYLMN": ppmvToUgKg(106.16),
+			"BENZENE": ppmvToUgKg(78.11), "NAPH": ppmvToUgKg(128.17),
+			"SV_ALK1": ppmvToUgKg(225), "SV_ALK2": ppmvToUgKg(205.1),
+			"SV_TOL1": ppmvToUgKg(153), "SV_TOL2": ppmvToUgKg(194),
+			"SV_XYL1": ppmvToUgKg(192), "SV_XYL2": ppmvToUgKg(194),
+			"SV_BNZ1": ppmvToUgKg(144), "SV_BNZ2": ppmvToUgKg(144),
+			"SV_PAH1": ppmvToUgKg(243), "SV_PAH2": ppmvToUgKg(243),
+		},
+		bVOC: map[string]float64{
+			"ISOP": ppmvToUgKg(68.12), "TERP": ppmvToUgKg(136.23), "SESQ": ppmvToUgKg(204.35),
+			"SV_ISO1": ppmvToUgKg(132), "SV_ISO2": ppmvToUgKg(133),
+			"SV_TRP1": ppmvToUgKg(168), "SV_TRP2": ppmvToUgKg(168),
+			"SV_SQT": ppmvToUgKg(378),
+		},
+		// AE6 anthropogenic SOA species [μg/m3].
+		aSOA: map[string]float64{
+			"AALK1J": 1, "AALK2J": 1, "AXYL1J": 1, "AXYL2J": 1, "AXYL3J": 1,
+			"ATOL1J": 1, "ATOL2J": 1, "ATOL3J": 1, "ABNZ1J": 1, "ABNZ2J": 1,
+			"ABNZ3J": 1, "APAH1J": 1, "APAH2J": 1, "APAH3J": 1, "AOLGAJ": 1,
+		},
+		// AE6 biogenic SOA species [μg/m3].
+		bSOA: map[string]float64{
+			"AISO1J": 1, "AISO2J": 1, "AISO3J": 1, "ATRP1J": 1, "ATRP2J": 1,
+			"ASQTJ": 1, "AOLGBJ": 1,
+		},
+		// NOx species. We are only interested in the mass
+		// of Nitrogen, rather than the mass of the whole molecule, so
+		// we use the molecular weight of Nitrogen.
+		nox: map[string]float64{"NO": ppmvToUgKg(mwN), "NO2": ppmvToUgKg(mwN)},
+		// pNO is the Nitrogen fraction of the Aitken and accumulation
+		// mode particulate nitrate [μg/m3].
+		pNO: map[string]float64{"ANO3I": mwN / mwNO3, "ANO3J": mwN / mwNO3},
+		// SOx species. We are only interested in the mass
+		// of Sulfur, rather than the mass of the whole molecule, so
+		// we use the molecular weight of Sulfur.
+		sox: map[string]float64{"SO2": ppmvToUgKg(mwS), "SULF": ppmvToUgKg(mwS)},
+		// pS is the Sulfur fraction of the Aitken and accumulation
+		// mode particulate sulfate [μg/m3].
+		pS: map[string]float64{"ASO4I": mwS / mwSO4, "ASO4J": mwS / mwSO4},
+		// NH3 is ammonia. We are only interested in the mass
+		// of Nitrogen, rather than the mass of the whole molecule, so
+		// we use the molecular weight of Nitrogen.
+		nh3: map[string]float64{"NH3": ppmvToUgKg(mwN)},
+		// pNH is the Nitrogen fraction of the Aitken and accumulation
+		// mode particulate ammonium [μg/m3].
+		pNH: map[string]float64{"ANH4I": mwN / mwNH4, "ANH4J": mwN / mwNH4},
+
+		metCro3D:  METCRO3D,
+		metCro2D:  METCRO2D,
+		metDot3D:  METDOT3D,
+		gridCro2D: GRIDCRO2D,
+		conc:      CONC,
+		msgChan:   msgChan,
+		ncfReader: newNCFReader(),
+	}
+
+	var err error
+	c.start, err = time.Parse(inDateFormat, startDate)
+	if err != nil {
+		return nil, fmt.Errorf("inmap: CMAQ preprocessor start time: %v", err)
+	}
+	c.end, err = time.Parse(inDateFormat, endDate)
+	if err != nil {
+		return nil, fmt.Errorf("inmap: CMAQ preprocessor end time: %v", err)
+	}
+	if !c.end.After(c.start) {
+		return nil, fmt.Errorf("inmap: CMAQ preprocessor end time %v is not after start time %v", c.end, c.start)
+	}
+
+	if err := c.checkFiles(); err != nil {
+		return nil, fmt.Errorf("inmap: CMAQ preprocessor: %v", err)
+	}
+	return &c, nil
+}
+
+// checkFiles checks that the meteorology and concentration files
+// for the first day of the simulation are on the same grid and
+// contain the required variables. It also sets the grid dimensions,
+// the record interval, and the total PM2.5 species, and removes
+// species that are not in the concentration file from the
+// chemical species groups.
+func (c *CMAQ) checkFiles() error {
+	f, met, err := c.ncfFromTemplate(c.metCro3D, ioapiFormat, c.start)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	f2, conc, err := c.ncfFromTemplate(c.conc, ioapiFormat, c.start)
+	if err != nil {
+		return err
+	}
+	defer f2.Close()
+	f3, dot, err := c.ncfFromTemplate(c.metDot3D, ioapiFormat, c.start)
+	if err != nil {
+		return err
+	}
+	defer f3.Close()
+
+	c.nx, err = ioapiInt(met, "NCOLS")
+	if err != nil {
+		return err
+	}
+	c.ny, err = ioapiInt(met, "NROWS")
+	if err != nil {
+		return err
+	}
+	c.nz, err = ioapiInt(met, "NLAYS")
+	if err != nil {
+		return err
+	}
+	vgtyp, err := ioapiInt(met, "VGTYP")
+	if err != nil {
+		return err
+	}
+	// IOAPI vertical coordinate types 1, 2, and 7 are hydrostatic,
+	// non-hydrostatic, and WRF mass-core sigma-pressure coordinates, respectively.
+	if vgtyp != 1 && vgtyp != 2 && vgtyp != 7 {
+		return fmt.Errorf("vertical coordinate type (VGTYP) %d is not sigma-pressure", vgtyp)
+	}
+	if err := ioapiSameGrid(met, conc, "NCOLS", "NROWS", "NLAYS", "XORIG", "YORIG", "XCELL", "YCELL", "VGTYP", "VGTOP", "VGLVLS"); err != nil {
+		return fmt.Errorf("meteorology and concentration files: %v", err)
+	}
+
+	for _, v := range []string{"TA", "PRES", "DENS", "ZF", "QC", "QR", "CFRAC_3D", "WWIND"} {
+		if met.Header.Lengths(v) == nil {
+			return fmt.Errorf("variable %s is not in METCRO3D file", v)
+		}
+	}
+	if dot.Header.Lengths("UWINDC") != nil && dot.Header.Lengths("VWINDC") != nil {
+		c.cGrid = true
+	} else if dot.Header.Lengths("UWIND") == nil || dot.Header.Lengths("VWIND") == nil {
+		return fmt.Errorf("METDOT3D file must contain either UWINDC and VWINDC or UWIND and VWIND")
+	}
+
+	tstep, err := ioapiInt(conc, "TSTEP")
+	if err != nil {
+		return err
+	}
+	c.recordDelta = ioapiDuration(tstep)
+	if c.recordDelta <= 0 {
+		return fmt.Errorf("invalid concentration file time step %d", tstep)
+	}
+
+	for _, g := range []struct {
+		name  string
+		group map[string]float64
+	}{
+		{"aVOC", c.aVOC}, {"bVOC", c.bVOC}, {"aSOA", c.aSOA}, {"bSOA", c.bSOA},
+		{"NOx", c.nox}, {"pNO", c.pNO}, {"SOx", c.sox}, {"pS", c.pS},
+		{"NH3", c.nh3}, {"pNH", c.pNH},
+	} {
+		for v := range g.group {
+			if conc.Header.Lengths(v) == nil {
+				delete(g.group, v)
+			}
+		}
+		if len(g.group) == 0 {
+			return fmt.Errorf("concentration file does not contain any %s species", g.name)
+		}
+	}
+	for _, v := range []string{"OH", "H2O2"} {
+		if conc.Header.Lengths(v) == nil {
+			return fmt.Errorf("variable %s is not in concentration file", v)
+		}
+	}
+
+	// Total PM2.5 is the sum of the Aitken and accumulation mode
+	// (I and J) dry aerosol species.
+	c.totalPM25 = make(map[string]float64)
+	for _, v := range conc.Header.Variables() {
+		if strings.HasPrefix(v, "A") && (strings.HasSuffix(v, "I") || strings.HasSuffix(v, "J")) &&
+			!strings.HasPrefix(v, "AH2O") && !strings.HasPrefix(v, "AORGH2O") {
+			c.totalPM25[v] = 1
+		}
+	}
+	return nil
+}
+
+// ioapiInt returns the value of the given integer IOAPI global attribute.
+func ioapiInt(f *cdf.File, name string) (int, error) {
+	v, ok := f.Header.GetAttribute("", name).([]int32)
+	if !ok || len(v) != 1 {
+		return 0, fmt.Errorf("missing or invalid IOAPI attribute %s", name)
+	}
+	return int(v[0]), nil
+}
+
+// ioapiSameGrid returns an error if the given global attributes
+// are not the same in files a and b.
+func ioapiSameGrid(a, b *cdf.File, names ...string) error {
+	for _, name := range names {
+		va := fmt.Sprint(a.Header.GetAttribute("", name))
+		vb := fmt.Sprint(b.Header.GetAttribute("", name))
+		if va != vb {
+			return fmt.Errorf("attribute %s does not match (%s != %s)", name, va, vb)
+		}
+	}
+	return nil
+}
+
+// ioapiDuration converts an IOAPI time in the format HHMMSS to
+// a duration.
+func ioapiDuration(hhmmss int) time.Duration {
+	return time.Duration(hhmmss/10000)*time.Hour +
+		time.Duration(hhmmss/100%100)*time.Minute +
+		time.Duration(hhmmss%100)*time.Second
+}
+
+// ioapiRecord returns the index of the record in IOAPI file f
+// for time t, based on the TFLAG variable.
+func ioapiRecord(f *cdf.File, t time.Time) (int, error) {
+	date := int32(t.Year()*1000 + t.YearDay())
+	hms := int32(t.Hour()*10000 + t.Minute()*100 + t.Second())
+	for i := 0; ; i++ {
+		r := f.Reader("TFLAG", []int{i, 0, 0}, []int{i, 0, 1})
+		buf := r.Zero(2)
+		if _, err := r.Read(buf); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return -1, fmt.Errorf("inmap: time %v not found in IOAPI file", t)
+			}
+			return -1, err
+		}
+		if tflag := buf.([]int32); tflag[0] == date && tflag[1] == hms {
+			return i, nil
+		}
+	}
+}
+
+// nextDataIOAPI returns a function that sequentially retrieves time series data
+// for the specified variable (varName) from a series of IOAPI files
+// with the given file name template between the given start and end times.
+// Records are recordDelta apart and are located using the TFLAG variable, so
+// each file can contain a different number of records. Each file is assumed to
+// contain the data for the date that is in its name.
+func (r *ncfReader) nextDataIOAPI(fileTemplate, varName string, start, end time.Time, recordDelta time.Duration, msgChan chan string) NextData {
+	fileDate := func(date time.Time) time.Time {
+		return time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	var nRecords int
+	for date := start; date.Before(end); date = date.Add(recordDelta) {
+		nRecords++
+	}
+	next := r.pool.stream(nRecords, func(n int) (*sparse.DenseArray, error) {
+		date := start.Add(time.Duration(n) * recordDelta)
+		f, ff, err := r.ncfFromTemplate(fileTemplate, ioapiFormat, fileDate(date))
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		i, err := ioapiRecord(ff, date)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %s", err, f.Name())
+		}
+		return readNCF(varName, ff, i)
+	})
+	date := start
+	var n int
+	return func() (*sparse.DenseArray, error) {
+		data, err := next()
+		if err != nil {
+			return nil, err
+		}
+		fd := fileDate(date)
+		n++
+		date = date.Add(recordDelta)
+		if date.Day() != fd.Day() || !date.Before(end) {
+			if msgChan != nil {
+				fileName := strings.Replace(fileTemplate, "[DATE]", fd.Format(ioapiFormat), -1)
+				msgChan <- fmt.Sprintf("Read %d records of %s from %s", n, varName, fileName)
+			}
+			n = 0
+		}
+		return data, nil
+	}
+}
+
+// ioapi2D converts 3-D arrays with a single layer to 2-D arrays.
+func ioapi2D(f NextData) NextData {
+	return func() (*sparse.DenseArray, error) {
+		data, err := f()
+		if err != nil {
+			return nil, err
+		}
+		o := sparse.ZerosDense(data.Shape[1:]...)
+		copy(o.Elements, data.Elements)
+		return o, nil
+	}
+}
+
+func (c *CMAQ) read(template, varName string) NextData {
+	return c.nextDataIOAPI(template, varName, c.start, c.end, c.recordDelta, c.msgChan)
+}
+
+func (c *CMAQ) read2D(varName string) NextData {
+	return ioapi2D(c.read(c.metCro2D, varName))
+}
+
+func (c *CMAQ) readGroup(varGroup map[string]float64) NextData {
+	dataFuncs := make(map[string]NextData)
+	for v := range varGroup {
+		dataFuncs[v] = c.read(c.conc, v)
+	}
+	return nextDataGroup(dataFuncs, varGroup)
+}
+
+// readGroupAlt reads a group of gas-phase species and converts them
+// from mass fraction to concentration.
+func (c *CMAQ) readGroupAlt(varGroup map[string]float64) NextData {
+	return nextDataDivideAlt(c.readGroup(varGroup), c.ALT())
+}
+
+// Nx helps fulfill the Preprocessor interface by returning
+// the number of grid cells in the West-East direction.
+func (c *CMAQ) Nx() (int, error) { return c.nx, nil }
+
+// Ny helps fulfill the Preprocessor interface by returning
+// the number of grid cells in the South-North direction.
+func (c *CMAQ) Ny() (int, error) { return c.ny, nil }
+
+// Nz helps fulfill the Preprocessor interface by returning
+// the number of grid cells in the below-above direction.
+func (c *CMAQ) Nz() (int, error) { return c.nz, nil }
+
+// PBLH helps fulfill the Preprocessor interface by returning
+// planetary boundary layer height [m].
+func (c *CMAQ) PBLH() NextData { return c.read2D("PBL") }
+
+// Height helps fulfill the Preprocessor interface by returning
+// layer heights above ground level. MCIP provides the heights
+// of the layer tops (ZF), so we add the ground surface as the bottom edge.
+func (c *CMAQ) Height() NextData {
+	return addGroundLevel(c.read(c.metCro3D, "ZF"))
+}
+
+// addGroundLevel converts an array of values at the top faces of the
+// grid cells to a vertically staggered array with zeros at the
+// bottom of the lowest layer.
+func addGroundLevel(f NextData) NextData {
+	return func() (*sparse.DenseArray, error) {
+		top, err := f()
+		if err != nil {
+			return nil, err
+		}
+		o := sparse.ZerosDense(top.Shape[0]+1, top.Shape[1], top.Shape[2])
+		n := top.Shape[1] * top.Shape[2]
+		copy(o.Elements[n:], top.Elements)
+		return o, nil
+	}
+}
+
+// ALT helps fulfill the Preprocessor interface by returning
+// inverse air density [m3/kg].
+func (c *CMAQ) ALT() NextData {
+	densFunc := c.read(c.metCro3D, "DENS") // air density [kg/m3]
+	return func() (*sparse.DenseArray, error) {
+		dens, err := densFunc()
+		if err != nil {
+			return nil, err
+		}
+		alt := sparse.ZerosDense(dens.Shape...)
+		for i, d := range dens.Elements {
+			alt.Elements[i] = 1 / d
+		}
+		return alt, nil
+	}
+}
+
+// U helps fulfill the Preprocessor interface by returning
+// West-East wind speed [m/s].
+func (c *CMAQ) U() NextData {
+	if c.cGrid {
+		return cmaqCGrid(c.read(c.metDot3D, "UWINDC"), 2)
+	}
+	return cmaqBGrid(c.read(c.metDot3D, "UWIND"), 2)
+}
+
+// V helps fulfill the Preprocessor interface by returning
+// South-North wind speed [m/s].
+func (c *CMAQ) V() NextData {
+	if c.cGrid {
+		return cmaqCGrid(c.read(c.metDot3D, "VWINDC"), 1)
+	}
+	return cmaqBGrid(c.read(c.metDot3D, "VWIND"), 1)
+}
+
+// cmaqCGrid extracts winds that are staggered in dimension
+// staggerDim from MCIP dot-point arrays, which have an extra row and
+// column.
+func cmaqCGrid(f NextData, staggerDim int) NextData {
+	return func() (*sparse.DenseArray, error) {
+		dot, err := f()
+		if err != nil {
+			return nil, err
+		}
+		nz, ny, nx := dot.Shape[0], dot.Shape[1]-1, dot.Shape[2]-1
+		if staggerDim == 2 {
+			nx++
+		} else {
+			ny++
+		}
+		o := sparse.ZerosDense(nz, ny, nx)
+		for k := 0; k < nz; k++ {
+			for j := 0; j < ny; j++ {
+				for i := 0; i < nx; i++ {
+					o.Set(dot.Get(k, j, i), k, j, i)
+				}
+			}
+		}
+		return o, nil
+	}
+}
+
+// cmaqBGrid interpolates winds at the grid cell corners to the
+// cell faces in dimension staggerDim.
+func cmaqBGrid(f NextData, staggerDim int) NextData {
+	return func() (*sparse.DenseArray, error) {
+		dot, err := f()
+		if err != nil {
+			return nil, err
+		}
+		nz, ny, nx := dot.Shape[0], dot.Shape[1]-1, dot.Shape[2]-1
+		dj, di := 1, 0
+		if staggerDim == 2 {
+			nx++
+		} else {
+			ny++
+			dj, di = 0, 1
+		}
+		o := sparse.ZerosDense(nz, ny, nx)
+		for k := 0; k < nz; k++ {
+			for j := 0; j < ny; j++ {
+				for i := 0; i < nx; i++ {
+					o.Set((dot.Get(k, j, i)+dot.Get(k, j+dj, i+di))/2, k, j, i)
+				}
+			}
+		}
+		return o, nil
+	}
+}
+
+// W helps fulfill the Preprocessor interface by returning
+// below-above wind speed [m/s]. MCIP provides vertical wind speeds
+// at the layer tops (WWIND), so the wind speed at the ground is set to zero.
+func (c *CMAQ) W() NextData { return addGroundLevel(c.read(c.metCro3D, "WWIND")) }
+
+// AVOC helps fulfill the Preprocessor interface.
+func (c *CMAQ) AVOC() NextData { return c.readGroupAlt(c.aVOC) }
+
+// BVOC helps fulfill the Preprocessor interface.
+func (c *CMAQ) BVOC() NextData { return c.readGroupAlt(c.bVOC) }
+
+// NOx helps fulfill the Preprocessor interface.
+func (c *CMAQ) NOx() NextData { return c.readGroupAlt(c.nox) }
+
+// SOx helps fulfill the Preprocessor interface.
+func (c *CMAQ) SOx() NextData { return c.readGroupAlt(c.sox) }
+
+// NH3 helps fulfill the Preprocessor interface.
+func (c *CMAQ) NH3() NextData { return c.readGroupAlt(c.nh3) }
+
+// ASOA helps fulfill the Preprocessor interface.
+func (c *CMAQ) ASOA() NextData { return c.readGroup(c.aSOA) }
+
+// BSOA helps fulfill the Preprocessor interface.
+func (c *CMAQ) BSOA() NextData { return c.readGroup(c.bSOA) }
+
+// PNO helps fulfill the Preprocessor interface.
+func (c *CMAQ) PNO() NextData { return c.readGroup(c.pNO) }
+
+// PS helps fulfill the Preprocessor interface.
+func (c *CMAQ) PS() NextData { return c.readGroup(c.pS) }
+
+// PNH helps fulfill the Preprocessor interface.
+func (c *CMAQ) PNH() NextData { return c.readGroup(c.pNH) }
+
+// TotalPM25 helps fulfill the Preprocessor interface.
+func (c *CMAQ) TotalPM25() NextData { return c.readGroup(c.totalPM25) }
+
+// SurfaceHeatFlux helps fulfill the Preprocessor interface
+// by returning heat flux at the surface [W/m2].
+func (c *CMAQ) SurfaceHeatFlux() NextData { return c.read2D("HFX") }
+
+// UStar helps fulfill the Preprocessor interface
+// by returning friction velocity [m/s].
+func (c *CMAQ) UStar() NextData { return c.read2D("USTAR") }
+
+// T helps fulfill the Preprocessor interface by
+// returning temperature [K].
+func (c *CMAQ) T() NextData { return c.read(c.metCro3D, "TA") }
+
+// P helps fulfill the Preprocessor interface
+// by returning pressure [Pa].
+func (c *CMAQ) P() NextData { return c.read(c.metCro3D, "PRES") }
+
+// HO helps fulfill the Preprocessor interface
+// by returning hydroxyl radical concentration [ppmv].
+func (c *CMAQ) HO() NextData { return c.read(c.conc, "OH") }
+
+// H2O2 helps fulfill the Preprocessor interface
+// by returning hydrogen peroxide concentration [ppmv].
+func (c *CMAQ) H2O2() NextData { return c.read(c.conc, "H2O2") }
+
+// landUse returns the dominant USGS land use category in each grid cell,
+// which doesn't change over time.
+func (c *CMAQ) landUse() NextData {
+	return ioapi2D(nextDataConstantNCF("DLUSE", c.gridCro2D))
+}
+
+// SeinfeldLandUse helps fulfill the Preprocessor interface
+// by returning land use categories as
+// specified in github.com/ctessum/atmos/seinfeld.
+func (c *CMAQ) SeinfeldLandUse() NextData {
+	return cmaqLandUse(c.landUse(), func(lu int) float64 { return float64(USGSseinfeld[lu]) })
+}
+
+// WeselyLandUse helps fulfill the Preprocessor interface
+// by returning land use categories as
+// specified in github.com/ctessum/atmos/wesely1989.
+func (c *CMAQ) WeselyLandUse() NextData {
+	return cmaqLandUse(c.landUse(), func(lu int) float64 { return float64(USGSwesely[lu]) })
+}
+
+// cmaqLandUse converts the 1-based USGS land use categories returned
+// by luFunc using the given conversion function, which takes a
+// 0-based category index.
+func cmaqLandUse(luFunc NextData, convert func(int) float64) NextData {
+	return func() (*sparse.DenseArray, error) {
+		lu, err := luFunc() // USGS land use category
+		if err != nil {
+			return nil, err
+		}
+		o := sparse.ZerosDense(lu.Shape...)
+		for i, v := range lu.Elements {
+			l := f2i(v) - 1
+			if l < 0 || l >= len(USGSseinfeld) {
+				return nil, fmt.Errorf("inmap: CMAQ preprocessor: invalid USGS land use category %g", v)
+			}
+			o.Elements[i] = convert(l)
+		}
+		return o, nil
+	}
+}
+
+// Z0 helps fulfill the Preprocessor interface by
+// returning roughness length [m].
+func (c *CMAQ) Z0() NextData { return c.read2D("ZRUF") }
+
+// QRain helps fulfill the Preprocessor interface by
+// returning rain mass fraction.
+func (c *CMAQ) QRain() NextData { return c.read(c.metCro3D, "QR") }
+
+// CloudFrac helps fulfill the Preprocessor interface
+// by returning the fraction of each grid cell filled
+// with clouds [volume/volume].
+func (c *CMAQ) CloudFrac() NextData { return c.read(c.metCro3D, "CFRAC_3D") }
+
+// QCloud helps fulfill the Preprocessor interface by returning
+// the mass fraction of cloud water in each grid cell [mass/mass].
+func (c *CMAQ) QCloud() NextData { return c.read(c.metCro3D, "QC") }
+
+// RadiationDown helps fulfill the Preprocessor interface by returning
+// total downwelling radiation at ground level [W/m2].
+func (c *CMAQ) RadiationDown() NextData {
+	swDownFunc := c.read2D("RGRND") // downwelling short wave radiation at ground level [W/m2]
+	glwFunc := c.read2D("GLW")      // downwelling long wave radiation at ground level [W/m2]
+	return wrfRadiationDown(swDownFunc, glwFunc)
+}
@@ -0,0 +1,348 @@
+//go:build ignore
+// +build ignore
+
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// This program generates small synthetic IOAPI files that mimic CAMx
+// meteorological inputs and average concentration output, for testing
+// the CAMx preprocessor. The values are smooth, physically plausible functions of
+// time and location rather than real model output.
+// Run it from this directory with `go run generate.go`.
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"time"
+
+	"github.com/ctessum/cdf"
+)
+
+const (
+	nx, ny = 3, 2
+	dx     = 12000.
+	x0, y0 = -18000., -12000.
+	vgtop  = 5000.   // Pa
+	rd     = 287.058 // J/kg/K
+	g      = 9.80665 // m/s2
+	lapse  = 0.0065  // K/m
+)
+
+// vglvls are the sigma-pressure levels at the layer interfaces.
+var vglvls = []float32{1, 0.995, 0.988, 0.975, 0.95, 0.9, 0.8, 0.6}
+
+var nz = len(vglvls) - 1
+
+// variable is an IOAPI variable whose value is a function of time and
+// the layer (or category), row, and column indices.
+type variable struct {
+	name, units string
+	f           func(t time.Time, k, j, i int) float64
+
+	// ncat is the number of land use categories. If it is not zero,
+	// the variable has a land use dimension rather than a layer dimension.
+	ncat int
+}
+
+func main() {
+	days := []time.Time{
+		time.Date(2016, time.July, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2016, time.July, 2, 0, 0, 0, 0, time.UTC),
+	}
+	for _, day := range days {
+		d := day.Format("20060102")
+		// Meteorology files have 25 hourly records, including hour 0 of the next day.
+		write("camx.3d."+d+".nc", day, 25, nz, met3D)
+		write("camx.2d."+d+".nc", day, 25, 1, met2D)
+		write("camx.kv."+d+".nc", day, 25, nz, kv)
+		write("camx.avrg."+d+".nc", day, 24, nz, avrg)
+	}
+}
+
+// sun is a proxy for solar intensity, which is zero at night and one
+// at local solar noon.
+func sun(t time.Time) float64 {
+	lh := float64(t.Hour()) - 6 // Local hour
+	return math.Max(0, math.Sin(math.Pi*(lh-6)/12))
+}
+
+func surfacePressure(t time.Time, j, i int) float64 {
+	return 100000 - 200*float64(j) - 100*float64(i) + 150*math.Sin(2*math.Pi*float64(t.YearDay())/7)
+}
+
+func surfaceTemperature(t time.Time, j, i int) float64 {
+	return 290 + 8*sun(t) + float64(i) + 0.5*float64(j)
+}
+
+// sigmaPressure returns the pressure at the given sigma level. CAMx uses
+// heights rather than sigma levels as its vertical coordinate, but the
+// layers are usually taken directly from WRF.
+func sigmaPressure(σ, ps float64) float64 { return σ*(ps-vgtop) + vgtop }
+
+// interfaceHeights returns the heights of the layer interfaces
+// above ground, calculated using the hypsometric equation.
+func interfaceHeights(t time.Time, j, i int) []float64 {
+	ps := surfacePressure(t, j, i)
+	ts := surfaceTemperature(t, j, i)
+	z := make([]float64, nz+1)
+	for k := 0; k < nz; k++ {
+		pBot := sigmaPressure(float64(vglvls[k]), ps)
+		pTop := sigmaPressure(float64(vglvls[k+1]), ps)
+		tm := ts - lapse*z[k]
+		dz := rd * tm / g * math.Log(pBot/pTop)
+		tm = ts - lapse*(z[k]+dz/2)
+		z[k+1] = z[k] + rd*tm/g*math.Log(pBot/pTop)
+	}
+	return z
+}
+
+func midHeight(t time.Time, k, j, i int) float64 {
+	z := interfaceHeights(t, j, i)
+	return (z[k] + z[k+1]) / 2
+}
+
+func midPressure(t time.Time, k, j, i int) float64 {
+	σ := (float64(vglvls[k]) + float64(vglvls[k+1])) / 2
+	return sigmaPressure(σ, surfacePressure(t, j, i))
+}
+
+func temperature(t time.Time, k, j, i int) float64 {
+	return surfaceTemperature(t, j, i) - lapse*midHeight(t, k, j, i)
+}
+
+// cloudy returns whether there is a cloud in the given grid cell.
+func cloudy(t time.Time, k, j, i int) bool {
+	return (k == 4 || k == 5) && t.Hour()%6 < 3 && (i+j)%2 == 0
+}
+
+var met3D = []variable{
+	{name: "ZGRID_M", units: "m", f: func(t time.Time, k, j, i int) float64 {
+		return interfaceHeights(t, j, i)[k+1]
+	}},
+	{name: "PRESS_MB", units: "mb", f: func(t time.Time, k, j, i int) float64 {
+		return midPressure(t, k, j, i) / 100
+	}},
+	{name: "TEMP_K", units: "K", f: temperature},
+	{name: "HUMID_PPM", units: "ppm", f: func(t time.Time, k, j, i int) float64 {
+		return 19000 * math.Exp(-midHeight(t, k, j, i)/2500)
+	}},
+	// Winds at the East and North cell faces.
+	{name: "UWIND_MpS", units: "m/s", f: func(t time.Time, k, j, i int) float64 {
+		u, _ := wind(t, k, float64(j)+0.5, float64(i+1))
+		return u
+	}},
+	{name: "VWIND_MpS", units: "m/s", f: func(t time.Time, k, j, i int) float64 {
+		_, v := wind(t, k, float64(j+1), float64(i)+0.5)
+		return v
+	}},
+	{name: "CLWC_GpM3", units: "g/m3", f: func(t time.Time, k, j, i int) float64 {
+		if cloudy(t, k, j, i) {
+			return 0.2 * (1 + 0.5*float64(k-4))
+		}
+		return 0.01
+	}},
+	{name: "PRWC_GpM3", units: "g/m3", f: func(t time.Time, k, j, i int) float64 {
+		if cloudy(t, k, j, i) || (k < 4 && t.Hour()%6 < 3 && i == 0 && j == 0) {
+			return 0.01
+		}
+		return 0
+	}},
+}
+
+func wind(t time.Time, k int, y, x float64) (u, v float64) {
+	h := 2 * math.Pi * float64(t.Hour()) / 24
+	u = 3 + 2*math.Sin(h) + 0.5*float64(k) + 0.2*x
+	v = 1 + math.Cos(h) + 0.3*float64(k) - 0.1*y
+	return
+}
+
+// landUse holds the fractions of the CAMx 11 land use categories
+// in each grid cell.
+var landUse = [][]map[int]float64{
+	{{1: 0.7, 2: 0.3}, {7: 1}, {4: 0.5, 5: 0.3, 3: 0.2}},
+	{{2: 0.6, 10: 0.4}, {8: 0.8, 11: 0.2}, {9: 0.6, 7: 0.4}},
+}
+
+var met2D = []variable{
+	{name: "TSURF_K", units: "K", f: func(t time.Time, _, j, i int) float64 {
+		return surfaceTemperature(t, j, i) + 6*sun(t) - 2
+	}},
+	{name: "SWSFC_WpM2", units: "W/m2", f: func(t time.Time, _, j, i int) float64 { return 850 * sun(t) }},
+	{name: "LUCAT11", units: "fraction", ncat: 11, f: func(_ time.Time, l, j, i int) float64 {
+		return landUse[j][i][l+1]
+	}},
+}
+
+var kv = []variable{
+	{name: "KV_M2pS", units: "m2/s", f: func(t time.Time, k, j, i int) float64 {
+		pbl := 300 + 1200*sun(t) + 50*float64(i)
+		z := interfaceHeights(t, j, i)[k+1]
+		if z < pbl {
+			return 2 + 160*sun(t)*z/pbl*(1-z/pbl)
+		}
+		return 0.1
+	}},
+}
+
+// species returns a concentration that varies in time and space and
+// decreases with height.
+func species(base, amplitude, phase float64) func(t time.Time, k, j, i int) float64 {
+	return func(t time.Time, k, j, i int) float64 {
+		h := 2 * math.Pi * float64(t.Hour()) / 24
+		return base * (1 + amplitude*math.Sin(h+phase+float64(i)+0.5*float64(j))) / (1 + 0.3*float64(k))
+	}
+}
+
+var avrg = []variable{
+	{name: "NO", units: "ppmV", f: species(0.002, 0.5, 0)},
+	{name: "NO2", units: "ppmV", f: species(0.01, 0.4, 0.3)},
+	{name: "SO2", units: "ppmV", f: species(0.003, 0.3, 0.6)},
+	{name: "SULF", units: "ppmV", f: species(1.e-6, 0.5, 0.9)},
+	{name: "NH3", units: "ppmV", f: species(0.004, 0.3, 1.2)},
+	{name: "OH", units: "ppmV", f: func(t time.Time, k, j, i int) float64 { return 1.e-7*sun(t) + 1.e-9 }},
+	{name: "H2O2", units: "ppmV", f: species(0.001, 0.2, 1.5)},
+	{name: "TOL", units: "ppmV", f: species(5.e-4, 0.4, 1.8)},
+	{name: "XYL", units: "ppmV", f: species(3.e-4, 0.4, 2.1)},
+	{name: "BENZ", units: "ppmV", f: species(4.e-4, 0.3, 2.4)},
+	{name: "CG1", units: "ppmV", f: species(1.e-5, 0.5, 2.7)},
+	{name: "CG2", units: "ppmV", f: species(1.e-5, 0.5, 3.0)},
+	{name: "ISOP", units: "ppmV", f: func(t time.Time, k, j, i int) float64 { return (2.e-3*sun(t) + 1.e-4) / (1 + float64(k)) }},
+	{name: "TERP", units: "ppmV", f: species(3.e-4, 0.3, 3.3)},
+	{name: "SQT", units: "ppmV", f: species(1.e-5, 0.3, 3.6)},
+	{name: "CG3", units: "ppmV", f: species(2.e-5, 0.6, 3.9)},
+	{name: "CG4", units: "ppmV", f: species(1.e-5, 0.5, 4.2)},
+	{name: "PSO4", units: "ug/m3", f: species(2.2, 0.3, 0.4)},
+	{name: "PNO3", units: "ug/m3", f: species(0.55, 0.6, 1.0)},
+	{name: "PNH4", units: "ug/m3", f: species(1.0, 0.4, 1.6)},
+	{name: "NA", units: "ug/m3", f: species(0.1, 0.1, 1.9)},
+	{name: "PCL", units: "ug/m3", f: species(0.05, 0.1, 2.2)},
+	{name: "PEC", units: "ug/m3", f: species(0.4, 0.3, 2.5)},
+	{name: "POA", units: "ug/m3", f: species(1.2, 0.3, 2.8)},
+	{name: "FPRM", units: "ug/m3", f: species(0.6, 0.2, 3.1)},
+	{name: "SOA1", units: "ug/m3", f: species(0.05, 0.5, 3.4)},
+	{name: "SOA2", units: "ug/m3", f: species(0.04, 0.5, 3.7)},
+	{name: "SOPA", units: "ug/m3", f: species(0.1, 0.3, 4.0)},
+	{name: "SOA3", units: "ug/m3", f: species(0.2, 0.6, 4.3)},
+	{name: "SOA4", units: "ug/m3", f: species(0.15, 0.5, 4.6)},
+	{name: "SOPB", units: "ug/m3", f: species(0.3, 0.3, 4.9)},
+	{name: "CPRM", units: "ug/m3", f: species(3, 0.5, 5.2)},
+}
+
+// write writes an IOAPI file with nrec hourly records starting at
+// start.
+func write(fname string, start time.Time, nrec, nlay int, vars []variable) {
+	ncol, nrow := nx, ny
+	xorig, yorig := x0, y0
+	dims := []string{"TSTEP", "DATE-TIME", "LAY", "VAR", "ROW", "COL"}
+	lengths := []int{0, 2, nlay, len(vars), nrow, ncol}
+	for _, v := range vars {
+		if v.ncat > 0 {
+			dims = append(dims, "LANDUSE")
+			lengths = append(lengths, v.ncat)
+			break
+		}
+	}
+	h := cdf.NewHeader(dims, lengths)
+	h.AddVariable("TFLAG", []string{"TSTEP", "VAR", "DATE-TIME"}, []int32{0})
+	h.AddAttribute("TFLAG", "units", "<YYYYDDD,HHMMSS>")
+	var varList string
+	for _, v := range vars {
+		zDim := "LAY"
+		if v.ncat > 0 {
+			zDim = "LANDUSE"
+		}
+		h.AddVariable(v.name, []string{"TSTEP", zDim, "ROW", "COL"}, []float32{0})
+		h.AddAttribute(v.name, "long_name", fmt.Sprintf("%-16s", v.name))
+		h.AddAttribute(v.name, "units", fmt.Sprintf("%-16s", v.units))
+		varList += fmt.Sprintf("%-16s", v.name)
+	}
+	sdate := int32(start.Year()*1000 + start.YearDay())
+	h.AddAttribute("", "IOAPI_VERSION", "synthetic test data")
+	h.AddAttribute("", "FTYPE", []int32{1})
+	h.AddAttribute("", "SDATE", []int32{sdate})
+	h.AddAttribute("", "STIME", []int32{0})
+	h.AddAttribute("", "TSTEP", []int32{10000})
+	h.AddAttribute("", "NTHIK", []int32{1})
+	h.AddAttribute("", "NCOLS", []int32{int32(ncol)})
+	h.AddAttribute("", "NROWS", []int32{int32(nrow)})
+	h.AddAttribute("", "NLAYS", []int32{int32(nlay)})
+	h.AddAttribute("", "NVARS", []int32{int32(len(vars))})
+	h.AddAttribute("", "GDTYP", []int32{2})
+	h.AddAttribute("", "P_ALP", []float64{33})
+	h.AddAttribute("", "P_BET", []float64{45})
+	h.AddAttribute("", "P_GAM", []float64{-97})
+	h.AddAttribute("", "XCENT", []float64{-97})
+	h.AddAttribute("", "YCENT", []float64{40})
+	h.AddAttribute("", "XORIG", []float64{xorig})
+	h.AddAttribute("", "YORIG", []float64{yorig})
+	h.AddAttribute("", "XCELL", []float64{dx})
+	h.AddAttribute("", "YCELL", []float64{dx})
+	h.AddAttribute("", "VGTYP", []int32{7})
+	h.AddAttribute("", "VGTOP", []float32{vgtop})
+	h.AddAttribute("", "VGLVLS", vglvls)
+	h.AddAttribute("", "GDNAM", "SYNTHETIC")
+	h.AddAttribute("", "VAR-LIST", varList)
+	h.Define()
+
+	w, err := os.Create(fname)
+	if err != nil {
+		log.Fatal(err)
+	}
+	f, err := cdf.Create(w, h)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for r := 0; r < nrec; r++ {
+		t := start.Add(time.Duration(r) * time.Hour)
+		tflag := make([]int32, 2*len(vars))
+		for v := range vars {
+			tflag[2*v] = int32(t.Year()*1000 + t.YearDay())
+			tflag[2*v+1] = int32(t.Hour() * 10000)
+		}
+		if _, err := f.Writer("TFLAG", []int{r, 0, 0}, []int{r + 1, len(vars), 2}).Write(tflag); err != nil {
+			log.Fatal(err)
+		}
+		for _, v := range vars {
+			nz := nlay
+			if v.ncat > 0 {
+				nz = v.ncat
+			}
+			data := make([]float32, 0, nz*nrow*ncol)
+			for k := 0; k < nz; k++ {
+				for j := 0; j < nrow; j++ {
+					for i := 0; i < ncol; i++ {
+						data = append(data, float32(v.f(t, k, j, i)))
+					}
+				}
+			}
+			wr := f.Writer(v.name, []int{r, 0, 0, 0}, []int{r + 1, nz, nrow, ncol})
+			if _, err := wr.Write(data); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	if err := cdf.UpdateNumRecs(w); err != nil {
+		log.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
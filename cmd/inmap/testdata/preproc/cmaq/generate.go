@@ -0,0 +1,361 @@
+// +build ignore
+
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// This program generates small synthetic IOAPI files that mimic MCIP
+// meteorology and CMAQ concentration output, for testing the CMAQ
+// preprocessor. The values are smooth, physically plausible functions of
+// time and location rather than real model output.
+// Run it from this directory with `go run generate.go`.
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"time"
+
+	"github.com/ctessum/cdf"
+)
+
+const (
+	nx, ny = 3, 2
+	dx     = 12000.
+	x0, y0 = -18000., -12000.
+	vgtop  = 5000.   // Pa
+	rd     = 287.058 // J/kg/K
+	g      = 9.80665 // m/s2
+	lapse  = 0.0065  // K/m
+)
+
+// vglvls are the sigma-pressure levels at the layer interfaces.
+var vglvls = []float32{1, 0.995, 0.988, 0.975, 0.95, 0.9, 0.8, 0.6}
+
+var nz = len(vglvls) - 1
+
+// variable is an IOAPI variable whose value is a function of time and
+// the layer, row, and column indices.
+type variable struct {
+	name, units string
+	f           func(t time.Time, k, j, i int) float64
+}
+
+func main() {
+	days := []time.Time{
+		time.Date(2016, time.July, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2016, time.July, 2, 0, 0, 0, 0, time.UTC),
+	}
+	for _, day := range days {
+		d := day.Format("20060102")
+		// MCIP files have 25 hourly records, including hour 0 of the next day.
+		write("METCRO3D_"+d+".nc", day, 25, nz, false, met3D)
+		write("METCRO2D_"+d+".nc", day, 25, 1, false, met2D)
+		write("METDOT3D_"+d+".nc", day, 25, nz, true, metDot)
+		write("CCTM_ACONC_"+d+".nc", day, 24, nz, false, conc)
+	}
+	write("GRIDCRO2D.nc", time.Time{}, 0, 1, false, grid)
+}
+
+// sun is a proxy for solar intensity, which is zero at night and one
+// at local solar noon.
+func sun(t time.Time) float64 {
+	lh := float64(t.Hour()) - 6 // Local hour
+	return math.Max(0, math.Sin(math.Pi*(lh-6)/12))
+}
+
+func surfacePressure(t time.Time, j, i int) float64 {
+	return 100000 - 200*float64(j) - 100*float64(i) + 150*math.Sin(2*math.Pi*float64(t.YearDay())/7)
+}
+
+func surfaceTemperature(t time.Time, j, i int) float64 {
+	return 290 + 8*sun(t) + float64(i) + 0.5*float64(j)
+}
+
+// sigmaPressure returns the pressure at the given sigma level.
+func sigmaPressure(σ, ps float64) float64 { return σ*(ps-vgtop) + vgtop }
+
+// interfaceHeights returns the heights of the layer interfaces
+// above ground, calculated using the hypsometric equation.
+func interfaceHeights(t time.Time, j, i int) []float64 {
+	ps := surfacePressure(t, j, i)
+	ts := surfaceTemperature(t, j, i)
+	z := make([]float64, nz+1)
+	for k := 0; k < nz; k++ {
+		pBot := sigmaPressure(float64(vglvls[k]), ps)
+		pTop := sigmaPressure(float64(vglvls[k+1]), ps)
+		tm := ts - lapse*z[k]
+		dz := rd * tm / g * math.Log(pBot/pTop)
+		tm = ts - lapse*(z[k]+dz/2)
+		z[k+1] = z[k] + rd*tm/g*math.Log(pBot/pTop)
+	}
+	return z
+}
+
+func midHeight(t time.Time, k, j, i int) float64 {
+	z := interfaceHeights(t, j, i)
+	return (z[k] + z[k+1]) / 2
+}
+
+func midPressure(t time.Time, k, j, i int) float64 {
+	σ := (float64(vglvls[k]) + float64(vglvls[k+1])) / 2
+	return sigmaPressure(σ, surfacePressure(t, j, i))
+}
+
+func temperature(t time.Time, k, j, i int) float64 {
+	return surfaceTemperature(t, j, i) - lapse*midHeight(t, k, j, i)
+}
+
+// cloudy returns whether there is a cloud in the given grid cell.
+func cloudy(t time.Time, k, j, i int) bool {
+	return (k == 4 || k == 5) && t.Hour()%6 < 3 && (i+j)%2 == 0
+}
+
+var met3D = []variable{
+	{"TA", "K", temperature},
+	{"PRES", "Pa", midPressure},
+	{"DENS", "KG/M**3", func(t time.Time, k, j, i int) float64 {
+		return midPressure(t, k, j, i) / (rd * temperature(t, k, j, i))
+	}},
+	{"ZH", "M", midHeight},
+	{"ZF", "M", func(t time.Time, k, j, i int) float64 {
+		return interfaceHeights(t, j, i)[k+1]
+	}},
+	{"QV", "KG/KG", func(t time.Time, k, j, i int) float64 {
+		return 0.012 * math.Exp(-midHeight(t, k, j, i)/2500)
+	}},
+	{"QC", "KG/KG", func(t time.Time, k, j, i int) float64 {
+		if cloudy(t, k, j, i) {
+			return 2.e-4 * (1 + 0.5*float64(k-4))
+		}
+		return 0
+	}},
+	{"QR", "KG/KG", func(t time.Time, k, j, i int) float64 {
+		if cloudy(t, k, j, i) || (k < 4 && t.Hour()%6 < 3 && i == 0 && j == 0) {
+			return 1.e-5
+		}
+		return 0
+	}},
+	{"CFRAC_3D", "FRACTION", func(t time.Time, k, j, i int) float64 {
+		if cloudy(t, k, j, i) {
+			return 0.6
+		}
+		return 0
+	}},
+	{"WWIND", "M/S", func(t time.Time, k, j, i int) float64 {
+		return 0.02 * math.Sin(2*math.Pi*float64(t.Hour())/24+float64(i-j)) * float64(k+1) / float64(nz)
+	}},
+}
+
+var met2D = []variable{
+	{"PRSFC", "Pascal", func(t time.Time, _, j, i int) float64 { return surfacePressure(t, j, i) }},
+	{"USTAR", "M/S", func(t time.Time, _, j, i int) float64 { return 0.2 + 0.3*sun(t) + 0.02*float64(j) }},
+	{"PBL", "M", func(t time.Time, _, j, i int) float64 { return 300 + 1200*sun(t) + 50*float64(i) }},
+	{"ZRUF", "M", func(t time.Time, _, j, i int) float64 { return 0.05 + 0.1*float64(i) + 0.2*float64(j) }},
+	{"HFX", "WATTS/M**2", func(t time.Time, _, j, i int) float64 { return -15 + 250*sun(t) }},
+	{"RGRND", "WATTS/M**2", func(t time.Time, _, j, i int) float64 { return 850 * sun(t) }},
+	{"GLW", "WATTS/M**2", func(t time.Time, _, j, i int) float64 { return 320 + 10*sun(t) }},
+}
+
+func wind(t time.Time, k int, y, x float64) (u, v float64) {
+	h := 2 * math.Pi * float64(t.Hour()) / 24
+	u = 3 + 2*math.Sin(h) + 0.5*float64(k) + 0.2*x
+	v = 1 + math.Cos(h) + 0.3*float64(k) - 0.1*y
+	return
+}
+
+var metDot = []variable{
+	// C-grid winds at the West and South cell faces.
+	{"UWINDC", "M/S", func(t time.Time, k, j, i int) float64 {
+		u, _ := wind(t, k, float64(j)+0.5, float64(i))
+		return u
+	}},
+	{"VWINDC", "M/S", func(t time.Time, k, j, i int) float64 {
+		_, v := wind(t, k, float64(j), float64(i)+0.5)
+		return v
+	}},
+	// B-grid winds at the cell corners.
+	{"UWIND", "M/S", func(t time.Time, k, j, i int) float64 {
+		u, _ := wind(t, k, float64(j), float64(i))
+		return u
+	}},
+	{"VWIND", "M/S", func(t time.Time, k, j, i int) float64 {
+		_, v := wind(t, k, float64(j), float64(i))
+		return v
+	}},
+}
+
+var grid = []variable{
+	{"LAT", "DEGREES", func(_ time.Time, _, j, i int) float64 { return 40 + 0.1*float64(j) }},
+	{"LON", "DEGREES", func(_ time.Time, _, j, i int) float64 { return -97 + 0.14*float64(i) }},
+	{"HT", "M", func(_ time.Time, _, j, i int) float64 { return 300 + 20*float64(i+j) }},
+	{"DLUSE", "CATEGORY", func(_ time.Time, _, j, i int) float64 {
+		return [][]float64{{1, 7, 11}, {14, 16, 2}}[j][i]
+	}},
+}
+
+// species returns a concentration that varies in time and space and
+// decreases with height.
+func species(base, amplitude, phase float64) func(t time.Time, k, j, i int) float64 {
+	return func(t time.Time, k, j, i int) float64 {
+		h := 2 * math.Pi * float64(t.Hour()) / 24
+		return base * (1 + amplitude*math.Sin(h+phase+float64(i)+0.5*float64(j))) / (1 + 0.3*float64(k))
+	}
+}
+
+var conc = []variable{
+	{"NO", "ppmV", species(0.002, 0.5, 0)},
+	{"NO2", "ppmV", species(0.01, 0.4, 0.3)},
+	{"SO2", "ppmV", species(0.003, 0.3, 0.6)},
+	{"SULF", "ppmV", species(1.e-6, 0.5, 0.9)},
+	{"NH3", "ppmV", species(0.004, 0.3, 1.2)},
+	{"OH", "ppmV", func(t time.Time, k, j, i int) float64 { return 1.e-7*sun(t) + 1.e-9 }},
+	{"H2O2", "ppmV", species(0.001, 0.2, 1.5)},
+	{"TOL", "ppmV", species(5.e-4, 0.4, 1.8)},
+	{"XYLMN", "ppmV", species(3.e-4, 0.4, 2.1)},
+	{"BENZENE", "ppmV", species(4.e-4, 0.3, 2.4)},
+	{"SV_TOL1", "ppmV", species(1.e-5, 0.5, 2.7)},
+	{"SV_XYL1", "ppmV", species(1.e-5, 0.5, 3.0)},
+	{"ISOP", "ppmV", func(t time.Time, k, j, i int) float64 { return (2.e-3*sun(t) + 1.e-4) / (1 + float64(k)) }},
+	{"TERP", "ppmV", species(3.e-4, 0.3, 3.3)},
+	{"SESQ", "ppmV", species(1.e-5, 0.3, 3.6)},
+	{"SV_ISO1", "ppmV", species(2.e-5, 0.6, 3.9)},
+	{"SV_TRP1", "ppmV", species(1.e-5, 0.5, 4.2)},
+	{"ASO4I", "micrograms/m**3", species(0.2, 0.2, 0.1)},
+	{"ASO4J", "micrograms/m**3", species(2.0, 0.3, 0.4)},
+	{"ANO3I", "micrograms/m**3", species(0.05, 0.4, 0.7)},
+	{"ANO3J", "micrograms/m**3", species(0.5, 0.6, 1.0)},
+	{"ANH4I", "micrograms/m**3", species(0.08, 0.3, 1.3)},
+	{"ANH4J", "micrograms/m**3", species(0.9, 0.4, 1.6)},
+	{"ANAJ", "micrograms/m**3", species(0.1, 0.1, 1.9)},
+	{"ACLJ", "micrograms/m**3", species(0.05, 0.1, 2.2)},
+	{"AECJ", "micrograms/m**3", species(0.4, 0.3, 2.5)},
+	{"APOCJ", "micrograms/m**3", species(1.2, 0.3, 2.8)},
+	{"AOTHRJ", "micrograms/m**3", species(0.6, 0.2, 3.1)},
+	{"ATOL1J", "micrograms/m**3", species(0.05, 0.5, 3.4)},
+	{"AXYL1J", "micrograms/m**3", species(0.04, 0.5, 3.7)},
+	{"AOLGAJ", "micrograms/m**3", species(0.1, 0.3, 4.0)},
+	{"AISO1J", "micrograms/m**3", species(0.2, 0.6, 4.3)},
+	{"ATRP1J", "micrograms/m**3", species(0.15, 0.5, 4.6)},
+	{"AOLGBJ", "micrograms/m**3", species(0.3, 0.3, 4.9)},
+	{"AH2OJ", "micrograms/m**3", species(5, 0.5, 5.2)},
+}
+
+// write writes an IOAPI file with nrec hourly records starting at
+// start. If nrec is zero, the file is time-independent. If dot is true,
+// the file is on the dot-point grid.
+func write(fname string, start time.Time, nrec, nlay int, dot bool, vars []variable) {
+	ncol, nrow := nx, ny
+	xorig, yorig := x0, y0
+	if dot {
+		ncol++
+		nrow++
+		xorig -= dx / 2
+		yorig -= dx / 2
+	}
+	h := cdf.NewHeader(
+		[]string{"TSTEP", "DATE-TIME", "LAY", "VAR", "ROW", "COL"},
+		[]int{0, 2, nlay, len(vars), nrow, ncol})
+	h.AddVariable("TFLAG", []string{"TSTEP", "VAR", "DATE-TIME"}, []int32{0})
+	h.AddAttribute("TFLAG", "units", "<YYYYDDD,HHMMSS>")
+	var varList string
+	for _, v := range vars {
+		h.AddVariable(v.name, []string{"TSTEP", "LAY", "ROW", "COL"}, []float32{0})
+		h.AddAttribute(v.name, "long_name", fmt.Sprintf("%-16s", v.name))
+		h.AddAttribute(v.name, "units", fmt.Sprintf("%-16s", v.units))
+		varList += fmt.Sprintf("%-16s", v.name)
+	}
+	var sdate, tstep int32
+	if nrec > 0 {
+		sdate = int32(start.Year()*1000 + start.YearDay())
+		tstep = 10000
+	}
+	h.AddAttribute("", "IOAPI_VERSION", "synthetic test data")
+	h.AddAttribute("", "FTYPE", []int32{1})
+	h.AddAttribute("", "SDATE", []int32{sdate})
+	h.AddAttribute("", "STIME", []int32{0})
+	h.AddAttribute("", "TSTEP", []int32{tstep})
+	h.AddAttribute("", "NTHIK", []int32{1})
+	h.AddAttribute("", "NCOLS", []int32{int32(ncol)})
+	h.AddAttribute("", "NROWS", []int32{int32(nrow)})
+	h.AddAttribute("", "NLAYS", []int32{int32(nlay)})
+	h.AddAttribute("", "NVARS", []int32{int32(len(vars))})
+	h.AddAttribute("", "GDTYP", []int32{2})
+	h.AddAttribute("", "P_ALP", []float64{33})
+	h.AddAttribute("", "P_BET", []float64{45})
+	h.AddAttribute("", "P_GAM", []float64{-97})
+	h.AddAttribute("", "XCENT", []float64{-97})
+	h.AddAttribute("", "YCENT", []float64{40})
+	h.AddAttribute("", "XORIG", []float64{xorig})
+	h.AddAttribute("", "YORIG", []float64{yorig})
+	h.AddAttribute("", "XCELL", []float64{dx})
+	h.AddAttribute("", "YCELL", []float64{dx})
+	h.AddAttribute("", "VGTYP", []int32{7})
+	h.AddAttribute("", "VGTOP", []float32{vgtop})
+	h.AddAttribute("", "VGLVLS", vglvls)
+	h.AddAttribute("", "GDNAM", "SYNTHETIC")
+	h.AddAttribute("", "VAR-LIST", varList)
+	h.Define()
+
+	w, err := os.Create(fname)
+	if err != nil {
+		log.Fatal(err)
+	}
+	f, err := cdf.Create(w, h)
+	if err != nil {
+		log.Fatal(err)
+	}
+	n := nrec
+	if n == 0 {
+		n = 1
+	}
+	for r := 0; r < n; r++ {
+		t := start.Add(time.Duration(r) * time.Hour)
+		tflag := make([]int32, 2*len(vars))
+		if nrec > 0 {
+			for v := range vars {
+				tflag[2*v] = int32(t.Year()*1000 + t.YearDay())
+				tflag[2*v+1] = int32(t.Hour() * 10000)
+			}
+		}
+		if _, err := f.Writer("TFLAG", []int{r, 0, 0}, []int{r + 1, len(vars), 2}).Write(tflag); err != nil {
+			log.Fatal(err)
+		}
+		for _, v := range vars {
+			data := make([]float32, 0, nlay*nrow*ncol)
+			for k := 0; k < nlay; k++ {
+				for j := 0; j < nrow; j++ {
+					for i := 0; i < ncol; i++ {
+						data = append(data, float32(v.f(t, k, j, i)))
+					}
+				}
+			}
+			wr := f.Writer(v.name, []int{r, 0, 0, 0}, []int{r + 1, nlay, nrow, ncol})
+			if _, err := wr.Write(data); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	if err := cdf.UpdateNumRecs(w); err != nil {
+		log.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,413 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/ctessum/geom"
+	"github.com/ctessum/geom/encoding/geojson"
+	"github.com/ctessum/sparse"
+	"gonum.org/v1/plot/palette/moreland"
+)
+
+// ctmDataRange is the physically plausible range of a CTMData variable.
+type ctmDataRange struct {
+	min, max     float64
+	minExclusive bool // whether values equal to min are invalid.
+}
+
+// check returns a description of the problem with v, or
+// an empty string if v is valid.
+func (r ctmDataRange) check(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 0):
+		return "infinite"
+	case r.minExclusive && v <= r.min:
+		return fmt.Sprintf("<= %g", r.min)
+	case v < r.min:
+		return fmt.Sprintf("< %g", r.min)
+	case v > r.max:
+		return fmt.Sprintf("> %g", r.max)
+	}
+	return ""
+}
+
+var (
+	nonNegative = ctmDataRange{min: 0, max: math.Inf(1)}
+	positive    = ctmDataRange{min: 0, max: math.Inf(1), minExclusive: true}
+	fraction    = ctmDataRange{min: 0, max: 1}
+	velocity    = ctmDataRange{min: -200, max: 200}
+	finite      = ctmDataRange{min: math.Inf(-1), max: math.Inf(1)}
+)
+
+// ctmDataRanges holds the physically plausible ranges of the
+// variables created by Preprocess. Variables that are not listed
+// here are only checked for NaN and infinite values.
+var ctmDataRanges = map[string]ctmDataRange{
+	"UAvg":                       velocity,
+	"VAvg":                       velocity,
+	"WAvg":                       ctmDataRange{min: -10, max: 10},
+	"UDeviation":                 ctmDataRange{min: 0, max: 200},
+	"VDeviation":                 ctmDataRange{min: 0, max: 200},
+	"aOrgPartitioning":           fraction,
+	"aVOC":                       nonNegative,
+	"aSOA":                       nonNegative,
+	"bOrgPartitioning":           fraction,
+	"bVOC":                       nonNegative,
+	"bSOA":                       nonNegative,
+	"NOPartitioning":             fraction,
+	"gNO":                        nonNegative,
+	"pNO":                        nonNegative,
+	"SPartitioning":              fraction,
+	"gS":                         nonNegative,
+	"pS":                         nonNegative,
+	"NHPartitioning":             fraction,
+	"gNH":                        nonNegative,
+	"pNH":                        nonNegative,
+	"SO2oxidation":               nonNegative,
+	"ParticleDryDep":             nonNegative,
+	"SO2DryDep":                  nonNegative,
+	"NOxDryDep":                  nonNegative,
+	"NH3DryDep":                  nonNegative,
+	"VOCDryDep":                  nonNegative,
+	"Kxxyy":                      nonNegative,
+	"LayerHeights":               nonNegative,
+	"Dz":                         positive,
+	"ParticleWetDep":             nonNegative,
+	"SO2WetDep":                  nonNegative,
+	"OtherGasWetDep":             nonNegative,
+	"Kzz":                        nonNegative,
+	"M2u":                        nonNegative,
+	"M2d":                        nonNegative,
+	"Pblh":                       nonNegative,
+	"WindSpeed":                  positive,
+	"WindSpeedInverse":           positive,
+	"WindSpeedMinusThird":        positive,
+	"WindSpeedMinusOnePointFour": positive,
+	"Temperature":                ctmDataRange{min: 150, max: 350},
+	"Sclass":                     fraction,
+	"alt":                        positive,
+	"TotalPM25":                  nonNegative,
+}
+
+// ctmDataRangeFor returns the plausible range of the named variable.
+func ctmDataRangeFor(name string) ctmDataRange {
+	if r, ok := ctmDataRanges[name]; ok {
+		return r
+	}
+	return finite
+}
+
+// maxIssueLocations is the maximum number of locations stored
+// for each CTMDataIssue.
+const maxIssueLocations = 10
+
+// CTMDataIssue describes values of a CTMData variable that are
+// outside of the physically plausible range for that variable.
+type CTMDataIssue struct {
+	Variable string
+
+	// Problem describes the problem, e.g., "NaN" or "< 0".
+	Problem string
+
+	// Count is the number of grid cells with the problem.
+	Count int
+
+	// Locations holds the [layer, row, column] indices of up to
+	// 10 of the grid cells with the problem. Layer is
+	// always zero for two-dimensional variables.
+	Locations [][3]int
+}
+
+func (i CTMDataIssue) String() string {
+	locs := make([]string, len(i.Locations))
+	for j, l := range i.Locations {
+		locs[j] = fmt.Sprintf("(%d, %d, %d)", l[0], l[1], l[2])
+	}
+	more := ""
+	if i.Count > len(i.Locations) {
+		more = ", ..."
+	}
+	return fmt.Sprintf("%s: %d values %s at [layer, row, column] %s%s",
+		i.Variable, i.Count, i.Problem, strings.Join(locs, ", "), more)
+}
+
+// sortedNames returns the names of the variables in d in alphabetical order.
+func (d *CTMData) sortedNames() []string {
+	names := make([]string, 0, len(d.Data))
+	for n := range d.Data {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// layers returns the number of layers in a, and a function
+// to get the value at layer k, row j, and column i.
+func layers(a *sparse.DenseArray) (int, func(k, j, i int) float64) {
+	if len(a.Shape) == 2 {
+		return 1, func(_, j, i int) float64 { return a.Get(j, i) }
+	}
+	return a.Shape[0], func(k, j, i int) float64 { return a.Get(k, j, i) }
+}
+
+// Check checks the values of each variable in d against the
+// physically plausible range for that variable, and returns any
+// problems that are found, which could cause errors or incorrect results
+// when d is used in a simulation. NaN and infinite values are always
+// considered to be problems.
+func (d *CTMData) Check() []CTMDataIssue {
+	var issues []CTMDataIssue
+	for _, name := range d.sortedNames() {
+		a := d.Data[name].Data
+		r := ctmDataRangeFor(name)
+		nz, get := layers(a)
+		ny, nx := a.Shape[len(a.Shape)-2], a.Shape[len(a.Shape)-1]
+		index := make(map[string]int)
+		for k := 0; k < nz; k++ {
+			for j := 0; j < ny; j++ {
+				for i := 0; i < nx; i++ {
+					p := r.check(get(k, j, i))
+					if p == "" {
+						continue
+					}
+					ii, ok := index[p]
+					if !ok {
+						ii = len(issues)
+						index[p] = ii
+						issues = append(issues, CTMDataIssue{Variable: name, Problem: p})
+					}
+					issues[ii].Count++
+					if len(issues[ii].Locations) < maxIssueLocations {
+						issues[ii].Locations = append(issues[ii].Locations, [3]int{k, j, i})
+					}
+				}
+			}
+		}
+	}
+	return issues
+}
+
+// CTMDataLayerStats holds summary statistics for one layer of
+// a CTMData variable.
+type CTMDataLayerStats struct {
+	Variable       string
+	Layer          int
+	Min, Mean, Max float64
+
+	// Invalid is the number of values outside of the plausible range
+	// for the variable. NaN and infinite values are not included
+	// in the other statistics.
+	Invalid int
+}
+
+// LayerStats returns summary statistics for each layer of each
+// variable in d. Two-dimensional variables have a single layer.
+func (d *CTMData) LayerStats() []CTMDataLayerStats {
+	var stats []CTMDataLayerStats
+	for _, name := range d.sortedNames() {
+		a := d.Data[name].Data
+		r := ctmDataRangeFor(name)
+		nz, get := layers(a)
+		ny, nx := a.Shape[len(a.Shape)-2], a.Shape[len(a.Shape)-1]
+		for k := 0; k < nz; k++ {
+			s := CTMDataLayerStats{Variable: name, Layer: k, Min: math.Inf(1), Max: math.Inf(-1)}
+			var n int
+			for j := 0; j < ny; j++ {
+				for i := 0; i < nx; i++ {
+					v := get(k, j, i)
+					if r.check(v) != "" {
+						s.Invalid++
+					}
+					if math.IsNaN(v) || math.IsInf(v, 0) {
+						continue
+					}
+					s.Min = math.Min(s.Min, v)
+					s.Max = math.Max(s.Max, v)
+					s.Mean += v
+					n++
+				}
+			}
+			if n == 0 {
+				s.Min, s.Mean, s.Max = math.NaN(), math.NaN(), math.NaN()
+			} else {
+				s.Mean /= float64(n)
+			}
+			stats = append(stats, s)
+		}
+	}
+	return stats
+}
+
+// invalidColor is the color of invalid values in images created by LayerPNG.
+var invalidColor = color.RGBA{R: 255, G: 0, B: 255, A: 255}
+
+// LayerPNG writes a map of the given layer of the named variable
+// in d to w in PNG format. Values are colored from blue (minimum)
+// to red (maximum), and values outside of the plausible range for the variable
+// are colored magenta. Each grid cell is represented by a square
+// block of pixels, with the first row of the grid at the bottom of the image.
+func (d *CTMData) LayerPNG(w io.Writer, name string, layer int) error {
+	dd, ok := d.Data[name]
+	if !ok {
+		return fmt.Errorf("inmap: CTM data does not contain variable %s", name)
+	}
+	a := dd.Data
+	r := ctmDataRangeFor(name)
+	nz, get := layers(a)
+	if layer < 0 || layer >= nz {
+		return fmt.Errorf("inmap: variable %s does not have layer %d", name, layer)
+	}
+	ny, nx := a.Shape[len(a.Shape)-2], a.Shape[len(a.Shape)-1]
+
+	cmap := moreland.SmoothBlueRed()
+	min, max := math.Inf(1), math.Inf(-1)
+	for j := 0; j < ny; j++ {
+		for i := 0; i < nx; i++ {
+			if v := get(layer, j, i); r.check(v) == "" {
+				min, max = math.Min(min, v), math.Max(max, v)
+			}
+		}
+	}
+	if min == max {
+		// Avoid a zero-width color scale.
+		max = min + 1
+	}
+	if !math.IsInf(min, 0) {
+		cmap.SetMax(max)
+		cmap.SetMin(min)
+	}
+
+	// Scale the image so that it is at least 500 pixels wide or tall.
+	scale := 500 / nx
+	if s := 500 / ny; s < scale {
+		scale = s
+	}
+	if scale < 1 {
+		scale = 1
+	}
+	img := image.NewRGBA(image.Rect(0, 0, nx*scale, ny*scale))
+	for j := 0; j < ny; j++ {
+		for i := 0; i < nx; i++ {
+			v := get(layer, j, i)
+			var c color.Color = invalidColor
+			if r.check(v) == "" {
+				var err error
+				if c, err = cmap.At(v); err != nil {
+					return fmt.Errorf("inmap: coloring %s: %v", name, err)
+				}
+			}
+			for py := (ny - j - 1) * scale; py < (ny-j)*scale; py++ {
+				for px := i * scale; px < (i+1)*scale; px++ {
+					img.Set(px, py, c)
+				}
+			}
+		}
+	}
+	return png.Encode(w, img)
+}
+
+// LayerGeoJSON writes the given layer of d to w as a GeoJSON
+// FeatureCollection. Each feature is a CTM grid cell polygon in the native
+// spatial reference of the CTM grid, with the values of each variable at the cell
+// as properties. Values of variables on staggered grids are averaged
+// to the cell centers, and two-dimensional variables are included in every layer.
+// Values outside of the plausible range for each variable are
+// counted in the "Problems" property, and NaN and infinite values are null.
+func (d *CTMData) LayerGeoJSON(w io.Writer, layer int) error {
+	if _, ok := d.Data["Dz"]; !ok {
+		return fmt.Errorf("inmap: CTM data is missing variable `Dz`")
+	}
+	if nz := d.Data["Dz"].Data.Shape[0]; layer < 0 || layer >= nz {
+		return fmt.Errorf("inmap: CTM data does not have layer %d", layer)
+	}
+	type feature struct {
+		Type       string                 `json:"type"`
+		Geometry   *geojson.Geometry      `json:"geometry"`
+		Properties map[string]interface{} `json:"properties"`
+	}
+	fc := struct {
+		Type     string     `json:"type"`
+		Features []*feature `json:"features"`
+	}{Type: "FeatureCollection"}
+
+	names := d.sortedNames()
+	for j := 0; j < d.ny; j++ {
+		for i := 0; i < d.nx; i++ {
+			x0, y0 := d.xo+float64(i)*d.dx, d.yo+float64(j)*d.dy
+			g, err := geojson.ToGeoJSON(geom.Polygon{{
+				{X: x0, Y: y0}, {X: x0 + d.dx, Y: y0}, {X: x0 + d.dx, Y: y0 + d.dy},
+				{X: x0, Y: y0 + d.dy}, {X: x0, Y: y0},
+			}})
+			if err != nil {
+				return err
+			}
+			f := &feature{
+				Type:       "Feature",
+				Geometry:   g,
+				Properties: map[string]interface{}{"Row": j, "Column": i},
+			}
+			var problems int
+			for _, name := range names {
+				v := d.cellCenterValue(name, layer, j, i)
+				if ctmDataRangeFor(name).check(v) != "" {
+					problems++
+				}
+				if math.IsNaN(v) || math.IsInf(v, 0) {
+					f.Properties[name] = nil
+				} else {
+					f.Properties[name] = v
+				}
+			}
+			f.Properties["Problems"] = problems
+			fc.Features = append(fc.Features, f)
+		}
+	}
+	return json.NewEncoder(w).Encode(fc)
+}
+
+// cellCenterValue returns the value of the named variable at layer k, row j
+// and column i of the unstaggered grid, averaging values on staggered grids.
+func (d *CTMData) cellCenterValue(name string, k, j, i int) float64 {
+	a := d.Data[name].Data
+	if len(a.Shape) == 2 {
+		return a.Get(j, i)
+	}
+	nz, ny, nx := d.Data["Dz"].Data.Shape[0], d.ny, d.nx
+	switch {
+	case a.Shape[0] > nz:
+		return (a.Get(k, j, i) + a.Get(k+1, j, i)) / 2
+	case a.Shape[1] > ny:
+		return (a.Get(k, j, i) + a.Get(k, j+1, i)) / 2
+	case a.Shape[2] > nx:
+		return (a.Get(k, j, i) + a.Get(k, j, i+1)) / 2
+	}
+	return a.Get(k, j, i)
+}
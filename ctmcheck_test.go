@@ -0,0 +1,131 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"image/png"
+	"math"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func loadWRFChemGolden(t *testing.T) *CTMData {
+	f, err := os.Open("cmd/inmap/testdata/preproc/inmapData_WRFChem_golden.ncf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	d, err := (&VarGridConfig{}).LoadCTMData(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestCTMDataCheck(t *testing.T) {
+	d := loadWRFChemGolden(t)
+	if issues := d.Check(); len(issues) != 0 {
+		t.Fatalf("golden data should not have problems: %v", issues)
+	}
+
+	d.Data["Kzz"].Data.Set(math.NaN(), 0, 1, 0)
+	d.Data["Kzz"].Data.Set(-1, 1, 0, 1)
+	d.Data["Kzz"].Data.Set(-2, 2, 1, 1)
+	d.Data["Pblh"].Data.Set(math.Inf(1), 1, 0)
+	d.Data["WindSpeed"].Data.Elements[0] = 0
+	want := []CTMDataIssue{
+		{Variable: "Kzz", Problem: "NaN", Count: 1, Locations: [][3]int{{0, 1, 0}}},
+		{Variable: "Kzz", Problem: "< 0", Count: 2, Locations: [][3]int{{1, 0, 1}, {2, 1, 1}}},
+		{Variable: "Pblh", Problem: "infinite", Count: 1, Locations: [][3]int{{0, 1, 0}}},
+		{Variable: "WindSpeed", Problem: "<= 0", Count: 1, Locations: [][3]int{{0, 0, 0}}},
+	}
+	have := d.Check()
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	if s, w := have[1].String(), "Kzz: 2 values < 0 at [layer, row, column] (1, 0, 1), (2, 1, 1)"; s != w {
+		t.Errorf("have %q, want %q", s, w)
+	}
+
+	for _, s := range d.LayerStats() {
+		if s.Variable != "Kzz" || s.Layer != 0 {
+			continue
+		}
+		if s.Invalid != 1 {
+			t.Errorf("invalid: have %d, want 1", s.Invalid)
+		}
+		if math.IsNaN(s.Mean) || s.Min > s.Mean || s.Mean > s.Max {
+			t.Errorf("invalid statistics: %+v", s)
+		}
+	}
+
+	t.Run("png", func(t *testing.T) {
+		b := new(bytes.Buffer)
+		if err := d.LayerPNG(b, "Kzz", 0); err != nil {
+			t.Fatal(err)
+		}
+		img, err := png.Decode(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scale := img.Bounds().Dx() / d.nx
+		if img.Bounds().Dy() != d.ny*scale {
+			t.Errorf("image size %v is wrong for %d by %d grid", img.Bounds(), d.nx, d.ny)
+		}
+		// The NaN value at row 1, column 0 should be magenta.
+		r, g, b2, _ := img.At(0, (d.ny-2)*scale).RGBA()
+		if r != 0xffff || g != 0 || b2 != 0xffff {
+			t.Errorf("invalid value color: %d, %d, %d", r, g, b2)
+		}
+		if err := d.LayerPNG(b, "Kzz", 100); err == nil {
+			t.Error("invalid layer should cause an error")
+		}
+		if err := d.LayerPNG(b, "xxx", 0); err == nil {
+			t.Error("invalid variable should cause an error")
+		}
+	})
+
+	t.Run("geojson", func(t *testing.T) {
+		b := new(bytes.Buffer)
+		if err := d.LayerGeoJSON(b, 0); err != nil {
+			t.Fatal(err)
+		}
+		var fc struct {
+			Features []struct {
+				Properties map[string]interface{}
+			}
+		}
+		if err := json.Unmarshal(b.Bytes(), &fc); err != nil {
+			t.Fatal(err)
+		}
+		if len(fc.Features) != d.nx*d.ny {
+			t.Fatalf("have %d features, want %d", len(fc.Features), d.nx*d.ny)
+		}
+		p := fc.Features[d.nx].Properties
+		if p["Kzz"] != nil || p["Problems"] != 2.0 {
+			t.Errorf("Kzz = %v, Problems = %v", p["Kzz"], p["Problems"])
+		}
+		if u, want := p["UAvg"].(float64), (d.Data["UAvg"].Data.Get(0, 1, 0)+d.Data["UAvg"].Data.Get(0, 1, 1))/2; different(u, want, 1.0e-10) {
+			t.Errorf("UAvg: have %g, want %g", u, want)
+		}
+	})
+}
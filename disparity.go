@@ -0,0 +1,233 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/stat"
+)
+
+// DisparityPercentiles are the population-weighted exposure percentiles
+// calculated for each population group by Disparities.
+var DisparityPercentiles = []float64{5, 25, 50, 75, 95}
+
+// AtkinsonEpsilon is the inequality aversion parameter used to calculate
+// the Atkinson index.
+const AtkinsonEpsilon = 0.75
+
+// Disparity holds exposure disparity metrics for a single population
+// group and output variable.
+type Disparity struct {
+	// Variable is the name of the output variable that exposure is
+	// calculated for.
+	Variable string
+
+	// Group is the name of the population group.
+	Group string
+
+	// Population is the total number of people in the group.
+	Population float64
+
+	// Mean is the population-weighted mean exposure of the group.
+	Mean float64
+
+	// Ratio and Difference are the ratio of and the difference between
+	// Mean and the population-weighted mean exposure of the total population.
+	Ratio, Difference float64
+
+	// Atkinson and Theil are the Atkinson index (with inequality
+	// aversion parameter AtkinsonEpsilon) and Theil index of the distribution
+	// of exposure within the group. They are NaN if any of the exposure
+	// values where the group lives are negative.
+	Atkinson, Theil float64
+
+	// Percentiles are the population-weighted exposure percentiles
+	// corresponding to DisparityPercentiles.
+	Percentiles []float64
+}
+
+// Disparities calculates exposure disparity metrics for each of the
+// given ground-level output variables, which must be calculated by o,
+// and each of the given population groups in d.PopIndices. Differences
+// and ratios are calculated relative to population group totalGroup.
+func (d *InMAP) Disparities(o *Outputter, groups []string, totalGroup string, vars ...string) ([]*Disparity, error) {
+	for _, g := range append([]string{totalGroup}, groups...) {
+		if _, ok := d.PopIndices[g]; !ok {
+			return nil, fmt.Errorf("inmap: calculating disparities: undefined population group '%s'", g)
+		}
+	}
+	results, err := d.Results(o)
+	if err != nil {
+		return nil, err
+	}
+	pop := make(map[string][]float64)
+	for _, g := range append([]string{totalGroup}, groups...) {
+		pop[g] = d.toArray(g, 0, o.m)
+	}
+	var out []*Disparity
+	for _, v := range vars {
+		r, ok := results[v]
+		if !ok {
+			return nil, fmt.Errorf("inmap: calculating disparities: undefined output variable '%s'", v)
+		}
+		x := r[:len(pop[totalGroup])] // Only ground-level cells have people.
+		total := disparity(v, totalGroup, x, pop[totalGroup], math.NaN())
+		out = append(out, total)
+		for _, g := range groups {
+			if g == totalGroup {
+				continue
+			}
+			out = append(out, disparity(v, g, x, pop[g], total.Mean))
+		}
+	}
+	return out, nil
+}
+
+// disparity calculates exposure disparity metrics for population p
+// exposed to values x, where totalMean is the mean exposure of the
+// total population, or NaN if p is the total population.
+func disparity(variable, group string, x, p []float64, totalMean float64) *Disparity {
+	o := &Disparity{
+		Variable:    variable,
+		Group:       group,
+		Population:  floats.Sum(p),
+		Mean:        stat.Mean(x, p),
+		Percentiles: make([]float64, len(DisparityPercentiles)),
+	}
+	if math.IsNaN(totalMean) {
+		totalMean = o.Mean
+	}
+	o.Ratio = o.Mean / totalMean
+	o.Difference = o.Mean - totalMean
+
+	// Atkinson and Theil indices.
+	var negative bool
+	var aSum, tSum float64
+	for i, xi := range x {
+		if p[i] == 0 {
+			continue
+		}
+		if xi < 0 {
+			negative = true
+			break
+		}
+		aSum += p[i] * math.Pow(xi, 1-AtkinsonEpsilon)
+		if xi > 0 {
+			tSum += p[i] * xi / o.Mean * math.Log(xi/o.Mean)
+		}
+	}
+	switch {
+	case negative:
+		o.Atkinson, o.Theil = math.NaN(), math.NaN()
+	case o.Mean == 0:
+		o.Atkinson, o.Theil = 0, 0
+	default:
+		o.Atkinson = 1 - math.Pow(aSum/o.Population, 1/(1-AtkinsonEpsilon))/o.Mean
+		o.Theil = tSum / o.Population
+	}
+
+	// Population-weighted percentiles.
+	xs := make([]float64, 0, len(x))
+	ps := make([]float64, 0, len(x))
+	for i := range x {
+		if p[i] > 0 {
+			xs = append(xs, x[i])
+			ps = append(ps, p[i])
+		}
+	}
+	if len(xs) == 0 {
+		for i := range o.Percentiles {
+			o.Percentiles[i] = math.NaN()
+		}
+		return o
+	}
+	sort.Sort(byValue{x: xs, w: ps})
+	for i, q := range DisparityPercentiles {
+		o.Percentiles[i] = stat.Quantile(q/100, stat.Empirical, xs, ps)
+	}
+	return o
+}
+
+// byValue sorts values x and weights w by x.
+type byValue struct{ x, w []float64 }
+
+func (b byValue) Len() int           { return len(b.x) }
+func (b byValue) Less(i, j int) bool { return b.x[i] < b.x[j] }
+func (b byValue) Swap(i, j int) {
+	b.x[i], b.x[j] = b.x[j], b.x[i]
+	b.w[i], b.w[j] = b.w[j], b.w[i]
+}
+
+// DisparityOutput returns a function that calculates exposure disparity
+// metrics using Disparities and writes them to a CSV file.
+func (o *Outputter) DisparityOutput(fileName string, groups []string, totalGroup string, vars ...string) DomainManipulator {
+	return func(d *InMAP) error {
+		if len(vars) == 0 {
+			return nil
+		}
+		disparities, err := d.Disparities(o, groups, totalGroup, vars...)
+		if err != nil {
+			return err
+		}
+		f, err := os.Create(fileName)
+		if err != nil {
+			return fmt.Errorf("inmap: creating disparity output file: %v", err)
+		}
+		if err := WriteDisparities(f, disparities); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+}
+
+// WriteDisparities writes the given disparity metrics to w in CSV format.
+func WriteDisparities(w io.Writer, disparities []*Disparity) error {
+	cw := csv.NewWriter(w)
+	header := []string{"Variable", "Group", "Population", "Mean", "Ratio", "Difference", "Atkinson", "Theil"}
+	for _, p := range DisparityPercentiles {
+		header = append(header, "P"+strconv.FormatFloat(p, 'f', -1, 64))
+	}
+	if err := cw.Write(header); err != nil {
+		return fmt.Errorf("inmap: writing disparities: %v", err)
+	}
+	f := func(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+	for _, d := range disparities {
+		line := []string{d.Variable, d.Group, f(d.Population), f(d.Mean), f(d.Ratio), f(d.Difference), f(d.Atkinson), f(d.Theil)}
+		for _, p := range d.Percentiles {
+			line = append(line, f(p))
+		}
+		if err := cw.Write(line); err != nil {
+			return fmt.Errorf("inmap: writing disparities: %v", err)
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("inmap: writing disparities: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDisparity(t *testing.T) {
+	x := []float64{1, 2, 4}
+	total := disparity("x", "total", x, []float64{1, 1, 2}, math.NaN())
+	group := disparity("x", "group", x, []float64{0, 1, 1}, total.Mean)
+
+	want := &Disparity{
+		Variable:    "x",
+		Group:       "group",
+		Population:  2,
+		Mean:        3,
+		Ratio:       3 / 2.75,
+		Difference:  0.25,
+		Atkinson:    0.04294661848836778,
+		Theil:       0.056633012265132426,
+		Percentiles: []float64{2, 2, 2, 4, 4},
+	}
+	if total.Mean != 2.75 || total.Ratio != 1 || total.Difference != 0 {
+		t.Errorf("total: %+v", total)
+	}
+	if group.Variable != want.Variable || group.Group != want.Group ||
+		group.Population != want.Population || !reflect.DeepEqual(group.Percentiles, want.Percentiles) {
+		t.Errorf("have %+v, want %+v", group, want)
+	}
+	for _, v := range [][2]float64{
+		{group.Mean, want.Mean},
+		{group.Ratio, want.Ratio},
+		{group.Difference, want.Difference},
+		{group.Atkinson, want.Atkinson},
+		{group.Theil, want.Theil},
+	} {
+		if different(v[0], v[1], 1.e-10) {
+			t.Errorf("have %g, want %g", v[0], v[1])
+		}
+	}
+
+	negative := disparity("x", "group", []float64{-1, 2, 4}, []float64{1, 1, 1}, 1)
+	if !math.IsNaN(negative.Atkinson) || !math.IsNaN(negative.Theil) {
+		t.Errorf("negative values should give NaN inequality indices: %+v", negative)
+	}
+}
+
+func TestDisparities(t *testing.T) {
+	cfg, ctmdata, pop, popIndices, mr, mortIndices := VarGridTestData()
+	m := Mech{}
+	d := &InMAP{
+		InitFuncs: []DomainManipulator{
+			cfg.RegularGrid(ctmdata, pop, popIndices, mr, mortIndices, NewEmissions(), m),
+		},
+	}
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range d.cells.array() {
+		c.Cf[iPM2_5] = float64(i + 1)
+	}
+	o, err := NewOutputter("", false, map[string]string{
+		"TotalPM25": "TotalPM25",
+		"Wind":      "WindSpeed",
+	}, nil, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	disparities, err := d.Disparities(o, cfg.CensusPopColumns, "TotalPop", "TotalPM25", "Wind")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(disparities) != 2*len(cfg.CensusPopColumns) {
+		t.Fatalf("have %d disparities, want %d", len(disparities), 2*len(cfg.CensusPopColumns))
+	}
+	for _, dd := range disparities {
+		if dd.Group == "TotalPop" && (dd.Ratio != 1 || dd.Difference != 0) {
+			t.Errorf("total population should have ratio 1 and difference 0: %+v", dd)
+		}
+		if dd.Population > 0 && !(dd.Percentiles[0] <= dd.Mean && dd.Mean <= dd.Percentiles[len(dd.Percentiles)-1]) {
+			t.Errorf("mean should be between the lowest and highest percentiles: %+v", dd)
+		}
+	}
+
+	if _, err = d.Disparities(o, []string{"xxx"}, "TotalPop", "TotalPM25"); err == nil {
+		t.Error("invalid population group should cause an error")
+	}
+	if _, err = d.Disparities(o, cfg.CensusPopColumns, "TotalPop", "xxx"); err == nil {
+		t.Error("invalid variable should cause an error")
+	}
+
+	b := new(bytes.Buffer)
+	if err := WriteDisparities(b, disparities); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != len(disparities)+1 {
+		t.Errorf("have %d lines, want %d", len(lines), len(disparities)+1)
+	}
+	const header = "Variable,Group,Population,Mean,Ratio,Difference,Atkinson,Theil,P5,P25,P50,P75,P95"
+	if lines[0] != header {
+		t.Errorf("header: have %s, want %s", lines[0], header)
+	}
+}
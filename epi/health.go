@@ -20,7 +20,9 @@ along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
 package epi
 
 import (
+	"fmt"
 	"math"
+	"sort"
 
 	"github.com/gonum/floats"
 )
@@ -131,6 +133,27 @@ type HRer interface {
 	Name() string
 }
 
+// hrs holds the built-in hazard ratio functions, keyed by name.
+var hrs = map[string]HRer{
+	NasariACS.Name():           NasariACS,
+	Krewski2009.Name():         Krewski2009,
+	Krewski2009Ecologic.Name(): Krewski2009Ecologic,
+	Lepeule2012.Name():         Lepeule2012,
+}
+
+// HRByName returns the built-in hazard ratio function with the given name.
+func HRByName(name string) (HRer, error) {
+	if hr, ok := hrs[name]; ok {
+		return hr, nil
+	}
+	names := make([]string, 0, len(hrs))
+	for n := range hrs {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("epi: invalid hazard ratio function '%s'; valid options are %v", name, names)
+}
+
 // IoRegional returns the underlying regional average incidence rate for a region where
 // the reported incidence rate is I, individual locations within the
 // region have population p and concentration z, and hr specifies the
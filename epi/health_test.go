@@ -139,3 +139,18 @@ func TestKrewski2009(t *testing.T) {
 		t.Errorf("for z=%g: %g != %g", 15.0, c, cWant)
 	}
 }
+
+func TestHRByName(t *testing.T) {
+	for _, want := range []HRer{NasariACS, Krewski2009, Krewski2009Ecologic, Lepeule2012} {
+		have, err := HRByName(want.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have.Name() != want.Name() || have.HR(15) != want.HR(15) {
+			t.Errorf("%s: have %s", want.Name(), have.Name())
+		}
+	}
+	if _, err := HRByName("xxx"); err == nil {
+		t.Error("invalid name should cause an error")
+	}
+}
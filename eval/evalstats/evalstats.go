@@ -0,0 +1,154 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package evalstats calculates statistics for evaluating model
+// predictions against reference values, such as measurements or the
+// results of a more detailed model.
+package evalstats
+
+import (
+	"math"
+
+	"github.com/GaryBoone/GoStats/stats"
+)
+
+// MB returns the mean bias of the modeled values m relative to the
+// reference values o. If o is empty, NaN is returned.
+func MB(o, m []float64) float64 {
+	if len(o) == 0 {
+		return math.NaN()
+	}
+	r := 0.
+	for i, v1 := range o {
+		v2 := m[i]
+		r += (v2 - v1)
+	}
+	return r / float64(len(o))
+}
+
+// ME returns the mean error of the modeled values m relative to the
+// reference values o. If o is empty, NaN is returned.
+func ME(o, m []float64) float64 {
+	if len(o) == 0 {
+		return math.NaN()
+	}
+	r := 0.
+	for i, v1 := range o {
+		v2 := m[i]
+		r += math.Abs(v2 - v1)
+	}
+	return r / float64(len(o))
+}
+
+// MFB returns the mean fractional bias of the modeled values m relative
+// to the reference values o. The fractional bias is undefined for pairs
+// of values that sum to zero, so the result is NaN if there are any such
+// pairs. If o is empty, NaN is returned.
+func MFB(o, m []float64) float64 {
+	if len(o) == 0 {
+		return math.NaN()
+	}
+	r := 0.
+	for i, v1 := range o {
+		v2 := m[i]
+		r += 2 * (v2 - v1) / (v1 + v2)
+	}
+	return r / float64(len(o))
+}
+
+// MFE returns the mean fractional error of the modeled values m relative
+// to the reference values o. The fractional error is undefined for pairs
+// of values that sum to zero, so the result is NaN if there are any such
+// pairs. If o is empty, NaN is returned.
+func MFE(o, m []float64) float64 {
+	if len(o) == 0 {
+		return math.NaN()
+	}
+	r := 0.
+	for i, v1 := range o {
+		v2 := m[i]
+		r += 2 * math.Abs(v2-v1) / math.Abs(v1+v2)
+	}
+	return r / float64(len(o))
+}
+
+// MFBNonZero is like MFB, but pairs of values that sum to zero are
+// ignored rather than causing the result to be NaN. This is useful when
+// comparing fields, such as model concentrations, that can be zero in some
+// locations. If there are no pairs that don't sum to zero, NaN is returned.
+func MFBNonZero(o, m []float64) float64 {
+	r := 0.
+	var n int
+	for i, v1 := range o {
+		v2 := m[i]
+		if v1+v2 == 0 {
+			continue
+		}
+		r += 2 * (v2 - v1) / (v1 + v2)
+		n++
+	}
+	if n == 0 {
+		return math.NaN()
+	}
+	return r / float64(n)
+}
+
+// MFENonZero is like MFE, but pairs of values that sum to zero are
+// ignored in the same way as in MFBNonZero.
+func MFENonZero(o, m []float64) float64 {
+	r := 0.
+	var n int
+	for i, v1 := range o {
+		v2 := m[i]
+		if v1+v2 == 0 {
+			continue
+		}
+		r += 2 * math.Abs(v2-v1) / math.Abs(v1+v2)
+		n++
+	}
+	if n == 0 {
+		return math.NaN()
+	}
+	return r / float64(n)
+}
+
+// Stats holds statistics describing how well modeled values
+// match reference values.
+type Stats struct {
+	// MB, ME, MFB, and MFE are the mean bias, mean error, mean
+	// fractional bias, and mean fractional error.
+	MB, ME, MFB, MFE float64
+
+	// Slope, Intercept, and R2 are the slope, intercept, and
+	// coefficient of determination of a linear regression
+	// of the modeled values against the reference values.
+	Slope, Intercept, R2 float64
+}
+
+// Calculate returns statistics describing how well the modeled values m
+// match the reference values o.
+func Calculate(o, m []float64) *Stats {
+	s := &Stats{
+		MB:  MB(o, m),
+		ME:  ME(o, m),
+		MFB: MFB(o, m),
+		MFE: MFE(o, m),
+	}
+	s.Slope, s.Intercept, s.R2, _, _, _ = stats.LinearRegression(o, m)
+	return s
+}
@@ -0,0 +1,89 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package evalstats
+
+import (
+	"math"
+	"testing"
+
+	"gonum.org/v1/gonum/floats"
+)
+
+func TestCalculate(t *testing.T) {
+	o := []float64{1, 2, 3, 1}
+	m := []float64{2, 2, 6, 1}
+	s := Calculate(o, m)
+	want := &Stats{
+		MB:    1,                                              // (1 + 0 + 3 + 0) / 4
+		ME:    1,                                              // (1 + 0 + 3 + 0) / 4
+		MFB:   (2./3 + 0 + 2./3 + 0) / 4,                      // Each pair is included.
+		MFE:   (2./3 + 0 + 2./3 + 0) / 4,                      // Each pair is included.
+		Slope: 23. / 11, Intercept: -10. / 11, R2: 529. / 649, // Sxy=5.75, Sxx=2.75, Syy=14.75
+	}
+	for _, x := range []struct {
+		name       string
+		have, want float64
+	}{
+		{"MB", s.MB, want.MB},
+		{"ME", s.ME, want.ME},
+		{"MFB", s.MFB, want.MFB},
+		{"MFE", s.MFE, want.MFE},
+		{"Slope", s.Slope, want.Slope},
+		{"Intercept", s.Intercept, want.Intercept},
+		{"R2", s.R2, want.R2},
+	} {
+		if !floats.EqualWithinAbsOrRel(x.have, x.want, 1.e-10, 1.e-10) {
+			t.Errorf("%s: %g != %g", x.name, x.have, x.want)
+		}
+	}
+}
+
+func TestUndefined(t *testing.T) {
+	for name, f := range map[string]func(o, m []float64) float64{"MB": MB, "ME": ME, "MFB": MFB, "MFE": MFE} {
+		if v := f(nil, nil); !math.IsNaN(v) {
+			t.Errorf("%s of no values: %g != NaN", name, v)
+		}
+	}
+	o := []float64{1, 0}
+	m := []float64{2, 0}
+	for name, f := range map[string]func(o, m []float64) float64{"MFB": MFB, "MFE": MFE} {
+		if v := f(o, m); !math.IsNaN(v) {
+			t.Errorf("%s with values that sum to zero: %g != NaN", name, v)
+		}
+	}
+}
+
+func TestNonZero(t *testing.T) {
+	o := []float64{1, 2, 3, 0}
+	m := []float64{2, 2, 6, 0}
+	want := (2./3 + 0 + 2./3) / 3 // The last pair is ignored.
+	if v := MFBNonZero(o, m); !floats.EqualWithinAbsOrRel(v, want, 1.e-10, 1.e-10) {
+		t.Errorf("MFBNonZero: %g != %g", v, want)
+	}
+	if v := MFENonZero(o, m); !floats.EqualWithinAbsOrRel(v, want, 1.e-10, 1.e-10) {
+		t.Errorf("MFENonZero: %g != %g", v, want)
+	}
+	zero := []float64{0, 0}
+	if v := MFBNonZero(zero, zero); !math.IsNaN(v) {
+		t.Errorf("MFBNonZero of zeros: %g != NaN", v)
+	}
+	if v := MFENonZero(zero, zero); !math.IsNaN(v) {
+		t.Errorf("MFENonZero of zeros: %g != NaN", v)
+	}
+}
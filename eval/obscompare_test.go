@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"image/color"
 	"io"
-	"math"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -20,6 +19,7 @@ import (
 	"github.com/ctessum/geom/encoding/shp"
 	"github.com/ctessum/geom/index/rtree"
 	"github.com/ctessum/geom/proj"
+	"github.com/evookelj/inmap/eval/evalstats"
 	"gonum.org/v1/plot"
 	"gonum.org/v1/plot/plotter"
 	"gonum.org/v1/plot/vg"
@@ -377,10 +377,10 @@ func matchObs(polName string, obsData []*data, wrfData,
 	ts4 := ts
 	ts4.XAlign = -1
 	ts4.YAlign = -0.5
-	for j, ss := range []*statistics{wrfstats, inmapstats} {
+	for j, ss := range []*evalstats.Stats{wrfstats, inmapstats} {
 		cStats.FillText(ts4, vg.Point{X: left - 0.2*vg.Inch, Y: top - vg.Length(j+1)*rowspace}, types[j])
-		for i, s := range []interface{}{ss.mfb * 100, ss.mfe * 100,
-			ss.mb, ss.me, ss.slope, ss.rsquared} { //ss.intercept,
+		for i, s := range []interface{}{ss.MFB * 100, ss.MFE * 100,
+			ss.MB, ss.ME, ss.Slope, ss.R2} { //ss.Intercept,
 			cStats.FillText(ts3, vg.Point{X: left + vg.Length(i)*colspace,
 				Y: top - vg.Length(j+1)*rowspace},
 				fmt.Sprintf(format[i], s))
@@ -586,12 +586,8 @@ func rearrangeData(x, y []float64) plotter.XYs {
 	return out
 }
 
-type statistics struct {
-	mfb, mfe, mb, me, slope, intercept, rsquared float64
-}
-
 func makePlot(x, yWRF, yInMAP []float64,
-	caption string, c draw.Canvas) (*statistics, *statistics) {
+	caption string, c draw.Canvas) (*evalstats.Stats, *evalstats.Stats) {
 
 	labelFont, err := vg.MakeFont(plot.DefaultFont, vg.Points(7))
 	if err != nil {
@@ -599,20 +595,8 @@ func makePlot(x, yWRF, yInMAP []float64,
 	}
 
 	// Calculate stats
-	wrfstats := new(statistics)
-	inmapstats := new(statistics)
-	wrfstats.slope, wrfstats.intercept, wrfstats.rsquared, _, _, _ =
-		stats.LinearRegression(x, yWRF)
-	wrfstats.mfb = mfb(x, yWRF)
-	wrfstats.mfe = mfe(x, yWRF)
-	wrfstats.mb = mb(x, yWRF)
-	wrfstats.me = me(x, yWRF)
-	inmapstats.slope, inmapstats.intercept, inmapstats.rsquared, _, _, _ =
-		stats.LinearRegression(x, yInMAP)
-	inmapstats.mfb = mfb(x, yInMAP)
-	inmapstats.mfe = mfe(x, yInMAP)
-	inmapstats.mb = mb(x, yInMAP)
-	inmapstats.me = me(x, yInMAP)
+	wrfstats := evalstats.Calculate(x, yWRF)
+	inmapstats := evalstats.Calculate(x, yInMAP)
 
 	allDataWRF := append(x, yWRF...)
 	allDataInMAP := append(x, yInMAP...)
@@ -663,14 +647,14 @@ func makePlot(x, yWRF, yInMAP []float64,
 		panic(err)
 	}
 	l1.Color = color.NRGBA{255, 0, 0, 255}
-	l2, err := plotter.NewLine(plotter.XYs{{0, wrfstats.intercept},
-		{max, max*wrfstats.slope + wrfstats.intercept}})
+	l2, err := plotter.NewLine(plotter.XYs{{0, wrfstats.Intercept},
+		{max, max*wrfstats.Slope + wrfstats.Intercept}})
 	if err != nil {
 		panic(err)
 	}
 	l2.Color = color.NRGBA{127, 127, 127, 255}
-	l3, err := plotter.NewLine(plotter.XYs{{0, inmapstats.intercept},
-		{max, max*inmapstats.slope + inmapstats.intercept}})
+	l3, err := plotter.NewLine(plotter.XYs{{0, inmapstats.Intercept},
+		{max, max*inmapstats.Slope + inmapstats.Intercept}})
 	if err != nil {
 		panic(err)
 	}
@@ -702,39 +686,6 @@ func newMap(c draw.Canvas) (*carto.Canvas, *geom.Bounds) {
 	return carto.NewCanvas(N, S, E, W, c), &bounds
 }
 
-func mfb(a, b []float64) float64 {
-	r := 0.
-	for i, v1 := range a {
-		v2 := b[i]
-		r += 2 * (v2 - v1) / (v1 + v2)
-	}
-	return r / float64(len(a))
-}
-func mfe(a, b []float64) float64 {
-	r := 0.
-	for i, v1 := range a {
-		v2 := b[i]
-		r += 2 * math.Abs(v2-v1) / math.Abs(v1+v2)
-	}
-	return r / float64(len(a))
-}
-func mb(a, b []float64) float64 {
-	r := 0.
-	for i, v1 := range a {
-		v2 := b[i]
-		r += (v2 - v1)
-	}
-	return r / float64(len(a))
-}
-func me(a, b []float64) float64 {
-	r := 0.
-	for i, v1 := range a {
-		v2 := b[i]
-		r += math.Abs(v2 - v1)
-	}
-	return r / float64(len(a))
-}
-
 func findIndex(s string, sa []string) int {
 	for i, ss := range sa {
 		if s == ss {
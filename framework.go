@@ -33,7 +33,7 @@ const (
 
 	// VarGridDataVersion gives the version of the variable grid data reuquired by
 	// this version of the software.
-	VarGridDataVersion = "1.6.1"
+	VarGridDataVersion = "1.8.0"
 
 	// InMAPDataVersion is the version of the InMAP data required by this version
 	// of the software.
@@ -84,6 +84,11 @@ type InMAP struct {
 	// field in each Cell.
 	mortIndices map[string]int
 
+	// baselineHR is the name of the hazard ratio function that was used
+	// to calculate the underlying incidence rates in the IoData
+	// field in each Cell, if any.
+	baselineHR string
+
 	// index is a spatial index of Cells.
 	index *rtree.Rtree
 
@@ -169,6 +174,9 @@ type Cell struct {
 
 	PopData  []float64 // Population for multiple demographics [people/grid cell]
 	MortData []float64 // Baseline mortality rates for multiple demographics [Deaths per 100,000 people per year/grid cell]
+	IoData   []float64 // Underlying incidence rates for multiple demographics [Deaths per 100,000 people per year/grid cell]
+
+	MortRegion int `desc:"Index of the mortality rate region the cell is mostly within" units:"-"`
 
 	Dx     float64 `desc:"Cell x length" units:"m"`
 	Dy     float64 `desc:"Cell y length" units:"m"`
@@ -267,6 +275,8 @@ func (c *Cell) boundaryCopy(m Mechanism) *Cell {
 	c2.Volume = c2.Dx * c2.Dy * c2.Dz
 	c2.PopData = c.PopData
 	c2.MortData = c.MortData
+	c2.IoData = c.IoData
+	c2.MortRegion = c.MortRegion
 	return c2
 }
 
@@ -340,7 +350,7 @@ func SetTimestepCFL() DomainManipulator {
 			d.Dt = amin(d.Dt, dt1) // seconds
 		}
 		if !(d.Dt > 0) {
-			return fmt.Errorf("invalid timestep %g; check InMAP input data", d.Dt)
+			return fmt.Errorf("invalid timestep %g; check InMAP input data (e.g., using \"inmap preproc check\")", d.Dt)
 		}
 		return nil
 	}
@@ -0,0 +1,1139 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/ctessum/cdf"
+	"github.com/ctessum/sparse"
+)
+
+// GenericNCFConfig specifies how to preprocess chemical transport
+// model or meteorological output that is stored in NetCDF files
+// without a preprocessor written specifically for that model.
+// It is usually read from a TOML file using NewGenericNCF.
+type GenericNCFConfig struct {
+	// FileTemplate is the location of the NetCDF files. [DATE] should
+	// be used as a wild card for the date of each file.
+	FileTemplate string
+
+	// DateFormat is the format of the dates in the file names,
+	// specified as a Go time layout (for example "2006-01-02_15_04_05").
+	// The default is "20060102".
+	DateFormat string
+
+	// RecordInterval is the amount of time between records within
+	// each file, and FileInterval is the amount of time between
+	// files (for example, "1h" and "24h").
+	RecordInterval, FileInterval string
+
+	// VerticalCoordinate specifies what the data mapped to Height
+	// represent. Options are "height" for layer interface heights
+	// above ground level [m] (the default), "altitude" for layer
+	// interface heights above sea level [m], and "geopotential" for
+	// layer interface geopotential [m2 s-2].
+	VerticalCoordinate string
+
+	// TopDown specifies that the vertical layers in the files are
+	// ordered from the top of the atmosphere downward, and
+	// NorthToSouth specifies that the rows are ordered from
+	// north to south. The data are reordered so that the
+	// first layer is at the ground and the first row is in the south.
+	TopDown, NorthToSouth bool
+
+	// GridVariable is the NetCDF variable whose last three dimensions
+	// are the numbers of layers, rows, and columns in the grid. The
+	// default is the variable mapped to T, or to NOx if
+	// T is not specified.
+	GridVariable string
+
+	// Role specifies which of the Preprocessor methods data are
+	// provided for. By default, all methods must be specified.
+	// If Role is "meteorology", the chemistry methods (AVOC, BVOC,
+	// ASOA, BSOA, NOx, PNO, SOx, PS, NH3, PNH, TotalPM25, HO, and H2O2)
+	// can be omitted, and if Role is "chemistry", only the chemistry methods
+	// and Height are required. Preprocessors with a Role are meant to be
+	// combined with each other using NewMixedPreprocessor.
+	Role string
+
+	// Variables specifies the data for each of the methods of
+	// the Preprocessor interface, with the keys being the method names
+	// (for example "PBLH", "U", or "NOx"). The data must be in the units
+	// required by the Preprocessor interface after any conversions are applied.
+	Variables map[string]GenericNCFVariable
+}
+
+// GenericNCFVariable specifies the source of the data for a
+// Preprocessor method. Exactly one of Name, Group, Value, and IdealGas must be
+// specified. Any conversions are applied in the order in which
+// they are listed below.
+//
+// The NetCDF variables can be stored as float, double, short, or int
+// values, and packed values are unpacked using their scale_factor
+// and add_offset attributes. Data for three-dimensional methods that
+// have only one dimension, such as pressure level coordinates, are
+// treated as vertical profiles and copied to every grid column.
+type GenericNCFVariable struct {
+	// Name is the name of the NetCDF variable.
+	Name string
+
+	// Group is a group of NetCDF variables that are summed after
+	// being multiplied by the given weights.
+	Group map[string]float64
+
+	// Value is a constant value to use instead of reading the
+	// data from the files.
+	Value *float64
+
+	// IdealGas specifies that the data for ALT should be calculated
+	// from T and P using the ideal gas law instead of being read
+	// from the files.
+	IdealGas bool
+
+	// FileTemplate optionally overrides the FileTemplate in the
+	// GenericNCFConfig.
+	FileTemplate string
+
+	// RecordInterval and FileInterval optionally override those in
+	// the GenericNCFConfig for variables that are stored at a
+	// different frequency than the other variables. Records are skipped
+	// or repeated to match the RecordInterval in the GenericNCFConfig,
+	// which must be a whole multiple or fraction of the RecordInterval
+	// specified here.
+	RecordInterval, FileInterval string
+
+	// TimeInvariant specifies that the variables do not have a
+	// time dimension.
+	TimeInvariant bool
+
+	// Factor is a multiplier to convert the data units. The default is 1.
+	Factor *float64
+
+	// Offset is added to the data after they are multiplied by Factor.
+	Offset float64
+
+	// DivideByAlt specifies that the data are mixing ratios
+	// [μg/kg air] that should be divided by inverse air density (ALT)
+	// to convert them to concentrations [μg/m3].
+	DivideByAlt bool
+
+	// Omega specifies that the data mapped to W are vertical
+	// pressure velocity [Pa/s] that should be converted
+	// to vertical wind speed [m/s] using ALT.
+	Omega bool
+
+	// PotentialTemperature specifies that the data mapped to T
+	// are potential temperature [K] that should be converted
+	// to temperature using the pressure (P).
+	PotentialTemperature bool
+
+	// Categories specifies that the data mapped to SeinfeldLandUse,
+	// WeselyLandUse, or Z0 are land use category indices from the given
+	// classification scheme, which are converted using built-in tables.
+	// Currently the only option is "USGS", for the 24-category USGS
+	// classification used by WRF.
+	Categories string
+
+	// Table is a lookup table for converting the data, where
+	// each value is replaced by Table[value]. For SeinfeldLandUse and
+	// WeselyLandUse the table values are the integer values of the
+	// categories in github.com/ctessum/atmos/seinfeld and
+	// github.com/ctessum/atmos/wesely1989, respectively.
+	Table []float64
+
+	// Stagger specifies the dimension ("x", "y", or "z") along which
+	// data that are at grid cell centers should be interpolated
+	// to grid cell edges. InMAP requires U to be staggered in the x
+	// direction, V to be staggered in the y direction, and
+	// W and Height to be staggered in the z direction. When Height
+	// is staggered, the bottom and top interface heights are
+	// extrapolated from the layer center heights.
+	Stagger string
+}
+
+// genericNCFDims specifies the grid that the data for each
+// Preprocessor method are on.
+type genericNCFDims int
+
+const (
+	dims2D genericNCFDims = iota
+	dims3D
+	dimsXStagger
+	dimsYStagger
+	dimsZStagger
+)
+
+// genericNCFMethods are the Preprocessor methods that data can be
+// specified for and the grids that the data are on.
+var genericNCFMethods = map[string]genericNCFDims{
+	"PBLH": dims2D, "Height": dimsZStagger, "ALT": dims3D, "T": dims3D, "P": dims3D,
+	"UStar": dims2D, "SeinfeldLandUse": dims2D, "WeselyLandUse": dims2D, "Z0": dims2D,
+	"QRain": dims3D, "QCloud": dims3D, "CloudFrac": dims3D,
+	"SurfaceHeatFlux": dims2D, "RadiationDown": dims2D,
+	"U": dimsXStagger, "V": dimsYStagger, "W": dimsZStagger,
+	"AVOC": dims3D, "BVOC": dims3D, "ASOA": dims3D, "BSOA": dims3D,
+	"NOx": dims3D, "PNO": dims3D, "SOx": dims3D, "PS": dims3D, "NH3": dims3D, "PNH": dims3D,
+	"TotalPM25": dims3D, "HO": dims3D, "H2O2": dims3D,
+}
+
+// genericNCFChemMethods are the Preprocessor methods that provide
+// chemistry data.
+var genericNCFChemMethods = map[string]bool{
+	"AVOC": true, "BVOC": true, "ASOA": true, "BSOA": true, "NOx": true, "PNO": true,
+	"SOx": true, "PS": true, "NH3": true, "PNH": true, "TotalPM25": true, "HO": true, "H2O2": true,
+}
+
+// genericNCFRequired returns whether data must be specified for
+// the given method when the preprocessor has the given role.
+func genericNCFRequired(method, role string) bool {
+	switch role {
+	case "meteorology":
+		return !genericNCFChemMethods[method]
+	case "chemistry":
+		return genericNCFChemMethods[method] || method == "Height"
+	default:
+		return true
+	}
+}
+
+// GenericNCF is an InMAP preprocessor for NetCDF output from
+// any chemical transport model, as specified by a GenericNCFConfig.
+type GenericNCF struct {
+	cfg GenericNCFConfig
+
+	start, end time.Time
+
+	recordDelta, fileDelta time.Duration
+
+	// intervals holds the record and file intervals of
+	// each variable.
+	intervals map[string][2]time.Duration
+
+	nx, ny, nz int
+
+	msgChan chan string
+
+	*ncfReader
+}
+
+// NewGenericNCF initializes a preprocessor for NetCDF files using the
+// GenericNCFConfig in the TOML file at mappingFile. Environment variables
+// in mappingFile and in the file templates are expanded.
+// startDate and endDate are the dates of the beginning and end of the
+// simulation, respectively, in the format "YYYYMMDD".
+// If msgChan is not nil, status messages will be sent to it.
+func NewGenericNCF(mappingFile, startDate, endDate string, msgChan chan string) (*GenericNCF, error) {
+	var cfg GenericNCFConfig
+	if _, err := toml.DecodeFile(os.ExpandEnv(mappingFile), &cfg); err != nil {
+		return nil, fmt.Errorf("inmap: NetCDF preprocessor reading mapping file: %v", err)
+	}
+	cfg.FileTemplate = os.ExpandEnv(cfg.FileTemplate)
+	for name, v := range cfg.Variables {
+		v.FileTemplate = os.ExpandEnv(v.FileTemplate)
+		cfg.Variables[name] = v
+	}
+	return NewGenericNCFFromConfig(&cfg, startDate, endDate, msgChan)
+}
+
+// NewGenericNCFFromConfig initializes a preprocessor for NetCDF files
+// using the given configuration. The other arguments are the same as
+// for NewGenericNCF.
+func NewGenericNCFFromConfig(cfg *GenericNCFConfig, startDate, endDate string, msgChan chan string) (*GenericNCF, error) {
+	gn := GenericNCF{cfg: *cfg, msgChan: msgChan, ncfReader: newNCFReader()}
+	if gn.cfg.DateFormat == "" {
+		gn.cfg.DateFormat = inDateFormat
+	}
+	if gn.cfg.VerticalCoordinate == "" {
+		gn.cfg.VerticalCoordinate = "height"
+	}
+
+	var err error
+	gn.start, err = time.Parse(inDateFormat, startDate)
+	if err != nil {
+		return nil, fmt.Errorf("inmap: NetCDF preprocessor start time: %v", err)
+	}
+	gn.end, err = time.Parse(inDateFormat, endDate)
+	if err != nil {
+		return nil, fmt.Errorf("inmap: NetCDF preprocessor end time: %v", err)
+	}
+	if !gn.end.After(gn.start) {
+		return nil, fmt.Errorf("inmap: NetCDF preprocessor end time %v is not after start time %v", gn.end, gn.start)
+	}
+	gn.recordDelta, err = time.ParseDuration(gn.cfg.RecordInterval)
+	if err != nil {
+		return nil, fmt.Errorf("inmap: NetCDF preprocessor RecordInterval: %v", err)
+	}
+	gn.fileDelta, err = time.ParseDuration(gn.cfg.FileInterval)
+	if err != nil {
+		return nil, fmt.Errorf("inmap: NetCDF preprocessor FileInterval: %v", err)
+	}
+	if gn.recordDelta <= 0 || gn.fileDelta < gn.recordDelta {
+		return nil, fmt.Errorf("inmap: NetCDF preprocessor RecordInterval (%v) must be positive and not longer than FileInterval (%v)",
+			gn.recordDelta, gn.fileDelta)
+	}
+	switch gn.cfg.VerticalCoordinate {
+	case "height", "altitude", "geopotential":
+	default:
+		return nil, fmt.Errorf("inmap: NetCDF preprocessor VerticalCoordinate '%s' is invalid; valid options are 'height', 'altitude', and 'geopotential'",
+			gn.cfg.VerticalCoordinate)
+	}
+	switch gn.cfg.Role {
+	case "", "meteorology", "chemistry":
+	default:
+		return nil, fmt.Errorf("inmap: NetCDF preprocessor Role '%s' is invalid; valid options are '', 'meteorology', and 'chemistry'",
+			gn.cfg.Role)
+	}
+	if err = gn.checkVariables(); err != nil {
+		return nil, err
+	}
+	if err = gn.checkFiles(); err != nil {
+		return nil, err
+	}
+	if gn.nz, gn.ny, gn.nx, err = gn.gridSize(); err != nil {
+		return nil, err
+	}
+	return &gn, nil
+}
+
+// checkVariables checks that the variable mapping is complete and valid.
+func (gn *GenericNCF) checkVariables() error {
+	for method := range gn.cfg.Variables {
+		if _, ok := genericNCFMethods[method]; !ok {
+			return fmt.Errorf("inmap: NetCDF preprocessor: '%s' is not a valid variable; valid variables are %s",
+				method, strings.Join(genericNCFMethodNames(), ", "))
+		}
+	}
+	// requires returns an error if the given method, which is needed by
+	// variable method, is not specified.
+	requires := func(method, needed string) error {
+		if _, ok := gn.cfg.Variables[needed]; !ok {
+			return fmt.Errorf("inmap: NetCDF preprocessor: variable %s is required by the settings for %s but is not specified", needed, method)
+		}
+		return nil
+	}
+	gn.intervals = make(map[string][2]time.Duration)
+	for _, method := range genericNCFMethodNames() {
+		v, ok := gn.cfg.Variables[method]
+		if !ok {
+			if genericNCFRequired(method, gn.cfg.Role) {
+				return fmt.Errorf("inmap: NetCDF preprocessor: variable %s is not specified", method)
+			}
+			continue
+		}
+		n := 0
+		if v.Name != "" {
+			n++
+		}
+		if len(v.Group) > 0 {
+			n++
+		}
+		if v.Value != nil {
+			n++
+		}
+		if v.IdealGas {
+			n++
+		}
+		if n != 1 {
+			return fmt.Errorf("inmap: NetCDF preprocessor: exactly one of Name, Group, Value, and IdealGas must be specified for variable %s", method)
+		}
+		if v.IdealGas {
+			if method != "ALT" {
+				return fmt.Errorf("inmap: NetCDF preprocessor: IdealGas can only be specified for ALT, not %s", method)
+			}
+			if err := requires(method, "T"); err != nil {
+				return err
+			}
+			if err := requires(method, "P"); err != nil {
+				return err
+			}
+		}
+		if v.Name != "" || len(v.Group) > 0 {
+			if v.FileTemplate == "" && gn.cfg.FileTemplate == "" {
+				return fmt.Errorf("inmap: NetCDF preprocessor: no FileTemplate is specified for variable %s", method)
+			}
+			intervals, err := gn.variableIntervals(method, v)
+			if err != nil {
+				return err
+			}
+			gn.intervals[method] = intervals
+		}
+		if v.DivideByAlt {
+			if method == "ALT" {
+				return fmt.Errorf("inmap: NetCDF preprocessor: ALT cannot be divided by itself")
+			}
+			if err := requires(method, "ALT"); err != nil {
+				return err
+			}
+		}
+		if v.Omega {
+			if method != "W" {
+				return fmt.Errorf("inmap: NetCDF preprocessor: Omega can only be specified for W, not %s", method)
+			}
+			if err := requires(method, "ALT"); err != nil {
+				return err
+			}
+		}
+		if v.PotentialTemperature {
+			if method != "T" {
+				return fmt.Errorf("inmap: NetCDF preprocessor: PotentialTemperature can only be specified for T, not %s", method)
+			}
+			if err := requires(method, "P"); err != nil {
+				return err
+			}
+		}
+		if v.Categories != "" {
+			if v.Table != nil {
+				return fmt.Errorf("inmap: NetCDF preprocessor: only one of Categories and Table can be specified for %s", method)
+			}
+			if _, err := genericNCFCategories(method, v.Categories); err != nil {
+				return err
+			}
+		}
+		if _, err := genericNCFStaggerDim(method, v.Stagger); err != nil {
+			return err
+		}
+	}
+	if gn.cfg.GridVariable == "" {
+		if _, err := gn.gridMethod(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// variableIntervals returns the record and file intervals of
+// the given variable.
+func (gn *GenericNCF) variableIntervals(method string, v GenericNCFVariable) ([2]time.Duration, error) {
+	intervals := [2]time.Duration{gn.recordDelta, gn.fileDelta}
+	var err error
+	if v.RecordInterval != "" {
+		if intervals[0], err = time.ParseDuration(v.RecordInterval); err != nil {
+			return intervals, fmt.Errorf("inmap: NetCDF preprocessor RecordInterval for %s: %v", method, err)
+		}
+	}
+	if v.FileInterval != "" {
+		if intervals[1], err = time.ParseDuration(v.FileInterval); err != nil {
+			return intervals, fmt.Errorf("inmap: NetCDF preprocessor FileInterval for %s: %v", method, err)
+		}
+	}
+	if intervals[0] <= 0 || intervals[1] < intervals[0] {
+		return intervals, fmt.Errorf("inmap: NetCDF preprocessor RecordInterval (%v) for %s must be positive and not longer than FileInterval (%v)",
+			intervals[0], method, intervals[1])
+	}
+	if _, err = nextDataResample(nil, intervals[0], gn.recordDelta); err != nil {
+		return intervals, fmt.Errorf("inmap: NetCDF preprocessor RecordInterval for %s: %v", method, err)
+	}
+	return intervals, nil
+}
+
+// gridMethod returns the method whose variable is used to determine
+// the grid size if GridVariable is not specified.
+func (gn *GenericNCF) gridMethod() (string, error) {
+	for _, method := range []string{"T", "NOx"} {
+		if v, ok := gn.cfg.Variables[method]; ok && (v.Name != "" || len(v.Group) > 0) {
+			return method, nil
+		}
+	}
+	return "", fmt.Errorf("inmap: NetCDF preprocessor: GridVariable must be specified when neither T nor NOx is read from the files")
+}
+
+// genericNCFMethodNames returns the names of the Preprocessor
+// methods in alphabetical order.
+func genericNCFMethodNames() []string {
+	var names []string
+	for name := range genericNCFMethods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// genericNCFCategories returns the lookup table for the given
+// land use classification scheme and Preprocessor method.
+func genericNCFCategories(method, scheme string) ([]float64, error) {
+	if scheme != "USGS" {
+		return nil, fmt.Errorf("inmap: NetCDF preprocessor: invalid land use Categories '%s' for %s; the only valid option is 'USGS'", scheme, method)
+	}
+	switch method {
+	case "SeinfeldLandUse":
+		t := make([]float64, len(USGSseinfeld))
+		for i, c := range USGSseinfeld {
+			t[i] = float64(c)
+		}
+		return t, nil
+	case "WeselyLandUse":
+		t := make([]float64, len(USGSwesely))
+		for i, c := range USGSwesely {
+			t[i] = float64(c)
+		}
+		return t, nil
+	case "Z0":
+		return USGSz0, nil
+	default:
+		return nil, fmt.Errorf("inmap: NetCDF preprocessor: Categories can only be specified for SeinfeldLandUse, WeselyLandUse, and Z0, not %s", method)
+	}
+}
+
+// genericNCFStaggerDim returns the array dimension that corresponds
+// to the given Stagger setting, or -1 if stagger is empty.
+func genericNCFStaggerDim(method, stagger string) (int, error) {
+	dims := genericNCFMethods[method]
+	switch {
+	case stagger == "":
+		return -1, nil
+	case stagger == "z" && dims == dimsZStagger:
+		return 0, nil
+	case stagger == "y" && dims == dimsYStagger:
+		return 1, nil
+	case stagger == "x" && dims == dimsXStagger:
+		return 2, nil
+	default:
+		return -1, fmt.Errorf("inmap: NetCDF preprocessor: invalid Stagger '%s' for variable %s", stagger, method)
+	}
+}
+
+func (gn *GenericNCF) template(v GenericNCFVariable) string {
+	if v.FileTemplate != "" {
+		return v.FileTemplate
+	}
+	return gn.cfg.FileTemplate
+}
+
+// checkFiles checks that the mapped NetCDF variables are in the
+// files for the first simulation date.
+func (gn *GenericNCF) checkFiles() error {
+	for _, method := range genericNCFMethodNames() {
+		v, ok := gn.cfg.Variables[method]
+		if !ok || (v.Name == "" && len(v.Group) == 0) {
+			continue
+		}
+		f, ff, err := gn.ncfFromTemplate(gn.template(v), gn.cfg.DateFormat, gn.start)
+		if err != nil {
+			return fmt.Errorf("inmap: NetCDF preprocessor: opening file for variable %s: %v", method, err)
+		}
+		names := []string{v.Name}
+		if v.Name == "" {
+			names = names[:0]
+			for name := range v.Group {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+		}
+		for _, name := range names {
+			if len(ff.Header.Lengths(name)) == 0 {
+				f.Close()
+				return fmt.Errorf("inmap: NetCDF preprocessor: NetCDF variable %s for %s is not in file %s", name, method, f.Name())
+			}
+		}
+		f.Close()
+	}
+	return nil
+}
+
+// gridSize returns the numbers of layers, rows, and columns in the grid.
+func (gn *GenericNCF) gridSize() (nz, ny, nx int, err error) {
+	name := gn.cfg.GridVariable
+	template := gn.cfg.FileTemplate
+	if name == "" || template == "" {
+		method, err := gn.gridMethod()
+		if err != nil {
+			return -1, -1, -1, err
+		}
+		v := gn.cfg.Variables[method]
+		template = gn.template(v)
+		if name == "" {
+			name = v.Name
+			if name == "" {
+				for n := range v.Group {
+					if name == "" || n < name {
+						name = n
+					}
+				}
+			}
+		}
+	}
+	f, ff, err := gn.ncfFromTemplate(template, gn.cfg.DateFormat, gn.start)
+	if err != nil {
+		return -1, -1, -1, fmt.Errorf("inmap: NetCDF preprocessor: grid size: %v", err)
+	}
+	defer f.Close()
+	dims := ff.Header.Lengths(name)
+	if len(dims) < 3 {
+		return -1, -1, -1, fmt.Errorf("inmap: NetCDF preprocessor: grid size: GridVariable %s must have at least 3 dimensions but has %d", name, len(dims))
+	}
+	dims = dims[len(dims)-3:]
+	return dims[0], dims[1], dims[2], nil
+}
+
+// shape returns the array shape of the data for the given method.
+func (gn *GenericNCF) shape(method string) []int {
+	switch genericNCFMethods[method] {
+	case dims2D:
+		return []int{gn.ny, gn.nx}
+	case dimsXStagger:
+		return []int{gn.nz, gn.ny, gn.nx + 1}
+	case dimsYStagger:
+		return []int{gn.nz, gn.ny + 1, gn.nx}
+	case dimsZStagger:
+		return []int{gn.nz + 1, gn.ny, gn.nx}
+	default:
+		return []int{gn.nz, gn.ny, gn.nx}
+	}
+}
+
+// read returns the data for the given Preprocessor method
+// after applying any specified conversions.
+func (gn *GenericNCF) read(method string) NextData {
+	v, ok := gn.cfg.Variables[method]
+	if !ok {
+		return genericNCFError(fmt.Errorf("inmap: NetCDF preprocessor: variable %s is not specified", method))
+	}
+	var f NextData
+	switch {
+	case v.Value != nil:
+		return gn.constant(*v.Value, gn.shape(method))
+	case v.IdealGas:
+		f = genericNCFIdealGas(gn.T(), gn.P())
+	default:
+		intervals := gn.intervals[method]
+		readFunc := genericNCFReader(v.TimeInvariant)
+		if v.Name != "" {
+			f = gn.nextDataNCF(gn.template(v), gn.cfg.DateFormat, v.Name, gn.start, gn.end, intervals[0], intervals[1], readFunc, gn.msgChan)
+		} else {
+			f = gn.nextDataGroupNCF(gn.template(v), gn.cfg.DateFormat, v.Group, gn.start, gn.end, intervals[0], intervals[1], readFunc, gn.msgChan)
+		}
+		var err error
+		if f, err = nextDataResample(f, intervals[0], gn.recordDelta); err != nil {
+			return genericNCFError(fmt.Errorf("inmap: NetCDF preprocessor: %s: %v", method, err))
+		}
+		if genericNCFMethods[method] != dims2D {
+			f = genericNCFBroadcast(f, gn.ny, gn.nx)
+		}
+		if gn.cfg.TopDown || gn.cfg.NorthToSouth {
+			f = genericNCFFlip(f, gn.cfg.TopDown, gn.cfg.NorthToSouth)
+		}
+	}
+	if v.Factor != nil || v.Offset != 0 {
+		factor := 1.0
+		if v.Factor != nil {
+			factor = *v.Factor
+		}
+		f = genericNCFScale(f, factor, v.Offset)
+	}
+	if v.DivideByAlt {
+		f = nextDataDivideAlt(f, gn.ALT())
+	}
+	if v.Omega {
+		f = genericNCFOmega(f, gn.ALT())
+	}
+	if v.PotentialTemperature {
+		f = genericNCFTemperature(f, gn.P())
+	}
+	table := v.Table
+	if v.Categories != "" {
+		var err error
+		if table, err = genericNCFCategories(method, v.Categories); err != nil {
+			return genericNCFError(err)
+		}
+	}
+	if table != nil {
+		f = genericNCFLookup(method, f, table)
+	}
+	dim, err := genericNCFStaggerDim(method, v.Stagger)
+	if err != nil {
+		return genericNCFError(err)
+	}
+	if dim >= 0 {
+		if method == "Height" {
+			f = genericNCFStaggerHeight(f)
+		} else {
+			f = stagger(f, dim)
+		}
+	}
+	if method == "Height" {
+		f = genericNCFHeight(f, gn.cfg.VerticalCoordinate)
+	}
+	return genericNCFCheckShape(method, f, gn.shape(method))
+}
+
+// genericNCFError returns a NextData function that returns err.
+func genericNCFError(err error) NextData {
+	return func() (*sparse.DenseArray, error) { return nil, err }
+}
+
+// RecordInterval returns the amount of time between the
+// records returned by the preprocessor.
+func (gn *GenericNCF) RecordInterval() time.Duration { return gn.recordDelta }
+
+// genericNCFReader returns a function that reads a record of a NetCDF
+// variable with any numeric data type, unpacking the values if the
+// variable has scale_factor or add_offset attributes. If timeInvariant
+// is true, the variable is read as if it does not have a time dimension.
+func genericNCFReader(timeInvariant bool) readNCFFunc {
+	return func(pol string, ff *cdf.File, hour int) (*sparse.DenseArray, error) {
+		dims := ff.Header.Lengths(pol)
+		if len(dims) == 0 {
+			return nil, fmt.Errorf("inmap: preprocessor read netcdf: variable %v not in file", pol)
+		}
+		var start, end []int
+		if !timeInvariant || ff.Header.IsRecordVariable(pol) {
+			if timeInvariant {
+				hour = 0
+			}
+			dims = dims[1:]
+			start, end = make([]int, len(dims)+1), make([]int, len(dims)+1)
+			start[0], end[0] = hour, hour+1
+		}
+		nread := 1
+		for _, dim := range dims {
+			nread *= dim
+		}
+		r := ff.Reader(pol, start, end)
+		buf := r.Zero(nread)
+		if _, err := r.Read(buf); err != nil {
+			return nil, fmt.Errorf("inmap: preprocessor read netcdf variable %s: %v", pol, err)
+		}
+		data := sparse.ZerosDense(dims...)
+		switch b := buf.(type) {
+		case []float32:
+			for i, val := range b {
+				data.Elements[i] = float64(val)
+			}
+		case []float64:
+			copy(data.Elements, b)
+		case []int16:
+			for i, val := range b {
+				data.Elements[i] = float64(val)
+			}
+		case []int32:
+			for i, val := range b {
+				data.Elements[i] = float64(val)
+			}
+		default:
+			return nil, fmt.Errorf("inmap: preprocessor read netcdf variable %s: unsupported data type %T", pol, buf)
+		}
+		scale, hasScale := ncfAttributeFloat(ff, pol, "scale_factor")
+		offset, hasOffset := ncfAttributeFloat(ff, pol, "add_offset")
+		if hasScale || hasOffset {
+			if !hasScale {
+				scale = 1
+			}
+			for i, val := range data.Elements {
+				data.Elements[i] = val*scale + offset
+			}
+		}
+		return data, nil
+	}
+}
+
+// ncfAttributeFloat returns the first value of the given numeric
+// attribute of variable v and whether the attribute exists.
+func ncfAttributeFloat(ff *cdf.File, v, a string) (float64, bool) {
+	switch t := ff.Header.GetAttribute(v, a).(type) {
+	case []float64:
+		if len(t) > 0 {
+			return t[0], true
+		}
+	case []float32:
+		if len(t) > 0 {
+			return float64(t[0]), true
+		}
+	case []int32:
+		if len(t) > 0 {
+			return float64(t[0]), true
+		}
+	case []int16:
+		if len(t) > 0 {
+			return float64(t[0]), true
+		}
+	}
+	return 0, false
+}
+
+// genericNCFBroadcast copies one-dimensional vertical profiles
+// returned by f to every grid column. Data with more
+// dimensions are not changed.
+func genericNCFBroadcast(f NextData, ny, nx int) NextData {
+	return func() (*sparse.DenseArray, error) {
+		data, err := f()
+		if err != nil || len(data.Shape) != 1 {
+			return data, err
+		}
+		out := sparse.ZerosDense(data.Shape[0], ny, nx)
+		for k, val := range data.Elements {
+			for j := 0; j < ny; j++ {
+				for i := 0; i < nx; i++ {
+					out.Set(val, k, j, i)
+				}
+			}
+		}
+		return out, nil
+	}
+}
+
+// constant returns data with the given value and shape for
+// each time step.
+func (gn *GenericNCF) constant(value float64, shape []int) NextData {
+	var n int
+	for date := gn.start; date.Before(gn.end); date = date.Add(gn.fileDelta) {
+		n += int(gn.fileDelta / gn.recordDelta)
+	}
+	return func() (*sparse.DenseArray, error) {
+		if n == 0 {
+			return nil, io.EOF
+		}
+		n--
+		data := sparse.ZerosDense(shape...)
+		for i := range data.Elements {
+			data.Elements[i] = value
+		}
+		return data, nil
+	}
+}
+
+// genericNCFFlip reverses the order of the vertical layers
+// and/or the rows of the data returned by f.
+func genericNCFFlip(f NextData, topDown, northToSouth bool) NextData {
+	return func() (*sparse.DenseArray, error) {
+		data, err := f()
+		if err != nil {
+			return nil, err
+		}
+		out := sparse.ZerosDense(data.Shape...)
+		switch len(data.Shape) {
+		case 2:
+			ny, nx := data.Shape[0], data.Shape[1]
+			for j := 0; j < ny; j++ {
+				jj := j
+				if northToSouth {
+					jj = ny - 1 - j
+				}
+				for i := 0; i < nx; i++ {
+					out.Set(data.Get(jj, i), j, i)
+				}
+			}
+		case 3:
+			nz, ny, nx := data.Shape[0], data.Shape[1], data.Shape[2]
+			for k := 0; k < nz; k++ {
+				kk := k
+				if topDown {
+					kk = nz - 1 - k
+				}
+				for j := 0; j < ny; j++ {
+					jj := j
+					if northToSouth {
+						jj = ny - 1 - j
+					}
+					for i := 0; i < nx; i++ {
+						out.Set(data.Get(kk, jj, i), k, j, i)
+					}
+				}
+			}
+		default:
+			return nil, fmt.Errorf("inmap: NetCDF preprocessor: can't reorder data with %d dimensions", len(data.Shape))
+		}
+		return out, nil
+	}
+}
+
+// genericNCFScale multiplies the data returned by f by factor
+// and then adds offset.
+func genericNCFScale(f NextData, factor, offset float64) NextData {
+	return func() (*sparse.DenseArray, error) {
+		data, err := f()
+		if err != nil {
+			return nil, err
+		}
+		out := sparse.ZerosDense(data.Shape...)
+		for i, val := range data.Elements {
+			out.Elements[i] = val*factor + offset
+		}
+		return out, nil
+	}
+}
+
+// genericNCFTemperature converts potential temperature [K]
+// to temperature [K] using pressure [Pa].
+func genericNCFTemperature(thetaFunc, pFunc NextData) NextData {
+	const (
+		po    = 101300. // Pa, reference pressure
+		kappa = 0.2854  // related to von karman's constant
+	)
+	return func() (*sparse.DenseArray, error) {
+		theta, err := thetaFunc()
+		if err != nil {
+			return nil, err
+		}
+		p, err := pFunc()
+		if err != nil {
+			return nil, err
+		}
+		T := sparse.ZerosDense(theta.Shape...)
+		for i, θ := range theta.Elements {
+			T.Elements[i] = θ * math.Pow(p.Elements[i]/po, kappa)
+		}
+		return T, nil
+	}
+}
+
+// genericNCFIdealGas calculates inverse air density [m3/kg] from
+// temperature [K] and pressure [Pa] using the ideal gas law.
+func genericNCFIdealGas(TFunc, PFunc NextData) NextData {
+	return func() (*sparse.DenseArray, error) {
+		T, err := TFunc()
+		if err != nil {
+			return nil, err
+		}
+		P, err := PFunc()
+		if err != nil {
+			return nil, err
+		}
+		alt := sparse.ZerosDense(T.Shape...)
+		for i, t := range T.Elements {
+			alt.Elements[i] = rr * t / P.Elements[i]
+		}
+		return alt, nil
+	}
+}
+
+// genericNCFOmega converts vertical pressure velocity [Pa/s]
+// to vertical wind speed [m/s] using inverse air density [m3/kg].
+func genericNCFOmega(omegaFunc, altFunc NextData) NextData {
+	return func() (*sparse.DenseArray, error) {
+		omega, err := omegaFunc()
+		if err != nil {
+			return nil, err
+		}
+		alt, err := altFunc()
+		if err != nil {
+			return nil, err
+		}
+		w := sparse.ZerosDense(omega.Shape...)
+		for i, o := range omega.Elements {
+			w.Elements[i] = -o * alt.Elements[i] / g
+		}
+		return w, nil
+	}
+}
+
+// genericNCFLookup replaces each value returned by f with the
+// corresponding value in table.
+func genericNCFLookup(method string, f NextData, table []float64) NextData {
+	return func() (*sparse.DenseArray, error) {
+		data, err := f()
+		if err != nil {
+			return nil, err
+		}
+		out := sparse.ZerosDense(data.Shape...)
+		for i, val := range data.Elements {
+			index := f2i(val)
+			if index < 0 || index >= len(table) {
+				return nil, fmt.Errorf("inmap: NetCDF preprocessor: %s value %g is outside of lookup table with %d values", method, val, len(table))
+			}
+			out.Elements[i] = table[index]
+		}
+		return out, nil
+	}
+}
+
+// genericNCFStaggerHeight converts layer center heights to layer
+// interface heights, extrapolating the bottom and top interfaces.
+func genericNCFStaggerHeight(f NextData) NextData {
+	return func() (*sparse.DenseArray, error) {
+		in, err := f()
+		if err != nil {
+			return nil, err
+		}
+		if len(in.Shape) != 3 {
+			return nil, fmt.Errorf("inmap: NetCDF preprocessor: Height has shape %v but should be 3-dimensional", in.Shape)
+		}
+		nz, ny, nx := in.Shape[0], in.Shape[1], in.Shape[2]
+		if nz < 2 {
+			return staggerWorker(in, 0), nil
+		}
+		out := sparse.ZerosDense(nz+1, ny, nx)
+		for j := 0; j < ny; j++ {
+			for i := 0; i < nx; i++ {
+				out.Set(1.5*in.Get(0, j, i)-0.5*in.Get(1, j, i), 0, j, i)
+				for k := 1; k < nz; k++ {
+					out.Set((in.Get(k-1, j, i)+in.Get(k, j, i))/2, k, j, i)
+				}
+				out.Set(1.5*in.Get(nz-1, j, i)-0.5*in.Get(nz-2, j, i), nz, j, i)
+			}
+		}
+		return out, nil
+	}
+}
+
+// genericNCFHeight converts the given vertical coordinate
+// to layer heights above ground level [m].
+func genericNCFHeight(f NextData, coordinate string) NextData {
+	if coordinate == "height" {
+		return f
+	}
+	return func() (*sparse.DenseArray, error) {
+		data, err := f()
+		if err != nil {
+			return nil, err
+		}
+		divisor := 1.0
+		if coordinate == "geopotential" {
+			divisor = g
+		}
+		out := sparse.ZerosDense(data.Shape...)
+		for k := 0; k < data.Shape[0]; k++ {
+			for j := 0; j < data.Shape[1]; j++ {
+				for i := 0; i < data.Shape[2]; i++ {
+					out.Set((data.Get(k, j, i)-data.Get(0, j, i))/divisor, k, j, i)
+				}
+			}
+		}
+		return out, nil
+	}
+}
+
+// genericNCFCheckShape checks that the data returned by f have the
+// given shape.
+func genericNCFCheckShape(method string, f NextData, shape []int) NextData {
+	return func() (*sparse.DenseArray, error) {
+		data, err := f()
+		if err != nil {
+			return nil, err
+		}
+		if len(data.Shape) != len(shape) {
+			return nil, fmt.Errorf("inmap: NetCDF preprocessor: %s has shape %v but should have shape %v", method, data.Shape, shape)
+		}
+		for i, s := range shape {
+			if data.Shape[i] != s {
+				return nil, fmt.Errorf("inmap: NetCDF preprocessor: %s has shape %v but should have shape %v; check the Stagger setting", method, data.Shape, shape)
+			}
+		}
+		return data, nil
+	}
+}
+
+// Nx helps fulfill the Preprocessor interface by returning
+// the number of grid cells in the West-East direction.
+func (gn *GenericNCF) Nx() (int, error) { return gn.nx, nil }
+
+// Ny helps fulfill the Preprocessor interface by returning
+// the number of grid cells in the South-North direction.
+func (gn *GenericNCF) Ny() (int, error) { return gn.ny, nil }
+
+// Nz helps fulfill the Preprocessor interface by returning
+// the number of grid cells in the below-above direction.
+func (gn *GenericNCF) Nz() (int, error) { return gn.nz, nil }
+
+// PBLH helps fulfill the Preprocessor interface.
+func (gn *GenericNCF) PBLH() NextData { return gn.read("PBLH") }
+
+// Height helps fulfill the Preprocessor interface.
+func (gn *GenericNCF) Height() NextData { return gn.read("Height") }
+
+// ALT helps fulfill the Preprocessor interface.
+func (gn *GenericNCF) ALT() NextData { return gn.read("ALT") }
+
+// T helps fulfill the Preprocessor interface.
+func (gn *GenericNCF) T() NextData { return gn.read("T") }
+
+// P helps fulfill the Preprocessor interface.
+func (gn *GenericNCF) P() NextData { return gn.read("P") }
+
+// UStar helps fulfill the Preprocessor interface.
+func (gn *GenericNCF) UStar() NextData { return gn.read("UStar") }
+
+// SeinfeldLandUse helps fulfill the Preprocessor interface.
+func (gn *GenericNCF) SeinfeldLandUse() NextData { return gn.read("SeinfeldLandUse") }
+
+// WeselyLandUse helps fulfill the Preprocessor interface.
+func (gn *GenericNCF) WeselyLandUse() NextData { return gn.read("WeselyLandUse") }
+
+// Z0 helps fulfill the Preprocessor interface.
+func (gn *GenericNCF) Z0() NextData { return gn.read("Z0") }
+
+// QRain helps fulfill the Preprocessor interface.
+func (gn *GenericNCF) QRain() NextData { return gn.read("QRain") }
+
+// QCloud helps fulfill the Preprocessor interface.
+func (gn *GenericNCF) QCloud() NextData { return gn.read("QCloud") }
+
+// CloudFrac helps fulfill the Preprocessor interface.
+func (gn *GenericNCF) CloudFrac() NextData { return gn.read("CloudFrac") }
+
+// SurfaceHeatFlux helps fulfill the Preprocessor interface.
+func (gn *GenericNCF) SurfaceHeatFlux() NextData { return gn.read("SurfaceHeatFlux") }
+
+// RadiationDown helps fulfill the Preprocessor interface.
+func (gn *GenericNCF) RadiationDown() NextData { return gn.read("RadiationDown") }
+
+// U helps fulfill the Preprocessor interface.
+func (gn *GenericNCF) U() NextData { return gn.read("U") }
+
+// V helps fulfill the Preprocessor interface.
+func (gn *GenericNCF) V() NextData { return gn.read("V") }
+
+// W helps fulfill the Preprocessor interface.
+func (gn *GenericNCF) W() NextData { return gn.read("W") }
+
+// AVOC helps fulfill the Preprocessor interface.
+func (gn *GenericNCF) AVOC() NextData { return gn.read("AVOC") }
+
+// BVOC helps fulfill the Preprocessor interface.
+func (gn *GenericNCF) BVOC() NextData { return gn.read("BVOC") }
+
+// ASOA helps fulfill the Preprocessor interface.
+func (gn *GenericNCF) ASOA() NextData { return gn.read("ASOA") }
+
+// BSOA helps fulfill the Preprocessor interface.
+func (gn *GenericNCF) BSOA() NextData { return gn.read("BSOA") }
+
+// NOx helps fulfill the Preprocessor interface.
+func (gn *GenericNCF) NOx() NextData { return gn.read("NOx") }
+
+// PNO helps fulfill the Preprocessor interface.
+func (gn *GenericNCF) PNO() NextData { return gn.read("PNO") }
+
+// SOx helps fulfill the Preprocessor interface.
+func (gn *GenericNCF) SOx() NextData { return gn.read("SOx") }
+
+// PS helps fulfill the Preprocessor interface.
+func (gn *GenericNCF) PS() NextData { return gn.read("PS") }
+
+// NH3 helps fulfill the Preprocessor interface.
+func (gn *GenericNCF) NH3() NextData { return gn.read("NH3") }
+
+// PNH helps fulfill the Preprocessor interface.
+func (gn *GenericNCF) PNH() NextData { return gn.read("PNH") }
+
+// TotalPM25 helps fulfill the Preprocessor interface.
+func (gn *GenericNCF) TotalPM25() NextData { return gn.read("TotalPM25") }
+
+// HO helps fulfill the Preprocessor interface.
+func (gn *GenericNCF) HO() NextData { return gn.read("HO") }
+
+// H2O2 helps fulfill the Preprocessor interface.
+func (gn *GenericNCF) H2O2() NextData { return gn.read("H2O2") }
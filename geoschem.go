@@ -80,6 +80,8 @@ type GEOSChem struct {
 	dash string
 
 	msgChan chan string
+
+	*ncfReader
 }
 
 // NewGEOSChem initializes a GEOS-Chem preprocessor from the given
@@ -263,6 +265,7 @@ func NewGEOSChem(GEOSA1, GEOSA3Cld, GEOSA3Dyn, GEOSI3, GEOSA3MstE, GEOSApBp, GEO
 		dash:       d,
 		msgChan:    msgChan,
 		noChemHour: noChemHour,
+		ncfReader:  newNCFReader(),
 	}
 
 	var err error
@@ -362,48 +365,48 @@ func ppbvToUgKg(mw float64) float64 {
 
 func (gc *GEOSChem) readA3Dyn(varName string) NextData {
 	conv := geosLayerConvert(gc.nz)
-	return conv(nextDataNCF(gc.geosA3Dyn, geosFormat, varName, gc.start, gc.end, gc.recordDelta3h, gc.fileDelta24h, readNCF, gc.msgChan))
+	return conv(gc.nextDataNCF(gc.geosA3Dyn, geosFormat, varName, gc.start, gc.end, gc.recordDelta3h, gc.fileDelta24h, readNCF, gc.msgChan))
 }
 
 func (gc *GEOSChem) readA3MstE(varName string) NextData {
 	conv := geosLayerConvert(gc.nz)
-	return conv(nextDataNCF(gc.geosA3MstE, geosFormat, varName, gc.start, gc.end, gc.recordDelta3h, gc.fileDelta24h, readNCF, gc.msgChan))
+	return conv(gc.nextDataNCF(gc.geosA3MstE, geosFormat, varName, gc.start, gc.end, gc.recordDelta3h, gc.fileDelta24h, readNCF, gc.msgChan))
 }
 
 func (gc *GEOSChem) readA3Cld(varName string) NextData {
 	conv := geosLayerConvert(gc.nz)
-	return conv(nextDataNCF(gc.geosA3Cld, geosFormat, varName, gc.start, gc.end, gc.recordDelta3h, gc.fileDelta24h, readNCF, gc.msgChan))
+	return conv(gc.nextDataNCF(gc.geosA3Cld, geosFormat, varName, gc.start, gc.end, gc.recordDelta3h, gc.fileDelta24h, readNCF, gc.msgChan))
 }
 
 func (gc *GEOSChem) readA1(varName string) NextData {
 	// All variables in A1 are 2-d, so we don't need to perform a layer conversion.
-	return nextDataNCF(gc.geosA1, geosFormat, varName, gc.start, gc.end, gc.recordDelta1h, gc.fileDelta24h, readNCF, gc.msgChan)
+	return gc.nextDataNCF(gc.geosA1, geosFormat, varName, gc.start, gc.end, gc.recordDelta1h, gc.fileDelta24h, readNCF, gc.msgChan)
 }
 
 func (gc *GEOSChem) readI3(varName string) NextData {
 	conv := geosLayerConvert(gc.nz)
-	return conv(nextDataNCF(gc.geosI3, geosFormat, varName, gc.start, gc.end, gc.recordDelta3h, gc.fileDelta24h, readNCF, gc.msgChan))
+	return conv(gc.nextDataNCF(gc.geosI3, geosFormat, varName, gc.start, gc.end, gc.recordDelta3h, gc.fileDelta24h, readNCF, gc.msgChan))
 }
 
 func (gc *GEOSChem) readChem(varName string) NextData {
 	if gc.noChemHour {
-		return nextDataNCF(gc.geosChem, geosChemFormat, varName, gc.start, gc.end, gc.chemRecordDeltaInterval, gc.chemFileDeltaInterval, readNCFNoHour, gc.msgChan)
+		return gc.nextDataNCF(gc.geosChem, geosChemFormat, varName, gc.start, gc.end, gc.chemRecordDeltaInterval, gc.chemFileDeltaInterval, readNCFNoHour, gc.msgChan)
 	}
-	return nextDataNCF(gc.geosChem, geosChemFormat, varName, gc.start, gc.end, gc.chemRecordDeltaInterval, gc.chemFileDeltaInterval, readNCF, gc.msgChan)
+	return gc.nextDataNCF(gc.geosChem, geosChemFormat, varName, gc.start, gc.end, gc.chemRecordDeltaInterval, gc.chemFileDeltaInterval, readNCF, gc.msgChan)
 }
 
 func (gc *GEOSChem) readApBp(varName string) NextData {
 	if gc.geosApBp != "" {
 		return nextDataConstantNCF(strings.ToLower(varName), gc.geosApBp)
 	}
-	return nextDataNCF(gc.geosChem, geosChemFormat, varName, gc.start, gc.end, gc.recordDelta3h, gc.fileDelta3h, readNCFNoHour, gc.msgChan)
+	return gc.nextDataNCF(gc.geosChem, geosChemFormat, varName, gc.start, gc.end, gc.recordDelta3h, gc.fileDelta3h, readNCFNoHour, gc.msgChan)
 }
 
 func (gc *GEOSChem) readChemGroupAlt(varGroup map[string]float64) NextData {
 	if gc.noChemHour {
-		return nextDataGroupAltNCF(gc.geosChem, geosChemFormat, varGroup, gc.ALT(), gc.start, gc.end, gc.chemRecordDeltaInterval, gc.chemFileDeltaInterval, readNCFNoHour, gc.msgChan)
+		return gc.nextDataGroupAltNCF(gc.geosChem, geosChemFormat, varGroup, gc.ALT(), gc.start, gc.end, gc.chemRecordDeltaInterval, gc.chemFileDeltaInterval, readNCFNoHour, gc.msgChan)
 	}
-	return nextDataGroupAltNCF(gc.geosChem, geosChemFormat, varGroup, gc.ALT(), gc.start, gc.end, gc.chemRecordDeltaInterval, gc.chemFileDeltaInterval, readNCF, gc.msgChan)
+	return gc.nextDataGroupAltNCF(gc.geosChem, geosChemFormat, varGroup, gc.ALT(), gc.start, gc.end, gc.chemRecordDeltaInterval, gc.chemFileDeltaInterval, readNCF, gc.msgChan)
 }
 
 var geosLayerConvert = func(nz int) func(NextData) NextData {
@@ -513,7 +516,7 @@ func geosLayerConvertStaggered(in *sparse.DenseArray, staggeredLayerMap map[int]
 // Nx helps fulfill the Preprocessor interface by returning
 // the number of grid cells in the West-East direction.
 func (gc *GEOSChem) Nx() (int, error) {
-	f, ff, err := ncfFromTemplate(gc.geosA3Dyn, geosFormat, gc.start)
+	f, ff, err := gc.ncfFromTemplate(gc.geosA3Dyn, geosFormat, gc.start)
 	if err != nil {
 		return -1, err
 	}
@@ -529,7 +532,7 @@ func (gc *GEOSChem) Nx() (int, error) {
 // Ny helps fulfill the Preprocessor interface by returning
 // the number of grid cells in the South-North direction.
 func (gc *GEOSChem) Ny() (int, error) {
-	f, ff, err := ncfFromTemplate(gc.geosA3Dyn, geosFormat, gc.start)
+	f, ff, err := gc.ncfFromTemplate(gc.geosA3Dyn, geosFormat, gc.start)
 	if err != nil {
 		return -1, err
 	}
@@ -547,7 +550,7 @@ func (gc *GEOSChem) Ny() (int, error) {
 func (gc *GEOSChem) Nz() (int, error) {
 	// We get Nz from the GEOS-Chem output to make sure we're using the
 	// GEOS-Chem number of layers rather than the GEOS number of layers.
-	f, ff, err := ncfFromTemplate(gc.geosChem, geosChemFormat, gc.start)
+	f, ff, err := gc.ncfFromTemplate(gc.geosChem, geosChemFormat, gc.start)
 	if err != nil {
 		return -1, err
 	}
@@ -564,7 +567,7 @@ func (gc *GEOSChem) Nz() (int, error) {
 
 // Return the first set of values of a variable from a chemistry file.
 func (gc *GEOSChem) chemFirstValues(v string) ([]float64, error) {
-	f, ff, err := ncfFromTemplate(gc.geosChem, geosChemFormat, gc.start)
+	f, ff, err := gc.ncfFromTemplate(gc.geosChem, geosChemFormat, gc.start)
 	if err != nil {
 		return nil, err
 	}
@@ -582,7 +585,7 @@ func (gc *GEOSChem) chemFirstValues(v string) ([]float64, error) {
 
 // Return an attribute from a chemistry file.
 func (gc *GEOSChem) chemAttribute(a string) (float64, error) {
-	f, ff, err := ncfFromTemplate(gc.geosChem, geosChemFormat, gc.start)
+	f, ff, err := gc.ncfFromTemplate(gc.geosChem, geosChemFormat, gc.start)
 	if err != nil {
 		return math.NaN(), err
 	}
@@ -0,0 +1,180 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/evookelj/inmap/epi"
+)
+
+// deathsPrefix is the prefix of built-in health outcome variables,
+// which take the form "Deaths:<HRName>:<PopGroup>:<MortColumn>", where
+// HRName is the name of one of the hazard ratio functions in the epi package,
+// PopGroup is one of the census population columns, and MortColumn is one
+// of the mortality rate columns.
+const deathsPrefix = "Deaths:"
+
+// ioSuffix is appended to the name of a mortality rate to form the name of
+// the corresponding underlying incidence rate output variable.
+const ioSuffix = "Io"
+
+// deathsRegexp matches built-in health outcome variables within output
+// expressions, whether or not they are already escaped with square brackets.
+var deathsRegexp = regexp.MustCompile(`\[?(Deaths:[A-Za-z0-9_]+:[A-Za-z0-9_]+:[A-Za-z0-9_]+)\]?`)
+
+// escapeDeathsVars surrounds any built-in health outcome variables in
+// expression e with square brackets so that they are parsed as single
+// variables rather than as ternary operators.
+func escapeDeathsVars(e string) string {
+	return deathsRegexp.ReplaceAllString(e, "[$1]")
+}
+
+// isDeathsVar returns whether varName is a built-in health outcome variable.
+func isDeathsVar(varName string) bool {
+	return strings.HasPrefix(varName, deathsPrefix)
+}
+
+// deathsVar holds the components of a built-in health outcome variable.
+type deathsVar struct {
+	hr        epi.HRer
+	pop, mort string
+}
+
+// parseDeathsVar parses a built-in health outcome variable and checks
+// that the requested population group and mortality rate exist.
+func (d *InMAP) parseDeathsVar(varName string) (*deathsVar, error) {
+	parts := strings.Split(strings.TrimPrefix(varName, deathsPrefix), ":")
+	if !isDeathsVar(varName) || len(parts) != 3 {
+		return nil, fmt.Errorf("inmap: invalid health outcome variable '%s'; "+
+			"the format should be 'Deaths:<HRName>:<PopGroup>:<MortColumn>'", varName)
+	}
+	hr, err := epi.HRByName(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("inmap: health outcome variable '%s': %v", varName, err)
+	}
+	if _, ok := d.PopIndices[parts[1]]; !ok {
+		return nil, fmt.Errorf("inmap: health outcome variable '%s': undefined population group '%s'", varName, parts[1])
+	}
+	if _, ok := d.mortIndices[parts[2]]; !ok {
+		return nil, fmt.Errorf("inmap: health outcome variable '%s': undefined mortality rate '%s'", varName, parts[2])
+	}
+	return &deathsVar{hr: hr, pop: parts[1], mort: parts[2]}, nil
+}
+
+// deaths returns the number of deaths per year in each grid cell in the
+// given layer (or in all layers if layer < 0) caused by the modeled change
+// in total PM2.5 concentration, as specified by the built-in health
+// outcome variable varName.
+//
+// Deaths are calculated as p * Io * (HR(z₀ + Δz) - HR(z₀)), where p is
+// the population, z₀ is the baseline total PM2.5 concentration, Δz is the
+// modeled change in total PM2.5 concentration, and Io is the underlying
+// incidence rate. If the requested hazard ratio function is the one that
+// was used to calculate the underlying incidence rates stored in each grid
+// cell (VarGridConfig.BaselineHR), those rates are used directly.
+// Otherwise, Io is calculated with epi.IoRegional, and because the reported
+// mortality rates are typically available for administrative areas rather
+// than for individual grid cells, ground-level grid cells that are mostly
+// within the same mortality rate polygon (Cell.MortRegion) are treated as
+// a single region, with the population-weighted average mortality rate of
+// those cells as the regional mortality rate.
+func (d *InMAP) deaths(varName string, layer int, m Mechanism) ([]float64, error) {
+	v, err := d.parseDeathsVar(varName)
+	if err != nil {
+		return nil, err
+	}
+	const (
+		totalPM25    = "TotalPM25"
+		baselinePM25 = "BaselineTotalPM25"
+		ratePer      = 100000. // mortality rates are per 100,000 people.
+	)
+	if _, err := m.Units(totalPM25); err != nil {
+		return nil, fmt.Errorf("inmap: health outcome variable '%s': %v", varName, err)
+	}
+
+	cells := d.cells.array()
+	cellIo := d.baselineHR != "" && d.baselineHR == v.hr.Name()
+	mortIndex := d.mortIndices[v.mort]
+
+	// Group the ground-level cells into regions by the mortality rate
+	// polygon they are mostly within, and calculate the underlying
+	// incidence rate in each region.
+	type region struct {
+		p, z   []float64
+		deaths float64 // Baseline deaths: the sum of population times mortality rate.
+	}
+	regions := make(map[int]*region)
+	for _, c := range cells {
+		if c.Layer != 0 || (cellIo && len(c.IoData) > 0) {
+			continue
+		}
+		c.mutex.RLock()
+		r, ok := regions[c.MortRegion]
+		if !ok {
+			r = new(region)
+			regions[c.MortRegion] = r
+		}
+		p := c.getValue(v.pop, d.PopIndices, d.mortIndices, m)
+		r.p = append(r.p, p)
+		r.z = append(r.z, c.getValue(baselinePM25, d.PopIndices, d.mortIndices, m))
+		r.deaths += p * c.getValue(v.mort, d.PopIndices, d.mortIndices, m)
+		c.mutex.RUnlock()
+	}
+	io := make(map[int]float64, len(regions))
+	for id, r := range regions {
+		var pTotal float64
+		for _, p := range r.p {
+			pTotal += p
+		}
+		if pTotal == 0 {
+			continue
+		}
+		io[id] = epi.IoRegional(r.p, r.z, v.hr, r.deaths/pTotal/ratePer)
+	}
+
+	o := make([]float64, 0, d.cells.len())
+	for _, c := range cells {
+		if layer >= 0 && c.Layer > layer {
+			// The cells should be sorted with the lower layers first, so we
+			// should be done here.
+			return o, nil
+		}
+		if layer >= 0 && c.Layer != layer {
+			continue
+		}
+		if c.Layer != 0 {
+			o = append(o, 0) // Only ground-level cells have people.
+			continue
+		}
+		c.mutex.RLock()
+		p := c.getValue(v.pop, d.PopIndices, d.mortIndices, m)
+		z0 := c.getValue(baselinePM25, d.PopIndices, d.mortIndices, m)
+		Δz := c.getValue(totalPM25, d.PopIndices, d.mortIndices, m)
+		ci := io[c.MortRegion]
+		if cellIo && len(c.IoData) > 0 {
+			ci = c.IoData[mortIndex] / ratePer
+		}
+		c.mutex.RUnlock()
+		o = append(o, epi.Outcome(p, z0+Δz, ci, v.hr)-epi.Outcome(p, z0, ci, v.hr))
+	}
+	return o, nil
+}
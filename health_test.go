@@ -0,0 +1,207 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+import (
+	"testing"
+
+	"github.com/evookelj/inmap/epi"
+)
+
+func TestDeaths(t *testing.T) {
+	cfg, ctmdata, pop, popIndices, mr, mortIndices := VarGridTestData()
+	emis := NewEmissions()
+
+	m := Mech{}
+	d := &InMAP{
+		InitFuncs: []DomainManipulator{
+			cfg.RegularGrid(ctmdata, pop, popIndices, mr, mortIndices, emis, m),
+		},
+	}
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range d.cells.array() {
+		c.Cf[iPM2_5] = float64(i + 1)
+	}
+
+	const name = "Deaths:NasariACS:TotalPop:AllCause"
+	o, err := NewOutputter("", false, map[string]string{
+		"Deaths":    name,
+		"Deaths2":   "2 * " + name,
+		"TotDeaths": "{sum(" + name + ")}",
+	}, nil, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.checkModelVars(m, o.modelVariables...); err != nil {
+		t.Fatal(err)
+	}
+	r, err := d.Results(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Calculate the expected values.
+	var p, z0, z1, I []float64
+	var region []int
+	for _, c := range d.cells.array() {
+		if c.Layer != 0 {
+			break
+		}
+		p = append(p, c.PopData[popIndices["TotalPop"]])
+		z0 = append(z0, c.CBaseline[iPM2_5])
+		z1 = append(z1, c.CBaseline[iPM2_5]+c.Cf[iPM2_5])
+		I = append(I, c.MortData[mortIndices["AllCause"]])
+		region = append(region, c.MortRegion)
+	}
+	var want []float64
+	var wantTotal float64
+	for i := range p {
+		// Each region is made up of the cells that are mostly within the
+		// same mortality rate polygon.
+		var pr, zr []float64
+		var pTotal, deaths float64
+		for j := range p {
+			if region[j] == region[i] {
+				pr = append(pr, p[j])
+				zr = append(zr, z0[j])
+				pTotal += p[j]
+				deaths += p[j] * I[j]
+			}
+		}
+		io := epi.IoRegional(pr, zr, epi.NasariACS, deaths/pTotal/100000)
+		v := epi.Outcome(p[i], z1[i], io, epi.NasariACS) - epi.Outcome(p[i], z0[i], io, epi.NasariACS)
+		want = append(want, v)
+		wantTotal += v
+	}
+	if wantTotal == 0 {
+		t.Fatal("expected deaths should not be zero")
+	}
+
+	if len(r["Deaths"]) != len(want) {
+		t.Fatalf("length: have %d, want %d", len(r["Deaths"]), len(want))
+	}
+	for i, w := range want {
+		if different(r["Deaths"][i], w, 1.e-10) {
+			t.Errorf("Deaths %d: have %g, want %g", i, r["Deaths"][i], w)
+		}
+		if different(r["Deaths2"][i], 2*w, 1.e-10) {
+			t.Errorf("Deaths2 %d: have %g, want %g", i, r["Deaths2"][i], 2*w)
+		}
+		if different(r["TotDeaths"][i], wantTotal, 1.e-10) {
+			t.Errorf("TotDeaths %d: have %g, want %g", i, r["TotDeaths"][i], wantTotal)
+		}
+	}
+
+	if u := d.getUnits(name, m); u != "deaths/grid cell" {
+		t.Errorf("units: have %s", u)
+	}
+}
+
+func TestDeaths_invalid(t *testing.T) {
+	cfg, ctmdata, pop, popIndices, mr, mortIndices := VarGridTestData()
+	m := Mech{}
+	d := &InMAP{
+		InitFuncs: []DomainManipulator{
+			cfg.RegularGrid(ctmdata, pop, popIndices, mr, mortIndices, NewEmissions(), m),
+		},
+	}
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{
+		"Deaths:xxx:TotalPop:AllCause",
+		"Deaths:NasariACS:xxx:AllCause",
+		"Deaths:NasariACS:TotalPop:xxx",
+		"Deaths:NasariACS:TotalPop",
+	} {
+		if err := d.checkModelVars(m, name); err == nil {
+			t.Errorf("%s should cause an error", name)
+		}
+	}
+}
+
+func TestDeaths_baselineHR(t *testing.T) {
+	cfg, ctmdata, pop, popIndices, mr, mortIndices := VarGridTestData()
+	cfg.BaselineHR = "NasariACS"
+
+	m := Mech{}
+	d := &InMAP{
+		InitFuncs: []DomainManipulator{
+			cfg.RegularGrid(ctmdata, pop, popIndices, mr, mortIndices, NewEmissions(), m),
+		},
+	}
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range d.cells.array() {
+		c.Cf[iPM2_5] = float64(i + 1)
+	}
+
+	const name = "Deaths:NasariACS:TotalPop:AllCause"
+	o, err := NewOutputter("", false, map[string]string{
+		"Deaths": name,
+		"Io":     "AllCauseIo",
+	}, nil, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.checkModelVars(m, o.modelVariables...); err != nil {
+		t.Fatal(err)
+	}
+	r, err := d.Results(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	for i, c := range d.cells.array() {
+		if c.Layer != 0 {
+			break
+		}
+		p := c.PopData[popIndices["TotalPop"]]
+		I := c.MortData[mortIndices["AllCause"]]
+		io := c.IoData[mortIndices["AllCause"]]
+		if p == 0 {
+			continue
+		}
+		n++
+		// The baseline concentrations are above zero, so the underlying
+		// incidence rate should be less than the reported rate.
+		if !(io > 0 && io < I) {
+			t.Errorf("cell %d: underlying incidence %g should be between 0 and %g", i, io, I)
+		}
+		if r["Io"][i] != io {
+			t.Errorf("cell %d: Io output: have %g, want %g", i, r["Io"][i], io)
+		}
+		z0 := c.CBaseline[iPM2_5]
+		want := epi.Outcome(p, z0+c.Cf[iPM2_5], io/100000, epi.NasariACS) -
+			epi.Outcome(p, z0, io/100000, epi.NasariACS)
+		if different(r["Deaths"][i], want, 1.e-10) {
+			t.Errorf("cell %d: Deaths: have %g, want %g", i, r["Deaths"][i], want)
+		}
+	}
+	if n == 0 {
+		t.Fatal("no populated cells")
+	}
+	if u := d.getUnits("AllCauseIo", m); u != "deaths/100,000" {
+		t.Errorf("units: have %s", u)
+	}
+}
@@ -48,6 +48,28 @@ func NewCloudClient(cfg *Cfg) (cloudrpc.CloudRPCClient, error) {
 	return cloudrpc.NewCloudRPCClient(conn), nil
 }
 
+// NewLocalClient creates a client that runs simulations on the local
+// computer, based on the information in cfg.
+func NewLocalClient(cfg *Cfg) (*cloud.LocalClient, error) {
+	return cloud.NewLocalClient(
+		os.ExpandEnv(cfg.GetString("local_dir")),
+		cfg.Root,
+		cfg.OutputFiles(),
+		cfg.GetInt("local_concurrency"),
+		int32(cfg.GetInt("local_memory_gb")),
+	)
+}
+
+// newSRClient creates a client for running SR matrix simulations,
+// which runs them locally if the "local" option is set and
+// on a cloud cluster otherwise.
+func newSRClient(cfg *Cfg) (cloudrpc.CloudRPCClient, error) {
+	if cfg.GetBool("local") {
+		return NewLocalClient(cfg)
+	}
+	return NewCloudClient(cfg)
+}
+
 // CloudJobStart starts a new cloud job based on the information in cfg.
 func CloudJobStart(ctx context.Context, c cloudrpc.CloudRPCClient, cfg *Cfg) error {
 	in, err := cloud.JobSpec(
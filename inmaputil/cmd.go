@@ -52,7 +52,10 @@ type Cfg struct {
 	outputFiles []string
 
 	Root, versionCmd, runCmd, preprocCmd, combineCmd, steadyCmd, gridCmd    *cobra.Command
-	srCmd, srPredictCmd, srStartCmd, srSaveCmd, srCleanCmd                  *cobra.Command
+	preprocCheckCmd                                                         *cobra.Command
+	srCmd, srPredictCmd, srStartCmd, srSaveCmd, srCleanCmd, srRunCmd        *cobra.Command
+	srConvertCmd, srReceptorCmd, srBatchCmd, srServeCmd, srEvaluateCmd      *cobra.Command
+	srMergeCmd, srSubsetCmd                                                 *cobra.Command
 	cloudCmd, cloudStartCmd, cloudStatusCmd, cloudOutputCmd, cloudDeleteCmd *cobra.Command
 }
 
@@ -151,6 +154,10 @@ concentrations with no temporal variability.`,
 			if err != nil {
 				return err
 			}
+			disparityVars, err := checkDisparityVars(cfg.GetStringSlice("DisparityVariables"), outputVars)
+			if err != nil {
+				return err
+			}
 			emisUnits, err := checkEmissionUnits(cfg.GetString("EmissionUnits"))
 			if err != nil {
 				return err
@@ -178,9 +185,11 @@ concentrations with no temporal variability.`,
 				outputFile,
 				cfg.GetBool("OutputAllLayers"),
 				outputVars,
+				disparityVars,
 				emisUnits,
 				shapeFiles, mask,
 				vgc,
+				cfg.GetInt("year"),
 				inventoryConfig,
 				spatialConfig,
 				maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("InMAPData")), outChan),
@@ -224,29 +233,53 @@ file and saves the result for use in future InMAP simulations.`,
 			outChan := outChan()
 			ctx := context.TODO()
 
-			return Preproc(
-				os.ExpandEnv(cfg.GetString("Preproc.StartDate")),
-				os.ExpandEnv(cfg.GetString("Preproc.EndDate")),
-				os.ExpandEnv(cfg.GetString("Preproc.CTMType")),
-				maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.WRFChem.WRFOut")), outChan),
-				maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.GEOSChem.GEOSA1")), outChan),
-				maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.GEOSChem.GEOSA3Cld")), outChan),
-				maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.GEOSChem.GEOSA3Dyn")), outChan),
-				maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.GEOSChem.GEOSI3")), outChan),
-				maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.GEOSChem.GEOSA3MstE")), outChan),
-				os.ExpandEnv(cfg.GetString("Preproc.GEOSChem.GEOSApBp")),
-				maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.GEOSChem.GEOSChem")), outChan),
-				maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.GEOSChem.OlsonLandMap")), outChan),
-				maybeDownload(ctx, os.ExpandEnv(cfg.GetString("InMAPData")), outChan),
-				cfg.GetFloat64("Preproc.CtmGridXo"),
-				cfg.GetFloat64("Preproc.CtmGridYo"),
-				cfg.GetFloat64("Preproc.CtmGridDx"),
-				cfg.GetFloat64("Preproc.CtmGridDy"),
-				cfg.GetBool("Preproc.GEOSChem.Dash"),
-				cfg.GetString("Preproc.GEOSChem.ChemRecordInterval"),
-				cfg.GetString("Preproc.GEOSChem.ChemFileInterval"),
-				cfg.GetBool("Preproc.GEOSChem.NoChemHourIndex"),
-			)
+			return Preproc(&PreprocOptions{
+				StartDate:               os.ExpandEnv(cfg.GetString("Preproc.StartDate")),
+				EndDate:                 os.ExpandEnv(cfg.GetString("Preproc.EndDate")),
+				CTMType:                 os.ExpandEnv(cfg.GetString("Preproc.CTMType")),
+				WRFOut:                  maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.WRFChem.WRFOut")), outChan),
+				METCRO3D:                maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.CMAQ.METCRO3D")), outChan),
+				METCRO2D:                maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.CMAQ.METCRO2D")), outChan),
+				METDOT3D:                maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.CMAQ.METDOT3D")), outChan),
+				GRIDCRO2D:               maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.CMAQ.GRIDCRO2D")), outChan),
+				CONC:                    maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.CMAQ.CONC")), outChan),
+				CAMxMet3D:               maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.CAMx.Met3D")), outChan),
+				CAMxMet2D:               maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.CAMx.Met2D")), outChan),
+				CAMxKv:                  maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.CAMx.Kv")), outChan),
+				CAMxAvrg:                maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.CAMx.Avrg")), outChan),
+				NetCDFMapping:           maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.NetCDF.Mapping")), outChan),
+				MixedMetMapping:         maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.Mixed.MetMapping")), outChan),
+				MixedChemCTMType:        cfg.GetString("Preproc.Mixed.ChemCTMType"),
+				MixedChemRecordInterval: cfg.GetString("Preproc.Mixed.ChemRecordInterval"),
+				GEOSA1:                  maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.GEOSChem.GEOSA1")), outChan),
+				GEOSA3Cld:               maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.GEOSChem.GEOSA3Cld")), outChan),
+				GEOSA3Dyn:               maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.GEOSChem.GEOSA3Dyn")), outChan),
+				GEOSI3:                  maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.GEOSChem.GEOSI3")), outChan),
+				GEOSA3MstE:              maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.GEOSChem.GEOSA3MstE")), outChan),
+				GEOSApBp:                os.ExpandEnv(cfg.GetString("Preproc.GEOSChem.GEOSApBp")),
+				GEOSChem:                maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.GEOSChem.GEOSChem")), outChan),
+				OlsonLandMap:            maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.GEOSChem.OlsonLandMap")), outChan),
+				InMAPData:               maybeDownload(ctx, os.ExpandEnv(cfg.GetString("InMAPData")), outChan),
+				LandCoverFile:           maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.LandCover.File")), outChan),
+				LandCoverMapping:        maybeDownload(ctx, os.ExpandEnv(cfg.GetString("Preproc.LandCover.Mapping")), outChan),
+				LandCoverProj:           cfg.GetString("Preproc.LandCover.Proj"),
+				GridProj:                cfg.GetString("VarGrid.GridProj"),
+				CtmGridXo:               cfg.GetFloat64("Preproc.CtmGridXo"),
+				CtmGridYo:               cfg.GetFloat64("Preproc.CtmGridYo"),
+				CtmGridDx:               cfg.GetFloat64("Preproc.CtmGridDx"),
+				CtmGridDy:               cfg.GetFloat64("Preproc.CtmGridDy"),
+				ChemGridXo:              cfg.GetFloat64("Preproc.Mixed.ChemGridXo"),
+				ChemGridYo:              cfg.GetFloat64("Preproc.Mixed.ChemGridYo"),
+				ChemGridDx:              cfg.GetFloat64("Preproc.Mixed.ChemGridDx"),
+				ChemGridDy:              cfg.GetFloat64("Preproc.Mixed.ChemGridDy"),
+				Dash:                    cfg.GetBool("Preproc.GEOSChem.Dash"),
+				ChemRecordInterval:      cfg.GetString("Preproc.GEOSChem.ChemRecordInterval"),
+				ChemFileInterval:        cfg.GetString("Preproc.GEOSChem.ChemFileInterval"),
+				NoChemHourIndex:         cfg.GetBool("Preproc.GEOSChem.NoChemHourIndex"),
+				TimePeriods:             cfg.GetString("Preproc.TimePeriods"),
+				Workers:                 cfg.GetInt("Preproc.Workers"),
+				MaxResidentFields:       cfg.GetInt("Preproc.MaxResidentFields"),
+			})
 		},
 		DisableAutoGenTag: true,
 	}
@@ -272,7 +305,7 @@ each nested grid.`,
 					return fmt.Errorf("loading preprocessed input file: %w", err)
 				}
 			}
-			combined, err := inmap.CombineCTMData(data...)
+			combined, err := inmap.CombineCTMDataBlend(cfg.GetFloat64("blend_buffer"), data...)
 			if err != nil {
 				return fmt.Errorf("combining preprocessed input files: %w", err)
 			}
@@ -280,7 +313,50 @@ each nested grid.`,
 			if err != nil {
 				return fmt.Errorf("creating output file: %w", err)
 			}
-			return combined.Write(f)
+			if err = combined.Write(f); err != nil {
+				return err
+			}
+			if err = f.Close(); err != nil {
+				return err
+			}
+			jumpFile := os.ExpandEnv(cfg.GetString("jump_diagnostics_file"))
+			if jumpFile == "" {
+				return nil
+			}
+			jumps, err := inmap.NestBoundaryJumps(combined, data...)
+			if err != nil {
+				return fmt.Errorf("calculating nest boundary jumps: %w", err)
+			}
+			f, err = os.Create(jumpFile)
+			if err != nil {
+				return fmt.Errorf("creating jump diagnostics file: %w", err)
+			}
+			if err = jumps.Write(f); err != nil {
+				f.Close()
+				return err
+			}
+			return f.Close()
+		},
+		DisableAutoGenTag: true,
+	}
+
+	cfg.preprocCheckCmd = &cobra.Command{
+		Use:   "check",
+		Short: "Check preprocessed CTM data for problems",
+		Long: `check checks the preprocessed chemical transport model data
+in the InMAPData file for values outside of physically plausible ranges,
+such as NaN values, negative Kzz, or zero wind speed, which could
+cause simulations to fail or give incorrect results, and reports the number
+and locations of any problems that are found. It can also
+write summary statistics and maps of each layer of the data. The same checks
+are run automatically at the end of preprocessing.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return PreprocCheck(
+				maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("InMAPData")), outChan()),
+				os.ExpandEnv(cfg.GetString("check_output_dir")),
+				cfg.GetString("check_format"),
+				cfg.GetStringSlice("check_variables"),
+			)
 		},
 		DisableAutoGenTag: true,
 	}
@@ -295,7 +371,9 @@ each nested grid.`,
 		Use:   "start",
 		Short: "Start simulations to create an SR matrix",
 		Long: `start starts the InMAP simulations necessary to create
-a source-receptor matrix.`,
+a source-receptor matrix. If the --local flag is set, the simulations are
+run on this computer and start waits for them to finish; otherwise they
+are run on the cluster specified by --addr.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			vgc, err := VarGridConfig(cfg.Viper)
 			if err != nil {
@@ -305,7 +383,7 @@ a source-receptor matrix.`,
 			if err != nil {
 				return fmt.Errorf("inmap: reading SR 'layers': %v", err)
 			}
-			c, err := NewCloudClient(cfg)
+			c, err := newSRClient(cfg)
 			if err != nil {
 				return err
 			}
@@ -342,7 +420,7 @@ a source-receptor matrix.`,
 			if err != nil {
 				return fmt.Errorf("inmap: reading SR 'layers': %v", err)
 			}
-			c, err := NewCloudClient(cfg)
+			c, err := newSRClient(cfg)
 			if err != nil {
 				return err
 			}
@@ -362,6 +440,263 @@ a source-receptor matrix.`,
 		DisableAutoGenTag: true,
 	}
 
+	cfg.srRunCmd = &cobra.Command{
+		Use:   "run",
+		Short: "Run simulations on this computer to create an SR matrix",
+		Long: `run creates a source-receptor matrix by running InMAP simulations
+on this computer and saves the results to SR.OutputFile.
+Rather than running a separate simulation for each source, each simulation
+includes batch_size unit emissions sources whose impacts are tracked
+separately, which is much faster than using 'start' and 'save'.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			outChan := outChan()
+
+			vgc, err := VarGridConfig(cfg.Viper)
+			if err != nil {
+				return err
+			}
+			layers, err := intSliceFromString(cfg.GetString("layers"))
+			if err != nil {
+				return fmt.Errorf("inmap: reading SR 'layers': %v", err)
+			}
+			ctx := context.TODO()
+			return RunSR(
+				ctx,
+				os.ExpandEnv(cfg.GetString("SR.OutputFile")),
+				maybeDownload(ctx, os.ExpandEnv(cfg.GetString("VariableGridData")), outChan),
+				vgc,
+				cfg.GetInt("begin"),
+				cfg.GetInt("end"),
+				layers,
+				cfg.GetInt("batch_size"),
+				cfg.GetInt("NumIterations"),
+			)
+		},
+		DisableAutoGenTag: true,
+	}
+
+	cfg.srConvertCmd = &cobra.Command{
+		Use:   "convert",
+		Short: "Convert an SR matrix to the sparse format",
+		Long: `convert converts the SR matrix at SR.OutputFile to the sparse format
+and saves it to sparse_file. For each source, receptor values smaller than
+sparse_threshold times the largest value for that source are not stored,
+which can make the file much smaller. SR matrices in either format can be
+used anywhere an SR matrix is required.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return ConvertSR(
+				os.ExpandEnv(cfg.GetString("SR.OutputFile")),
+				os.ExpandEnv(cfg.GetString("sparse_file")),
+				cfg.GetFloat64("sparse_threshold"),
+			)
+		},
+		DisableAutoGenTag: true,
+	}
+
+	cfg.srReceptorCmd = &cobra.Command{
+		Use:   "receptor",
+		Short: "Calculate the contributions of sources to a receptor region",
+		Long: `receptor uses the SR matrix specified in the configuration file
+field SR.OutputFile to calculate the contribution of emissions in each grid
+cell to the area-weighted average concentrations in the receptor region made
+up of the polygons in receptor_shapefile. The results, in units of μg m-3
+concentration in the receptor region per μg s-1 emissions in each grid cell,
+are written to the shapefile specified in the OutputFile field of the
+configuration file.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			vgc, err := VarGridConfig(cfg.Viper)
+			if err != nil {
+				return err
+			}
+			outputFile, err := checkOutputFile(cfg.GetString("OutputFile"))
+			if err != nil {
+				return err
+			}
+			return SRReceptor(
+				os.ExpandEnv(cfg.GetString("SR.OutputFile")),
+				outputFile,
+				os.ExpandEnv(cfg.GetString("receptor_shapefile")),
+				cfg.GetInt("receptor_layer"),
+				vgc,
+			)
+		},
+		DisableAutoGenTag: true,
+	}
+
+	cfg.srBatchCmd = &cobra.Command{
+		Use:   "batch",
+		Short: "Predict concentrations for a batch of emissions scenarios",
+		Long: `batch uses the SR matrix specified in the configuration file
+field SR.OutputFile to predict concentrations for each of the emissions
+scenarios in the CSV file scenario_manifest. The manifest has the columns
+"Scenario", "File", and "Scale", with one row for each emissions shapefile
+in each scenario; the emissions in each file are multiplied by Scale, and
+relative file paths are relative to the directory containing the manifest.
+For each scenario and each variable in the OutputVariables configuration
+field, the total, minimum, maximum, and population-weighted mean values
+across ground-level grid cells are written in long format to the CSV file
+scenario_table. If scenario_grid_dir is set, the gridded results for each
+scenario are also written to a shapefile named after the scenario in that
+directory. The concentrations caused by each emissions file are only
+calculated once, and up to scenario_workers scenarios are evaluated concurrently.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			vgc, err := VarGridConfig(cfg.Viper)
+			if err != nil {
+				return err
+			}
+			outputVars, err := checkOutputVars(GetStringMapString("OutputVariables", cfg.Viper))
+			if err != nil {
+				return err
+			}
+			emisUnits, err := checkEmissionUnits(cfg.GetString("EmissionUnits"))
+			if err != nil {
+				return err
+			}
+			mask, err := parseMask(cfg.GetString("EmissionMaskGeoJSON"))
+			if err != nil {
+				return err
+			}
+			return SRBatch(
+				emisUnits,
+				os.ExpandEnv(cfg.GetString("SR.OutputFile")),
+				os.ExpandEnv(cfg.GetString("scenario_manifest")),
+				os.ExpandEnv(cfg.GetString("scenario_table")),
+				os.ExpandEnv(cfg.GetString("scenario_grid_dir")),
+				outputVars,
+				mask,
+				vgc,
+				cfg.GetInt("year"),
+				cfg.GetBool("load_popmort"),
+				cfg.GetInt("scenario_workers"),
+			)
+		},
+		DisableAutoGenTag: true,
+	}
+
+	cfg.srServeCmd = &cobra.Command{
+		Use:   "serve",
+		Short: "Serve an SR matrix over HTTP",
+		Long: `serve serves a JSON API for the SR matrix specified in the configuration
+file field SR.OutputFile at the network address serve_address. The API
+provides the SR matrix grid geometry at /geometry, the concentrations caused
+by emissions from individual sources at
+/source?pollutant=<pollutant>&layer=<layer>&index=<index>, and the
+concentrations caused by the array of emissions records in the body of
+POST requests to /concentrations.
+
+For this command and the other commands that use an SR matrix, SR.OutputFile
+can be an http(s) URL or a blob storage path (e.g., gs://bucket/sr.ncf), in
+which case the SR matrix is read remotely using range requests rather than
+being downloaded.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return SRServe(
+				os.ExpandEnv(cfg.GetString("SR.OutputFile")),
+				cfg.GetString("serve_address"),
+			)
+		},
+		DisableAutoGenTag: true,
+	}
+
+	cfg.srEvaluateCmd = &cobra.Command{
+		Use:   "evaluate",
+		Short: "Evaluate SR matrix predictions against a full InMAP simulation",
+		Long: `evaluate runs a full InMAP simulation on the variable resolution grid
+specified by VariableGridData and predicts concentrations using the SR matrix
+specified by SR.OutputFile, for the emissions in EmissionsShapefiles, to
+evaluate how well the SR matrix reproduces the InMAP results. The SR matrix
+must have been created using the same grid.
+The mean bias (MB), mean error (ME), mean fractional bias (MFB), mean
+fractional error (MFE), and linear regression slope, intercept, and R²
+of the SR matrix predictions relative to the InMAP results for each species
+are written to a CSV file with the suffix '_evaluation.csv' in the same
+location as OutputFile, and maps of the differences between the SR matrix
+predictions and the InMAP results (SR matrix minus InMAP) are written to
+OutputFile. Grid cells where both concentrations are zero are not included
+in the MFB and MFE.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			outChan := outChan()
+
+			vgc, err := VarGridConfig(cfg.Viper)
+			if err != nil {
+				return err
+			}
+			outputFile, err := checkOutputFile(cfg.GetString("OutputFile"))
+			if err != nil {
+				return err
+			}
+			emisUnits, err := checkEmissionUnits(cfg.GetString("EmissionUnits"))
+			if err != nil {
+				return err
+			}
+			ctx := context.TODO()
+			shapeFiles := expandStringSlice(cfg.GetStringSlice("EmissionsShapefiles"))
+			for i := range shapeFiles {
+				shapeFiles[i] = maybeDownload(ctx, shapeFiles[i], outChan)
+			}
+			mask, err := parseMask(cfg.GetString("EmissionMaskGeoJSON"))
+			if err != nil {
+				return err
+			}
+			return SREvaluate(
+				ctx,
+				emisUnits,
+				os.ExpandEnv(cfg.GetString("SR.OutputFile")),
+				maybeDownload(ctx, os.ExpandEnv(cfg.GetString("VariableGridData")), outChan),
+				outputFile,
+				shapeFiles,
+				mask,
+				vgc,
+				cfg.GetInt("NumIterations"),
+			)
+		},
+		DisableAutoGenTag: true,
+	}
+
+	cfg.srMergeCmd = &cobra.Command{
+		Use:   "merge",
+		Short: "Combine SR matrices",
+		Long: `merge combines the SR matrices in merge_files, which must have been created
+using the same grid but can contain different layers or ranges of grid cells
+(for example, from 'sr run' or 'sr save' with different begin and end values),
+and saves the result to SR.OutputFile. The merged SR matrix includes all of
+the layers in any of the input files. A grid cell is considered to be included
+in an input file if its results are not all zero; if a grid cell is included
+in more than one input file, the results from the last of those files are used,
+so an SR matrix where part of the grid has been recreated can be listed after
+the original to replace that part.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return MergeSR(
+				os.ExpandEnv(cfg.GetString("SR.OutputFile")),
+				expandStringSlice(cfg.GetStringSlice("merge_files")),
+			)
+		},
+		DisableAutoGenTag: true,
+	}
+
+	cfg.srSubsetCmd = &cobra.Command{
+		Use:   "subset",
+		Short: "Extract part of an SR matrix",
+		Long: `subset extracts the part of the SR matrix at SR.OutputFile within the
+region made up of the polygons in subset_shapefile and saves it to subset_file
+as a smaller SR matrix that can be used on its own. The subset includes the
+grid cells whose centers are within the region, as both sources and receptors,
+so emissions outside of the region are ignored and concentrations are only
+calculated within the region.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			vgc, err := VarGridConfig(cfg.Viper)
+			if err != nil {
+				return err
+			}
+			return SubsetSR(
+				os.ExpandEnv(cfg.GetString("SR.OutputFile")),
+				os.ExpandEnv(cfg.GetString("subset_file")),
+				os.ExpandEnv(cfg.GetString("subset_shapefile")),
+				vgc,
+			)
+		},
+		DisableAutoGenTag: true,
+	}
+
 	cfg.srCleanCmd = &cobra.Command{
 		Use:   "clean",
 		Short: "clean cleans up temporary simulation output",
@@ -377,7 +712,7 @@ a source-receptor matrix.`,
 			if err != nil {
 				return fmt.Errorf("inmap: reading SR 'layers': %v", err)
 			}
-			c, err := NewCloudClient(cfg)
+			c, err := newSRClient(cfg)
 			if err != nil {
 				return err
 			}
@@ -482,11 +817,19 @@ a source-receptor matrix.`,
 		Short: "Predict concentrations",
 		Long: `predict uses the SR matrix specified in the configuration file
 field SR.OutputFile to predict concentrations resulting
-from the emissions specified in the EmissionsShapefiles field in the configuration
+from the emissions specified in the EmissionsShapefiles field and the emissions
+inventories specified in the aep.InventoryConfig fields in the configuration
 file, outputting the results in the shapefile specified in OutputFile field.
 of the configuration file. The EmissionUnits field in the configuration
-file specifies the units of the emissions. The OutputVariables configuration
-variable specifies the information to be output.`,
+file specifies the units of the emissions in EmissionsShapefiles. Inventory
+emissions are allocated to the SR matrix grid using the aep spatial
+surrogate configuration fields, and point sources with stack parameters
+are allocated among SR matrix layers using plume rise.
+The OutputVariables configuration variable specifies the information to be output.
+Output expressions can include the population and mortality rate data stored
+in the SR matrix, as well as built-in health outcome variables such as
+'Deaths:NasariACS:TotalPop:allcause'. The year and load_popmort options
+can be used to replace the stored data with data from the VarGrid configuration.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			outChan := outChan()
 
@@ -502,6 +845,10 @@ variable specifies the information to be output.`,
 			if err != nil {
 				return err
 			}
+			disparityVars, err := checkDisparityVars(cfg.GetStringSlice("DisparityVariables"), outputVars)
+			if err != nil {
+				return err
+			}
 			emisUnits, err := checkEmissionUnits(cfg.GetString("EmissionUnits"))
 			if err != nil {
 				return err
@@ -518,15 +865,25 @@ variable specifies the information to be output.`,
 				return err
 			}
 
-			return SRPredict(
-				emisUnits,
-				os.ExpandEnv(cfg.GetString("SR.OutputFile")),
-				outputFile,
-				outputVars,
-				shapeFiles,
-				mask,
-				vgc,
-			)
+			inventoryConfig, spatialConfig, err := aeputilConfig(cfg.Viper)
+			if err != nil {
+				return err
+			}
+
+			return SRPredict(&SRPredictOptions{
+				EmissionUnits:       emisUnits,
+				SROutputFile:        os.ExpandEnv(cfg.GetString("SR.OutputFile")),
+				OutputFile:          outputFile,
+				OutputVariables:     outputVars,
+				DisparityVariables:  disparityVars,
+				EmissionsShapefiles: shapeFiles,
+				EmissionMask:        mask,
+				InventoryConfig:     inventoryConfig,
+				SpatialConfig:       spatialConfig,
+				VarGrid:             vgc,
+				Year:                cfg.GetInt("year"),
+				LoadPopMort:         cfg.GetBool("load_popmort"),
+			})
 		},
 		DisableAutoGenTag: true,
 	}
@@ -538,11 +895,12 @@ variable specifies the information to be output.`,
 	cfg.Root.AddCommand(cfg.gridCmd)
 	cfg.Root.AddCommand(cfg.preprocCmd)
 	cfg.Root.AddCommand(cfg.srCmd)
-	cfg.srCmd.AddCommand(cfg.srStartCmd, cfg.srSaveCmd, cfg.srCleanCmd)
+	cfg.srCmd.AddCommand(cfg.srStartCmd, cfg.srSaveCmd, cfg.srCleanCmd, cfg.srRunCmd, cfg.srConvertCmd, cfg.srReceptorCmd, cfg.srBatchCmd, cfg.srServeCmd, cfg.srEvaluateCmd,
+		cfg.srMergeCmd, cfg.srSubsetCmd)
 	cfg.Root.AddCommand(cfg.srPredictCmd)
 	cfg.Root.AddCommand(cfg.cloudCmd)
 	cfg.cloudCmd.AddCommand(cfg.cloudStartCmd, cfg.cloudStatusCmd, cfg.cloudOutputCmd, cfg.cloudDeleteCmd)
-	cfg.preprocCmd.AddCommand(cfg.combineCmd)
+	cfg.preprocCmd.AddCommand(cfg.combineCmd, cfg.preprocCheckCmd)
 
 	// Options are the configuration options available to InMAP.
 	options = []struct {
@@ -638,7 +996,7 @@ variable specifies the information to be output.`,
 			name:       "VarGrid.GridProj",
 			usage:      `GridProj gives projection info for the CTM grid in Proj4 or WKT format.`,
 			defaultVal: "+proj=lcc +lat_1=33.000000 +lat_2=45.000000 +lat_0=40.000000 +lon_0=-97.000000 +x_0=0 +y_0=0 +a=6370997.000000 +b=6370997.000000 +to_meter=1",
-			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags(), cfg.srPredictCmd.Flags()},
+			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags(), cfg.srPredictCmd.Flags(), cfg.preprocCmd.Flags()},
 		},
 		{
 			name: "VarGrid.HiResLayers",
@@ -668,9 +1026,16 @@ variable specifies the information to be output.`,
 			defaultVal: 0.000000001,
 			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags()},
 		},
+		{
+			name: "VarGrid.MassWeightedPartitioning",
+			usage: `VarGrid.MassWeightedPartitioning specifies that the gas/particle partitioning fractions in each grid cell should be averaged over the CTM grid cells that intersect it weighted by gas- plus particle-phase mass rather than by area alone. This is intended for high-resolution CTM output where the CTM grid cells are no larger than the smallest variable grid cells.
+`,
+			defaultVal: false,
+			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags()},
+		},
 		{
 			name: "VarGrid.CensusFile",
-			usage: `VarGrid.CensusFile is the path to the shapefile or COARDs-compliant NetCDF file holding population information.
+			usage: `VarGrid.CensusFile is the path to the shapefile, COARDs-compliant NetCDF file, GeoTIFF file (.tif), point CSV file (.csv), or GeoJSON file (.geojson) holding population information. GeoTIFF files must be in geographic coordinates with one band per CensusPopColumns entry, matched by band description or, if the bands have no descriptions, by order. CSV files must have x and y (or lon and lat) columns, which are assumed to be longitude and latitude unless a .prj file with the same base name is present. Population in points is assigned to the grid cell that contains the point.
 `,
 			defaultVal:  "${INMAP_ROOT_DIR}/cmd/inmap/testdata/testPopulation.shp",
 			isInputFile: true,
@@ -692,7 +1057,7 @@ variable specifies the information to be output.`,
 		},
 		{
 			name: "VarGrid.MortalityRateFile",
-			usage: `VarGrid.MortalityRateFile is the path to the shapefile containing baseline mortality rate data.
+			usage: `VarGrid.MortalityRateFile is the path to the shapefile, GeoTIFF file (.tif), point CSV file (.csv), or GeoJSON file (.geojson) containing baseline mortality rate data. GeoTIFF and CSV files follow the same conventions as CensusFile. When mortality rates are given as points, each grid cell uses the rates from the point nearest to each of its population shapes.
 `,
 			defaultVal:  "${INMAP_ROOT_DIR}/cmd/inmap/testdata/testMortalityRate.shp",
 			isInputFile: true,
@@ -712,13 +1077,48 @@ variable specifies the information to be output.`,
 			},
 			flagsets: []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags()},
 		},
+		{
+			name: "VarGrid.BaselineHR",
+			usage: `VarGrid.BaselineHR is the name of the hazard ratio function (NasariACS, Krewski2009, Krewski2009Ecologic, or Lepeule2012) used to calculate underlying incidence rates from the baseline mortality rates and baseline total PM2.5 concentrations. The underlying incidence rates are available as output variables with names in the form '<MortalityRateColumn>Io', and they are used by built-in health outcome variables that use the same hazard ratio function. If empty, underlying incidence rates are not calculated.
+`,
+			defaultVal: "",
+			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags()},
+		},
+		{
+			name: "VarGrid.CensusProjections",
+			usage: `VarGrid.CensusProjections gives paths to population files for different years, in the same format as CensusFile, with the years as keys (e.g., {"2030": "census2030.shp", "2050": "census2050.shp"}). When the year option is set, population is read from these files, interpolating linearly between the closest available years. If empty, CensusFile is used for all years. The paths can include environment variables.
+`,
+			defaultVal: map[string]string{},
+			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.srPredictCmd.Flags()},
+		},
+		{
+			name: "VarGrid.MortalityRateProjections",
+			usage: `VarGrid.MortalityRateProjections gives paths to baseline mortality rate files for different years, in the same format as MortalityRateFile, with the years as keys (e.g., {"2030": "mort2030.shp", "2050": "mort2050.shp"}). When the year option is set, mortality rates are read from these files, interpolating linearly between the closest available years. If empty, MortalityRateFile is used for all years. The paths can include environment variables.
+`,
+			defaultVal: map[string]string{},
+			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.srPredictCmd.Flags()},
+		},
+		{
+			name: "year",
+			usage: `year specifies the analysis year for population and mortality rates from VarGrid.CensusProjections and VarGrid.MortalityRateProjections. The population and mortality rates in the variable resolution grid or SR matrix are replaced by the data for that year, so the same grid can be used for any year. If 0, the population and mortality rate data are not changed.
+`,
+			defaultVal: 0,
+			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.srPredictCmd.Flags(), cfg.srBatchCmd.Flags()},
+		},
+		{
+			name: "load_popmort",
+			usage: `load_popmort specifies whether the population and mortality rate data stored in the SR matrix should be replaced by data loaded from VarGrid.CensusFile and VarGrid.MortalityRateFile when making predictions, for example to use population or mortality rate data that were not available when the SR matrix was created. It is ignored if year is not 0.
+`,
+			defaultVal: false,
+			flagsets:   []*pflag.FlagSet{cfg.srPredictCmd.Flags(), cfg.srBatchCmd.Flags()},
+		},
 		{
 			name: "InMAPData",
-			usage: `InMAPData is the path to location of baseline meteorology and pollutant data. The path can include environment variables.
+			usage: `InMAPData is the path to location of baseline meteorology and pollutant data. The path can include environment variables. The path can also include the wild card [PERIOD] to specify files created using the Preproc.TimePeriods option, in which case "inmap run steady" runs a separate simulation for each time period and outputs the average results weighted by period length; this requires the --static and --creategrid options.
 `,
 			defaultVal:  "${INMAP_ROOT_DIR}/cmd/inmap/testdata/testInMAPInputData.ncf",
 			isInputFile: true,
-			flagsets:    []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.srStartCmd.Flags(), cfg.preprocCmd.Flags(), cfg.cloudStartCmd.Flags()},
+			flagsets:    []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.srStartCmd.Flags(), cfg.preprocCmd.Flags(), cfg.preprocCheckCmd.Flags(), cfg.cloudStartCmd.Flags()},
 		},
 		{
 			name: "VariableGridData",
@@ -726,7 +1126,7 @@ variable specifies the information to be output.`,
 `,
 			defaultVal:  "${INMAP_ROOT_DIR}/cmd/inmap/testdata/inmapVarGrid.gob",
 			isInputFile: true,
-			flagsets:    []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.srStartCmd.PersistentFlags()},
+			flagsets:    []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.srStartCmd.PersistentFlags(), cfg.srRunCmd.Flags(), cfg.srEvaluateCmd.Flags()},
 		},
 		{
 			name: "EmissionsShapefiles",
@@ -734,21 +1134,21 @@ variable specifies the information to be output.`,
 `,
 			defaultVal:  []string{"${INMAP_ROOT_DIR}/cmd/inmap/testdata/testEmis.shp"},
 			isInputFile: true,
-			flagsets:    []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.srPredictCmd.Flags(), cfg.cloudStartCmd.Flags()},
+			flagsets:    []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.srPredictCmd.Flags(), cfg.srEvaluateCmd.Flags(), cfg.cloudStartCmd.Flags()},
 		},
 		{
 			name:        "EmissionMaskGeoJSON",
 			usage:       `EmissionMaskGeoJSON is an optional file containing a GeoJSON-formatted polygon string that specifies the area outside of which emissions will be ignored. The mask is assumed to  use the same spatial reference as VarGrid.GridProj. Example="{\"type\": \"Polygon\",\"coordinates\": [ [ [-4000, -4000], [4000, -4000], [4000, 4000], [-4000, 4000] ] ] }"`,
 			defaultVal:  "",
 			isInputFile: true,
-			flagsets:    []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.srPredictCmd.Flags(), cfg.cloudStartCmd.Flags()},
+			flagsets:    []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.srPredictCmd.Flags(), cfg.srBatchCmd.Flags(), cfg.srEvaluateCmd.Flags(), cfg.cloudStartCmd.Flags()},
 		},
 		{
 			name: "EmissionUnits",
 			usage: `EmissionUnits gives the units that the input emissions are in. Acceptable values are 'tons/year', 'kg/year', 'ug/s', and 'μg/s'.
 `,
 			defaultVal: "tons/year",
-			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.srPredictCmd.Flags(), cfg.cloudStartCmd.Flags()},
+			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.srPredictCmd.Flags(), cfg.srBatchCmd.Flags(), cfg.srEvaluateCmd.Flags(), cfg.cloudStartCmd.Flags()},
 		},
 		{
 			name: "OutputFile",
@@ -756,7 +1156,7 @@ variable specifies the information to be output.`,
 `,
 			defaultVal:   "inmap_output.shp",
 			isOutputFile: true,
-			flagsets:     []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.srPredictCmd.Flags()},
+			flagsets:     []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.srPredictCmd.Flags(), cfg.srReceptorCmd.Flags(), cfg.srEvaluateCmd.Flags()},
 		},
 		{
 			name: "LogFile",
@@ -781,14 +1181,21 @@ variable specifies the information to be output.`,
 				"TotalPM25": "PrimaryPM25 + pNH4 + pSO4 + pNO3 + SOA",
 				"TotalPopD": "(exp(log(1.078)/10 * TotalPM25) - 1) * TotalPop * AllCause / 100000",
 			},
-			flagsets: []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.cloudStartCmd.Flags(), cfg.srPredictCmd.Flags()},
+			flagsets: []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.cloudStartCmd.Flags(), cfg.srPredictCmd.Flags(), cfg.srBatchCmd.Flags()},
+		},
+		{
+			name: "DisparityVariables",
+			usage: `DisparityVariables specifies which of the OutputVariables exposure disparity metrics should be calculated for. For each population group in VarGrid.CensusPopColumns, the population-weighted mean exposure, the ratio and difference relative to the VarGrid.PopGridColumn population, the Atkinson and Theil inequality indices, and population-weighted exposure percentiles are written to a CSV file with the same name as OutputFile but ending in '_disparity.csv'. It can include environment variables.
+`,
+			defaultVal: []string{},
+			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.cloudStartCmd.Flags(), cfg.srPredictCmd.Flags()},
 		},
 		{
 			name: "NumIterations",
 			usage: `NumIterations is the number of iterations to calculate. If < 1, convergence is automatically calculated.
 `,
 			defaultVal: 0,
-			flagsets:   []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags()},
+			flagsets:   []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags(), cfg.srRunCmd.Flags(), cfg.srEvaluateCmd.Flags()},
 		},
 		{
 			name: "aep.InventoryConfig.NEIFiles",
@@ -796,7 +1203,7 @@ variable specifies the information to be output.`,
 `,
 			defaultVal:  map[string][]string{},
 			isInputFile: true,
-			flagsets:    []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags()},
+			flagsets:    []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.srPredictCmd.Flags(), cfg.cloudStartCmd.Flags()},
 		},
 		{
 			name: "aep.InventoryConfig.COARDSFiles",
@@ -804,20 +1211,20 @@ variable specifies the information to be output.`,
 `,
 			defaultVal:  map[string][]string{},
 			isInputFile: true,
-			flagsets:    []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags()},
+			flagsets:    []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.srPredictCmd.Flags(), cfg.cloudStartCmd.Flags()},
 		},
 		{
 			name: "aep.InventoryConfig.COARDSYear",
 			usage: `COARDSYear specifies the year of emissions for COARDS emissions files. COARDS emissions are assumed to be in units of mass of emissions per year. The year will not be used for NEI emissions files.
 `,
 			defaultVal: 0,
-			flagsets:   []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags()},
+			flagsets:   []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.srPredictCmd.Flags(), cfg.cloudStartCmd.Flags()},
 		},
 		{
 			name:       "aep.InventoryConfig.InputUnits",
 			usage:      `InputUnits specifies the units of input data. Acceptable values are 'tons', 'tonnes', 'kg', 'g', and 'lbs'. This value will be used for AEP emissions only, not for shapefiles.`,
 			defaultVal: "no_default",
-			flagsets:   []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags()},
+			flagsets:   []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.srPredictCmd.Flags(), cfg.cloudStartCmd.Flags()},
 		},
 		{
 			name: "aep.SrgSpecSMOKE",
@@ -825,7 +1232,7 @@ variable specifies the information to be output.`,
 `,
 			defaultVal:  "",
 			isInputFile: true,
-			flagsets:    []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags()},
+			flagsets:    []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.srPredictCmd.Flags(), cfg.cloudStartCmd.Flags()},
 		},
 		{
 			name: "aep.SrgSpecOSM",
@@ -833,14 +1240,14 @@ variable specifies the information to be output.`,
 `,
 			defaultVal:  "",
 			isInputFile: true,
-			flagsets:    []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags()},
+			flagsets:    []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.srPredictCmd.Flags(), cfg.cloudStartCmd.Flags()},
 		},
 		{
 			name: "aep.SrgShapefileDirectory",
 			usage: `SrgShapefileDirectory gives the location of the directory holding the shapefiles used for creating spatial surrogates. It is used for assigning spatial locations to emissions records. It is only used when SrgSpecType == "SMOKE".
 `,
 			defaultVal: "no_default",
-			flagsets:   []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags()},
+			flagsets:   []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.srPredictCmd.Flags(), cfg.cloudStartCmd.Flags()},
 		},
 		{
 			name: "aep.GridRef",
@@ -848,61 +1255,61 @@ variable specifies the information to be output.`,
 `,
 			defaultVal:  []string{"no_default"},
 			isInputFile: true,
-			flagsets:    []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags()},
+			flagsets:    []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.srPredictCmd.Flags(), cfg.cloudStartCmd.Flags()},
 		},
 		{
 			name: "aep.SCCExactMatch",
 			usage: `SCCExactMatch specifies whether SCC codes must match exactly when processing emissions.
 `,
 			defaultVal: true,
-			flagsets:   []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags()},
+			flagsets:   []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.srPredictCmd.Flags(), cfg.cloudStartCmd.Flags()},
 		},
 		{
 			name: "aep.SpatialConfig.InputSR",
 			usage: `InputSR specifies the input emissions spatial reference in Proj4 format.
 `,
 			defaultVal: "+proj=longlat",
-			flagsets:   []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags()},
+			flagsets:   []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.srPredictCmd.Flags(), cfg.cloudStartCmd.Flags()},
 		},
 		{
 			name: "aep.SpatialConfig.SpatialCache",
 			usage: `SpatialCache specifies the location for storing spatial emissions data for quick access. If this is left empty, no cache will be used.
 `,
 			defaultVal: "",
-			flagsets:   []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags()},
+			flagsets:   []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.srPredictCmd.Flags(), cfg.cloudStartCmd.Flags()},
 		},
 		{
 			name:       "aep.SpatialConfig.SrgDataCache",
 			usage:      `SrgDataCache specifies the location for caching spatial surrogate input data. If it is empty, the input surrogate data will be stored in SpatialCache.`,
 			defaultVal: "",
-			flagsets:   []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags()},
+			flagsets:   []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.srPredictCmd.Flags(), cfg.cloudStartCmd.Flags()},
 		},
 		{
 			name: "aep.SpatialConfig.MaxCacheEntries",
 			usage: `MaxCacheEntries specifies the maximum number of emissions and concentrations surrogates to hold in a memory cache. Larger numbers can result in faster processing but increased memory usage.
 `,
 			defaultVal: 10,
-			flagsets:   []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags()},
+			flagsets:   []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.srPredictCmd.Flags(), cfg.cloudStartCmd.Flags()},
 		},
 		{
 			name: "aep.SpatialConfig.GridName",
 			usage: `GridName specifies a name for the grid which is used in the names of intermediate and output files. Changes to the geometry of the grid must be accompanied by either a a change in GridName or the deletion of all the files in the SpatialCache directory.
 `,
 			defaultVal: "inmap",
-			flagsets:   []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags()},
+			flagsets:   []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.srPredictCmd.Flags(), cfg.cloudStartCmd.Flags()},
 		},
 		{
 			name: "SR.OutputFile",
-			usage: `SR.OutputFile is the path where the output file is or should be created when creating a source-receptor matrix. It can contain environment variables.
+			usage: `SR.OutputFile is the path where the output file is or should be created when creating a source-receptor matrix. It can contain environment variables. When using an existing SR matrix, it can also be an http(s) URL or a blob storage path, in which case the SR matrix is read remotely rather than being downloaded.
 `,
 			defaultVal:   "${INMAP_ROOT_DIR}/cmd/inmap/testdata/output_${InMAPRunType}.shp",
 			isOutputFile: false,
 			isInputFile:  false,
-			flagsets:     []*pflag.FlagSet{cfg.srSaveCmd.Flags(), cfg.srPredictCmd.Flags(), cfg.cloudStartCmd.Flags()},
+			flagsets:     []*pflag.FlagSet{cfg.srSaveCmd.Flags(), cfg.srRunCmd.Flags(), cfg.srConvertCmd.Flags(), cfg.srReceptorCmd.Flags(), cfg.srBatchCmd.Flags(), cfg.srServeCmd.Flags(), cfg.srEvaluateCmd.Flags(), cfg.srMergeCmd.Flags(), cfg.srSubsetCmd.Flags(), cfg.srPredictCmd.Flags(), cfg.cloudStartCmd.Flags()},
 		},
 		{
 			name: "Preproc.CTMType",
-			usage: `Preproc.CTMType specifies what type of chemical transport model we are going to be reading data from. Valid options are "GEOS-Chem" and "WRF-Chem".
+			usage: `Preproc.CTMType specifies what type of chemical transport model we are going to be reading data from. Valid options are "GEOS-Chem", "WRF-Chem", "CMAQ", "CAMx", "NetCDF", and "Mixed".
 `,
 			defaultVal: "WRF-Chem",
 			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
@@ -914,6 +1321,123 @@ variable specifies the information to be output.`,
 			defaultVal: "${INMAP_ROOT_DIR}/cmd/inmap/testdata/preproc/wrfout_d01_[DATE]",
 			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
 		},
+		{
+			name: "Preproc.CMAQ.METCRO3D",
+			usage: `Preproc.CMAQ.METCRO3D is the location of the MCIP 3-D cross-point meteorology files, which must include the WWIND and CFRAC_3D variables. [DATE] should be used as a wild card for the simulation date, in the format YYYYMMDD.
+`,
+			defaultVal: "${INMAP_ROOT_DIR}/cmd/inmap/testdata/preproc/cmaq/METCRO3D_[DATE].nc",
+			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
+		},
+		{
+			name: "Preproc.CMAQ.METCRO2D",
+			usage: `Preproc.CMAQ.METCRO2D is the location of the MCIP 2-D cross-point meteorology files. [DATE] should be used as a wild card for the simulation date, in the format YYYYMMDD.
+`,
+			defaultVal: "${INMAP_ROOT_DIR}/cmd/inmap/testdata/preproc/cmaq/METCRO2D_[DATE].nc",
+			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
+		},
+		{
+			name: "Preproc.CMAQ.METDOT3D",
+			usage: `Preproc.CMAQ.METDOT3D is the location of the MCIP 3-D dot-point meteorology files. [DATE] should be used as a wild card for the simulation date, in the format YYYYMMDD.
+`,
+			defaultVal: "${INMAP_ROOT_DIR}/cmd/inmap/testdata/preproc/cmaq/METDOT3D_[DATE].nc",
+			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
+		},
+		{
+			name: "Preproc.CMAQ.GRIDCRO2D",
+			usage: `Preproc.CMAQ.GRIDCRO2D is the location of the time-independent MCIP 2-D cross-point grid file. Its dominant land use categories (DLUSE) must be from the 24-category USGS classification.
+`,
+			defaultVal:  "${INMAP_ROOT_DIR}/cmd/inmap/testdata/preproc/cmaq/GRIDCRO2D.nc",
+			isInputFile: true,
+			flagsets:    []*pflag.FlagSet{cfg.preprocCmd.Flags()},
+		},
+		{
+			name: "Preproc.CMAQ.CONC",
+			usage: `Preproc.CMAQ.CONC is the location of the CMAQ CONC or ACONC concentration files, which must contain all model layers. [DATE] should be used as a wild card for the simulation date, in the format YYYYMMDD.
+`,
+			defaultVal: "${INMAP_ROOT_DIR}/cmd/inmap/testdata/preproc/cmaq/CCTM_ACONC_[DATE].nc",
+			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
+		},
+		{
+			name: "Preproc.CAMx.Met3D",
+			usage: `Preproc.CAMx.Met3D is the location of the CAMx 3-D meteorology input files, which must include the ZGRID_M, PRESS_MB, TEMP_K, HUMID_PPM, UWIND_MpS, VWIND_MpS, CLWC_GpM3, and PRWC_GpM3 variables. [DATE] should be used as a wild card for the simulation date, in the format YYYYMMDD.
+`,
+			defaultVal: "${INMAP_ROOT_DIR}/cmd/inmap/testdata/preproc/camx/camx.3d.[DATE].nc",
+			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
+		},
+		{
+			name: "Preproc.CAMx.Met2D",
+			usage: `Preproc.CAMx.Met2D is the location of the CAMx 2-D surface meteorology input files, which must include the TSURF_K and SWSFC_WpM2 variables and either LUCAT11 or LUCAT26 land use fractions. [DATE] should be used as a wild card for the simulation date, in the format YYYYMMDD.
+`,
+			defaultVal: "${INMAP_ROOT_DIR}/cmd/inmap/testdata/preproc/camx/camx.2d.[DATE].nc",
+			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
+		},
+		{
+			name: "Preproc.CAMx.Kv",
+			usage: `Preproc.CAMx.Kv is the location of the CAMx vertical diffusivity (KV_M2pS) input files. [DATE] should be used as a wild card for the simulation date, in the format YYYYMMDD.
+`,
+			defaultVal: "${INMAP_ROOT_DIR}/cmd/inmap/testdata/preproc/camx/camx.kv.[DATE].nc",
+			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
+		},
+		{
+			name: "Preproc.CAMx.Avrg",
+			usage: `Preproc.CAMx.Avrg is the location of the CAMx average concentration output files, which must contain all model layers. [DATE] should be used as a wild card for the simulation date, in the format YYYYMMDD.
+`,
+			defaultVal: "${INMAP_ROOT_DIR}/cmd/inmap/testdata/preproc/camx/camx.avrg.[DATE].nc",
+			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
+		},
+		{
+			name: "Preproc.NetCDF.Mapping",
+			usage: `Preproc.NetCDF.Mapping is the location of a TOML file that specifies how to read chemical transport model output from NetCDF files when Preproc.CTMType is "NetCDF". The file specifies the locations of the NetCDF files and, for each of the variables required by InMAP, the NetCDF variable or weighted group of variables to read and any unit conversions, staggering, or land use category conversions to apply. See the documentation for inmap.GenericNCFConfig for the file format.
+`,
+			defaultVal: "${INMAP_ROOT_DIR}/cmd/inmap/testdata/preproc/netcdf/wrfchem.toml",
+			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
+		},
+		{
+			name: "Preproc.Mixed.MetMapping",
+			usage: `Preproc.Mixed.MetMapping is the location of a TOML file that specifies how to read meteorology data from NetCDF files, such as ERA5 or MERRA-2 reanalysis files, when Preproc.CTMType is "Mixed". The format is the same as for Preproc.NetCDF.Mapping, but the chemistry variables can be omitted by setting Role = "meteorology". Example mapping files for ERA5 and MERRA-2 are in the cmd/inmap directory of the InMAP source code. The meteorology grid is specified by the Preproc.CtmGrid* options.
+`,
+			defaultVal: "${INMAP_ROOT_DIR}/cmd/inmap/testdata/preproc/netcdf/wrfchem.toml",
+			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
+		},
+		{
+			name: "Preproc.Mixed.ChemCTMType",
+			usage: `Preproc.Mixed.ChemCTMType specifies the type of chemical transport model that chemistry data are read from when Preproc.CTMType is "Mixed". Valid options are "GEOS-Chem", "WRF-Chem", "CMAQ", "CAMx", and "NetCDF", and the corresponding Preproc options specify the locations of the files. The chemistry data are regridded to the meteorology grid.
+`,
+			defaultVal: "WRF-Chem",
+			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
+		},
+		{
+			name: "Preproc.Mixed.ChemRecordInterval",
+			usage: `Preproc.Mixed.ChemRecordInterval is the amount of time between the records of the chemistry data when Preproc.CTMType is "Mixed", for example "1h" or "3h". It must be a whole multiple or fraction of the meteorology record interval; chemistry records are repeated or skipped to match the meteorology records.
+`,
+			defaultVal: "1h",
+			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
+		},
+		{
+			name: "Preproc.Mixed.ChemGridXo",
+			usage: `Preproc.Mixed.ChemGridXo is the lower left of the chemistry grid, x, when Preproc.CTMType is "Mixed". The chemistry grid must be in the same spatial reference as the meteorology grid.
+`,
+			defaultVal: 0.0,
+			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
+		},
+		{
+			name:       "Preproc.Mixed.ChemGridYo",
+			usage:      `Preproc.Mixed.ChemGridYo is the lower left of the chemistry grid, y, when Preproc.CTMType is "Mixed".`,
+			defaultVal: 0.0,
+			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
+		},
+		{
+			name:       "Preproc.Mixed.ChemGridDx",
+			usage:      `Preproc.Mixed.ChemGridDx is the chemistry grid cell length in the x direction when Preproc.CTMType is "Mixed".`,
+			defaultVal: 1000.0,
+			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
+		},
+		{
+			name:       "Preproc.Mixed.ChemGridDy",
+			usage:      `Preproc.Mixed.ChemGridDy is the chemistry grid cell length in the y direction when Preproc.CTMType is "Mixed".`,
+			defaultVal: 1000.0,
+			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
+		},
 		{
 			name: "Preproc.GEOSChem.GEOSA1",
 			usage: `Preproc.GEOSChem.GEOSA1 is the location of the GEOS 1-hour time average files. [DATE] should be used as a wild card for the simulation date.
@@ -992,6 +1516,29 @@ variable specifies the information to be output.`,
 			isInputFile: true,
 			flagsets:    []*pflag.FlagSet{cfg.preprocCmd.Flags()},
 		},
+		{
+			name: "Preproc.LandCover.File",
+			usage: `Preproc.LandCover.File is the location of an optional land cover raster in GeoTIFF format, for example from the National Land Cover Database (NLCD) or MODIS. If it is specified, dry deposition velocities in each grid cell are calculated as an average weighted by the fraction of each land use category in the cell, instead of using the land use information in the chemical transport model output. VarGrid.GridProj must give the projection of the chemical transport model grid.
+`,
+			defaultVal:  "",
+			isInputFile: true,
+			flagsets:    []*pflag.FlagSet{cfg.preprocCmd.Flags()},
+		},
+		{
+			name: "Preproc.LandCover.Mapping",
+			usage: `Preproc.LandCover.Mapping is the location of a TOML file that maps the categories in Preproc.LandCover.File to Seinfeld and Wesely (1989) land use categories. Mappings for NLCD and MODIS (IGBP) land cover are in the cmd/inmap directory of the InMAP source code.
+`,
+			defaultVal:  "${INMAP_ROOT_DIR}/cmd/inmap/landCoverNLCD.toml",
+			isInputFile: true,
+			flagsets:    []*pflag.FlagSet{cfg.preprocCmd.Flags()},
+		},
+		{
+			name: "Preproc.LandCover.Proj",
+			usage: `Preproc.LandCover.Proj gives the projection of Preproc.LandCover.File in Proj4 or WKT format. It is only required if the raster does not use geographic (longitude-latitude) coordinates.
+`,
+			defaultVal: "",
+			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
+		},
 		{
 			name: "Preproc.GEOSChem.Dash",
 			usage: `Preproc.GEOSChem.Dash indicates whether GEOS-Chem chemical variable names should be assumed to be in the form 'IJ-AVG-S__xxx' vs. the form 'IJ_AVG_S__xxx'.
@@ -1013,6 +1560,27 @@ variable specifies the information to be output.`,
 			defaultVal: "No Default",
 			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
 		},
+		{
+			name: "Preproc.TimePeriods",
+			usage: `Preproc.TimePeriods specifies whether to create a single preprocessed data file for the whole time period between Preproc.StartDate and Preproc.EndDate ("all"), or separate files for each season ("seasonal") or month ("monthly"). If it is not "all", InMAPData must include the wild card [PERIOD], which will be replaced by the name of each time period: YYYYSSS for seasons, where SSS is DJF, MAM, JJA, or SON; and YYYYMM for months. "inmap run steady" can then be run with the same InMAPData setting to run a separate simulation for each time period and average the results, weighted by the length of each period.
+`,
+			defaultVal: "all",
+			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
+		},
+		{
+			name: "Preproc.Workers",
+			usage: `Preproc.Workers is the maximum number of chemical transport model data fields to read at the same time. If it is less than one, the number of available CPUs is used. Changing it does not change the preprocessing results.
+`,
+			defaultVal: 0,
+			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
+		},
+		{
+			name: "Preproc.MaxResidentFields",
+			usage: `Preproc.MaxResidentFields is the maximum number of chemical transport model data fields to read ahead of when they are needed and hold in memory. Larger values can speed up preprocessing at the expense of increased memory use. If it is zero, fields are only read when they are needed. Changing it does not change the preprocessing results.
+`,
+			defaultVal: inmap.DefaultPreprocessMaxResidentFields,
+			flagsets:   []*pflag.FlagSet{cfg.preprocCmd.Flags()},
+		},
 		{
 			name: "Preproc.CtmGridXo",
 			usage: `Preproc.CtmGridXo is the lower left of Chemical Transport Model (CTM) grid, x
@@ -1050,6 +1618,126 @@ variable specifies the information to be output.`,
 			defaultVal: "inmap.run:443",
 			flagsets:   []*pflag.FlagSet{cfg.cloudCmd.PersistentFlags(), cfg.srCmd.PersistentFlags()},
 		},
+		{
+			name: "local",
+			usage: `local specifies that SR matrix simulations should be run on this computer
+rather than on a cloud cluster. The simulation inputs and outputs are stored in local_dir.`,
+			defaultVal: false,
+			flagsets:   []*pflag.FlagSet{cfg.srCmd.PersistentFlags()},
+		},
+		{
+			name:       "local_dir",
+			usage:      `local_dir specifies the directory where local SR matrix simulation inputs and outputs are stored.`,
+			defaultVal: "inmap_sr_local",
+			flagsets:   []*pflag.FlagSet{cfg.srCmd.PersistentFlags()},
+		},
+		{
+			name: "local_concurrency",
+			usage: `local_concurrency specifies the maximum number of local SR matrix simulations to run at the same time.
+If it is less than one, the number of CPUs is used.`,
+			defaultVal: 1,
+			flagsets:   []*pflag.FlagSet{cfg.srStartCmd.Flags()},
+		},
+		{
+			name: "local_memory_gb",
+			usage: `local_memory_gb specifies the total gigabytes of RAM memory available to local SR matrix
+simulations that are running at the same time, where each simulation is assumed to need memory_gb.
+If it is zero, memory use is not limited.`,
+			defaultVal: 0,
+			flagsets:   []*pflag.FlagSet{cfg.srStartCmd.Flags()},
+		},
+		{
+			name: "batch_size",
+			usage: `batch_size specifies the number of SR matrix sources to include in each
+simulation when using 'sr run'. Larger batches require fewer simulations but more memory.`,
+			defaultVal: 64,
+			flagsets:   []*pflag.FlagSet{cfg.srRunCmd.Flags()},
+		},
+		{
+			name:       "sparse_file",
+			usage:      `sparse_file is the path where the sparse SR matrix created by 'sr convert' should be saved.`,
+			defaultVal: "sr_sparse.ncf",
+			flagsets:   []*pflag.FlagSet{cfg.srConvertCmd.Flags()},
+		},
+		{
+			name: "sparse_threshold",
+			usage: `sparse_threshold specifies which values are stored when converting an SR matrix to the
+sparse format. For each source, receptor values with an absolute value smaller than sparse_threshold
+times the largest absolute value for that source are not stored. If it is zero, all non-zero
+values are stored.`,
+			defaultVal: 1.e-4,
+			flagsets:   []*pflag.FlagSet{cfg.srConvertCmd.Flags()},
+		},
+		{
+			name: "receptor_shapefile",
+			usage: `receptor_shapefile is the path to a shapefile containing the polygons that make up
+the receptor region for 'sr receptor'. It can contain environment variables.`,
+			defaultVal: "",
+			flagsets:   []*pflag.FlagSet{cfg.srReceptorCmd.Flags()},
+		},
+		{
+			name: "receptor_layer",
+			usage: `receptor_layer is the index of the emissions source layer for 'sr receptor' within the
+layers included in the SR matrix; for example, if the SR matrix includes layers 0, 2, and 4,
+a receptor_layer of 1 refers to layer 2.`,
+			defaultVal: 0,
+			flagsets:   []*pflag.FlagSet{cfg.srReceptorCmd.Flags()},
+		},
+		{
+			name: "scenario_manifest",
+			usage: `scenario_manifest is the path to a CSV file listing the emissions scenarios to evaluate
+with 'sr batch', with columns "Scenario", "File", and "Scale". It can contain environment variables.`,
+			defaultVal: "",
+			flagsets:   []*pflag.FlagSet{cfg.srBatchCmd.Flags()},
+		},
+		{
+			name: "scenario_table",
+			usage: `scenario_table is the path where the CSV file of scenario summary metrics created by
+'sr batch' should be saved. It can contain environment variables.`,
+			defaultVal: "inmap_scenarios.csv",
+			flagsets:   []*pflag.FlagSet{cfg.srBatchCmd.Flags()},
+		},
+		{
+			name: "scenario_grid_dir",
+			usage: `scenario_grid_dir is the path to a directory where 'sr batch' should save a shapefile of
+the gridded results for each scenario. If it is empty, gridded results are not saved.
+It can contain environment variables.`,
+			defaultVal: "",
+			flagsets:   []*pflag.FlagSet{cfg.srBatchCmd.Flags()},
+		},
+		{
+			name: "scenario_workers",
+			usage: `scenario_workers specifies the number of scenarios that 'sr batch' evaluates concurrently.
+Each scenario worker holds its own copy of the SR matrix grid data. If it is 0, the number of processors is used.`,
+			defaultVal: 0,
+			flagsets:   []*pflag.FlagSet{cfg.srBatchCmd.Flags()},
+		},
+		{
+			name:       "serve_address",
+			usage:      `serve_address is the network address where 'sr serve' should listen for requests.`,
+			defaultVal: "localhost:8080",
+			flagsets:   []*pflag.FlagSet{cfg.srServeCmd.Flags()},
+		},
+		{
+			name: "merge_files",
+			usage: `merge_files are the paths to the SR matrix files to be combined by 'sr merge'. They can
+contain environment variables.`,
+			defaultVal: []string{},
+			flagsets:   []*pflag.FlagSet{cfg.srMergeCmd.Flags()},
+		},
+		{
+			name:       "subset_file",
+			usage:      `subset_file is the path where the SR matrix created by 'sr subset' should be saved.`,
+			defaultVal: "sr_subset.ncf",
+			flagsets:   []*pflag.FlagSet{cfg.srSubsetCmd.Flags()},
+		},
+		{
+			name: "subset_shapefile",
+			usage: `subset_shapefile is the path to a shapefile containing the polygons that make up
+the region to be extracted by 'sr subset'. It can contain environment variables.`,
+			defaultVal: "",
+			flagsets:   []*pflag.FlagSet{cfg.srSubsetCmd.Flags()},
+		},
 		{
 			name:       "cmds",
 			usage:      `cmds specifies the inmap subcommands to run.`,
@@ -1075,6 +1763,46 @@ variable specifies the information to be output.`,
 			defaultVal: "inmapdata_combined.ncf",
 			flagsets:   []*pflag.FlagSet{cfg.combineCmd.Flags()},
 		},
+		{
+			name: "blend_buffer",
+			usage: `blend_buffer is the width, in grid cells of the inner nest, of the zone
+inside the edge of each nest where the inner- and outer-nest data are linearly blended
+to avoid discontinuities at the nest boundaries. The default of zero means that
+inner nests are overlaid on outer nests without blending.`,
+			defaultVal: 0.0,
+			flagsets:   []*pflag.FlagSet{cfg.combineCmd.Flags()},
+		},
+		{
+			name: "check_output_dir",
+			usage: `check_output_dir, if specified, is the directory where summary statistics
+(stats.csv) and maps of each layer of the checked data should be written.`,
+			defaultVal: "",
+			flagsets:   []*pflag.FlagSet{cfg.preprocCheckCmd.Flags()},
+		},
+		{
+			name: "check_format",
+			usage: `check_format is the format of the maps written to check_output_dir. It can be
+"png", in which case one image is written for each layer of each variable, or "geojson",
+in which case one file containing all variables is written for each layer.`,
+			defaultVal: "png",
+			flagsets:   []*pflag.FlagSet{cfg.preprocCheckCmd.Flags()},
+		},
+		{
+			name: "check_variables",
+			usage: `check_variables is a list of the variables to write "png" maps for.
+If it is empty, maps are written for all variables.`,
+			defaultVal: []string{},
+			flagsets:   []*pflag.FlagSet{cfg.preprocCheckCmd.Flags()},
+		},
+		{
+			name: "jump_diagnostics_file",
+			usage: `jump_diagnostics_file, if specified, is the location where a file should be written
+containing the magnitude of the jump in each variable across the nest boundaries
+in the combined output, for diagnosing discontinuities. It has the same format as the
+combined output file.`,
+			defaultVal: "",
+			flagsets:   []*pflag.FlagSet{cfg.combineCmd.Flags()},
+		},
 	}
 
 	// Set the prefix for configuration environment variables.
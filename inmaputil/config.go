@@ -100,6 +100,30 @@ func checkOutputFile(f string) (string, error) {
 	return f, nil
 }
 
+// checkDisparityVars checks that each of the disparity variables is
+// one of the output variables.
+func checkDisparityVars(disparityVars []string, outputVars map[string]string) ([]string, error) {
+	for i, v := range disparityVars {
+		disparityVars[i] = os.ExpandEnv(v)
+		if _, ok := outputVars[disparityVars[i]]; !ok {
+			return nil, fmt.Errorf("inmap: disparity variable '%s' is not one of the OutputVariables", disparityVars[i])
+		}
+	}
+	return disparityVars, nil
+}
+
+// disparityFile returns the path of the exposure disparity output file
+// corresponding to outputFile.
+func disparityFile(outputFile string) string {
+	return strings.TrimSuffix(outputFile, filepath.Ext(outputFile)) + "_disparity.csv"
+}
+
+// evaluationFile returns the path of the SR matrix evaluation statistics
+// output file corresponding to outputFile.
+func evaluationFile(outputFile string) string {
+	return strings.TrimSuffix(outputFile, filepath.Ext(outputFile)) + "_evaluation.csv"
+}
+
 // checkLogFile fills in a default value for the log file path if one isn't
 // specified.
 func checkLogFile(logFile, outputFile string) string {
@@ -163,7 +187,12 @@ func VarGridConfig(cfg *viper.Viper) (*inmap.VarGridConfig, error) {
 		PopGridColumn:        os.ExpandEnv(cfg.GetString("VarGrid.PopGridColumn")),
 		MortalityRateFile:    maybeDownload(ctx, os.ExpandEnv(cfg.GetString("VarGrid.MortalityRateFile")), outChan()),
 		MortalityRateColumns: GetStringMapString("VarGrid.MortalityRateColumns", cfg),
+		BaselineHR:           os.ExpandEnv(cfg.GetString("VarGrid.BaselineHR")),
 		GridProj:             os.ExpandEnv(cfg.GetString("VarGrid.GridProj")),
+
+		CensusProjections:        projectionFiles(ctx, "VarGrid.CensusProjections", cfg),
+		MortalityRateProjections: projectionFiles(ctx, "VarGrid.MortalityRateProjections", cfg),
+		MassWeightedPartitioning: cfg.GetBool("VarGrid.MassWeightedPartitioning"),
 	}
 
 	vars := []float64{c.VariableGridDx, c.VariableGridDy}
@@ -297,6 +326,17 @@ func GetStringMapString(varName string, cfg *viper.Viper) map[string]string {
 	}
 }
 
+// projectionFiles returns the year-keyed file paths in configuration
+// variable varName, expanding any environment variables and downloading
+// any remote files.
+func projectionFiles(ctx context.Context, varName string, cfg *viper.Viper) map[string]string {
+	o := make(map[string]string)
+	for year, f := range GetStringMapString(varName, cfg) {
+		o[year] = maybeDownload(ctx, os.ExpandEnv(f), outChan())
+	}
+	return o
+}
+
 // getStringMapStringSlice returns a map[string][]string from a viper configuration,
 // accounting for the fact that it might be a json object if it was set
 // from a command line argument.
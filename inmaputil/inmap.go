@@ -23,7 +23,10 @@ import (
 	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"runtime"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -88,6 +91,12 @@ var DefaultScienceFuncs = []inmap.CellManipulator{
 // OutputVariables specifies which model variables should be included in the
 // output file.
 //
+// DisparityVariables specifies which of the OutputVariables exposure
+// disparity metrics should be calculated for. The metrics are calculated for
+// each of the VarGrid.CensusPopColumns population groups relative to the
+// VarGrid.PopGridColumn population and written to a CSV file
+// next to OutputFile.
+//
 // EmissionUnits gives the units that the input emissions are in.
 // Acceptable values are 'tons/year', 'kg/year', 'ug/s', and 'μg/s'.
 //
@@ -105,6 +114,10 @@ var DefaultScienceFuncs = []inmap.CellManipulator{
 //
 // VarGrid provides information for specifying the variable resolution grid.
 //
+// If Year is not 0, population and mortality rates for that year are
+// loaded from the VarGrid.CensusProjections and VarGrid.MortalityRateProjections
+// files, and they replace the data in a grid loaded from VariableGridData.
+//
 // InMAPData is the path to location of baseline meteorology and pollutant data.
 //
 // VariableGridData is the path to the location of the variable-resolution gridded
@@ -123,7 +136,7 @@ var DefaultScienceFuncs = []inmap.CellManipulator{
 // notMeters should be set to true if the units of the grid are not meters
 // (e.g., if the grid is in degrees latitude/longitude.)
 func Run(CobraCommand *cobra.Command, LogFile string, OutputFile string, OutputAllLayers bool, OutputVariables map[string]string,
-	EmissionUnits string, EmissionsShapefiles []string, EmissionsMask geom.Polygon, VarGrid *inmap.VarGridConfig,
+	DisparityVariables []string, EmissionUnits string, EmissionsShapefiles []string, EmissionsMask geom.Polygon, VarGrid *inmap.VarGridConfig, Year int,
 	inventoryConfig *aeputil.InventoryConfig, spatialConfig *aeputil.SpatialConfig,
 	InMAPData, VariableGridData string, NumIterations int,
 	dynamic, createGrid bool, scienceFuncs []inmap.CellManipulator, addInit, addRun, addCleanup []inmap.DomainManipulator,
@@ -184,6 +197,11 @@ func Run(CobraCommand *cobra.Command, LogFile string, OutputFile string, OutputA
 	}
 	log.Println("Parsing output variable expressions...")
 
+	var disparityOutput string
+	if len(DisparityVariables) > 0 {
+		disparityOutput = upload.maybeUpload(disparityFile(OutputFile))
+	}
+
 	if upload.err != nil {
 		return upload.err
 	}
@@ -199,18 +217,36 @@ func Run(CobraCommand *cobra.Command, LogFile string, OutputFile string, OutputA
 
 	aepSetEmis := setEmissionsAEP(inventoryConfig, spatialConfig, emis, EmissionsMask)
 
+	// InMAPData files for multiple time periods are run separately
+	// and the results are averaged.
+	inmapDataFiles := []string{InMAPData}
+	periods := strings.Contains(InMAPData, "[PERIOD]")
+	if periods {
+		if dynamic || !createGrid {
+			return fmt.Errorf("inmap: InMAPData files for multiple time periods can only be used with the --static and --creategrid options")
+		}
+		inmapDataFiles, err = filepath.Glob(strings.Replace(InMAPData, "[PERIOD]", "*", -1))
+		if err != nil {
+			return fmt.Errorf("inmap: finding InMAPData files: %v", err)
+		}
+		if len(inmapDataFiles) == 0 {
+			return fmt.Errorf("inmap: no InMAPData files match %s", InMAPData)
+		}
+		sort.Strings(inmapDataFiles)
+	}
+
 	// Only load the population if we're creating the grid.
 	var pop *inmap.Population
 	var mr *inmap.MortalityRates
 	var popIndices inmap.PopIndices
 	var mortIndices inmap.MortIndices
-	var ctmData *inmap.CTMData
-	if dynamic || createGrid {
-		log.Println("Loading CTM data...")
-		ctmData, err = getCTMData(InMAPData, VarGrid)
+	if Year != 0 {
+		log.Printf("Loading population and mortality rate data for %d...", Year)
+		pop, popIndices, mr, mortIndices, err = VarGrid.LoadPopMortYear(Year)
 		if err != nil {
 			return err
 		}
+	} else if dynamic || createGrid {
 		log.Println("Loading population and mortality rate data...")
 		pop, popIndices, mr, mortIndices, err = VarGrid.LoadPopMort()
 		if err != nil {
@@ -220,105 +256,156 @@ func Run(CobraCommand *cobra.Command, LogFile string, OutputFile string, OutputA
 
 	scienceCalcs := inmap.Calculations(scienceFuncs...)
 
-	var initFuncs, runFuncs []inmap.DomainManipulator
-	if !dynamic {
-		if createGrid {
-			var mutator inmap.GridMutator
-			mutator, err = inmap.PopulationMutator(VarGrid, popIndices)
+	var avg inmap.PeriodAverager
+	for i, inmapData := range inmapDataFiles {
+		if periods {
+			log.Printf("Running time period %d of %d (%s)...", i+1, len(inmapDataFiles), inmapData)
+		}
+		var ctmData *inmap.CTMData
+		if dynamic || createGrid || (Year != 0 && VarGrid.BaselineHR != "") {
+			log.Println("Loading CTM data...")
+			ctmData, err = getCTMData(inmapData, VarGrid)
 			if err != nil {
 				return err
 			}
-			initFuncs = []inmap.DomainManipulator{
-				VarGrid.RegularGrid(ctmData, pop, popIndices, mr, mortIndices, nil, m),
-				VarGrid.MutateGrid(mutator, ctmData, pop, mr, nil, m, msgLog),
-				aepSetEmis,
-				inmap.SetTimestepCFL(),
-				o.CheckOutputVars(m),
+		}
+		po := o
+		var periodLength time.Duration
+		if periods {
+			periodLength = ctmData.End.Sub(ctmData.Start)
+			if periodLength <= 0 {
+				return fmt.Errorf("inmap: InMAPData file %s does not specify the time period it represents", inmapData)
 			}
-		} else { // pre-created static grid
-			var r io.Reader
-			r, err = os.Open(VariableGridData)
+			// Each period needs its own Outputter because evaluating
+			// output expressions can modify the output variables.
+			vars := make(map[string]string, len(OutputVariables))
+			for k, v := range OutputVariables {
+				vars[k] = v
+			}
+			po, err = inmap.NewOutputter(OutputFile, OutputAllLayers, vars, nil, m)
 			if err != nil {
-				return fmt.Errorf("problem opening file to load VariableGridData: %v", err)
+				return err
+			}
+		}
+
+		var initFuncs, runFuncs []inmap.DomainManipulator
+		if !dynamic {
+			if createGrid {
+				var mutator inmap.GridMutator
+				mutator, err = inmap.PopulationMutator(VarGrid, popIndices)
+				if err != nil {
+					return err
+				}
+				initFuncs = []inmap.DomainManipulator{
+					VarGrid.RegularGrid(ctmData, pop, popIndices, mr, mortIndices, nil, m),
+					VarGrid.MutateGrid(mutator, ctmData, pop, mr, nil, m, msgLog),
+					aepSetEmis,
+					inmap.SetTimestepCFL(),
+					po.CheckOutputVars(m),
+				}
+			} else { // pre-created static grid
+				var r io.Reader
+				r, err = os.Open(VariableGridData)
+				if err != nil {
+					return fmt.Errorf("problem opening file to load VariableGridData: %v", err)
+				}
+				initFuncs = []inmap.DomainManipulator{
+					inmap.Load(r, VarGrid, nil, m),
+				}
+				if Year != 0 {
+					initFuncs = append(initFuncs, VarGrid.SetPopMort(ctmData, pop, popIndices, mr, mortIndices))
+				}
+				initFuncs = append(initFuncs,
+					aepSetEmis,
+					inmap.SetTimestepCFL(),
+					po.CheckOutputVars(m),
+				)
+			}
+			runFuncs = []inmap.DomainManipulator{
+				inmap.Log(cLog),
+				inmap.Calculations(inmap.AddEmissionsFlux()),
+				scienceCalcs,
+				inmap.SteadyStateConvergenceCheck(NumIterations,
+					VarGrid.PopGridColumn, m, cConverge),
 			}
+		} else { // dynamic grid
 			initFuncs = []inmap.DomainManipulator{
-				inmap.Load(r, VarGrid, nil, m),
+				VarGrid.RegularGrid(ctmData, pop, popIndices, mr, mortIndices, nil, m),
 				aepSetEmis,
 				inmap.SetTimestepCFL(),
 				o.CheckOutputVars(m),
 			}
-		}
-		runFuncs = []inmap.DomainManipulator{
-			inmap.Log(cLog),
-			inmap.Calculations(inmap.AddEmissionsFlux()),
-			scienceCalcs,
-			inmap.SteadyStateConvergenceCheck(NumIterations,
-				VarGrid.PopGridColumn, m, cConverge),
-		}
-	} else { // dynamic grid
-		initFuncs = []inmap.DomainManipulator{
-			VarGrid.RegularGrid(ctmData, pop, popIndices, mr, mortIndices, nil, m),
-			aepSetEmis,
-			inmap.SetTimestepCFL(),
-			o.CheckOutputVars(m),
-		}
 
-		// Set up a domain manipulator that mutates the grid, sets the emissions,
-		// the sets the timestep.
-		popConcMutator := inmap.NewPopConcMutator(VarGrid, popIndices)
-		const gridMutateInterval = 3 * 60 * 60 // every 3 hours in seconds
-		mg := VarGrid.MutateGrid(popConcMutator.Mutate(), ctmData, pop, mr, nil, m, msgLog)
-		setTS := inmap.SetTimestepCFL()
-		mutateThenAddEmis := func(d *inmap.InMAP) error {
-			if err := mg(d); err != nil {
-				return err
+			// Set up a domain manipulator that mutates the grid, sets the emissions,
+			// the sets the timestep.
+			popConcMutator := inmap.NewPopConcMutator(VarGrid, popIndices)
+			const gridMutateInterval = 3 * 60 * 60 // every 3 hours in seconds
+			mg := VarGrid.MutateGrid(popConcMutator.Mutate(), ctmData, pop, mr, nil, m, msgLog)
+			setTS := inmap.SetTimestepCFL()
+			mutateThenAddEmis := func(d *inmap.InMAP) error {
+				if err := mg(d); err != nil {
+					return err
+				}
+				if err := aepSetEmis(d); err != nil {
+					return err
+				}
+				return setTS(d)
 			}
-			if err := aepSetEmis(d); err != nil {
-				return err
+
+			runFuncs = []inmap.DomainManipulator{
+				inmap.Log(cLog),
+				inmap.Calculations(inmap.AddEmissionsFlux()),
+				scienceCalcs,
+				inmap.RunPeriodically(gridMutateInterval, mutateThenAddEmis),
+				inmap.SteadyStateConvergenceCheck(NumIterations, VarGrid.PopGridColumn, m, cConverge),
 			}
-			return setTS(d)
 		}
 
-		runFuncs = []inmap.DomainManipulator{
-			inmap.Log(cLog),
-			inmap.Calculations(inmap.AddEmissionsFlux()),
-			scienceCalcs,
-			inmap.RunPeriodically(gridMutateInterval, mutateThenAddEmis),
-			inmap.SteadyStateConvergenceCheck(NumIterations, VarGrid.PopGridColumn, m, cConverge),
+		var cleanupFuncs []inmap.DomainManipulator
+		if periods {
+			cleanupFuncs = append(cleanupFuncs, avg.Add(po, periodLength))
+		}
+		if i == len(inmapDataFiles)-1 {
+			if periods {
+				cleanupFuncs = append(cleanupFuncs, avg.SetResults(o))
+			}
+			cleanupFuncs = append(cleanupFuncs,
+				o.Output(sr),
+				o.DisparityOutput(disparityOutput, VarGrid.CensusPopColumns, VarGrid.PopGridColumn, DisparityVariables...),
+				upload.uploadOutput,
+			)
+			cleanupFuncs = append(cleanupFuncs, addCleanup...)
 		}
-	}
 
-	d := &inmap.InMAP{
-		InitFuncs: append(initFuncs, addInit...),
-		RunFuncs:  append(runFuncs, addRun...),
-		CleanupFuncs: append([]inmap.DomainManipulator{
-			o.Output(sr),
-			upload.uploadOutput,
-		}, addCleanup...),
-	}
+		d := &inmap.InMAP{
+			InitFuncs:    append(initFuncs, addInit...),
+			RunFuncs:     append(runFuncs, addRun...),
+			CleanupFuncs: cleanupFuncs,
+		}
 
-	log.Println("Initializing model...")
-	if err = d.Init(); err != nil {
-		return fmt.Errorf("InMAP: problem initializing model: %v\n", err)
-	}
+		log.Println("Initializing model...")
+		if err = d.Init(); err != nil {
+			return fmt.Errorf("InMAP: problem initializing model: %v\n", err)
+		}
 
-	emisTotals := make([]float64, len(d.Cells()[0].Cf))
-	for _, c := range d.Cells() {
-		for i, val := range c.EmisFlux {
-			emisTotals[i] += val * c.Volume
+		emisTotals := make([]float64, len(d.Cells()[0].Cf))
+		for _, c := range d.Cells() {
+			for i, val := range c.EmisFlux {
+				emisTotals[i] += val * c.Volume
+			}
+		}
+		log.Println("Emission totals:")
+		for i, pol := range inmap.PolNames {
+			log.Printf("%v, %g μg/s\n", pol, emisTotals[i])
 		}
-	}
-	log.Println("Emission totals:")
-	for i, pol := range inmap.PolNames {
-		log.Printf("%v, %g μg/s\n", pol, emisTotals[i])
-	}
 
-	if err = d.Run(); err != nil {
-		return fmt.Errorf("InMAP: problem running simulation: %v\n", err)
-	}
+		if err = d.Run(); err != nil {
+			return fmt.Errorf("InMAP: problem running simulation: %v\n", err)
+		}
 
-	if err = d.Cleanup(); err != nil {
-		return fmt.Errorf("InMAP: problem shutting down model: %v\n", err)
+		if err = d.Cleanup(); err != nil {
+			return fmt.Errorf("InMAP: problem shutting down model: %v\n", err)
+		}
 	}
 
 	elapsedTime := time.Since(startTime)
@@ -346,41 +433,16 @@ func setEmissionsAEP(inventoryConfig *aeputil.InventoryConfig, spatialConfig *ae
 
 		// Specify the grid cells we want to allocate to.
 		cells := d.Cells()
-		spatialConfig.GridCells = make([]geom.Polygonal, 0, len(cells))
+		gridCells := make([]geom.Polygonal, 0, len(cells))
 		for _, c := range cells {
 			if c.Layer == 0 {
-				spatialConfig.GridCells = append(spatialConfig.GridCells, c)
-			}
-		}
-
-		iter := spatialConfig.Iterator(aeputil.IteratorFromMap(recs), 0)
-		var spatialRecs []aep.RecordGridded
-		for {
-			rec, err := iter.Next()
-			if err == io.EOF {
-				break
-			} else if err != nil {
-				return err
+				gridCells = append(gridCells, c)
 			}
-			spatialRecs = append(spatialRecs, rec.(aep.RecordGridded))
 		}
 
-		var emisRecs []*inmap.EmisRecord
-		if len(spatialRecs) > 0 {
-			sp, err := spatialConfig.SpatialProcessor()
-			if err != nil {
-				return err
-			}
-			emisRecs, err = inmap.FromAEP(spatialRecs, sp.Grids, 0,
-				[]aep.Pollutant{{Name: "VOC"}},
-				[]aep.Pollutant{{Name: "NOx"}},
-				[]aep.Pollutant{{Name: "NH3"}},
-				[]aep.Pollutant{{Name: "SOx"}},
-				[]aep.Pollutant{{Name: "PM2_5"}},
-			)
-			if err != nil {
-				return err
-			}
+		emisRecs, err := griddedAEPEmissions(recs, spatialConfig, gridCells)
+		if err != nil {
+			return err
 		}
 		emis := inmap.NewEmissions()
 		emis.Mask = mask
@@ -395,3 +457,38 @@ func setEmissionsAEP(inventoryConfig *aeputil.InventoryConfig, spatialConfig *ae
 		return d.SetEmissionsFlux(emis, m)
 	}
 }
+
+// griddedAEPEmissions allocates the AEP emissions records in recs to
+// gridCells using the spatial surrogates specified in spatialConfig,
+// and converts them to InMAP emissions records. Elevated point sources
+// retain their stack parameters.
+func griddedAEPEmissions(recs map[string][]aep.Record, spatialConfig *aeputil.SpatialConfig, gridCells []geom.Polygonal) ([]*inmap.EmisRecord, error) {
+	spatialConfig.GridCells = gridCells
+
+	iter := spatialConfig.Iterator(aeputil.IteratorFromMap(recs), 0)
+	var spatialRecs []aep.RecordGridded
+	for {
+		rec, err := iter.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		spatialRecs = append(spatialRecs, rec.(aep.RecordGridded))
+	}
+	if len(spatialRecs) == 0 {
+		return nil, nil
+	}
+
+	sp, err := spatialConfig.SpatialProcessor()
+	if err != nil {
+		return nil, err
+	}
+	return inmap.FromAEP(spatialRecs, sp.Grids, 0,
+		[]aep.Pollutant{{Name: "VOC"}},
+		[]aep.Pollutant{{Name: "NOx"}},
+		[]aep.Pollutant{{Name: "NH3"}},
+		[]aep.Pollutant{{Name: "SOx"}},
+		[]aep.Pollutant{{Name: "PM2_5"}},
+	)
+}
@@ -20,12 +20,17 @@ package inmaputil
 
 import (
 	"fmt"
+	"io/ioutil"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/ctessum/geom/encoding/shp"
 	"github.com/evookelj/inmap"
 )
 
@@ -57,6 +62,96 @@ func TestInMAPStaticCreateGrid(t *testing.T) {
 	}
 }
 
+func TestInMAPStaticCreateGrid_periods(t *testing.T) {
+	// Create InMAPData files for two time periods. The baseline
+	// concentrations in the second period are four times those in
+	// the first, but the periods are otherwise identical.
+	f, err := os.Open(os.ExpandEnv("$INMAP_ROOT_DIR/cmd/inmap/testdata/testInMAPInputData.ncf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vgc := &inmap.VarGridConfig{}
+	ctmData, err := vgc.LoadCTMData(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	inmapData := os.ExpandEnv("$INMAP_ROOT_DIR/cmd/inmap/testdata/testInMAPInputData_[PERIOD].ncf")
+	for _, p := range []struct {
+		name  string
+		days  int
+		scale float64
+	}{{"201606", 30, 1}, {"201607", 10, 4}} {
+		ctmData.Start = time.Date(2016, 6, 1, 0, 0, 0, 0, time.UTC)
+		ctmData.End = ctmData.Start.AddDate(0, 0, p.days)
+		ctmData.Data["TotalPM25"].Data.Scale(p.scale)
+		fname := strings.Replace(inmapData, "[PERIOD]", p.name, -1)
+		w, err := os.Create(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(fname)
+		if err = ctmData.Write(w); err != nil {
+			t.Fatal(err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type outData struct {
+		BasePM25, TotalPM25 float64
+	}
+	run := func(runType, inmapData string) []outData {
+		cfg := InitializeConfig()
+		cfg.Set("static", true)
+		cfg.Set("creategrid", true)
+		os.Setenv("InMAPRunType", runType)
+		cfg.Set("config", "../cmd/inmap/configExample.toml")
+		cfg.Set("InMAPData", inmapData)
+		cfg.Root.SetArgs([]string{"run", "steady"})
+		outFile := os.ExpandEnv("$INMAP_ROOT_DIR/cmd/inmap/testdata/output_" + runType + ".shp")
+		defer os.Remove(os.ExpandEnv("$INMAP_ROOT_DIR/cmd/inmap/testdata/output_" + runType + ".log"))
+		defer inmap.DeleteShapefile(outFile)
+		if err := cfg.Root.Execute(); err != nil {
+			t.Fatal(err)
+		}
+		dec, err := shp.NewDecoder(outFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dec.Close()
+		var recs []outData
+		for {
+			var rec outData
+			if more := dec.DecodeRow(&rec); !more {
+				break
+			}
+			recs = append(recs, rec)
+		}
+		if err := dec.Error(); err != nil {
+			t.Fatal(err)
+		}
+		return recs
+	}
+	want := run("static", "${INMAP_ROOT_DIR}/cmd/inmap/testdata/testInMAPInputData.ncf")
+	have := run("periods", inmapData)
+
+	if len(want) == 0 || len(have) != len(want) {
+		t.Fatalf("have %d records, want %d", len(have), len(want))
+	}
+	const baseScale = (30 + 4*10) / 40.
+	for i, w := range want {
+		h := have[i]
+		if math.Abs(h.BasePM25-w.BasePM25*baseScale) > 1.e-6*w.BasePM25 {
+			t.Errorf("record %d BasePM25: have %g, want %g", i, h.BasePM25, w.BasePM25*baseScale)
+		}
+		if math.Abs(h.TotalPM25-w.TotalPM25) > 1.e-6*w.TotalPM25 {
+			t.Errorf("record %d TotalPM25: have %g, want %g", i, h.TotalPM25, w.TotalPM25)
+		}
+	}
+}
+
 func TestInMAPStaticLoadGrid(t *testing.T) {
 	cfg := InitializeConfig()
 	cfg.Set("static", true)
@@ -71,6 +166,58 @@ func TestInMAPStaticLoadGrid(t *testing.T) {
 	}
 }
 
+func TestInMAPStaticLoadGrid_disparity(t *testing.T) {
+	cfg := InitializeConfig()
+	cfg.Set("static", true)
+	cfg.Set("createGrid", false)
+	os.Setenv("InMAPRunType", "staticLoadGrid")
+	cfg.Set("config", "../cmd/inmap/configExample.toml")
+	cfg.Set("DisparityVariables", []string{"TotalPM25", "TotalPopD"})
+	cfg.Root.SetArgs([]string{"run", "steady"})
+	disparityFile := os.ExpandEnv("$INMAP_ROOT_DIR/cmd/inmap/testdata/output_staticLoadGrid_disparity.csv")
+	defer os.Remove(os.ExpandEnv("$INMAP_ROOT_DIR/cmd/inmap/testdata/output_staticLoadGrid.log"))
+	defer inmap.DeleteShapefile(os.ExpandEnv("$INMAP_ROOT_DIR/cmd/inmap/testdata/output_staticLoadGrid.shp"))
+	defer os.Remove(disparityFile)
+	if err := cfg.Root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(disparityFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// There should be a header and one line for each of the 6 population
+	// groups for each of the 2 variables.
+	if n := len(strings.Split(strings.TrimSpace(string(b)), "\n")); n != 13 {
+		t.Errorf("have %d lines, want 13", n)
+	}
+}
+
+func TestInMAPStaticLoadGrid_year(t *testing.T) {
+	cfg := InitializeConfig()
+	cfg.Set("static", true)
+	cfg.Set("createGrid", false)
+	os.Setenv("InMAPRunType", "staticLoadGrid")
+	cfg.Set("config", "../cmd/inmap/configExample.toml")
+	cfg.Set("VarGrid.CensusProjections", map[string]string{
+		"2020": "${INMAP_ROOT_DIR}/cmd/inmap/testdata/testPopulation.shp",
+		"2040": "${INMAP_ROOT_DIR}/cmd/inmap/testdata/testPopulation.shp",
+	})
+	cfg.Set("VarGrid.MortalityRateProjections", map[string]string{
+		"2020": "${INMAP_ROOT_DIR}/cmd/inmap/testdata/testMortalityRate.shp",
+	})
+	cfg.Set("year", 2030)
+	cfg.Root.SetArgs([]string{"run", "steady"})
+	defer os.Remove(os.ExpandEnv("$INMAP_ROOT_DIR/cmd/inmap/testdata/output_staticLoadGrid.log"))
+	defer inmap.DeleteShapefile(os.ExpandEnv("$INMAP_ROOT_DIR/cmd/inmap/testdata/output_staticLoadGrid.shp"))
+	if err := cfg.Root.Execute(); err == nil {
+		t.Fatal("2030 is outside of the range of the mortality rate projections so there should be an error")
+	}
+	cfg.Set("VarGrid.MortalityRateProjections", map[string]string{})
+	if err := cfg.Root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestInMAPDynamic(t *testing.T) {
 	cfg := InitializeConfig()
 	cfg.Set("static", false)
@@ -19,144 +19,502 @@ along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
 package inmaputil
 
 import (
+	"encoding/csv"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/ctessum/geom/proj"
 	"github.com/evookelj/inmap"
 )
 
+// PreprocOptions holds the options for Preproc.
+type PreprocOptions struct {
+	// StartDate is the date of the beginning of the simulation.
+	// Format = "YYYYMMDD".
+	StartDate string
+
+	// EndDate is the date of the end of the simulation.
+	// Format = "YYYYMMDD".
+	EndDate string
+
+	// CTMType specifies what type of chemical transport
+	// model we are going to be reading data from. Valid
+	// options are "GEOS-Chem", "WRF-Chem", "CMAQ", "CAMx", "NetCDF", and "Mixed".
+	CTMType string
+
+	// WRFOut is the location of WRF-Chem output files.
+	// [DATE] should be used as a wild card for the simulation date.
+	WRFOut string
+
+	// METCRO3D, METCRO2D, and METDOT3D are the locations of the MCIP
+	// 3-dimensional cross-point, 2-dimensional cross-point, and 3-dimensional
+	// dot-point meteorology files used with CMAQ, and CONC is the location of
+	// the CMAQ CONC or ACONC concentration files.
+	// [DATE] should be used as a wild card for the simulation date.
+	METCRO3D, METCRO2D, METDOT3D, CONC string
+
+	// GRIDCRO2D is the location of the MCIP 2-dimensional cross-point
+	// grid file.
+	GRIDCRO2D string
+
+	// CAMxMet3D, CAMxMet2D, and CAMxKv are the locations of the CAMx
+	// 3-dimensional meteorology, 2-dimensional surface meteorology, and
+	// vertical diffusivity input files, and CAMxAvrg is the location of the
+	// CAMx average concentration output files.
+	// [DATE] should be used as a wild card for the simulation date.
+	CAMxMet3D, CAMxMet2D, CAMxKv, CAMxAvrg string
+
+	// NetCDFMapping is the location of a TOML file that specifies
+	// how to read the data from NetCDF files when CTMType is "NetCDF".
+	// Its format is described by inmap.GenericNCFConfig.
+	NetCDFMapping string
+
+	// When CTMType is "Mixed", meteorology data are read from NetCDF files
+	// as specified by the mapping file at MixedMetMapping, and chemistry data
+	// are read from the CTM type specified by MixedChemCTMType using the other
+	// options, then regridded to the meteorology grid.
+	// MixedChemRecordInterval is the amount of time between the chemistry records.
+	MixedMetMapping, MixedChemCTMType, MixedChemRecordInterval string
+
+	// GEOSA1 is the location of the GEOS 1-hour time average files.
+	// [DATE] should be used as a wild card for the simulation date.
+	GEOSA1 string
+
+	// GEOSA3Cld is the location of the GEOS 3-hour average cloud
+	// parameter files. [DATE] should be used as a wild card for
+	// the simulation date.
+	GEOSA3Cld string
+
+	// GEOSA3Dyn is the location of the GEOS 3-hour average dynamical
+	// parameter files. [DATE] should be used as a wild card for
+	// the simulation date.
+	GEOSA3Dyn string
+
+	// GEOSI3 is the location of the GEOS 3-hour instantaneous parameter
+	// files. [DATE] should be used as a wild card for
+	// the simulation date.
+	GEOSI3 string
+
+	// GEOSA3MstE is the location of the GEOS 3-hour average moist parameters
+	// on level edges files. [DATE] should be used as a wild card for
+	// the simulation date.
+	GEOSA3MstE string
+
+	// GEOSApBp is the location of the pressure level variable file.
+	// It is optional; if it is not specified the Ap and Bp information
+	// will be extracted from the GEOSChem files.
+	GEOSApBp string
+
+	// GEOSChem is the location of GEOS-Chem output files.
+	// [DATE] should be used as a wild card for the simulation date.
+	GEOSChem string
+
+	// OlsonLandMap is the location of the GEOS-Chem Olson land use map file,
+	// which is described here:
+	// http://wiki.seas.harvard.edu/geos-chem/index.php/Olson_land_map
+	OlsonLandMap string
+
+	// Dash indicates whether GEOS-Chem variable names are in the form 'IJ-AVG-S__xxx'
+	// as opposed to 'IJ_AVG_S_xxx'.
+	Dash bool
+
+	// ChemRecordInterval and ChemFileInterval are the time durations
+	// represented by each GEOS-Chem output record and file, for
+	// example "3h". If NoChemHourIndex is true, the GEOS-Chem output files
+	// are assumed to not contain a time dimension.
+	ChemRecordInterval, ChemFileInterval string
+	NoChemHourIndex                      bool
+
+	// InMAPData is the path where the preprocessed baseline meteorology and pollutant
+	// data should be written. If TimePeriods is not "all", [PERIOD] must be
+	// used as a wild card for the time period name.
+	InMAPData string
+
+	// LandCoverFile is the location of an optional land cover GeoTIFF file
+	// (for example NLCD or MODIS) that is used instead of the CTM land use
+	// to calculate land-use-weighted dry deposition velocities in each grid cell.
+	// LandCoverMapping is the location of a TOML file that maps the raster
+	// categories to Seinfeld and Wesely land use categories, and LandCoverProj
+	// is the projection of the raster, which is only required if the raster does
+	// not use longitude-latitude coordinates. GridProj is the projection of
+	// the CTM grid.
+	LandCoverFile, LandCoverMapping, LandCoverProj, GridProj string
+
+	// CtmGridXo is the lower left of Chemical Transport Model (CTM) grid [x].
+	CtmGridXo float64
+
+	// CtmGridYo is the lower left of grid [y]
+	CtmGridYo float64
+
+	// CtmGridDx is the grid cell size in the x direction [m].
+	CtmGridDx float64
+
+	// CtmGridDy is the grid cell size in the y direction [m].
+	CtmGridDy float64
+
+	// ChemGridXo, ChemGridYo, ChemGridDx, and ChemGridDy specify the
+	// chemistry grid in the same way when CTMType is "Mixed".
+	ChemGridXo, ChemGridYo, ChemGridDx, ChemGridDy float64
+
+	// TimePeriods specifies whether to create a single preprocessed
+	// data file for the whole time window between StartDate and EndDate
+	// ("all"), or a separate file for each season ("seasonal") or
+	// month ("monthly"). Season names are in the format YYYYSSS, where SSS
+	// is DJF, MAM, JJA, or SON, and month names are in the format YYYYMM.
+	// The default is "all".
+	TimePeriods string
+
+	// Workers is the maximum number of CTM data fields to read at the same time.
+	// If it is less than one, the number of available CPUs is used.
+	Workers int
+
+	// MaxResidentFields is the maximum number of CTM data fields to read ahead
+	// of when they are needed and hold in memory.
+	MaxResidentFields int
+}
+
+// metGrid returns the CTM grid, which is the meteorology grid when
+// CTMType is "Mixed".
+func (o *PreprocOptions) metGrid() inmap.CTMGrid {
+	return inmap.CTMGrid{Xo: o.CtmGridXo, Yo: o.CtmGridYo, Dx: o.CtmGridDx, Dy: o.CtmGridDy}
+}
+
+// chemGrid returns the chemistry grid when CTMType is "Mixed".
+func (o *PreprocOptions) chemGrid() inmap.CTMGrid {
+	return inmap.CTMGrid{Xo: o.ChemGridXo, Yo: o.ChemGridYo, Dx: o.ChemGridDx, Dy: o.ChemGridDy}
+}
+
 // Preproc preprocesses chemical transport model
-// output as specified by information in cfg
+// output as specified by o
 // and saves the result for use in future InMAP simulations.
-//
-// StartDate is the date of the beginning of the simulation.
-// Format = "YYYYMMDD".
-//
-// EndDate is the date of the end of the simulation.
-// Format = "YYYYMMDD".
-//
-// CTMType specifies what type of chemical transport
-// model we are going to be reading data from. Valid
-// options are "GEOS-Chem" and "WRF-Chem".
-//
-// WRFOut is the location of WRF-Chem output files.
-// [DATE] should be used as a wild card for the simulation date.
-//
-// GEOSA1 is the location of the GEOS 1-hour time average files.
-// [DATE] should be used as a wild card for the simulation date.
-//
-// GEOSA3Cld is the location of the GEOS 3-hour average cloud
-// parameter files. [DATE] should be used as a wild card for
-// the simulation date.
-//
-// GEOSA3Dyn is the location of the GEOS 3-hour average dynamical
-// parameter files. [DATE] should be used as a wild card for
-// the simulation date.
-//
-// GEOSI3 is the location of the GEOS 3-hour instantaneous parameter
-// files. [DATE] should be used as a wild card for
-// the simulation date.
-//
-// GEOSA3MstE is the location of the GEOS 3-hour average moist parameters
-// on level edges files. [DATE] should be used as a wild card for
-// the simulation date.
-//
-// GEOSApBp is the location of the pressure level variable file.
-// It is optional; if it is not specified the Ap and Bp information
-// will be extracted from the GEOSChem files.
-//
-// GEOSChem is the location of GEOS-Chem output files.
-// [DATE] should be used as a wild card for the simulation date.
-//
-// OlsonLandMap is the location of the GEOS-Chem Olson land use map file,
-// which is described here:
-// http://wiki.seas.harvard.edu/geos-chem/index.php/Olson_land_map
-//
-// InMAPData is the path where the preprocessed baseline meteorology and pollutant
-// data should be written.
-//
-// CtmGridXo is the lower left of Chemical Transport Model (CTM) grid [x].
-//
-// CtmGridYo is the lower left of grid [y]
-//
-// CtmGridDx is the grid cell size in the x direction [m].
-//
-// CtmGridDy is the grid cell size in the y direction [m].
-//
-// dash indicates whether GEOS-Chem variable names are in the form 'IJ-AVG-S__xxx'
-// as opposed to 'IJ_AVG_S_xxx'.
-func Preproc(StartDate, EndDate, CTMType, WRFOut, GEOSA1, GEOSA3Cld, GEOSA3Dyn, GEOSI3, GEOSA3MstE, GEOSApBp,
-	GEOSChem, OlsonLandMap, InMAPData string, CtmGridXo, CtmGridYo, CtmGridDx, CtmGridDy float64, dash bool, recordDeltaStr, fileDeltaStr string, noChemHour bool) error {
+func Preproc(o *PreprocOptions) error {
 	msgChan := make(chan string)
 	go func() {
 		for {
 			log.Println(<-msgChan)
 		}
 	}()
+	timePeriods := o.TimePeriods
+	if timePeriods == "" {
+		timePeriods = "all"
+	}
+	if timePeriods != "all" && !strings.Contains(o.InMAPData, "[PERIOD]") {
+		return fmt.Errorf("inmap preprocessor: InMAPData must contain the wild card [PERIOD] when TimePeriods is '%s'", timePeriods)
+	}
+	for i, v := range []string{o.StartDate, o.EndDate} {
+		if v == "" {
+			return fmt.Errorf("inmap preprocessor: configuration variable %s is not specified", []string{"StartDate", "EndDate"}[i])
+		}
+	}
+	start, err := time.Parse("20060102", o.StartDate)
+	if err != nil {
+		return fmt.Errorf("inmap preprocessor: parsing StartDate: %v", err)
+	}
+	end, err := time.Parse("20060102", o.EndDate)
+	if err != nil {
+		return fmt.Errorf("inmap preprocessor: parsing EndDate: %v", err)
+	}
+	periods, err := inmap.SplitTimePeriods(start, end, timePeriods)
+	if err != nil {
+		return err
+	}
+	var landCover *inmap.LandCover
+	var gridSR *proj.SR
+	if o.LandCoverFile != "" {
+		landCover, err = inmap.NewLandCover(o.LandCoverFile, o.LandCoverMapping, o.LandCoverProj)
+		if err != nil {
+			return err
+		}
+		gridSR, err = proj.Parse(o.GridProj)
+		if err != nil {
+			return fmt.Errorf("inmap preprocessor: parsing GridProj: %v", err)
+		}
+	}
+	for _, period := range periods {
+		if len(periods) > 1 {
+			msgChan <- fmt.Sprintf("preprocessing time period %s", period.Name)
+		}
+		po := *o
+		po.StartDate, po.EndDate = period.Start.Format("20060102"), period.End.Format("20060102")
+		ctm, err := newPreprocessor(&po, msgChan)
+		if err != nil {
+			return err
+		}
+		if landCover != nil {
+			ctm, err = inmap.NewLandCoverPreprocessor(ctm, landCover, o.metGrid(), gridSR)
+			if err != nil {
+				return err
+			}
+		}
+		if err = inmap.SetPreprocessLimits(ctm, o.Workers, o.MaxResidentFields); err != nil {
+			return err
+		}
+		ctmData, err := inmap.Preprocess(ctm, o.CtmGridXo, o.CtmGridYo, o.CtmGridDx, o.CtmGridDy)
+		if err != nil {
+			return err
+		}
+		ctmData.Start, ctmData.End = period.Start, period.End
+
+		// Write out the result.
+		ff, err := os.Create(strings.Replace(o.InMAPData, "[PERIOD]", period.Name, -1))
+		if err != nil {
+			return fmt.Errorf("inmap: preprocessor writing output file: %v", err)
+		}
+		if err := ctmData.Write(ff); err != nil {
+			return fmt.Errorf("inmap: preprocessor writing output file: %v", err)
+		}
+		if err := ff.Close(); err != nil {
+			return fmt.Errorf("inmap: preprocessor closing output file: %v", err)
+		}
+		if issues := ctmData.Check(); len(issues) > 0 {
+			msgChan <- fmt.Sprintf("WARNING: preprocessed data contain %d problem(s); "+
+				"run 'inmap preproc check' for details", len(issues))
+			for _, issue := range issues {
+				msgChan <- issue.String()
+			}
+		}
+	}
+	return nil
+}
+
+// PreprocCheck checks the preprocessed data in InMAPData for values outside
+// of physically plausible ranges, such as NaN values, negative Kzz, or zero
+// wind speed, and logs any problems that are found.
+// If OutputDir is not empty, summary statistics for each variable
+// and layer are written to stats.csv in OutputDir, along with maps
+// of each layer in the given Format, which can be "png" or "geojson".
+// For "png" format, one image named [Variable]_[layer].png is written for each
+// layer of each of the given Variables, or of all variables if
+// Variables is empty. For "geojson" format, a file
+// named layer[layer].geojson containing all of the variables is written for each
+// layer.
+// An error is returned if any problems are found.
+func PreprocCheck(InMAPData, OutputDir, Format string, Variables []string) error {
+	f, err := os.Open(InMAPData)
+	if err != nil {
+		return fmt.Errorf("inmap preprocessor check: %v", err)
+	}
+	d, err := (&inmap.VarGridConfig{}).LoadCTMData(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("inmap preprocessor check: %v", err)
+	}
+	if OutputDir != "" {
+		if err := writeCheckSummary(d, OutputDir, Format, Variables); err != nil {
+			return fmt.Errorf("inmap preprocessor check: %v", err)
+		}
+	}
+	issues := d.Check()
+	for _, issue := range issues {
+		log.Println(issue)
+	}
+	if len(issues) > 0 {
+		return fmt.Errorf("inmap preprocessor check: %s contains %d problem(s)", InMAPData, len(issues))
+	}
+	log.Printf("no problems found in %s", InMAPData)
+	return nil
+}
+
+// writeCheckSummary writes summary statistics and maps of d to dir.
+func writeCheckSummary(d *inmap.CTMData, dir, format string, variables []string) error {
+	if format != "png" && format != "geojson" {
+		return fmt.Errorf("invalid format '%s'; valid formats are 'png' and 'geojson'", format)
+	}
+	for _, v := range variables {
+		if _, ok := d.Data[v]; !ok {
+			return fmt.Errorf("data do not contain variable %s", v)
+		}
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	f, err := os.Create(filepath.Join(dir, "stats.csv"))
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"Variable", "Layer", "Min", "Mean", "Max", "Invalid"})
+	layers := make(map[string]int)
+	for _, s := range d.LayerStats() {
+		w.Write([]string{s.Variable, strconv.Itoa(s.Layer),
+			fmt.Sprint(s.Min), fmt.Sprint(s.Mean), fmt.Sprint(s.Max), strconv.Itoa(s.Invalid)})
+		layers[s.Variable] = s.Layer + 1
+	}
+	w.Flush()
+	if err = w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	write := func(name string, do func(f *os.File) error) error {
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		if err := do(f); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+	if format == "geojson" {
+		for k := 0; k < layers["Dz"]; k++ {
+			err := write(fmt.Sprintf("layer%d.geojson", k), func(f *os.File) error {
+				return d.LayerGeoJSON(f, k)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(variables) == 0 {
+		for v := range d.Data {
+			variables = append(variables, v)
+		}
+		sort.Strings(variables)
+	}
+	for _, v := range variables {
+		for k := 0; k < layers[v]; k++ {
+			err := write(fmt.Sprintf("%s_%d.png", v, k), func(f *os.File) error {
+				return d.LayerPNG(f, v, k)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// newPreprocessor returns a preprocessor for the CTMType and
+// time window specified in o.
+func newPreprocessor(o *PreprocOptions, msgChan chan string) (inmap.Preprocessor, error) {
 	var ctm inmap.Preprocessor
-	switch CTMType {
+	switch o.CTMType {
 	case "GEOS-Chem":
-		vars := []string{StartDate, EndDate, CTMType, GEOSA1, GEOSA3Cld, GEOSA3Dyn, GEOSI3, GEOSA3MstE, GEOSChem, OlsonLandMap, recordDeltaStr, fileDeltaStr}
+		vars := []string{o.StartDate, o.EndDate, o.CTMType, o.GEOSA1, o.GEOSA3Cld, o.GEOSA3Dyn, o.GEOSI3, o.GEOSA3MstE, o.GEOSChem, o.OlsonLandMap, o.ChemRecordInterval, o.ChemFileInterval}
 		varNames := []string{"StartDate", "EndDate", "CTMType", "GEOSA1", "GEOSA3Cld", "GEOSA3Dyn", "GEOSI3", "GEOSA3MstE", "GEOSChem", "OlsonLandMap", "recordDeltaStr", "fileDeltaStr"}
 		for i, v := range vars {
 			if v == "" {
-				return fmt.Errorf("inmap preprocessor: configuration variable %s is not specified", varNames[i])
+				return nil, fmt.Errorf("inmap preprocessor: configuration variable %s is not specified", varNames[i])
 			}
 		}
 		var err error
 		ctm, err = inmap.NewGEOSChem(
-			GEOSA1,
-			GEOSA3Cld,
-			GEOSA3Dyn,
-			GEOSI3,
-			GEOSA3MstE,
-			GEOSApBp,
-			GEOSChem,
-			OlsonLandMap,
-			StartDate,
-			EndDate,
-			dash,
-			recordDeltaStr,
-			fileDeltaStr,
-			noChemHour,
+			o.GEOSA1,
+			o.GEOSA3Cld,
+			o.GEOSA3Dyn,
+			o.GEOSI3,
+			o.GEOSA3MstE,
+			o.GEOSApBp,
+			o.GEOSChem,
+			o.OlsonLandMap,
+			o.StartDate,
+			o.EndDate,
+			o.Dash,
+			o.ChemRecordInterval,
+			o.ChemFileInterval,
+			o.NoChemHourIndex,
 			msgChan,
 		)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	case "WRF-Chem":
-		vars := []string{StartDate, EndDate, CTMType, WRFOut}
+		vars := []string{o.StartDate, o.EndDate, o.CTMType, o.WRFOut}
 		varNames := []string{"StartDate", "EndDate", "CTMType", "WRFOut"}
 		for i, v := range vars {
 			if v == "" {
-				return fmt.Errorf("inmap preprocessor: configuration variable %s is not specified", varNames[i])
+				return nil, fmt.Errorf("inmap preprocessor: configuration variable %s is not specified", varNames[i])
 			}
 		}
 		var err error
-		ctm, err = inmap.NewWRFChem(WRFOut, StartDate, EndDate, msgChan)
+		ctm, err = inmap.NewWRFChem(o.WRFOut, o.StartDate, o.EndDate, msgChan)
 		if err != nil {
-			return err
+			return nil, err
+		}
+	case "CMAQ":
+		vars := []string{o.StartDate, o.EndDate, o.CTMType, o.METCRO3D, o.METCRO2D, o.METDOT3D, o.GRIDCRO2D, o.CONC}
+		varNames := []string{"StartDate", "EndDate", "CTMType", "METCRO3D", "METCRO2D", "METDOT3D", "GRIDCRO2D", "CONC"}
+		for i, v := range vars {
+			if v == "" {
+				return nil, fmt.Errorf("inmap preprocessor: configuration variable %s is not specified", varNames[i])
+			}
+		}
+		var err error
+		ctm, err = inmap.NewCMAQ(o.METCRO3D, o.METCRO2D, o.METDOT3D, o.GRIDCRO2D, o.CONC, o.StartDate, o.EndDate, msgChan)
+		if err != nil {
+			return nil, err
+		}
+	case "CAMx":
+		vars := []string{o.StartDate, o.EndDate, o.CTMType, o.CAMxMet3D, o.CAMxMet2D, o.CAMxKv, o.CAMxAvrg}
+		varNames := []string{"StartDate", "EndDate", "CTMType", "CAMxMet3D", "CAMxMet2D", "CAMxKv", "CAMxAvrg"}
+		for i, v := range vars {
+			if v == "" {
+				return nil, fmt.Errorf("inmap preprocessor: configuration variable %s is not specified", varNames[i])
+			}
+		}
+		var err error
+		ctm, err = inmap.NewCAMx(o.CAMxMet3D, o.CAMxMet2D, o.CAMxKv, o.CAMxAvrg, o.StartDate, o.EndDate, msgChan)
+		if err != nil {
+			return nil, err
+		}
+	case "NetCDF":
+		vars := []string{o.StartDate, o.EndDate, o.CTMType, o.NetCDFMapping}
+		varNames := []string{"StartDate", "EndDate", "CTMType", "NetCDFMapping"}
+		for i, v := range vars {
+			if v == "" {
+				return nil, fmt.Errorf("inmap preprocessor: configuration variable %s is not specified", varNames[i])
+			}
+		}
+		var err error
+		ctm, err = inmap.NewGenericNCF(o.NetCDFMapping, o.StartDate, o.EndDate, msgChan)
+		if err != nil {
+			return nil, err
+		}
+	case "Mixed":
+		vars := []string{o.StartDate, o.EndDate, o.CTMType, o.MixedMetMapping, o.MixedChemCTMType, o.MixedChemRecordInterval}
+		varNames := []string{"StartDate", "EndDate", "CTMType", "MixedMetMapping", "MixedChemCTMType", "MixedChemRecordInterval"}
+		for i, v := range vars {
+			if v == "" {
+				return nil, fmt.Errorf("inmap preprocessor: configuration variable %s is not specified", varNames[i])
+			}
+		}
+		if o.MixedChemCTMType == "Mixed" {
+			return nil, fmt.Errorf("inmap preprocessor: MixedChemCTMType cannot be Mixed")
+		}
+		chemRecordDelta, err := time.ParseDuration(o.MixedChemRecordInterval)
+		if err != nil {
+			return nil, fmt.Errorf("inmap preprocessor: MixedChemRecordInterval: %v", err)
+		}
+		met, err := inmap.NewGenericNCF(o.MixedMetMapping, o.StartDate, o.EndDate, msgChan)
+		if err != nil {
+			return nil, err
+		}
+		co := *o
+		co.CTMType = o.MixedChemCTMType
+		co.MixedMetMapping, co.MixedChemCTMType, co.MixedChemRecordInterval = "", "", ""
+		chem, err := newPreprocessor(&co, msgChan)
+		if err != nil {
+			return nil, err
+		}
+		ctm, err = inmap.NewMixedPreprocessor(met, chem, o.metGrid(), o.chemGrid(), met.RecordInterval(), chemRecordDelta)
+		if err != nil {
+			return nil, err
 		}
 	default:
-		return fmt.Errorf("inmap preprocessor: the CTMType you specified, '%s', is invalid. Valid options are WRF-Chem and GEOS-Chem", CTMType)
+		return nil, fmt.Errorf("inmap preprocessor: the CTMType you specified, '%s', is invalid. Valid options are WRF-Chem, GEOS-Chem, CMAQ, CAMx, NetCDF, and Mixed", o.CTMType)
 	}
-	ctmData, err := inmap.Preprocess(ctm, CtmGridXo, CtmGridYo, CtmGridDx, CtmGridDy)
-	if err != nil {
-		return err
-	}
-
-	// Write out the result.
-	ff, err := os.Create(InMAPData)
-	if err != nil {
-		return fmt.Errorf("inmap: preprocessor writing output file: %v", err)
-	}
-	if err := ctmData.Write(ff); err != nil {
-		return fmt.Errorf("inmap: preprocessor writing output file: %v", err)
-	}
-	if err := ff.Close(); err != nil {
-		return fmt.Errorf("inmap: preprocessor closing output file: %v", err)
-	}
-
-	return nil
+	return ctm, nil
 }
@@ -20,7 +20,10 @@ package inmaputil
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/evookelj/inmap"
 )
 
 func TestPreprocWRFChem(t *testing.T) {
@@ -35,6 +38,19 @@ func TestPreprocWRFChem(t *testing.T) {
 	}
 }
 
+func TestPreprocWRFChem_landCover(t *testing.T) {
+	cfg := InitializeConfig()
+	cfg.Set("config", "../cmd/inmap/configExampleWRFChem.toml")
+	cfg.Set("Preproc.LandCover.File", "../cmd/inmap/testdata/preproc/landcover.tif")
+	cfg.Set("Preproc.LandCover.Mapping", "../cmd/inmap/landCoverNLCD.toml")
+	cfg.Set("InMAPData", "inmapData_WRFChem_landCover.ncf")
+	cfg.Root.SetArgs([]string{"preproc"})
+	defer os.Remove("inmapData_WRFChem_landCover.ncf")
+	if err := cfg.Root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestPreprocGEOSChem(t *testing.T) {
 	cfg := InitializeConfig()
 	// Here we only test whether the program runs. We
@@ -47,6 +63,148 @@ func TestPreprocGEOSChem(t *testing.T) {
 	}
 }
 
+func TestPreprocCMAQ(t *testing.T) {
+	cfg := InitializeConfig()
+	// Here we only test whether the program runs. We
+	// check whether the output is correct elsewhere.
+	cfg.Set("config", "../cmd/inmap/configExampleCMAQ.toml")
+	cfg.Root.SetArgs([]string{"preproc"})
+	defer os.Remove("../cmd/inmap/testdata/preproc/inmapData_CMAQ.ncf")
+	if err := cfg.Root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPreprocNetCDF(t *testing.T) {
+	cfg := InitializeConfig()
+	// Here we only test whether the program runs. We
+	// check whether the output is correct elsewhere.
+	cfg.Set("config", "../cmd/inmap/configExampleNetCDF.toml")
+	cfg.Root.SetArgs([]string{"preproc"})
+	defer os.Remove("../cmd/inmap/testdata/preproc/inmapData_NetCDF.ncf")
+	if err := cfg.Root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPreprocMixed(t *testing.T) {
+	cfg := InitializeConfig()
+	// Here we only test whether the program runs. We
+	// check whether the output is correct elsewhere.
+	cfg.Set("config", "../cmd/inmap/configExampleMixed.toml")
+	cfg.Root.SetArgs([]string{"preproc"})
+	defer os.Remove("../cmd/inmap/testdata/preproc/inmapData_Mixed.ncf")
+	if err := cfg.Root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPreprocCombine_blend(t *testing.T) {
+	cfg := InitializeConfig()
+	cfg.Set("preprocessed_inputs", []string{
+		"../cmd/inmap/testdata/inmapData_combine_outerNest.ncf",
+		"../cmd/inmap/testdata/inmapData_combine_innerNest.ncf",
+	})
+	cfg.Set("blend_buffer", 2.0)
+	cfg.Set("jump_diagnostics_file", "inmapdata_combined_jumps.ncf")
+	cfg.Root.SetArgs([]string{"preproc", "combine"})
+	defer os.Remove("inmapdata_combined.ncf")
+	defer os.Remove("inmapdata_combined_jumps.ncf")
+
+	if err := cfg.Root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open("inmapdata_combined_jumps.ncf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	jumps, err := (&inmap.VarGridConfig{}).LoadCTMData(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jumps.Data["Kzz"].Data.Max() == 0 {
+		t.Error("missing Kzz jumps")
+	}
+}
+
+func TestPreprocCheck(t *testing.T) {
+	const dir = "testCheck"
+	defer os.RemoveAll(dir)
+	for _, format := range []string{"png", "geojson"} {
+		t.Run(format, func(t *testing.T) {
+			cfg := InitializeConfig()
+			cfg.Set("InMAPData", "../cmd/inmap/testdata/preproc/inmapData_WRFChem_golden.ncf")
+			cfg.Set("check_output_dir", dir)
+			cfg.Set("check_format", format)
+			cfg.Set("check_variables", []string{"Kzz", "Pblh"})
+			cfg.Root.SetArgs([]string{"preproc", "check"})
+			if err := cfg.Root.Execute(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+	for _, file := range []string{"stats.csv", "Kzz_9.png", "Pblh_0.png", "layer9.geojson"} {
+		if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "Pblh_1.png")); err == nil {
+		t.Error("two-dimensional variables should only have one layer")
+	}
+
+	cfg := InitializeConfig()
+	cfg.Set("InMAPData", "../cmd/inmap/testdata/preproc/inmapData_WRFChem_golden.ncf")
+	cfg.Set("check_output_dir", dir)
+	cfg.Set("check_format", "jpg")
+	cfg.Root.SetArgs([]string{"preproc", "check"})
+	if err := cfg.Root.Execute(); err == nil {
+		t.Error("invalid format should cause an error")
+	}
+}
+
+func TestPreprocCAMx(t *testing.T) {
+	cfg := InitializeConfig()
+	// Here we only test whether the program runs. We
+	// check whether the output is correct elsewhere.
+	cfg.Set("config", "../cmd/inmap/configExampleCAMx.toml")
+	cfg.Root.SetArgs([]string{"preproc"})
+	defer os.Remove("../cmd/inmap/testdata/preproc/inmapData_CAMx.ncf")
+	if err := cfg.Root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPreprocCAMx_periods(t *testing.T) {
+	cfg := InitializeConfig()
+	cfg.Set("config", "../cmd/inmap/configExampleCAMx.toml")
+	cfg.Set("Preproc.TimePeriods", "monthly")
+	cfg.Root.SetArgs([]string{"preproc"})
+	if err := cfg.Root.Execute(); err == nil {
+		t.Error("InMAPData without [PERIOD] should cause an error")
+	}
+
+	cfg.Set("InMAPData", "../cmd/inmap/testdata/preproc/inmapData_CAMx_[PERIOD].ncf")
+	const file = "../cmd/inmap/testdata/preproc/inmapData_CAMx_201607.ncf"
+	defer os.Remove(file)
+	if err := cfg.Root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	vgc := &inmap.VarGridConfig{}
+	data, err := vgc.LoadCTMData(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, e := data.Start.Format("20060102"), data.End.Format("20060102"); s != "20160701" || e != "20160703" {
+		t.Errorf("time period: have %s–%s, want 20160701–20160703", s, e)
+	}
+}
+
 func TestPreprocCombine(t *testing.T) {
 	cfg := InitializeConfig()
 	// Here we only test whether the program runs. We
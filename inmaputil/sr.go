@@ -21,12 +21,21 @@ package inmaputil
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
+	"strings"
 
+	"github.com/ctessum/cdf"
 	"github.com/ctessum/geom"
+	"github.com/ctessum/geom/encoding/shp"
+	"github.com/ctessum/geom/proj"
 	"github.com/evookelj/inmap"
+	"github.com/evookelj/inmap/cloud"
 	"github.com/evookelj/inmap/cloud/cloudrpc"
+	"github.com/evookelj/inmap/emissions/aep/aeputil"
 	"github.com/evookelj/inmap/sr"
 )
 
@@ -49,6 +58,8 @@ import (
 // layers specifies which vertical layers to process.
 //
 // client is a client of the cluster that will run the simulations.
+// If client is a *cloud.LocalClient, StartSR waits for the simulations
+// to finish.
 func StartSR(ctx context.Context, jobName string, cmds []string, memoryGB int32, VariableGridData string, VarGrid *inmap.VarGridConfig, begin, end int, layers []int, client cloudrpc.CloudRPCClient, cfg *Cfg) error {
 	outChan := outChan()
 	varGridReader, err := os.Open(maybeDownload(ctx, VariableGridData, outChan))
@@ -62,6 +73,9 @@ func StartSR(ctx context.Context, jobName string, cmds []string, memoryGB int32,
 	if err = sr.Start(ctx, jobName, layers, begin, end, cfg.Root, cfg.Viper, cmds, cfg.InputFiles(), memoryGB); err != nil {
 		return err
 	}
+	if lc, ok := client.(*cloud.LocalClient); ok {
+		return lc.Wait()
+	}
 	return nil
 }
 
@@ -94,6 +108,167 @@ func SaveSR(ctx context.Context, jobName, OutputFile string, VariableGridData st
 	return sr.Save(ctx, OutputFile, jobName, layers, begin, end)
 }
 
+// RunSR creates an SR matrix by running simulations on the local computer,
+// with batchSize sources included in each simulation, and saves
+// the results to OutputFile.
+//
+// VariableGridData is the path to the location of the variable-resolution gridded
+// InMAP data.
+//
+// VarGrid provides information for specifying the variable resolution grid.
+//
+// begin and end specify the beginning and end grid indices to process.
+//
+// layers specifies which vertical layers to process.
+//
+// numIterations specifies the number of iterations to run each simulation
+// for; if it is zero, each simulation is run until it converges.
+func RunSR(ctx context.Context, OutputFile, VariableGridData string, VarGrid *inmap.VarGridConfig, begin, end int, layers []int, batchSize, numIterations int) error {
+	varGridReader, err := os.Open(VariableGridData)
+	if err != nil {
+		return fmt.Errorf("running SR matrix---can't open variable grid data file: %v", err)
+	}
+	sr, err := sr.NewSR(varGridReader, VarGrid, nil)
+	if err != nil {
+		return err
+	}
+	return sr.RunTagged(ctx, OutputFile, layers, begin, end, batchSize, numIterations)
+}
+
+// ConvertSR converts the SR matrix at SROutputFile to the sparse format,
+// dropping values smaller than threshold times the largest value for each
+// source, and saves it to SparseFile.
+func ConvertSR(SROutputFile, SparseFile string, threshold float64) error {
+	f, err := openSRFile(context.TODO(), SROutputFile)
+	if err != nil {
+		return fmt.Errorf("inmap: converting SR matrix: %v", err)
+	}
+	if c, ok := f.(io.Closer); ok {
+		defer c.Close()
+	}
+	r, err := sr.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("inmap: converting SR matrix: %v", err)
+	}
+	w, err := os.Create(SparseFile)
+	if err != nil {
+		return fmt.Errorf("inmap: converting SR matrix: %v", err)
+	}
+	if err = sr.ConvertSparse(w, r, threshold); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// MergeSR combines the SR matrices in MergeFiles, which must have been
+// created using the same grid, and saves the result to SROutputFile.
+// See the documentation for sr.Merge for more information.
+func MergeSR(SROutputFile string, MergeFiles []string) error {
+	if len(MergeFiles) == 0 {
+		return fmt.Errorf("inmap: merging SR matrices: no input files specified")
+	}
+	readers := make([]*sr.Reader, len(MergeFiles))
+	for i, file := range MergeFiles {
+		if file == SROutputFile {
+			return fmt.Errorf("inmap: merging SR matrices: input file %s is the same as the output file", file)
+		}
+		f, err := openSRFile(context.TODO(), file)
+		if err != nil {
+			return fmt.Errorf("inmap: merging SR matrices: %v", err)
+		}
+		if c, ok := f.(io.Closer); ok {
+			defer c.Close()
+		}
+		if readers[i], err = sr.NewReader(f); err != nil {
+			return fmt.Errorf("inmap: merging SR matrices: reading %s: %v", file, err)
+		}
+	}
+	w, err := os.Create(SROutputFile)
+	if err != nil {
+		return fmt.Errorf("inmap: merging SR matrices: %v", err)
+	}
+	if err = sr.Merge(w, readers...); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// SubsetSR extracts the part of the SR matrix at SROutputFile within
+// the region made up of the polygons in SubsetShapefile and saves it to
+// SubsetFile. See the documentation for sr.Subset for more information.
+func SubsetSR(SROutputFile, SubsetFile, SubsetShapefile string, VarGrid *inmap.VarGridConfig) error {
+	vgsr, err := spatialRef(VarGrid)
+	if err != nil {
+		return err
+	}
+	region, err := readRegionShapefile(SubsetShapefile, vgsr)
+	if err != nil {
+		return err
+	}
+	f, err := openSRFile(context.TODO(), SROutputFile)
+	if err != nil {
+		return fmt.Errorf("inmap: subsetting SR matrix: %v", err)
+	}
+	if c, ok := f.(io.Closer); ok {
+		defer c.Close()
+	}
+	r, err := sr.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("inmap: subsetting SR matrix: %v", err)
+	}
+	w, err := os.Create(SubsetFile)
+	if err != nil {
+		return fmt.Errorf("inmap: subsetting SR matrix: %v", err)
+	}
+	if err = sr.Subset(w, r, region); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// SRServe serves a JSON API for the SR matrix at SROutputFile at
+// the given network address. See the documentation for sr.NewHandler
+// for a description of the API.
+func SRServe(SROutputFile, address string) error {
+	f, err := openSRFile(context.TODO(), SROutputFile)
+	if err != nil {
+		return err
+	}
+	if c, ok := f.(io.Closer); ok {
+		defer c.Close()
+	}
+	r, err := sr.NewReader(f)
+	if err != nil {
+		return err
+	}
+	log.Printf("serving SR matrix %s at %s", SROutputFile, address)
+	return http.ListenAndServe(address, sr.NewHandler(r))
+}
+
+// openSRFile opens the SR matrix file at path for reading. If path
+// is an http(s) URL or a blob storage path, the file is read remotely
+// using range requests rather than being downloaded.
+func openSRFile(ctx context.Context, path string) (cdf.ReaderWriterAt, error) {
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		return sr.NewHTTPReader(ctx, path, nil)
+	}
+	if IsBlob(path) {
+		url, err := url.Parse(path)
+		if err != nil {
+			return nil, err
+		}
+		bucket, err := cloud.OpenBucket(ctx, url.Scheme+"://"+url.Host)
+		if err != nil {
+			return nil, err
+		}
+		return sr.NewBlobReader(ctx, bucket, strings.TrimPrefix(url.Path, "/"))
+	}
+	return os.Open(path)
+}
+
 // CleanSR cleans up remote data created during the SR matrix creation simulations.
 func CleanSR(ctx context.Context, jobName, VariableGridData string, VarGrid *inmap.VarGridConfig, begin, end int, layers []int, client cloudrpc.CloudRPCClient) error {
 	varGridReader, err := os.Open(VariableGridData)
@@ -107,14 +282,52 @@ func CleanSR(ctx context.Context, jobName, VariableGridData string, VarGrid *inm
 	return sr.Clean(ctx, jobName, layers, begin, end)
 }
 
-// SRPredict uses the SR matrix specified in SROutputFile
+// SRPredictOptions holds the options for SRPredict.
+type SRPredictOptions struct {
+	// EmissionUnits specifies the units of the emissions.
+	EmissionUnits string
+
+	// SROutputFile is the location of the SR matrix.
+	SROutputFile string
+
+	// OutputFile is the location where the results specified by
+	// OutputVariables are written.
+	OutputFile      string
+	OutputVariables map[string]string
+
+	// If any DisparityVariables are specified, exposure disparity
+	// metrics for those output variables are written to a CSV file
+	// next to OutputFile.
+	DisparityVariables []string
+
+	// EmissionsShapefiles are the locations of shapefiles containing
+	// the emissions, which are optionally masked by EmissionMask.
+	EmissionsShapefiles []string
+	EmissionMask        geom.Polygon
+
+	// InventoryConfig optionally specifies emissions inventories to
+	// include. Inventory emissions are allocated to the SR matrix grid
+	// as specified by SpatialConfig.
+	InventoryConfig *aeputil.InventoryConfig
+	SpatialConfig   *aeputil.SpatialConfig
+
+	// VarGrid specifies the variable resolution grid.
+	VarGrid *inmap.VarGridConfig
+
+	// If Year is not 0, the population and mortality rates in the SR matrix
+	// are replaced by those for Year from VarGrid.CensusProjections and
+	// VarGrid.MortalityRateProjections. Otherwise, if LoadPopMort is true,
+	// they are replaced by those in VarGrid.CensusFile and
+	// VarGrid.MortalityRateFile.
+	Year        int
+	LoadPopMort bool
+}
+
+// SRPredict uses the SR matrix specified in o.SROutputFile
 // to predict concentrations resulting
-// from the emissions in EmissionsShapefiles (optionally
-// masked by emissionMask), outputting the
-// results specified by outputVaraibles in OutputFile.
-// EmissionUnits specifies the units
-// of the emissions. VarGrid specifies the variable resolution grid.
-func SRPredict(EmissionUnits, SROutputFile, OutputFile string, outputVariables map[string]string, EmissionsShapefiles []string, emissionMask geom.Polygon, VarGrid *inmap.VarGridConfig) error {
+// from the emissions specified in o, outputting the
+// results specified by o.OutputVariables in o.OutputFile.
+func SRPredict(o *SRPredictOptions) error {
 	msgLog := make(chan string)
 	go func() {
 		for {
@@ -122,16 +335,16 @@ func SRPredict(EmissionUnits, SROutputFile, OutputFile string, outputVariables m
 		}
 	}()
 
-	vgsr, err := spatialRef(VarGrid)
+	vgsr, err := spatialRef(o.VarGrid)
 	if err != nil {
 		return err
 	}
 
-	emis, err := inmap.ReadEmissionShapefiles(vgsr, EmissionUnits, msgLog, emissionMask, EmissionsShapefiles...)
+	emis, err := inmap.ReadEmissionShapefiles(vgsr, o.EmissionUnits, msgLog, o.EmissionMask, o.EmissionsShapefiles...)
 	if err != nil {
 		return err
 	}
-	f, err := os.Open(SROutputFile)
+	f, err := openSRFile(context.TODO(), o.SROutputFile)
 	if err != nil {
 		return err
 	}
@@ -139,6 +352,22 @@ func SRPredict(EmissionUnits, SROutputFile, OutputFile string, outputVariables m
 	if err != nil {
 		return err
 	}
+	if o.InventoryConfig != nil && (len(o.InventoryConfig.NEIFiles) > 0 || len(o.InventoryConfig.COARDSFiles) > 0) {
+		recs, _, err := o.InventoryConfig.ReadEmissions()
+		if err != nil {
+			return err
+		}
+		emisRecs, err := griddedAEPEmissions(recs, o.SpatialConfig, r.Geometry())
+		if err != nil {
+			return err
+		}
+		for _, e := range emisRecs {
+			emis.Add(e)
+		}
+	}
+	if err = setSRPopMort(o.VarGrid, o.Year, o.LoadPopMort, r); err != nil {
+		return err
+	}
 	conc, err := r.Concentrations(emis.EmisRecords()...)
 	if err != nil {
 		if _, ok := err.(sr.AboveTopErr); ok {
@@ -152,18 +381,183 @@ func SRPredict(EmissionUnits, SROutputFile, OutputFile string, outputVariables m
 	}
 
 	var upload uploader
-	o := upload.maybeUpload(OutputFile)
+	of := upload.maybeUpload(o.OutputFile)
+	var df string
+	if len(o.DisparityVariables) > 0 {
+		df = upload.maybeUpload(disparityFile(o.OutputFile))
+	}
 	if upload.err != nil {
 		return upload.err
 	}
 
-	if err = r.Output(o, outputVariables, nil, vgsr); err != nil {
+	// Output modifies the variable expressions, so we keep a copy for
+	// calculating disparities.
+	disparityOutputVariables := make(map[string]string)
+	for k, v := range o.OutputVariables {
+		disparityOutputVariables[k] = v
+	}
+
+	if err = r.Output(of, o.OutputVariables, nil, vgsr); err != nil {
 		return err
 	}
 
+	if len(o.DisparityVariables) > 0 {
+		disparities, err := r.Disparities(disparityOutputVariables, nil, o.VarGrid.CensusPopColumns, o.VarGrid.PopGridColumn, o.DisparityVariables...)
+		if err != nil {
+			return err
+		}
+		if err = writeDisparityFile(df, disparities); err != nil {
+			return err
+		}
+	}
+
 	if err := upload.uploadOutput(nil); err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// setSRPopMort replaces the population and mortality rates in each of readers with
+// those for Year from VarGrid if Year is not 0, or otherwise with
+// those in VarGrid.CensusFile and VarGrid.MortalityRateFile if
+// LoadPopMort is true.
+func setSRPopMort(VarGrid *inmap.VarGridConfig, Year int, LoadPopMort bool, readers ...*sr.Reader) error {
+	if Year == 0 && !LoadPopMort {
+		return nil
+	}
+	var pop *inmap.Population
+	var popIndices inmap.PopIndices
+	var mr *inmap.MortalityRates
+	var mortIndices inmap.MortIndices
+	var err error
+	if Year != 0 {
+		pop, popIndices, mr, mortIndices, err = VarGrid.LoadPopMortYear(Year)
+	} else {
+		pop, popIndices, mr, mortIndices, err = VarGrid.LoadPopMort()
+	}
+	if err != nil {
+		return err
+	}
+	for _, r := range readers {
+		if err = r.SetPopMort(VarGrid, pop, popIndices, mr, mortIndices); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SRReceptor uses the SR matrix specified in SROutputFile to calculate
+// the contribution of emissions in each grid cell in SR layer index
+// layer to the area-weighted average concentrations within the receptor
+// region made up of the polygons in ReceptorShapefile, and writes the
+// results to OutputFile. The output units are μg m-3 concentration within
+// the receptor region per μg s-1 emissions in each grid cell. VarGrid
+// specifies the variable resolution grid.
+func SRReceptor(SROutputFile, OutputFile, ReceptorShapefile string, layer int, VarGrid *inmap.VarGridConfig) error {
+	vgsr, err := spatialRef(VarGrid)
+	if err != nil {
+		return err
+	}
+	region, err := readRegionShapefile(ReceptorShapefile, vgsr)
+	if err != nil {
+		return err
+	}
+	f, err := openSRFile(context.TODO(), SROutputFile)
+	if err != nil {
+		return err
+	}
+	r, err := sr.NewReader(f)
+	if err != nil {
+		return err
+	}
+	conc := new(sr.Concentrations)
+	for pol, v := range map[string]*[]float64{
+		"pNH4":        &conc.PNH4,
+		"pNO3":        &conc.PNO3,
+		"pSO4":        &conc.PSO4,
+		"SOA":         &conc.SOA,
+		"PrimaryPM25": &conc.PrimaryPM25,
+	} {
+		if *v, err = r.ReceptorRegion(pol, layer, region); err != nil {
+			return err
+		}
+	}
+	if err = r.SetConcentrations(conc); err != nil {
+		return err
+	}
+
+	var upload uploader
+	o := upload.maybeUpload(OutputFile)
+	if upload.err != nil {
+		return upload.err
+	}
+	outputVariables := map[string]string{
+		"PrimPM25": "PrimaryPM25",
+		"pNH4":     "pNH4",
+		"pSO4":     "pSO4",
+		"pNO3":     "pNO3",
+		"SOA":      "SOA",
+	}
+	if err = r.Output(o, outputVariables, nil, vgsr); err != nil {
+		return err
+	}
+	return upload.uploadOutput(nil)
+}
+
+// readRegionShapefile reads the polygons in the given shapefile
+// and converts them to spatial reference gridSR.
+func readRegionShapefile(filename string, gridSR *proj.SR) (geom.MultiPolygon, error) {
+	d, err := shp.NewDecoder(filename)
+	if err != nil {
+		return nil, fmt.Errorf("inmap: opening region shapefile: %v", err)
+	}
+	defer d.Close()
+	src, err := d.SR()
+	if err != nil {
+		return nil, fmt.Errorf("inmap: reading region shapefile projection: %v", err)
+	}
+	trans, err := src.NewTransform(gridSR)
+	if err != nil {
+		return nil, fmt.Errorf("inmap: reading region shapefile projection: %v", err)
+	}
+	var region geom.MultiPolygon
+	for {
+		g, _, more := d.DecodeRowFields()
+		if !more {
+			break
+		}
+		gg, err := g.Transform(trans)
+		if err != nil {
+			return nil, fmt.Errorf("inmap: reprojecting region shapefile: %v", err)
+		}
+		switch p := gg.(type) {
+		case geom.Polygon:
+			region = append(region, p)
+		case geom.MultiPolygon:
+			region = append(region, p...)
+		default:
+			return nil, fmt.Errorf("inmap: region shapefile shapes need to be polygons, not %T", gg)
+		}
+	}
+	if err := d.Error(); err != nil {
+		return nil, fmt.Errorf("inmap: reading region shapefile: %v", err)
+	}
+	if len(region) == 0 {
+		return nil, fmt.Errorf("inmap: region shapefile %s does not contain any polygons", filename)
+	}
+	return region, nil
+}
+
+// writeDisparityFile writes exposure disparity metrics to a CSV file.
+func writeDisparityFile(fileName string, disparities []*inmap.Disparity) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("inmap: creating disparity output file: %v", err)
+	}
+	if err := inmap.WriteDisparities(f, disparities); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -19,12 +19,29 @@ along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
 package inmaputil
 
 import (
+	"bytes"
 	"context"
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/ctessum/geom"
+	"github.com/ctessum/geom/encoding/shp"
+	"github.com/ctessum/geom/proj"
+	"github.com/ctessum/unit/badunit"
 	"github.com/evookelj/inmap"
 	"github.com/evookelj/inmap/cloud"
+	"github.com/evookelj/inmap/science/chem/simplechem"
+	"github.com/evookelj/inmap/sr"
+	"github.com/gonum/floats"
 )
 
 func TestSR(t *testing.T) {
@@ -63,6 +80,84 @@ func TestSR(t *testing.T) {
 	}
 }
 
+func TestSR_local(t *testing.T) {
+	cfg := InitializeConfig()
+	output := "../cmd/inmap/testdata/tempSR_local.ncf"
+	begin := 8
+	end := 9
+	layers := []int{0}
+	cmds := []string{"run", "steady"}
+	defer os.Remove(output)
+	vgc, err := VarGridConfig(cfg.Viper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	cfg.Set("local_dir", "test_local")
+	c, err := NewLocalClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("test_local")
+	// Run the simulations in this process so the inmap
+	// command does not need to be installed.
+	c.Exec = func(ctx context.Context, dir string, cmd []string) ([]byte, error) {
+		cfg := InitializeConfig()
+		var b bytes.Buffer
+		cfg.Root.SetOutput(&b)
+		cfg.Root.SetArgs(cmd[1:])
+		err := cfg.Root.Execute()
+		return b.Bytes(), err
+	}
+
+	err = StartSR(ctx, "test_sr", cmds, 1,
+		os.ExpandEnv(cfg.GetString("VariableGridData")),
+		vgc, begin, end, layers, c, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SaveSR(ctx, "test_sr", output,
+		os.ExpandEnv(cfg.GetString("VariableGridData")),
+		vgc, begin, end, layers, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(output); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSR_run(t *testing.T) {
+	cfg := InitializeConfig()
+	output := "../cmd/inmap/testdata/tempSR_run.ncf"
+	defer os.Remove(output)
+	cfg.Root.SetArgs([]string{"sr", "run", "--config=../cmd/inmap/configExample.toml",
+		"--begin=7", "--end=10", "--layers=0", "--batch_size=2", "--NumIterations=5",
+		"--SR.OutputFile=" + output})
+	if err := cfg.Root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := sr.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 7; i < 10; i++ {
+		v, err := r.Source("PrimaryPM25", 0, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if floats.Sum(v) == 0 {
+			t.Errorf("source %d: no concentrations", i)
+		}
+	}
+}
+
 func TestSRPredict(t *testing.T) {
 	cfg := InitializeConfig()
 	cfg.Set("SR.OutputFile", "../cmd/inmap/testdata/testSR_golden.ncf")
@@ -80,6 +175,32 @@ func TestSRPredict(t *testing.T) {
 	}
 }
 
+func TestSR_convert(t *testing.T) {
+	const sparseFile = "../cmd/inmap/testdata/testSR_sparse.ncf"
+	defer os.Remove(sparseFile)
+	cfg := InitializeConfig()
+	cfg.Root.SetArgs([]string{"sr", "convert", "--config=../cmd/inmap/configExample.toml",
+		"--SR.OutputFile=../cmd/inmap/testdata/testSR_golden.ncf",
+		"--sparse_file=" + sparseFile, "--sparse_threshold=0.001"})
+	if err := cfg.Root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The sparse SR matrix should be usable for predictions.
+	cfg = InitializeConfig()
+	cfg.Set("config", "../cmd/inmap/configExample.toml")
+	cfg.Set("SR.OutputFile", sparseFile)
+	cfg.Set("OutputFile", "../cmd/inmap/testdata/output_SRPredict.shp")
+	cfg.Set("OutputVariables", `{"TotalPM25": "PrimaryPM25 + pNH4 + pSO4 + pNO3 + SOA"}`)
+	cfg.Set("EmissionsShapefiles", []string{"../cmd/inmap/testdata/testEmisSR.shp"})
+	defer os.Remove(os.ExpandEnv("$INMAP_ROOT_DIR/cmd/inmap/testdata/output_SRPredict.log"))
+	defer inmap.DeleteShapefile(os.ExpandEnv("$INMAP_ROOT_DIR/cmd/inmap/testdata/output_SRPredict.shp"))
+	cfg.Root.SetArgs([]string{"srpredict"})
+	if err := cfg.Root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestSRPredictAboveTop(t *testing.T) {
 	cfg := InitializeConfig()
 	cfg.Set("config", "../cmd/inmap/configExample.toml")
@@ -106,7 +227,708 @@ func TestSRPredictAboveTop(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err := SRPredict(cfg.GetString("EmissionUnits"), cfg.GetString("SR.OutputFile"), cfg.GetString("OutputFile"), outputVars, cfg.GetStringSlice("EmissionsShapefiles"), mask, vcfg); err != nil {
+	if err := SRPredict(&SRPredictOptions{
+		EmissionUnits:       cfg.GetString("EmissionUnits"),
+		SROutputFile:        cfg.GetString("SR.OutputFile"),
+		OutputFile:          cfg.GetString("OutputFile"),
+		OutputVariables:     outputVars,
+		EmissionsShapefiles: cfg.GetStringSlice("EmissionsShapefiles"),
+		EmissionMask:        mask,
+		VarGrid:             vcfg,
+	}); err != nil {
 		t.Fatal(err)
 	}
 }
+
+func TestSRPredict_remote(t *testing.T) {
+	ts := httptest.NewServer(http.FileServer(http.Dir("../cmd/inmap/testdata")))
+	defer ts.Close()
+	var results [][]float64
+	for _, srFile := range []string{"../cmd/inmap/testdata/testSR_golden.ncf", ts.URL + "/testSR_golden.ncf"} {
+		const outputFile = "../cmd/inmap/testdata/output_SRPredict_remote.shp"
+		cfg := InitializeConfig()
+		cfg.Set("config", "../cmd/inmap/configExample.toml")
+		cfg.Set("SR.OutputFile", srFile)
+		cfg.Set("OutputFile", outputFile)
+		cfg.Set("OutputVariables", `{"TotalPM25": "PrimaryPM25 + pNH4 + pSO4 + pNO3 + SOA"}`)
+		cfg.Set("EmissionsShapefiles", []string{"../cmd/inmap/testdata/testEmisSR.shp"})
+		cfg.Root.SetArgs([]string{"srpredict"})
+		if err := cfg.Root.Execute(); err != nil {
+			t.Fatal(err)
+		}
+		d, err := shp.NewDecoder(outputFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r []float64
+		for {
+			var rec struct {
+				geom.Polygon
+				TotalPM25 float64
+			}
+			if more := d.DecodeRow(&rec); !more {
+				break
+			}
+			r = append(r, rec.TotalPM25)
+		}
+		if err = d.Error(); err != nil {
+			t.Fatal(err)
+		}
+		d.Close()
+		inmap.DeleteShapefile(outputFile)
+		results = append(results, r)
+	}
+	if !reflect.DeepEqual(results[0], results[1]) {
+		t.Errorf("remote results %v don't match local results %v", results[1], results[0])
+	}
+}
+
+// writeRegionShapefile writes region to a shapefile with the
+// same projection as the test SR matrix.
+func writeRegionShapefile(t *testing.T, file string, region geom.Polygon) {
+	e, err := shp.NewEncoder(file, struct{ geom.Polygon }{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = e.Encode(struct{ geom.Polygon }{region}); err != nil {
+		t.Fatal(err)
+	}
+	e.Close()
+	prj, err := ioutil.ReadFile("../cmd/inmap/testdata/testEmisSR.prj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(strings.TrimSuffix(file, ".shp")+".prj", prj, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSR_receptor(t *testing.T) {
+	const (
+		receptorFile = "../cmd/inmap/testdata/testReceptorSR.shp"
+		outputFile   = "../cmd/inmap/testdata/output_SRReceptor.shp"
+		srFile       = "../cmd/inmap/testdata/testSR_golden.ncf"
+	)
+	region := geom.Polygon{{
+		{X: -4000, Y: -4000}, {X: -2500, Y: -4000}, {X: -2500, Y: -3000}, {X: -4000, Y: -3000},
+	}}
+	writeRegionShapefile(t, receptorFile, region)
+	defer inmap.DeleteShapefile(receptorFile)
+
+	cfg := InitializeConfig()
+	cfg.Root.SetArgs([]string{"sr", "receptor", "--config=../cmd/inmap/configExample.toml",
+		"--SR.OutputFile=" + srFile, "--OutputFile=" + outputFile,
+		"--receptor_shapefile=" + receptorFile, "--receptor_layer=1"})
+	if err := cfg.Root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	defer inmap.DeleteShapefile(outputFile)
+
+	f, err := os.Open(srFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := sr.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := r.ReceptorRegion("pSO4", 1, region)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := shp.NewDecoder(outputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	var have []float64
+	for {
+		var rec struct {
+			geom.Polygon
+			PSO4 float64 `shp:"pSO4"`
+		}
+		if more := d.DecodeRow(&rec); !more {
+			break
+		}
+		have = append(have, rec.PSO4)
+	}
+	if err = d.Error(); err != nil {
+		t.Fatal(err)
+	}
+	if !floats.EqualApprox(have, want, 1.e-10) {
+		t.Errorf("have %v, want %v", have, want)
+	}
+}
+
+// saveSRGrid saves a variable resolution grid for SR matrix
+// testing to filename.
+func saveSRGrid(t *testing.T, filename string) {
+	cfg, ctmdata, pop, popIndices, mr, mortIndices := inmap.VarGridTestData()
+	cfg.HiResLayers = 6
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var m simplechem.Mechanism
+	mutator, err := inmap.PopulationMutator(cfg, popIndices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emis := inmap.NewEmissions()
+	d := &inmap.InMAP{
+		InitFuncs: []inmap.DomainManipulator{
+			cfg.RegularGrid(ctmdata, pop, popIndices, mr, mortIndices, emis, m),
+			cfg.MutateGrid(mutator, ctmdata, pop, mr, emis, m, nil),
+			inmap.Save(f),
+		},
+	}
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSR_merge(t *testing.T) {
+	const (
+		varGridFile = "../cmd/inmap/testdata/inmapVarGrid_merge.gob"
+		partA       = "../cmd/inmap/testdata/tempSR_mergeA.ncf"
+		partB       = "../cmd/inmap/testdata/tempSR_mergeB.ncf"
+		mergedFile  = "../cmd/inmap/testdata/tempSR_merged.ncf"
+	)
+	saveSRGrid(t, varGridFile)
+	defer os.Remove(varGridFile)
+	for _, part := range []struct {
+		file, begin, end, layers string
+	}{
+		{file: partA, begin: "0", end: "5", layers: "0"},
+		{file: partB, begin: "3", end: "-1", layers: "0,2"},
+	} {
+		cfg := InitializeConfig()
+		cfg.Root.SetArgs([]string{"sr", "run", "--config=../cmd/inmap/configExample.toml",
+			"--begin=" + part.begin, "--end=" + part.end, "--layers=" + part.layers,
+			"--NumIterations=2", "--VariableGridData=" + varGridFile,
+			"--SR.OutputFile=" + part.file})
+		if err := cfg.Root.Execute(); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(part.file)
+	}
+
+	cfg := InitializeConfig()
+	cfg.Root.SetArgs([]string{"sr", "merge", "--config=../cmd/inmap/configExample.toml",
+		"--SR.OutputFile=" + mergedFile, "--merge_files=" + partA + "," + partB})
+	if err := cfg.Root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(mergedFile)
+
+	open := func(file string) *sr.Reader {
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := sr.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	a, b, merged := open(partA), open(partB), open(mergedFile)
+	for _, check := range []struct {
+		r                 *sr.Reader
+		layer, begin, end int
+		mergedLayer       int
+	}{
+		{r: a, layer: 0, begin: 0, end: 3, mergedLayer: 0},
+		// partB takes precedence for sources in both files.
+		{r: b, layer: 0, begin: 3, end: 10, mergedLayer: 0},
+		{r: b, layer: 1, begin: 0, end: 10, mergedLayer: 1},
+	} {
+		for i := check.begin; i < check.end; i++ {
+			want, err := check.r.Source("PrimaryPM25", check.layer, i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			have, err := merged.Source("PrimaryPM25", check.mergedLayer, i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if floats.Sum(want) == 0 {
+				t.Errorf("layer %d source %d: no concentrations", check.layer, i)
+			}
+			if !reflect.DeepEqual(have, want) {
+				t.Errorf("layer %d source %d: %v != %v", check.layer, i, have, want)
+			}
+		}
+	}
+}
+
+func TestSR_subset(t *testing.T) {
+	const (
+		regionFile = "../cmd/inmap/testdata/testSubsetSR.shp"
+		subsetFile = "../cmd/inmap/testdata/tempSR_subset.ncf"
+		srFile     = "../cmd/inmap/testdata/testSR_golden.ncf"
+	)
+	writeRegionShapefile(t, regionFile, geom.Polygon{{
+		{X: -4000, Y: -4000}, {X: -2500, Y: -4000}, {X: -2500, Y: -3000}, {X: -4000, Y: -3000},
+	}})
+	defer inmap.DeleteShapefile(regionFile)
+
+	cfg := InitializeConfig()
+	cfg.Root.SetArgs([]string{"sr", "subset", "--config=../cmd/inmap/configExample.toml",
+		"--SR.OutputFile=" + srFile, "--subset_file=" + subsetFile,
+		"--subset_shapefile=" + regionFile})
+	if err := cfg.Root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(subsetFile)
+
+	f, err := os.Open(subsetFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := sr.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := r.Geometry()
+	if len(g) == 0 || len(g) >= 10 {
+		t.Fatalf("subset should include some but not all of the 10 grid cells; it includes %d", len(g))
+	}
+	for i, gg := range g {
+		if c := gg.Centroid(); c.X > -2500 || c.Y > -3000 {
+			t.Errorf("cell %d center %v is outside of the subset region", i, c)
+		}
+	}
+}
+
+func TestSRPredict_inventory(t *testing.T) {
+	const srFile = "../cmd/inmap/testdata/testSR_golden.ncf"
+	f, err := os.Open(srFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := sr.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Locate the point source at the center of one of the SR grid cells.
+	gridSR, err := proj.Parse("+proj=lcc +lat_1=33.000000 +lat_2=45.000000 +lat_0=40.000000 +lon_0=-97.000000 +x_0=0 +y_0=0 +a=6370997.000000 +b=6370997.000000 +to_meter=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lonLatSR, err := proj.Parse("+proj=longlat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct, err := gridSR.NewTransform(lonLatSR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	center := r.Geometry()[4].Centroid()
+	lonLat, err := center.Transform(ct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lon, lat := lonLat.(geom.Point).X, lonLat.(geom.Point).Y
+
+	const (
+		tonsPerYear       = 1000.0
+		kgPerYearToUgPerS = 1.0e9 / (60 * 60 * 24 * 365)
+	)
+	tests := []struct {
+		name                         string
+		height, diam, temp, velocity float64 // ft, ft, °F, ft/s
+	}{
+		{name: "ground"},
+		{name: "elevated", height: 200, diam: 2, temp: 100, velocity: 5},
+	}
+	results := make(map[string][]float64)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "inmap_srpredict_inventory")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			neiFile := filepath.Join(dir, "point.csv")
+			emisData := fmt.Sprintf(`#FORMAT=FF10_POINT
+#COUNTRY=US
+#YEAR=2011
+#VALUE_UNITS=TON
+country_cd,region_cd,tribal_code,facility_id,unit_id,rel_point_id,process_id,agy_facility_id,agy_unit_id,agy_rel_point_id,agy_process_id,scc,poll,ann_value,ann_pct_red,facility_name,erptype,stkhgt,stkdiam,stktemp,stkflow,stkvel,naics,longitude,latitude,ll_datum,horiz_coll_mthd,design_capacity,design_capacity_units,reg_codes,fac_source_type,unit_type_code,control_ids,control_measures,current_cost,cumulative_cost,projection_factor,submitter_id,calc_method,data_set_id,facil_category_code,oris_facility_code,oris_boiler_id,ipm_yn,calc_year,date_updated,fug_height,fug_width_ydim,fug_length_xdim,fug_angle,zipcode,annual_avg_hours_per_year,jan_value,feb_value,mar_value,apr_value,may_value,jun_value,jul_value,aug_value,sep_value,oct_value,nov_value,dec_value,jan_pctred,feb_pctred,mar_pctred,apr_pctred,may_pctred,jun_pctred,jul_pctred,aug_pctred,sep_pctred,oct_pctred,nov_pctred,dec_pctred,comment
+"US","20001",,"1","1","1","1",,,,,"10100101","PM2_5",%g,,"Test Facility","2",%g,%g,%g,,%g,"221112",%.10f,%.10f,,,,,,,,,,,,,,,,,,,,"2011",20130317,,,,,,,,,,,,,,,,,,,,,,,,,,,,,,,
+`, tonsPerYear, test.height, test.diam, test.temp, test.velocity, lon, lat)
+			if err = ioutil.WriteFile(neiFile, []byte(emisData), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg := InitializeConfig()
+			cfg.Set("config", "../cmd/inmap/configExample.toml")
+			cfg.Set("SR.OutputFile", srFile)
+			cfg.Set("OutputFile", filepath.Join(dir, "output.shp"))
+			cfg.Set("OutputVariables", `{"TotalPM25": "PrimaryPM25 + pNH4 + pSO4 + pNO3 + SOA"}`)
+			cfg.Set("EmissionsShapefiles", []string{})
+			cfg.Set("aep.InventoryConfig.NEIFiles", fmt.Sprintf(`{"point":[%q]}`, neiFile))
+			cfg.Set("aep.InventoryConfig.InputUnits", "tons")
+			cfg.Set("aep.GridRef", []string{"../emissions/aep/aeputil/testdata/gridref_osm.txt"})
+			cfg.Set("aep.SrgSpecOSM", "../emissions/aep/testdata/srgspec_osm.json")
+			cfg.Root.SetArgs([]string{"srpredict"})
+			if err = cfg.Root.Execute(); err != nil {
+				t.Fatal(err)
+			}
+
+			d, err := shp.NewDecoder(filepath.Join(dir, "output.shp"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close()
+			var have []float64
+			for {
+				var rec struct {
+					geom.Polygon
+					TotalPM25 float64
+				}
+				if more := d.DecodeRow(&rec); !more {
+					break
+				}
+				have = append(have, rec.TotalPM25)
+			}
+			if err = d.Error(); err != nil {
+				t.Fatal(err)
+			}
+
+			conc, err := r.Concentrations(&inmap.EmisRecord{
+				Geom:     center,
+				PM25:     badunit.Ton(tonsPerYear).Value() * kgPerYearToUgPerS,
+				Height:   badunit.Foot(test.height).Value(),
+				Diam:     badunit.Foot(test.diam).Value(),
+				Temp:     badunit.Fahrenheit(test.temp).Value(),
+				Velocity: badunit.FootPerSecond(test.velocity).Value(),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := conc.TotalPM25()
+			if !floats.EqualApprox(have, want, 1.e-8) {
+				t.Errorf("have %v, want %v", have, want)
+			}
+			results[test.name] = have
+		})
+	}
+	if floats.EqualApprox(results["ground"], results["elevated"], 1.e-8) {
+		t.Error("elevated and ground-level emissions should have different impacts")
+	}
+}
+
+func TestSRPredict_health(t *testing.T) {
+	for _, loadPopMort := range []bool{false, true} {
+		t.Run(fmt.Sprintf("load_popmort=%v", loadPopMort), func(t *testing.T) {
+			const outputFile = "../cmd/inmap/testdata/output_SRPredict_health.shp"
+			cfg := InitializeConfig()
+			cfg.Set("config", "../cmd/inmap/configExample.toml")
+			cfg.Set("SR.OutputFile", "../cmd/inmap/testdata/testSR_golden.ncf")
+			cfg.Set("OutputFile", outputFile)
+			cfg.Set("OutputVariables", `{"TotalPop": "TotalPop",
+"Deaths": "Deaths:NasariACS:TotalPop:allcause",
+"DeathsK": "Deaths:Krewski2009:TotalPop:allcause * 1000"}`)
+			cfg.Set("EmissionsShapefiles", []string{"../cmd/inmap/testdata/testEmisSR.shp"})
+			defer os.Remove("../cmd/inmap/testdata/output_SRPredict_health.log")
+			cfg.Root.SetArgs([]string{"srpredict", fmt.Sprintf("--load_popmort=%v", loadPopMort)})
+			if err := cfg.Root.Execute(); err != nil {
+				t.Fatal(err)
+			}
+			defer inmap.DeleteShapefile(outputFile)
+
+			d, err := shp.NewDecoder(outputFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close()
+			var pop, deaths, deathsK float64
+			for {
+				var rec struct {
+					geom.Polygon
+					TotalPop, Deaths, DeathsK float64
+				}
+				if more := d.DecodeRow(&rec); !more {
+					break
+				}
+				pop += rec.TotalPop
+				deaths += rec.Deaths
+				deathsK += rec.DeathsK
+			}
+			if err = d.Error(); err != nil {
+				t.Fatal(err)
+			}
+			if pop <= 0 || deaths <= 0 || deathsK <= 0 {
+				t.Errorf("population (%g) and deaths (%g, %g) should be positive", pop, deaths, deathsK)
+			}
+		})
+	}
+
+	// The population data should be loaded from VarGrid.CensusFile.
+	cfg := InitializeConfig()
+	cfg.Set("config", "../cmd/inmap/configExample.toml")
+	cfg.Set("SR.OutputFile", "../cmd/inmap/testdata/testSR_golden.ncf")
+	cfg.Set("OutputFile", "../cmd/inmap/testdata/output_SRPredict_health.shp")
+	cfg.Set("VarGrid.CensusFile", "../cmd/inmap/testdata/nonexistent.shp")
+	cfg.Root.SetArgs([]string{"srpredict", "--load_popmort=true"})
+	if err := cfg.Root.Execute(); err == nil {
+		t.Error("a missing census file should cause an error when loading population")
+	}
+}
+
+func TestSR_batch(t *testing.T) {
+	const (
+		manifestFile = "../cmd/inmap/testdata/testScenarios.csv"
+		tableFile    = "../cmd/inmap/testdata/output_scenarios.csv"
+		predictFile  = "../cmd/inmap/testdata/output_SRBatch.shp"
+	)
+	// Scenario "half" includes the same file twice.
+	manifest := `Scenario,File,Scale
+base,testEmisSR.shp,1
+double,testEmisSR.shp,2
+half,testEmisSR.shp,0.25
+half,testEmisSR.shp,0.25
+`
+	if err := ioutil.WriteFile(manifestFile, []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(manifestFile)
+	gridDir, err := ioutil.TempDir("", "inmap_scenarios")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gridDir)
+
+	cfg := InitializeConfig()
+	cfg.Set("config", "../cmd/inmap/configExample.toml")
+	cfg.Set("OutputVariables", `{"TotalPM25": "PrimaryPM25 + pNH4 + pSO4 + pNO3 + SOA", "TotalPop": "TotalPop"}`)
+	cfg.Root.SetArgs([]string{"sr", "batch",
+		"--SR.OutputFile=../cmd/inmap/testdata/testSR_golden.ncf",
+		"--scenario_manifest=" + manifestFile, "--scenario_table=" + tableFile,
+		"--scenario_grid_dir=" + gridDir, "--scenario_workers=2"})
+	if err = cfg.Root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tableFile)
+
+	f, err := os.Open(tableFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	recs, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Scenario", "Variable", "Metric", "Value"}; !reflect.DeepEqual(recs[0], want) {
+		t.Errorf("header: %v != %v", recs[0], want)
+	}
+	// There should be 4 metrics for each of 2 variables in 3 scenarios.
+	if len(recs) != 25 {
+		t.Fatalf("table should have 25 lines but has %d", len(recs))
+	}
+	metrics := make(map[string]float64)
+	for _, rec := range recs[1:] {
+		v, err := strconv.ParseFloat(rec[3], 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		metrics[strings.Join(rec[:3], ",")] = v
+	}
+	if recs[1][0] != "base" || recs[24][0] != "half" {
+		t.Errorf("scenarios should be in manifest order")
+	}
+
+	// The base scenario should match the results of srpredict.
+	vgc, err := VarGridConfig(cfg.Viper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = SRPredict(&SRPredictOptions{
+		EmissionUnits:       "tons/year",
+		SROutputFile:        "../cmd/inmap/testdata/testSR_golden.ncf",
+		OutputFile:          predictFile,
+		OutputVariables:     map[string]string{"TotalPM25": "PrimaryPM25 + pNH4 + pSO4 + pNO3 + SOA", "TotalPop": "TotalPop"},
+		EmissionsShapefiles: []string{"../cmd/inmap/testdata/testEmisSR.shp"},
+		VarGrid:             vgc,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer inmap.DeleteShapefile(predictFile)
+	readTotalPM25 := func(file string) []float64 {
+		d, err := shp.NewDecoder(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer d.Close()
+		var o []float64
+		for {
+			var rec struct {
+				geom.Polygon
+				TotalPM25 float64
+			}
+			if more := d.DecodeRow(&rec); !more {
+				break
+			}
+			o = append(o, rec.TotalPM25)
+		}
+		if err = d.Error(); err != nil {
+			t.Fatal(err)
+		}
+		return o
+	}
+	want := readTotalPM25(predictFile)
+	if have := readTotalPM25(filepath.Join(gridDir, "base.shp")); !floats.EqualApprox(have, want, 1.e-10) {
+		t.Errorf("base grid: have %v, want %v", have, want)
+	}
+	if have, want := metrics["base,TotalPM25,Total"], floats.Sum(want); !floats.EqualWithinRel(have, want, 1.e-10) {
+		t.Errorf("base total: %g != %g", have, want)
+	}
+	if have, want := metrics["base,TotalPM25,Max"], floats.Max(want); !floats.EqualWithinRel(have, want, 1.e-10) {
+		t.Errorf("base max: %g != %g", have, want)
+	}
+
+	// Concentrations should scale with emissions, but population shouldn't.
+	for _, metric := range []string{"Total", "Min", "Max", "PopWeightedMean"} {
+		base := metrics["base,TotalPM25,"+metric]
+		if have := metrics["double,TotalPM25,"+metric]; !floats.EqualWithinRel(have, 2*base, 1.e-10) {
+			t.Errorf("double %s: %g != %g", metric, have, 2*base)
+		}
+		if have := metrics["half,TotalPM25,"+metric]; !floats.EqualWithinRel(have, base/2, 1.e-10) {
+			t.Errorf("half %s: %g != %g", metric, have, base/2)
+		}
+		if metrics["base,TotalPop,"+metric] != metrics["double,TotalPop,"+metric] {
+			t.Errorf("TotalPop %s should not change", metric)
+		}
+	}
+	if metrics["base,TotalPop,Total"] <= 0 {
+		t.Errorf("total population should be positive")
+	}
+}
+
+func TestSR_evaluate(t *testing.T) {
+	const (
+		varGridFile = "../cmd/inmap/testdata/inmapVarGrid_evaluate.gob"
+		srFile      = "../cmd/inmap/testdata/tempSR_evaluate.ncf"
+		outputFile  = "../cmd/inmap/testdata/output_SREvaluate.shp"
+	)
+	saveSRGrid(t, varGridFile)
+	defer os.Remove(varGridFile)
+	cfg := InitializeConfig()
+	cfg.Root.SetArgs([]string{"sr", "run", "--config=../cmd/inmap/configExample.toml",
+		"--layers=0,2", "--NumIterations=10", "--VariableGridData=" + varGridFile,
+		"--SR.OutputFile=" + srFile})
+	if err := cfg.Root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(srFile)
+
+	cfg = InitializeConfig()
+	cfg.Root.SetArgs([]string{"sr", "evaluate", "--config=../cmd/inmap/configExample.toml",
+		"--NumIterations=10", "--VariableGridData=" + varGridFile,
+		"--SR.OutputFile=" + srFile,
+		"--EmissionsShapefiles=../cmd/inmap/testdata/testEmisSR.shp",
+		"--OutputFile=" + outputFile})
+	if err := cfg.Root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	defer inmap.DeleteShapefile(outputFile)
+	defer os.Remove(evaluationFile(outputFile))
+
+	f, err := os.Open(evaluationFile(outputFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	recs, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Species", "MB", "ME", "MFB", "MFE", "Slope", "Intercept", "R2"}; !reflect.DeepEqual(recs[0], want) {
+		t.Errorf("header: %v != %v", recs[0], want)
+	}
+	if len(recs) != len(srEvaluationSpecies)+1 {
+		t.Fatalf("evaluation file should have %d lines but has %d", len(srEvaluationSpecies)+1, len(recs))
+	}
+	// The SR matrix was created with the same grid and number of
+	// iterations, so its predictions should match the InMAP results.
+	for i, rec := range recs[1:] {
+		if rec[0] != srEvaluationSpecies[i].name {
+			t.Errorf("species %d: %s != %s", i, rec[0], srEvaluationSpecies[i].name)
+		}
+		stats := make(map[string]float64)
+		for j, v := range rec[1:] {
+			if stats[recs[0][j+1]], err = strconv.ParseFloat(v, 64); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, stat := range []string{"MFB", "MFE"} {
+			if v := stats[stat]; v < -1.e-6 || v > 1.e-6 {
+				t.Errorf("%s %s: %g should be close to zero", rec[0], stat, v)
+			}
+		}
+		for _, stat := range []string{"Slope", "R2"} {
+			if v := stats[stat]; !floats.EqualWithinAbs(v, 1, 1.e-6) {
+				t.Errorf("%s %s: %g should be close to one", rec[0], stat, v)
+			}
+		}
+	}
+
+	d, err := shp.NewDecoder(outputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	var n int
+	for {
+		var rec struct {
+			geom.Polygon
+			TotalPM25 float64
+		}
+		if more := d.DecodeRow(&rec); !more {
+			break
+		}
+		n++
+	}
+	if err = d.Error(); err != nil {
+		t.Fatal(err)
+	}
+	if n != 10 {
+		t.Errorf("difference map should have 10 grid cells but has %d", n)
+	}
+}
+
+func TestReadSRScenarios(t *testing.T) {
+	for name, manifest := range map[string]string{
+		"header":   "Name,File,Scale\nbase,a.shp,1\n",
+		"empty":    "Scenario,File,Scale\n",
+		"scale":    "Scenario,File,Scale\nbase,a.shp,x\n",
+		"file":     "Scenario,File,Scale\nbase,,1\n",
+		"name":     "Scenario,File,Scale\na/b,a.shp,1\n",
+		"parent":   "Scenario,File,Scale\n..,a.shp,1\n",
+		"nColumns": "Scenario,File,Scale\nbase,a.shp\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			f, err := ioutil.TempFile("", "manifest")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(f.Name())
+			if _, err = f.WriteString(manifest); err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+			if _, err = readSRScenarios(f.Name()); err == nil {
+				t.Error("invalid manifest should cause an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,364 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmaputil
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ctessum/geom"
+	"github.com/ctessum/geom/proj"
+	"github.com/ctessum/requestcache"
+	"github.com/evookelj/inmap"
+	"github.com/evookelj/inmap/sr"
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/stat"
+)
+
+// srScenario is an emissions scenario in a batch SR matrix
+// prediction manifest.
+type srScenario struct {
+	name string
+	// files are the emissions shapefiles in the scenario, and
+	// scales are the factors the emissions in each file are multiplied by.
+	files  []string
+	scales []float64
+}
+
+// readSRScenarios reads the scenarios in the CSV manifest file filename.
+// The manifest must have the columns "Scenario", "File", and "Scale",
+// with one row for each emissions shapefile in each scenario. Relative
+// file paths are relative to the directory containing the manifest, and
+// paths can include environment variables. Scenarios are returned in
+// the order they first appear in the manifest.
+func readSRScenarios(filename string) ([]*srScenario, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("inmap: opening scenario manifest: %v", err)
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = 3
+	r.TrimLeadingSpace = true
+	recs, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("inmap: reading scenario manifest: %v", err)
+	}
+	if len(recs) == 0 {
+		return nil, fmt.Errorf("inmap: scenario manifest %s is empty", filename)
+	}
+	for i, col := range []string{"scenario", "file", "scale"} {
+		if strings.ToLower(strings.TrimSpace(recs[0][i])) != col {
+			return nil, fmt.Errorf("inmap: scenario manifest %s must have columns Scenario, File, and Scale but has %v", filename, recs[0])
+		}
+	}
+	dir := filepath.Dir(filename)
+	var scenarios []*srScenario
+	byName := make(map[string]*srScenario)
+	for i, rec := range recs[1:] {
+		line := i + 2
+		name := strings.TrimSpace(rec[0])
+		// Scenario names are used as file names in the scenario grid directory.
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+			return nil, fmt.Errorf("inmap: scenario manifest line %d: invalid scenario name '%s'", line, name)
+		}
+		file := os.ExpandEnv(strings.TrimSpace(rec[1]))
+		if file == "" {
+			return nil, fmt.Errorf("inmap: scenario manifest line %d: missing file", line)
+		}
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		scale, err := strconv.ParseFloat(strings.TrimSpace(rec[2]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("inmap: scenario manifest line %d: invalid scale: %v", line, err)
+		}
+		s, ok := byName[name]
+		if !ok {
+			s = &srScenario{name: name}
+			byName[name] = s
+			scenarios = append(scenarios, s)
+		}
+		s.files = append(s.files, file)
+		s.scales = append(s.scales, scale)
+	}
+	if len(scenarios) == 0 {
+		return nil, fmt.Errorf("inmap: scenario manifest %s does not contain any scenarios", filename)
+	}
+	return scenarios, nil
+}
+
+// srMetric is a summary metric for an output variable in a scenario.
+type srMetric struct {
+	scenario, variable, metric string
+	value                      float64
+}
+
+// srBatch holds the information shared among the scenarios in
+// a batch of SR matrix predictions.
+type srBatch struct {
+	vgsr            *proj.SR
+	outputVariables map[string]string
+	pop             []float64
+	gridDir         string
+
+	// concCache holds the concentrations caused by each emissions file.
+	concCache *requestcache.Cache
+}
+
+// SRBatch uses the SR matrix specified in SROutputFile to predict
+// concentrations for each of the emissions scenarios in the CSV file
+// ScenarioManifest, which has one row for each emissions shapefile in
+// each scenario, with columns "Scenario" (the scenario name), "File"
+// (the path to the shapefile), and "Scale" (a factor to multiply the
+// emissions in the file by). For each scenario and each output variable in
+// outputVariables, the total, minimum, maximum, and population-weighted
+// mean (using population VarGrid.PopGridColumn) across the ground-level
+// grid cells are written in long format to the CSV file ScenarioTable.
+// If ScenarioGridDir is not empty, the gridded results for each scenario
+// are also written to a shapefile named after the scenario in that
+// directory.
+//
+// Because concentrations are linear in emissions, the concentrations
+// caused by each emissions file are only calculated once, no matter how
+// many scenarios it is included in, and up to workers scenarios are
+// evaluated concurrently. Each worker calculates the output variables
+// with its own copy of the SR matrix grid data, so memory use increases
+// with the number of workers. If workers is 0, the number of processors is used.
+// EmissionUnits, emissionMask, VarGrid, Year, and LoadPopMort have the
+// same meaning as for SRPredict.
+func SRBatch(EmissionUnits, SROutputFile, ScenarioManifest, ScenarioTable, ScenarioGridDir string, outputVariables map[string]string, emissionMask geom.Polygon,
+	VarGrid *inmap.VarGridConfig, Year int, LoadPopMort bool, workers int) error {
+	scenarios, err := readSRScenarios(ScenarioManifest)
+	if err != nil {
+		return err
+	}
+	vgsr, err := spatialRef(VarGrid)
+	if err != nil {
+		return err
+	}
+	f, err := openSRFile(context.TODO(), SROutputFile)
+	if err != nil {
+		return err
+	}
+	if c, ok := f.(io.Closer); ok {
+		defer c.Close()
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(-1)
+	}
+	// r is only used to calculate the concentrations caused by each
+	// emissions file. Setting the concentrations of a scenario modifies the
+	// grid data of a reader, so each worker has its own reader for
+	// calculating the output variables.
+	r, err := sr.NewReader(f)
+	if err != nil {
+		return err
+	}
+	readers := make([]*sr.Reader, workers)
+	for w := range readers {
+		if readers[w], err = sr.NewReader(f); err != nil {
+			return err
+		}
+	}
+	if err = setSRPopMort(VarGrid, Year, LoadPopMort, readers...); err != nil {
+		return err
+	}
+	pop, err := readers[0].Variables(VarGrid.PopGridColumn)
+	if err != nil {
+		return fmt.Errorf("inmap: reading population for scenario summaries: %v", err)
+	}
+	if ScenarioGridDir != "" {
+		if err = os.MkdirAll(ScenarioGridDir, os.ModePerm); err != nil {
+			return fmt.Errorf("inmap: creating scenario grid output directory: %v", err)
+		}
+	}
+
+	files := make(map[string]struct{})
+	for _, s := range scenarios {
+		for _, file := range s.files {
+			files[file] = struct{}{}
+		}
+	}
+	b := &srBatch{
+		vgsr:            vgsr,
+		outputVariables: outputVariables,
+		pop:             pop[VarGrid.PopGridColumn],
+		gridDir:         ScenarioGridDir,
+	}
+	b.concCache = requestcache.NewCache(func(ctx context.Context, request interface{}) (interface{}, error) {
+		file := request.(string)
+		emis, err := inmap.ReadEmissionShapefiles(vgsr, EmissionUnits, nil, emissionMask, file)
+		if err != nil {
+			return nil, err
+		}
+		conc, err := r.Concentrations(emis.EmisRecords()...)
+		if err != nil {
+			if _, ok := err.(sr.AboveTopErr); ok {
+				log.Printf("%s: %v; calculating concentrations for emissions in SR matrix top layer.", file, err)
+			} else {
+				return nil, err
+			}
+		}
+		return conc, nil
+	}, workers, requestcache.Deduplicate(), requestcache.Memory(len(files)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	results := make([][]srMetric, len(scenarios))
+	errs := make([]error, len(scenarios))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func(r *sr.Reader) {
+			defer wg.Done()
+			for i := range jobs {
+				results[i], errs[i] = b.evaluate(ctx, r, scenarios[i])
+				if errs[i] != nil {
+					cancel()
+				}
+			}
+		}(readers[w])
+	}
+feed:
+	for i, s := range scenarios {
+		select {
+		case jobs <- i:
+			log.Printf("evaluating scenario %d of %d: %s", i+1, len(scenarios), s.name)
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("inmap: evaluating scenario %s: %v", scenarios[i].name, err)
+		}
+	}
+
+	var upload uploader
+	o := upload.maybeUpload(ScenarioTable)
+	if upload.err != nil {
+		return upload.err
+	}
+	if err = writeSRMetrics(o, results); err != nil {
+		return err
+	}
+	return upload.uploadOutput(nil)
+}
+
+// evaluate calculates the summary metrics for scenario s using
+// reader r, which must not be used concurrently by other calls to
+// evaluate, and writes out its gridded results if requested.
+func (b *srBatch) evaluate(ctx context.Context, r *sr.Reader, s *srScenario) ([]srMetric, error) {
+	n := len(b.pop)
+	conc := &sr.Concentrations{
+		PNH4:        make([]float64, n),
+		PNO3:        make([]float64, n),
+		PSO4:        make([]float64, n),
+		SOA:         make([]float64, n),
+		PrimaryPM25: make([]float64, n),
+	}
+	for i, file := range s.files {
+		result, err := b.concCache.NewRequest(ctx, file, file).Result()
+		if err != nil {
+			return nil, err
+		}
+		c := result.(*sr.Concentrations)
+		floats.AddScaled(conc.PNH4, s.scales[i], c.PNH4)
+		floats.AddScaled(conc.PNO3, s.scales[i], c.PNO3)
+		floats.AddScaled(conc.PSO4, s.scales[i], c.PSO4)
+		floats.AddScaled(conc.SOA, s.scales[i], c.SOA)
+		floats.AddScaled(conc.PrimaryPM25, s.scales[i], c.PrimaryPM25)
+	}
+
+	if err := r.SetConcentrations(conc); err != nil {
+		return nil, err
+	}
+	results, err := r.Results(b.outputVariables, nil)
+	if err != nil {
+		return nil, err
+	}
+	if b.gridDir != "" {
+		// Output modifies the variable expressions, so we give it a copy.
+		vars := make(map[string]string, len(b.outputVariables))
+		for k, v := range b.outputVariables {
+			vars[k] = v
+		}
+		if err = r.Output(filepath.Join(b.gridDir, s.name+".shp"), vars, nil, b.vgsr); err != nil {
+			return nil, err
+		}
+	}
+
+	names := make([]string, 0, len(results))
+	for name := range results {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var metrics []srMetric
+	for _, name := range names {
+		v := results[name]
+		metrics = append(metrics,
+			srMetric{scenario: s.name, variable: name, metric: "Total", value: floats.Sum(v)},
+			srMetric{scenario: s.name, variable: name, metric: "Min", value: floats.Min(v)},
+			srMetric{scenario: s.name, variable: name, metric: "Max", value: floats.Max(v)},
+			srMetric{scenario: s.name, variable: name, metric: "PopWeightedMean", value: stat.Mean(v, b.pop)},
+		)
+	}
+	return metrics, nil
+}
+
+// writeSRMetrics writes the given scenario summary metrics to a CSV file.
+func writeSRMetrics(fileName string, metrics [][]srMetric) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("inmap: creating scenario table: %v", err)
+	}
+	cw := csv.NewWriter(f)
+	if err = cw.Write([]string{"Scenario", "Variable", "Metric", "Value"}); err != nil {
+		f.Close()
+		return fmt.Errorf("inmap: writing scenario table: %v", err)
+	}
+	for _, mm := range metrics {
+		for _, m := range mm {
+			if err = cw.Write([]string{m.scenario, m.variable, m.metric, strconv.FormatFloat(m.value, 'g', -1, 64)}); err != nil {
+				f.Close()
+				return fmt.Errorf("inmap: writing scenario table: %v", err)
+			}
+		}
+	}
+	cw.Flush()
+	if err = cw.Error(); err != nil {
+		f.Close()
+		return fmt.Errorf("inmap: writing scenario table: %v", err)
+	}
+	return f.Close()
+}
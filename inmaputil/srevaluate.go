@@ -0,0 +1,199 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmaputil
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/ctessum/geom"
+	"github.com/evookelj/inmap"
+	"github.com/evookelj/inmap/eval/evalstats"
+	"github.com/evookelj/inmap/sr"
+	"gonum.org/v1/gonum/floats"
+)
+
+// srEvaluationSpecies are the species that SR matrix predictions are
+// evaluated for, along with functions to extract them from a
+// set of concentrations.
+var srEvaluationSpecies = []struct {
+	name string
+	get  func(*sr.Concentrations) []float64
+}{
+	{"PrimaryPM25", func(c *sr.Concentrations) []float64 { return c.PrimaryPM25 }},
+	{"pNH4", func(c *sr.Concentrations) []float64 { return c.PNH4 }},
+	{"pSO4", func(c *sr.Concentrations) []float64 { return c.PSO4 }},
+	{"pNO3", func(c *sr.Concentrations) []float64 { return c.PNO3 }},
+	{"SOA", func(c *sr.Concentrations) []float64 { return c.SOA }},
+	{"TotalPM25", func(c *sr.Concentrations) []float64 { return c.TotalPM25() }},
+}
+
+// SREvaluate evaluates the accuracy of the SR matrix at SROutputFile by
+// predicting the concentrations caused by the emissions in
+// EmissionsShapefiles both with the SR matrix and by running a full
+// InMAP simulation on the variable resolution grid at VariableGridData,
+// which should be the grid the SR matrix was created with.
+// Statistics comparing the SR matrix predictions to the InMAP results
+// for each species are written to a CSV file with the suffix
+// "_evaluation.csv" next to OutputFile, and maps of the differences between
+// the SR matrix predictions and the InMAP results are written to OutputFile.
+// Grid cells where both concentrations are zero are not included in the
+// mean fractional bias and error, which are undefined for them.
+//
+// numIterations specifies the number of iterations to run the simulation
+// for; if it is zero, the simulation is run until it converges.
+func SREvaluate(ctx context.Context, EmissionUnits, SROutputFile, VariableGridData, OutputFile string, EmissionsShapefiles []string, emissionMask geom.Polygon,
+	VarGrid *inmap.VarGridConfig, numIterations int) error {
+	msgLog := make(chan string)
+	go func() {
+		for {
+			log.Println(<-msgLog)
+		}
+	}()
+
+	vgsr, err := spatialRef(VarGrid)
+	if err != nil {
+		return err
+	}
+	emis, err := inmap.ReadEmissionShapefiles(vgsr, EmissionUnits, msgLog, emissionMask, EmissionsShapefiles...)
+	if err != nil {
+		return err
+	}
+	recs := emis.EmisRecords()
+
+	f, err := openSRFile(ctx, SROutputFile)
+	if err != nil {
+		return err
+	}
+	if c, ok := f.(io.Closer); ok {
+		defer c.Close()
+	}
+	r, err := sr.NewReader(f)
+	if err != nil {
+		return err
+	}
+	srConc, err := r.Concentrations(recs...)
+	if err != nil {
+		if _, ok := err.(sr.AboveTopErr); ok {
+			log.Printf("%v; calculating concentrations for emissions in SR matrix top layer.", err)
+		} else {
+			return err
+		}
+	}
+
+	varGridReader, err := os.Open(VariableGridData)
+	if err != nil {
+		return fmt.Errorf("inmap: evaluating SR matrix---can't open variable grid data file: %v", err)
+	}
+	s, err := sr.NewSR(varGridReader, VarGrid, nil)
+	varGridReader.Close()
+	if err != nil {
+		return err
+	}
+	log.Println("running InMAP simulation for SR matrix evaluation")
+	inmapConc, err := s.Concentrations(ctx, numIterations, recs...)
+	if err != nil {
+		return err
+	}
+	if len(inmapConc.PrimaryPM25) != len(srConc.PrimaryPM25) {
+		return fmt.Errorf("inmap: evaluating SR matrix: the variable resolution grid has %d ground-level cells but the SR matrix has %d; "+
+			"the SR matrix must have been created using the same grid", len(inmapConc.PrimaryPM25), len(srConc.PrimaryPM25))
+	}
+
+	var upload uploader
+	o := upload.maybeUpload(OutputFile)
+	ef := upload.maybeUpload(evaluationFile(OutputFile))
+	if upload.err != nil {
+		return upload.err
+	}
+
+	stats := make([]*evalstats.Stats, len(srEvaluationSpecies))
+	for i, sp := range srEvaluationSpecies {
+		o, m := sp.get(inmapConc), sp.get(srConc)
+		stats[i] = evalstats.Calculate(o, m)
+		// Concentrations of some species can be zero in both
+		// simulations, for example SOA when there are no VOC emissions.
+		stats[i].MFB, stats[i].MFE = evalstats.MFBNonZero(o, m), evalstats.MFENonZero(o, m)
+	}
+	if err = writeSREvaluation(ef, stats); err != nil {
+		return err
+	}
+
+	diff := &sr.Concentrations{
+		PNH4:        make([]float64, len(srConc.PNH4)),
+		PNO3:        make([]float64, len(srConc.PNO3)),
+		PSO4:        make([]float64, len(srConc.PSO4)),
+		SOA:         make([]float64, len(srConc.SOA)),
+		PrimaryPM25: make([]float64, len(srConc.PrimaryPM25)),
+	}
+	floats.SubTo(diff.PNH4, srConc.PNH4, inmapConc.PNH4)
+	floats.SubTo(diff.PNO3, srConc.PNO3, inmapConc.PNO3)
+	floats.SubTo(diff.PSO4, srConc.PSO4, inmapConc.PSO4)
+	floats.SubTo(diff.SOA, srConc.SOA, inmapConc.SOA)
+	floats.SubTo(diff.PrimaryPM25, srConc.PrimaryPM25, inmapConc.PrimaryPM25)
+	if err = r.SetConcentrations(diff); err != nil {
+		return err
+	}
+	outputVariables := map[string]string{
+		"PrimPM25":  "PrimaryPM25",
+		"pNH4":      "pNH4",
+		"pSO4":      "pSO4",
+		"pNO3":      "pNO3",
+		"SOA":       "SOA",
+		"TotalPM25": "PrimaryPM25 + pNH4 + pSO4 + pNO3 + SOA",
+	}
+	if err = r.Output(o, outputVariables, nil, vgsr); err != nil {
+		return err
+	}
+	return upload.uploadOutput(nil)
+}
+
+// writeSREvaluation writes SR matrix evaluation statistics, in the
+// same order as srEvaluationSpecies, to a CSV file.
+func writeSREvaluation(fileName string, stats []*evalstats.Stats) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("inmap: creating SR evaluation file: %v", err)
+	}
+	cw := csv.NewWriter(f)
+	if err = cw.Write([]string{"Species", "MB", "ME", "MFB", "MFE", "Slope", "Intercept", "R2"}); err != nil {
+		f.Close()
+		return fmt.Errorf("inmap: writing SR evaluation file: %v", err)
+	}
+	format := func(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+	for i, s := range stats {
+		row := []string{srEvaluationSpecies[i].name, format(s.MB), format(s.ME), format(s.MFB), format(s.MFE),
+			format(s.Slope), format(s.Intercept), format(s.R2)}
+		if err = cw.Write(row); err != nil {
+			f.Close()
+			return fmt.Errorf("inmap: writing SR evaluation file: %v", err)
+		}
+	}
+	cw.Flush()
+	if err = cw.Error(); err != nil {
+		f.Close()
+		return fmt.Errorf("inmap: writing SR evaluation file: %v", err)
+	}
+	return f.Close()
+}
@@ -122,6 +122,14 @@ sidebar_label: Model Variables
 This is a list of InMAP output options that can be used in the 'OutputVariables'
 configuration variable.
 
+In addition to the variables below, the number of deaths per grid cell caused by
+the modeled change in total PM2.5 concentration can be calculated using variables
+in the form 'Deaths:<HRName>:<PopGroup>:<MortColumn>', where 'HRName' is one of
+the hazard ratio functions in the epi package (NasariACS, Krewski2009,
+Krewski2009Ecologic, or Lepeule2012), 'PopGroup' is one of the population
+variables below, and 'MortColumn' is one of the mortality rate variables below.
+For example, 'Deaths:NasariACS:TotalPop:AllCause'.
+
 This file is automatically generated; do not edit.
 
 `))
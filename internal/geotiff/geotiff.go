@@ -0,0 +1,553 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package geotiff reads the subset of the GeoTIFF raster format that is
+// typically used for gridded population, mortality rate, and land cover data.
+package geotiff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/ctessum/geom"
+	"golang.org/x/image/tiff/lzw"
+)
+
+// TIFF tags used for reading GeoTIFF files.
+const (
+	tImageWidth      = 256
+	tImageLength     = 257
+	tBitsPerSample   = 258
+	tCompression     = 259
+	tStripOffsets    = 273
+	tSamplesPerPixel = 277
+	tRowsPerStrip    = 278
+	tStripByteCounts = 279
+	tPlanarConfig    = 284
+	tPredictor       = 317
+	tTileWidth       = 322
+	tTileLength      = 323
+	tTileOffsets     = 324
+	tTileByteCounts  = 325
+	tSampleFormat    = 339
+	tModelPixelScale = 33550
+	tModelTiepoint   = 33922
+	tGeoKeyDirectory = 34735
+	tGDALMetadata    = 42112
+	tGDALNoData      = 42113
+)
+
+// GeoTIFF geographic keys.
+const (
+	gkModelType  = 1024
+	gkRasterType = 1025
+
+	modelTypeProjected  = 1
+	modelTypeGeographic = 2
+	rasterPixelIsPoint  = 2
+)
+
+// GeoTIFF holds the header information of a single-image
+// GeoTIFF file with geographic (longitude-latitude) or projected coordinates.
+// Only the features of the TIFF format that are typically used for
+// gridded population, mortality rate, and land cover data are supported.
+type GeoTIFF struct {
+	r     io.ReaderAt
+	order binary.ByteOrder
+
+	// Width and Height are the numbers of pixel columns and rows,
+	// and Bands is the number of bands.
+	Width, Height int
+	Bands         int
+
+	bitsPerSample int
+	sampleFormat  int
+	compression   int
+	predictor     int
+	planar        bool
+
+	// chunkWidth and chunkHeight are the dimensions of each strip or
+	// tile, and chunksAcross is the number of them in each row.
+	chunkWidth, chunkHeight, chunksAcross int
+	offsets, byteCounts                   []uint64
+
+	// X0 and Y0 are the coordinates of the upper-left corner of the
+	// image, and Dx and Dy are the pixel dimensions.
+	X0, Y0, Dx, Dy float64
+
+	noData float64
+
+	// Projected specifies that the image is in projected
+	// rather than geographic coordinates. The projection itself
+	// is not read from the file.
+	Projected bool
+
+	// Names are the band descriptions, if any.
+	Names []string
+}
+
+// Read reads the header of the GeoTIFF file in r.
+func Read(r io.ReaderAt) (*GeoTIFF, error) {
+	g := &GeoTIFF{r: r, noData: math.NaN()}
+	head := make([]byte, 8)
+	if _, err := r.ReadAt(head, 0); err != nil {
+		return nil, fmt.Errorf("geotiff: reading GeoTIFF header: %v", err)
+	}
+	switch string(head[0:2]) {
+	case "II":
+		g.order = binary.LittleEndian
+	case "MM":
+		g.order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("geotiff: invalid GeoTIFF file")
+	}
+	if v := g.order.Uint16(head[2:4]); v != 42 {
+		if v == 43 {
+			return nil, fmt.Errorf("geotiff: BigTIFF files are not supported")
+		}
+		return nil, fmt.Errorf("geotiff: invalid GeoTIFF file")
+	}
+	tags, err := g.readIFD(int64(g.order.Uint32(head[4:8])))
+	if err != nil {
+		return nil, err
+	}
+
+	getInt := func(tag, defaultVal int) int {
+		if v, ok := tags[tag]; ok && len(v.ints) > 0 {
+			return int(v.ints[0])
+		}
+		return defaultVal
+	}
+	g.Width = getInt(tImageWidth, 0)
+	g.Height = getInt(tImageLength, 0)
+	g.Bands = getInt(tSamplesPerPixel, 1)
+	g.bitsPerSample = getInt(tBitsPerSample, 1)
+	g.sampleFormat = getInt(tSampleFormat, 1)
+	g.compression = getInt(tCompression, 1)
+	g.predictor = getInt(tPredictor, 1)
+	g.planar = getInt(tPlanarConfig, 1) == 2
+	if g.Width == 0 || g.Height == 0 {
+		return nil, fmt.Errorf("geotiff: GeoTIFF file is missing image dimensions")
+	}
+	switch g.bitsPerSample {
+	case 8, 16, 32, 64:
+	default:
+		return nil, fmt.Errorf("geotiff: unsupported GeoTIFF bits per sample %d", g.bitsPerSample)
+	}
+	if g.sampleFormat == 3 && g.bitsPerSample != 32 && g.bitsPerSample != 64 {
+		return nil, fmt.Errorf("geotiff: unsupported GeoTIFF floating point bits per sample %d", g.bitsPerSample)
+	}
+	switch g.compression {
+	case 1, 5, 8, 32946:
+	default:
+		return nil, fmt.Errorf("geotiff: unsupported GeoTIFF compression type %d", g.compression)
+	}
+	if g.predictor < 1 || g.predictor > 3 {
+		return nil, fmt.Errorf("geotiff: unsupported GeoTIFF predictor %d", g.predictor)
+	}
+
+	if _, ok := tags[tTileWidth]; ok {
+		g.chunkWidth = getInt(tTileWidth, 0)
+		g.chunkHeight = getInt(tTileLength, 0)
+		g.offsets = tags[tTileOffsets].ints
+		g.byteCounts = tags[tTileByteCounts].ints
+	} else {
+		g.chunkWidth = g.Width
+		g.chunkHeight = getInt(tRowsPerStrip, g.Height)
+		if g.chunkHeight > g.Height {
+			g.chunkHeight = g.Height
+		}
+		g.offsets = tags[tStripOffsets].ints
+		g.byteCounts = tags[tStripByteCounts].ints
+	}
+	if g.chunkWidth <= 0 || g.chunkHeight <= 0 {
+		return nil, fmt.Errorf("geotiff: invalid GeoTIFF strip or tile dimensions")
+	}
+	g.chunksAcross = (g.Width + g.chunkWidth - 1) / g.chunkWidth
+	nChunks := g.chunksAcross * ((g.Height + g.chunkHeight - 1) / g.chunkHeight)
+	if g.planar {
+		nChunks *= g.Bands
+	}
+	if len(g.offsets) < nChunks || len(g.byteCounts) < nChunks {
+		return nil, fmt.Errorf("geotiff: GeoTIFF file is missing strip or tile offsets")
+	}
+
+	// Georeferencing.
+	scale, tie := tags[tModelPixelScale].floats, tags[tModelTiepoint].floats
+	if len(scale) < 2 || len(tie) < 6 {
+		return nil, fmt.Errorf("geotiff: GeoTIFF file is missing ModelPixelScale or ModelTiepoint information; " +
+			"other georeferencing methods are not supported")
+	}
+	g.Dx, g.Dy = scale[0], scale[1]
+	g.X0 = tie[3] - tie[0]*g.Dx
+	g.Y0 = tie[4] + tie[1]*g.Dy
+	if keys := tags[tGeoKeyDirectory].ints; len(keys) >= 4 {
+		for i := 4; i+3 < len(keys); i += 4 {
+			if keys[i+1] != 0 {
+				continue // Only keys with values stored in the directory are relevant here.
+			}
+			switch keys[i] {
+			case gkModelType:
+				switch keys[i+3] {
+				case modelTypeGeographic:
+				case modelTypeProjected:
+					g.Projected = true
+				default:
+					return nil, fmt.Errorf("geotiff: only GeoTIFF files with geographic (longitude-latitude) " +
+						"or projected coordinates are supported")
+				}
+			case gkRasterType:
+				if keys[i+3] == rasterPixelIsPoint {
+					g.X0 -= g.Dx / 2
+					g.Y0 += g.Dy / 2
+				}
+			}
+		}
+	}
+
+	if v, ok := tags[tGDALNoData]; ok {
+		s := strings.Trim(v.str, "\x00 ")
+		if g.noData, err = strconv.ParseFloat(s, 64); err != nil {
+			return nil, fmt.Errorf("geotiff: invalid GeoTIFF nodata value '%s'", s)
+		}
+	}
+	if v, ok := tags[tGDALMetadata]; ok {
+		g.Names = gdalBandNames(v.str, g.Bands)
+	}
+	return g, nil
+}
+
+// tiffTag holds the values of a TIFF tag.
+type tiffTag struct {
+	ints   []uint64
+	floats []float64
+	str    string
+}
+
+// readIFD reads the TIFF image file directory at offset.
+func (g *GeoTIFF) readIFD(offset int64) (map[int]tiffTag, error) {
+	b := make([]byte, 2)
+	if _, err := g.r.ReadAt(b, offset); err != nil {
+		return nil, fmt.Errorf("geotiff: reading GeoTIFF directory: %v", err)
+	}
+	n := int(g.order.Uint16(b))
+	entries := make([]byte, 12*n)
+	if _, err := g.r.ReadAt(entries, offset+2); err != nil {
+		return nil, fmt.Errorf("geotiff: reading GeoTIFF directory: %v", err)
+	}
+	// Sizes of each of the TIFF data types.
+	sizes := map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8, 16: 8}
+	tags := make(map[int]tiffTag)
+	for i := 0; i < n; i++ {
+		e := entries[12*i : 12*(i+1)]
+		tag := int(g.order.Uint16(e[0:2]))
+		typ := g.order.Uint16(e[2:4])
+		count := int(g.order.Uint32(e[4:8]))
+		size, ok := sizes[typ]
+		if !ok {
+			continue // Ignore unknown types.
+		}
+		data := e[8:12]
+		if size*count > 4 {
+			data = make([]byte, size*count)
+			if _, err := g.r.ReadAt(data, int64(g.order.Uint32(e[8:12]))); err != nil {
+				return nil, fmt.Errorf("geotiff: reading GeoTIFF tag %d: %v", tag, err)
+			}
+		}
+		var t tiffTag
+		for j := 0; j < count; j++ {
+			d := data[j*size:]
+			switch typ {
+			case 1, 6, 7:
+				t.ints = append(t.ints, uint64(d[0]))
+			case 3, 8:
+				t.ints = append(t.ints, uint64(g.order.Uint16(d)))
+			case 4, 9:
+				t.ints = append(t.ints, uint64(g.order.Uint32(d)))
+			case 16:
+				t.ints = append(t.ints, g.order.Uint64(d))
+			case 11:
+				t.floats = append(t.floats, float64(math.Float32frombits(g.order.Uint32(d))))
+			case 12:
+				t.floats = append(t.floats, math.Float64frombits(g.order.Uint64(d)))
+			case 5, 10:
+				t.floats = append(t.floats, float64(g.order.Uint32(d))/float64(g.order.Uint32(d[4:])))
+			}
+		}
+		if typ == 2 {
+			t.str = string(data[:count])
+		}
+		tags[tag] = t
+	}
+	return tags, nil
+}
+
+// gdalBandNames returns the band descriptions in GDAL metadata XML string s.
+// It returns nil if not all of the bands have descriptions.
+func gdalBandNames(s string, nBands int) []string {
+	var md struct {
+		Items []struct {
+			Name   string `xml:"name,attr"`
+			Sample string `xml:"sample,attr"`
+			Role   string `xml:"role,attr"`
+			Value  string `xml:",chardata"`
+		} `xml:"Item"`
+	}
+	if err := xml.Unmarshal([]byte(strings.Trim(s, "\x00")), &md); err != nil {
+		return nil
+	}
+	names := make([]string, nBands)
+	for _, item := range md.Items {
+		if item.Role != "description" {
+			continue
+		}
+		i, err := strconv.Atoi(item.Sample)
+		if err != nil || i < 0 || i >= nBands {
+			continue
+		}
+		names[i] = strings.TrimSpace(item.Value)
+	}
+	for _, n := range names {
+		if n == "" {
+			return nil
+		}
+	}
+	return names
+}
+
+// BandIndices returns the band index for each of the given variable names.
+// If the GeoTIFF file contains band descriptions, they are matched to the
+// names. Otherwise, the bands are assumed to be in the same order as names.
+func (g *GeoTIFF) BandIndices(names []string) ([]int, error) {
+	o := make([]int, len(names))
+	if g.Names == nil {
+		if len(names) > g.Bands {
+			return nil, fmt.Errorf("geotiff: GeoTIFF file has %d bands but %d variables (%v) are required",
+				g.Bands, len(names), names)
+		}
+		for i := range names {
+			o[i] = i
+		}
+		return o, nil
+	}
+	for i, n := range names {
+		o[i] = -1
+		for j, bn := range g.Names {
+			if bn == n {
+				o[i] = j
+			}
+		}
+		if o[i] < 0 {
+			return nil, fmt.Errorf("geotiff: GeoTIFF file does not contain a band named %s; it contains %v", n, g.Names)
+		}
+	}
+	return o, nil
+}
+
+// Window returns the range of pixel columns [x0, x1) and
+// rows [y0, y1) that overlap b, which is in geographic coordinates.
+// If b is nil, the entire image is returned.
+func (g *GeoTIFF) Window(b *geom.Bounds) (x0, y0, x1, y1 int) {
+	if b == nil {
+		return 0, 0, g.Width, g.Height
+	}
+	clamp := func(v float64, max int) int {
+		return int(math.Max(0, math.Min(float64(max), v)))
+	}
+	x0 = clamp(math.Floor((b.Min.X-g.X0)/g.Dx), g.Width)
+	x1 = clamp(math.Ceil((b.Max.X-g.X0)/g.Dx), g.Width)
+	y0 = clamp(math.Floor((g.Y0-b.Max.Y)/g.Dy), g.Height)
+	y1 = clamp(math.Ceil((g.Y0-b.Min.Y)/g.Dy), g.Height)
+	return
+}
+
+// Pixel returns the geometry of the pixel in column x and row y.
+func (g *GeoTIFF) Pixel(x, y int) geom.Polygon {
+	l, r := g.X0+float64(x)*g.Dx, g.X0+float64(x+1)*g.Dx
+	u, b := g.Y0-float64(y)*g.Dy, g.Y0-float64(y+1)*g.Dy
+	return geom.Polygon{{{X: l, Y: b}, {X: r, Y: b}, {X: r, Y: u}, {X: l, Y: u}, {X: l, Y: b}}}
+}
+
+// ReadBands returns the data for the given bands in the pixel columns [x0, x1)
+// and rows [y0, y1), with the outer index being the band and the
+// inner index being (y-y0)*(x1-x0)+(x-x0). Missing values are set to NaN.
+func (g *GeoTIFF) ReadBands(bands []int, x0, y0, x1, y1 int) ([][]float64, error) {
+	nx := x1 - x0
+	o := make([][]float64, len(bands))
+	for i := range o {
+		o[i] = make([]float64, nx*(y1-y0))
+	}
+	if nx <= 0 || y1 <= y0 {
+		return o, nil
+	}
+	chunkBands := []int{0}
+	if g.planar {
+		chunkBands = bands
+	}
+	cx0, cx1 := x0/g.chunkWidth, (x1-1)/g.chunkWidth
+	cy0, cy1 := y0/g.chunkHeight, (y1-1)/g.chunkHeight
+	chunksPerBand := g.chunksAcross * ((g.Height + g.chunkHeight - 1) / g.chunkHeight)
+	for _, cb := range chunkBands {
+		for cy := cy0; cy <= cy1; cy++ {
+			for cx := cx0; cx <= cx1; cx++ {
+				i := cy*g.chunksAcross + cx
+				if g.planar {
+					i += cb * chunksPerBand
+				}
+				data, err := g.chunk(i)
+				if err != nil {
+					return nil, err
+				}
+				spp := g.Bands
+				if g.planar {
+					spp = 1
+				}
+				for y := cy * g.chunkHeight; y < (cy+1)*g.chunkHeight && y < y1; y++ {
+					if y < y0 {
+						continue
+					}
+					for x := cx * g.chunkWidth; x < (cx+1)*g.chunkWidth && x < x1; x++ {
+						if x < x0 {
+							continue
+						}
+						pos := ((y-cy*g.chunkHeight)*g.chunkWidth + x - cx*g.chunkWidth) * spp
+						for bi, b := range bands {
+							switch {
+							case !g.planar:
+								o[bi][(y-y0)*nx+x-x0] = g.value(data[pos+b])
+							case b == cb:
+								o[bi][(y-y0)*nx+x-x0] = g.value(data[pos])
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return o, nil
+}
+
+// value converts raw sample bits to a floating point value.
+func (g *GeoTIFF) value(bits uint64) float64 {
+	var v float64
+	switch {
+	case g.sampleFormat == 3 && g.bitsPerSample == 32:
+		v = float64(math.Float32frombits(uint32(bits)))
+	case g.sampleFormat == 3:
+		v = math.Float64frombits(bits)
+	case g.sampleFormat == 2:
+		shift := uint(64 - g.bitsPerSample)
+		v = float64(int64(bits<<shift) >> shift)
+	default:
+		v = float64(bits)
+	}
+	if v == g.noData || float32(v) == float32(g.noData) {
+		return math.NaN()
+	}
+	return v
+}
+
+// chunk reads and decompresses the strip or tile with index i and returns
+// the raw bits of its samples.
+func (g *GeoTIFF) chunk(i int) ([]uint64, error) {
+	b := make([]byte, g.byteCounts[i])
+	if _, err := g.r.ReadAt(b, int64(g.offsets[i])); err != nil {
+		return nil, fmt.Errorf("geotiff: reading GeoTIFF data: %v", err)
+	}
+	var r io.Reader = bytes.NewReader(b)
+	switch g.compression {
+	case 5:
+		lr := lzw.NewReader(r, lzw.MSB, 8)
+		defer lr.Close()
+		r = lr
+	case 8, 32946:
+		zr, err := zlib.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("geotiff: decompressing GeoTIFF data: %v", err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+	spp := g.Bands
+	if g.planar {
+		spp = 1
+	}
+	bps := g.bitsPerSample / 8
+	rowSamples := g.chunkWidth * spp
+	n := rowSamples * g.chunkHeight
+	b = make([]byte, n*bps)
+	// The last strip may be shorter than the others.
+	nRead, err := io.ReadFull(r, b)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, fmt.Errorf("geotiff: decompressing GeoTIFF data: %v", err)
+	}
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		return nil, fmt.Errorf("geotiff: decompressing GeoTIFF data: %v", err)
+	}
+	rows := nRead / (rowSamples * bps)
+
+	o := make([]uint64, n)
+	for y := 0; y < rows; y++ {
+		row := b[y*rowSamples*bps : (y+1)*rowSamples*bps]
+		out := o[y*rowSamples : (y+1)*rowSamples]
+		if g.predictor == 3 {
+			// Floating point predictor: bytes are differenced and then
+			// grouped by significance.
+			for j := spp; j < len(row); j++ {
+				row[j] += row[j-spp]
+			}
+			for j := range out {
+				var v uint64
+				for k := 0; k < bps; k++ {
+					v = v<<8 | uint64(row[k*rowSamples+j])
+				}
+				out[j] = v
+			}
+			continue
+		}
+		for j := range out {
+			d := row[j*bps:]
+			switch bps {
+			case 1:
+				out[j] = uint64(d[0])
+			case 2:
+				out[j] = uint64(g.order.Uint16(d))
+			case 4:
+				out[j] = uint64(g.order.Uint32(d))
+			case 8:
+				out[j] = g.order.Uint64(d)
+			}
+		}
+		if g.predictor == 2 {
+			// Horizontal differencing predictor.
+			mask := uint64(math.MaxUint64) >> uint(64-g.bitsPerSample)
+			for j := spp; j < len(out); j++ {
+				out[j] = (out[j] + out[j-spp]) & mask
+			}
+		}
+	}
+	return o, nil
+}
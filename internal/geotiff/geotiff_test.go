@@ -0,0 +1,126 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package geotiff
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/ctessum/geom"
+)
+
+func TestGeoTIFF(t *testing.T) {
+	const fname = "tempGeoTIFF.tif"
+	nan := float32(math.NaN())
+	base := TestFile{
+		Width: 5, Height: 3,
+		Bands: [][]float32{
+			{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, -9999, 15},
+			{0.5, 1.5, 2.5, 3.5, 4.5, 5.5, 6.5, 7.5, 8.5, 9.5, 10.5, 11.5, 12.5, 13.5, nan},
+		},
+		X0: -97.1, Y0: 40.1, Dx: 0.01, Dy: 0.02,
+		NoData: "-9999",
+	}
+	want := [][]float64{
+		{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, math.NaN(), 15},
+		{0.5, 1.5, 2.5, 3.5, 4.5, 5.5, 6.5, 7.5, 8.5, 9.5, 10.5, 11.5, 12.5, 13.5, math.NaN()},
+	}
+
+	tiled := base
+	tiled.Tile = 2
+	tiled.Deflate = true
+	tiled.Names = []string{"a", "b"}
+
+	for name, tg := range map[string]TestFile{"strips": base, "tiles_deflate": tiled} {
+		t.Run(name, func(t *testing.T) {
+			if err := tg.Write(fname); err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(fname)
+			f, err := os.Open(fname)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			g, err := Read(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if g.Width != 5 || g.Height != 3 || g.Bands != 2 {
+				t.Errorf("dimensions: %d, %d, %d", g.Width, g.Height, g.Bands)
+			}
+			if g.X0 != -97.1 || g.Y0 != 40.1 || g.Dx != 0.01 || g.Dy != 0.02 {
+				t.Errorf("georeferencing: %g, %g, %g, %g", g.X0, g.Y0, g.Dx, g.Dy)
+			}
+			if !reflect.DeepEqual(g.Names, tg.Names) {
+				t.Errorf("names: have %v, want %v", g.Names, tg.Names)
+			}
+
+			bands, err := g.BandIndices([]string{"b", "a"})
+			if tg.Names == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				bands = []int{1, 0}
+			} else if !reflect.DeepEqual(bands, []int{1, 0}) {
+				t.Errorf("band indices: %v, %v", bands, err)
+			}
+			data, err := g.ReadBands(bands, 0, 0, 5, 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, b := range bands {
+				compareFloats(t, fmt.Sprintf("band %d", b), data[i], want[b])
+			}
+
+			// Read a window.
+			x0, y0, x1, y1 := g.Window(&geom.Bounds{
+				Min: geom.Point{X: -97.085, Y: 40.05},
+				Max: geom.Point{X: -97.065, Y: 40.07},
+			})
+			if x0 != 1 || y0 != 1 || x1 != 4 || y1 != 3 {
+				t.Errorf("window: %d, %d, %d, %d", x0, y0, x1, y1)
+			}
+			data, err = g.ReadBands([]int{0}, x0, y0, x1, y1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			compareFloats(t, "window", data[0], []float64{7, 8, 9, 12, 13, math.NaN()})
+
+			if _, err := g.BandIndices([]string{"a", "b", "c"}); err == nil {
+				t.Error("missing bands should cause an error")
+			}
+		})
+	}
+}
+
+func compareFloats(t *testing.T, name string, have, want []float64) {
+	if len(have) != len(want) {
+		t.Fatalf("%s: have %v, want %v", name, have, want)
+	}
+	for i := range have {
+		if have[i] != want[i] && !(math.IsNaN(have[i]) && math.IsNaN(want[i])) {
+			t.Errorf("%s: have %v, want %v", name, have, want)
+			return
+		}
+	}
+}
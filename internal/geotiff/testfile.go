@@ -0,0 +1,224 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package geotiff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+)
+
+// TestFile holds information for writing a little-endian
+// float32 GeoTIFF file for testing.
+type TestFile struct {
+	Width, Height  int
+	Bands          [][]float32 // [band][row*width+column]
+	X0, Y0, Dx, Dy float64
+	Names          []string // band descriptions
+	NoData         string
+
+	// Tile is the tile size. If it is zero, the image is written in
+	// strips of two rows.
+	Tile int
+
+	// Deflate specifies whether to use deflate compression with
+	// the floating point predictor.
+	Deflate bool
+
+	// Projected specifies that the image is in projected coordinates.
+	Projected bool
+}
+
+// Write writes the test file to fname.
+func (g TestFile) Write(fname string) error {
+	le := binary.LittleEndian
+	spp := len(g.Bands)
+	cw, ch := g.Width, 2
+	if g.Tile > 0 {
+		cw, ch = g.Tile, g.Tile
+	}
+	across := (g.Width + cw - 1) / cw
+	down := (g.Height + ch - 1) / ch
+
+	buf := bytes.NewBuffer([]byte{'I', 'I', 42, 0, 0, 0, 0, 0})
+	var offsets, counts []uint32
+	for cy := 0; cy < down; cy++ {
+		for cx := 0; cx < across; cx++ {
+			var raw []byte
+			for y := cy * ch; y < (cy+1)*ch; y++ {
+				if g.Tile == 0 && y >= g.Height {
+					break // The last strip can be shorter.
+				}
+				row := make([]float32, cw*spp)
+				for x := cx * cw; x < (cx+1)*cw; x++ {
+					for b := range g.Bands {
+						if x < g.Width && y < g.Height {
+							row[(x-cx*cw)*spp+b] = g.Bands[b][y*g.Width+x]
+						}
+					}
+				}
+				rb := make([]byte, 4*len(row))
+				if g.Deflate {
+					// Floating point predictor.
+					for i, v := range row {
+						bits := math.Float32bits(v)
+						for k := 0; k < 4; k++ {
+							rb[k*len(row)+i] = byte(bits >> uint(8*(3-k)))
+						}
+					}
+					for j := len(rb) - 1; j >= spp; j-- {
+						rb[j] -= rb[j-spp]
+					}
+				} else {
+					for i, v := range row {
+						le.PutUint32(rb[4*i:], math.Float32bits(v))
+					}
+				}
+				raw = append(raw, rb...)
+			}
+			if g.Deflate {
+				var b bytes.Buffer
+				w := zlib.NewWriter(&b)
+				w.Write(raw)
+				w.Close()
+				raw = b.Bytes()
+			}
+			offsets = append(offsets, uint32(buf.Len()))
+			counts = append(counts, uint32(len(raw)))
+			buf.Write(raw)
+		}
+	}
+
+	type entry struct {
+		tag, typ uint16
+		count    int
+		data     []byte
+	}
+	shorts := func(v ...uint16) []byte {
+		b := make([]byte, 2*len(v))
+		for i, vv := range v {
+			le.PutUint16(b[2*i:], vv)
+		}
+		return b
+	}
+	longs := func(v ...uint32) []byte {
+		b := make([]byte, 4*len(v))
+		for i, vv := range v {
+			le.PutUint32(b[4*i:], vv)
+		}
+		return b
+	}
+	doubles := func(v ...float64) []byte {
+		b := make([]byte, 8*len(v))
+		for i, vv := range v {
+			le.PutUint64(b[8*i:], math.Float64bits(vv))
+		}
+		return b
+	}
+	bps := make([]uint16, spp)
+	sf := make([]uint16, spp)
+	for i := range bps {
+		bps[i], sf[i] = 32, 3
+	}
+	modelType := uint16(modelTypeGeographic)
+	if g.Projected {
+		modelType = modelTypeProjected
+	}
+	compression, predictor := uint16(1), uint16(1)
+	if g.Deflate {
+		compression, predictor = 8, 3
+	}
+	entries := []entry{
+		{tImageWidth, 4, 1, longs(uint32(g.Width))},
+		{tImageLength, 4, 1, longs(uint32(g.Height))},
+		{tBitsPerSample, 3, spp, shorts(bps...)},
+		{tCompression, 3, 1, shorts(compression)},
+		{tSamplesPerPixel, 3, 1, shorts(uint16(spp))},
+		{tPredictor, 3, 1, shorts(predictor)},
+		{tSampleFormat, 3, spp, shorts(sf...)},
+		{tModelPixelScale, 12, 3, doubles(g.Dx, g.Dy, 0)},
+		{tModelTiepoint, 12, 6, doubles(0, 0, 0, g.X0, g.Y0, 0)},
+		{tGeoKeyDirectory, 3, 12, shorts(1, 1, 0, 2, gkModelType, 0, 1, modelType, gkRasterType, 0, 1, 1)},
+	}
+	if g.Tile > 0 {
+		entries = append(entries,
+			entry{tTileWidth, 3, 1, shorts(uint16(cw))},
+			entry{tTileLength, 3, 1, shorts(uint16(ch))},
+			entry{tTileOffsets, 4, len(offsets), longs(offsets...)},
+			entry{tTileByteCounts, 4, len(counts), longs(counts...)},
+		)
+	} else {
+		entries = append(entries,
+			entry{tRowsPerStrip, 3, 1, shorts(uint16(ch))},
+			entry{tStripOffsets, 4, len(offsets), longs(offsets...)},
+			entry{tStripByteCounts, 4, len(counts), longs(counts...)},
+		)
+	}
+	if g.Names != nil {
+		md := "<GDALMetadata>"
+		for i, n := range g.Names {
+			md += fmt.Sprintf(`<Item name="DESCRIPTION" sample="%d" role="description">%s</Item>`, i, n)
+		}
+		md += "</GDALMetadata>\x00"
+		entries = append(entries, entry{tGDALMetadata, 2, len(md), []byte(md)})
+	}
+	if g.NoData != "" {
+		entries = append(entries, entry{tGDALNoData, 2, len(g.NoData) + 1, []byte(g.NoData + "\x00")})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+
+	if buf.Len()%2 != 0 {
+		buf.WriteByte(0)
+	}
+	ifdOffset := buf.Len()
+	extraOffset := ifdOffset + 2 + 12*len(entries) + 4
+	var extra []byte
+	ifd := shorts(uint16(len(entries)))
+	for _, e := range entries {
+		ifd = append(ifd, shorts(e.tag, e.typ)...)
+		ifd = append(ifd, longs(uint32(e.count))...)
+		if len(e.data) <= 4 {
+			ifd = append(ifd, append(e.data, make([]byte, 4-len(e.data))...)...)
+		} else {
+			ifd = append(ifd, longs(uint32(extraOffset+len(extra)))...)
+			extra = append(extra, e.data...)
+			if len(extra)%2 != 0 {
+				extra = append(extra, 0)
+			}
+		}
+	}
+	ifd = append(ifd, 0, 0, 0, 0) // No more directories.
+	buf.Write(ifd)
+	buf.Write(extra)
+	b := buf.Bytes()
+	le.PutUint32(b[4:], uint32(ifdOffset))
+
+	f, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(b); err != nil {
+		return err
+	}
+	return f.Close()
+}
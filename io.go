@@ -75,6 +75,10 @@ type EmisRecord struct {
 	Diam               float64 // stack diameter [m]
 	Temp               float64 // stack temperature [K]
 	Velocity           float64 // stack velocity [m/s]
+
+	// Tag specifies the group that the emissions belong to
+	// when using a TaggedMechanism. It is ignored otherwise.
+	Tag int
 }
 
 // add adds the emissions in o to the receiver.
@@ -449,19 +453,25 @@ func (c *Cell) SetEmissionsFlux(e *Emissions, m Mechanism) error {
 			continue
 		}
 
-		if err := m.AddEmisFlux(c, "VOC", e.VOC*weightFactor); err != nil {
+		addEmisFlux := m.AddEmisFlux
+		if tm, ok := m.(TaggedMechanism); ok {
+			addEmisFlux = func(c *Cell, name string, val float64) error {
+				return tm.AddTaggedEmisFlux(c, name, e.Tag, val)
+			}
+		}
+		if err := addEmisFlux(c, "VOC", e.VOC*weightFactor); err != nil {
 			return err
 		}
-		if err := m.AddEmisFlux(c, "NOx", e.NOx*weightFactor); err != nil {
+		if err := addEmisFlux(c, "NOx", e.NOx*weightFactor); err != nil {
 			return err
 		}
-		if err := m.AddEmisFlux(c, "NH3", e.NH3*weightFactor); err != nil {
+		if err := addEmisFlux(c, "NH3", e.NH3*weightFactor); err != nil {
 			return err
 		}
-		if err := m.AddEmisFlux(c, "SOx", e.SOx*weightFactor); err != nil {
+		if err := addEmisFlux(c, "SOx", e.SOx*weightFactor); err != nil {
 			return err
 		}
-		if err := m.AddEmisFlux(c, "PM2_5", e.PM25*weightFactor); err != nil {
+		if err := addEmisFlux(c, "PM2_5", e.PM25*weightFactor); err != nil {
 			return err
 		}
 	}
@@ -491,6 +501,10 @@ type Outputter struct {
 	modelVariables  []string
 	outputFunctions map[string]govaluate.ExpressionFunction
 	m               Mechanism
+
+	// results, if not nil, are returned by InMAP.Results instead of
+	// results calculated from the current model state.
+	results map[string][]float64
 }
 
 // NewOutputter initializes a new Outputter holder and adds a set of default
@@ -543,6 +557,10 @@ func NewOutputter(fileName string, allLayers bool, outputVariables map[string]st
 		m:               m,
 	}
 
+	for key, val := range o.outputVariables {
+		o.outputVariables[key] = escapeDeathsVars(val)
+	}
+
 	for _, val := range o.outputVariables {
 		regx, _ := regexp.Compile("\\{(.*?)\\}")
 		matches := regx.FindAllString(val, -1)
@@ -683,6 +701,12 @@ func (d *InMAP) checkModelVars(m Mechanism, g ...string) error {
 		mapOutputOps[n] = struct{}{}
 	}
 	for _, v := range g {
+		if isDeathsVar(v) {
+			if _, err := d.parseDeathsVar(v); err != nil {
+				return err
+			}
+			continue
+		}
 		if _, ok := mapOutputOps[v]; !ok {
 			return fmt.Errorf("inmap: undefined variable name '%s'", v)
 		}
@@ -851,6 +875,13 @@ func shpFieldFromArray(name string, d []float64) goshp.Field {
 // Results returns the simulation results.
 // Output is in the form of map[variable][row]concentration.
 func (d *InMAP) Results(o *Outputter) (map[string][]float64, error) {
+	if o.results != nil {
+		output := make(map[string][]float64, len(o.results))
+		for k, v := range o.results {
+			output[k] = append([]float64(nil), v...)
+		}
+		return output, nil
+	}
 
 	// Prepare output data.
 	modelVals := make(map[string]interface{})
@@ -860,15 +891,21 @@ func (d *InMAP) Results(o *Outputter) (map[string][]float64, error) {
 
 	// Get the model variables that are to be used in the output.
 	for _, name := range o.modelVariables {
+		layer := 0
 		if o.allLayers {
-			data := d.toArray(name, -1, o.m)
-			modelVals[name] = data
-			nCells = len(data)
+			layer = -1
+		}
+		var data []float64
+		if isDeathsVar(name) {
+			var err error
+			if data, err = d.deaths(name, layer, o.m); err != nil {
+				return nil, err
+			}
 		} else {
-			data := d.toArray(name, 0, o.m)
-			modelVals[name] = data
-			nCells = len(data)
+			data = d.toArray(name, layer, o.m)
 		}
+		modelVals[name] = data
+		nCells = len(data)
 	}
 
 	// Identify segments of output variable expressions that are surrounded by braces.
@@ -953,6 +990,12 @@ func (c *Cell) getValue(varName string, popIndices, mortIndices map[string]int,
 	} else if i, ok := mortIndices[varName]; ok { // Mortality rate
 		return c.MortData[i]
 
+	} else if i, ok := mortIndices[strings.TrimSuffix(varName, ioSuffix)]; ok && strings.HasSuffix(varName, ioSuffix) { // Underlying incidence rate
+		if len(c.IoData) == 0 {
+			return 0
+		}
+		return c.IoData[i]
+
 	} // Everything else
 	v2 := reflect.ValueOf(c).Elem()
 	if _, ok := v2.Type().FieldByName(varName); !ok {
@@ -981,8 +1024,10 @@ func (d *InMAP) getUnits(varName string, m Mechanism) string {
 		return "people/grid cell"
 	} else if _, ok := d.mortIndices[varName]; ok { // Mortality Rate
 		return "deaths/100,000"
-	} else if _, ok := d.PopIndices[strings.Replace(varName, " deaths", "", 1)]; ok {
-		// Mortalities
+	} else if _, ok := d.mortIndices[strings.TrimSuffix(varName, ioSuffix)]; ok && strings.HasSuffix(varName, ioSuffix) {
+		// Underlying incidence rate
+		return "deaths/100,000"
+	} else if isDeathsVar(varName) { // Mortalities
 		return "deaths/grid cell"
 	}
 	// Everything else
@@ -1042,6 +1087,14 @@ func (d *InMAP) OutputOptions(m Mechanism) (names []string, descriptions []strin
 		descriptions = append(descriptions, strings.Replace(n, "Mort", "", 1)+"MortalityRate")
 	}
 
+	// Underlying incidence rates
+	if d.baselineHR != "" {
+		for _, n := range tempMort {
+			names = append(names, n+ioSuffix)
+			descriptions = append(descriptions, strings.Replace(n, "Mort", "", 1)+"UnderlyingIncidenceRate")
+		}
+	}
+
 	// Eveything else
 	t := reflect.TypeOf(*(*d.cells)[0].Cell)
 	var tempNames []string
@@ -0,0 +1,357 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/BurntSushi/toml"
+	"github.com/ctessum/atmos/seinfeld"
+	"github.com/ctessum/atmos/wesely1989"
+	"github.com/ctessum/geom"
+	"github.com/ctessum/geom/proj"
+	"github.com/ctessum/sparse"
+	"github.com/evookelj/inmap/internal/geotiff"
+)
+
+// Numbers of land use categories specified in github.com/ctessum/atmos/seinfeld
+// and github.com/ctessum/atmos/wesely1989.
+const (
+	nSeinfeldLandUse = int(seinfeld.Shrubs) + 1
+	nWeselyLandUse   = int(wesely1989.RockyShrubs) + 1
+)
+
+var seinfeldLandUseNames = map[string]seinfeld.LandUseCategory{
+	"Evergreen": seinfeld.Evergreen,
+	"Deciduous": seinfeld.Deciduous,
+	"Grass":     seinfeld.Grass,
+	"Desert":    seinfeld.Desert,
+	"Shrubs":    seinfeld.Shrubs,
+}
+
+var weselyLandUseNames = map[string]wesely1989.LandUseCategory{
+	"Urban":        wesely1989.Urban,
+	"Agricultural": wesely1989.Agricultural,
+	"Range":        wesely1989.Range,
+	"Deciduous":    wesely1989.Deciduous,
+	"Coniferous":   wesely1989.Coniferous,
+	"MixedForest":  wesely1989.MixedForest,
+	"Water":        wesely1989.Water,
+	"Barren":       wesely1989.Barren,
+	"Wetland":      wesely1989.Wetland,
+	"RangeAg":      wesely1989.RangeAg,
+	"RockyShrubs":  wesely1989.RockyShrubs,
+}
+
+// LandCoverClass specifies the land use categories used for dry
+// deposition calculations that correspond to a land cover raster category.
+type LandCoverClass struct {
+	// Name is an optional description of the category.
+	Name string
+
+	// Seinfeld is the name of the corresponding land use category
+	// specified in github.com/ctessum/atmos/seinfeld:
+	// Evergreen, Deciduous, Grass, Desert, or Shrubs.
+	Seinfeld string
+
+	// Wesely is the name of the corresponding land use category
+	// specified in github.com/ctessum/atmos/wesely1989:
+	// Urban, Agricultural, Range, Deciduous, Coniferous, MixedForest,
+	// Water, Barren, Wetland, RangeAg, or RockyShrubs.
+	Wesely string
+}
+
+// LandCover holds information about a land cover raster, such as
+// from the National Land Cover Database (NLCD) or MODIS, for calculating
+// the fraction of each CTM grid cell covered by each land use category.
+type LandCover struct {
+	file     string
+	rasterSR *proj.SR
+	seinfeld map[int]seinfeld.LandUseCategory
+	wesely   map[int]wesely1989.LandUseCategory
+}
+
+// NewLandCover returns a new LandCover for the single-band GeoTIFF file
+// rasterFile, whose values are land cover category numbers.
+// mappingFile is a TOML file with a [Categories] table specifying
+// the LandCoverClass of each land cover category number, for example:
+//
+//	[Categories.11]
+//	Name = "Open Water"
+//	Seinfeld = "Desert"
+//	Wesely = "Water"
+//
+// Raster values that are not in the mapping, such as fill values, are ignored.
+// rasterProj is the spatial reference of the raster in Proj4 format,
+// which is required if the raster uses projected rather than
+// geographic (longitude-latitude) coordinates.
+func NewLandCover(rasterFile, mappingFile, rasterProj string) (*LandCover, error) {
+	var mapping struct {
+		Categories map[string]LandCoverClass
+	}
+	if _, err := toml.DecodeFile(mappingFile, &mapping); err != nil {
+		return nil, fmt.Errorf("inmap: reading land cover mapping file: %v", err)
+	}
+	if len(mapping.Categories) == 0 {
+		return nil, fmt.Errorf("inmap: land cover mapping file %s does not contain any categories", mappingFile)
+	}
+	lc := &LandCover{
+		file:     rasterFile,
+		seinfeld: make(map[int]seinfeld.LandUseCategory),
+		wesely:   make(map[int]wesely1989.LandUseCategory),
+	}
+	for key, class := range mapping.Categories {
+		c, err := strconv.Atoi(key)
+		if err != nil {
+			return nil, fmt.Errorf("inmap: land cover category '%s' is not an integer", key)
+		}
+		var ok bool
+		if lc.seinfeld[c], ok = seinfeldLandUseNames[class.Seinfeld]; !ok {
+			return nil, fmt.Errorf("inmap: land cover category %d: invalid Seinfeld land use category '%s'", c, class.Seinfeld)
+		}
+		if lc.wesely[c], ok = weselyLandUseNames[class.Wesely]; !ok {
+			return nil, fmt.Errorf("inmap: land cover category %d: invalid Wesely land use category '%s'", c, class.Wesely)
+		}
+	}
+
+	f, err := os.Open(rasterFile)
+	if err != nil {
+		return nil, fmt.Errorf("inmap: opening land cover file: %v", err)
+	}
+	defer f.Close()
+	g, err := geotiff.Read(f)
+	if err != nil {
+		return nil, err
+	}
+	if g.Bands != 1 {
+		return nil, fmt.Errorf("inmap: land cover file must have 1 band but has %d", g.Bands)
+	}
+	if rasterProj == "" {
+		if g.Projected {
+			return nil, fmt.Errorf("inmap: the spatial reference of land cover file %s must be specified "+
+				"because it uses projected coordinates", rasterFile)
+		}
+		rasterProj = "+proj=longlat"
+	}
+	if lc.rasterSR, err = proj.Parse(rasterProj); err != nil {
+		return nil, fmt.Errorf("inmap: parsing land cover spatial reference: %v", err)
+	}
+	return lc, nil
+}
+
+// Fractions returns the fraction of each cell in the given grid, which has
+// nx columns and ny rows and spatial reference gridSR, covered by each of the land use
+// categories specified in github.com/ctessum/atmos/seinfeld and
+// github.com/ctessum/atmos/wesely1989, with dimensions [category, y, x].
+// Each raster pixel is assigned to the grid cell that contains its center.
+// The fractions in grid cells that do not contain any mapped raster pixels are zero.
+func (lc *LandCover) Fractions(grid CTMGrid, nx, ny int, gridSR *proj.SR) (seinfeldFrac, weselyFrac *sparse.DenseArray, err error) {
+	f, err := os.Open(lc.file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("inmap: opening land cover file: %v", err)
+	}
+	defer f.Close()
+	g, err := geotiff.Read(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	ct, err := lc.rasterSR.NewTransform(gridSR)
+	if err != nil {
+		return nil, nil, fmt.Errorf("inmap: creating land cover transform: %v", err)
+	}
+	same := ct == nil // The raster and grid spatial references are the same.
+
+	// Only read the part of the file that overlaps the grid.
+	b := &geom.Bounds{
+		Min: geom.Point{X: grid.Xo, Y: grid.Yo},
+		Max: geom.Point{X: grid.Xo + grid.Dx*float64(nx), Y: grid.Yo + grid.Dy*float64(ny)},
+	}
+	rb, err := geographicBounds(gridSR, lc.rasterSR, b)
+	if err != nil {
+		return nil, nil, err
+	}
+	rb.Min.X -= g.Dx
+	rb.Min.Y -= g.Dy
+	rb.Max.X += g.Dx
+	rb.Max.Y += g.Dy
+	x0, y0, x1, y1 := g.Window(rb)
+
+	seinfeldFrac = sparse.ZerosDense(nSeinfeldLandUse, ny, nx)
+	weselyFrac = sparse.ZerosDense(nWeselyLandUse, ny, nx)
+	count := sparse.ZerosDense(ny, nx)
+
+	// Read the raster in blocks of rows to limit memory use.
+	const blockRows = 256
+	for by := y0; by < y1; by += blockRows {
+		by1 := by + blockRows
+		if by1 > y1 {
+			by1 = y1
+		}
+		data, err := g.ReadBands([]int{0}, x0, by, x1, by1)
+		if err != nil {
+			return nil, nil, err
+		}
+		for y := by; y < by1; y++ {
+			for x := x0; x < x1; x++ {
+				v := data[0][(y-by)*(x1-x0)+x-x0]
+				if math.IsNaN(v) {
+					continue
+				}
+				c := int(v)
+				sCat, ok := lc.seinfeld[c]
+				if !ok {
+					continue
+				}
+				px, py := g.X0+(float64(x)+0.5)*g.Dx, g.Y0-(float64(y)+0.5)*g.Dy
+				if !same {
+					if px, py, err = ct(px, py); err != nil {
+						return nil, nil, err
+					}
+				}
+				i := int(math.Floor((px - grid.Xo) / grid.Dx))
+				j := int(math.Floor((py - grid.Yo) / grid.Dy))
+				if i < 0 || j < 0 || i >= nx || j >= ny {
+					continue
+				}
+				seinfeldFrac.AddVal(1, int(sCat), j, i)
+				weselyFrac.AddVal(1, int(lc.wesely[c]), j, i)
+				count.AddVal(1, j, i)
+			}
+		}
+	}
+	for j := 0; j < ny; j++ {
+		for i := 0; i < nx; i++ {
+			n := count.Get(j, i)
+			if n == 0 {
+				continue
+			}
+			for c := 0; c < nSeinfeldLandUse; c++ {
+				seinfeldFrac.Set(seinfeldFrac.Get(c, j, i)/n, c, j, i)
+			}
+			for c := 0; c < nWeselyLandUse; c++ {
+				weselyFrac.Set(weselyFrac.Get(c, j, i)/n, c, j, i)
+			}
+		}
+	}
+	return seinfeldFrac, weselyFrac, nil
+}
+
+// LandCoverPreprocessor wraps a Preprocessor, supplementing its
+// land use information with land use fractions from a LandCover raster.
+// It implements LandUseFractioner, so that dry deposition velocities
+// are calculated as the area-weighted average of the velocities for
+// each land use category in each grid cell.
+type LandCoverPreprocessor struct {
+	Preprocessor
+	seinfeld, wesely *sparse.DenseArray
+}
+
+// NewLandCoverPreprocessor returns a new LandCoverPreprocessor
+// that wraps p, where grid specifies the location of the grid cells of p in
+// spatial reference gridSR. Grid cells that are not covered by the land cover
+// raster use the land use categories from p.
+func NewLandCoverPreprocessor(p Preprocessor, lc *LandCover, grid CTMGrid, gridSR *proj.SR) (*LandCoverPreprocessor, error) {
+	nx, err := p.Nx()
+	if err != nil {
+		return nil, err
+	}
+	ny, err := p.Ny()
+	if err != nil {
+		return nil, err
+	}
+	o := &LandCoverPreprocessor{Preprocessor: p}
+	o.seinfeld, o.wesely, err = lc.Fractions(grid, nx, ny, gridSR)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// SeinfeldLandUseFractions helps fulfill the LandUseFractioner interface.
+func (p *LandCoverPreprocessor) SeinfeldLandUseFractions() NextData {
+	return landCoverFractions(p.Preprocessor.SeinfeldLandUse(), p.seinfeld)
+}
+
+// WeselyLandUseFractions helps fulfill the LandUseFractioner interface.
+func (p *LandCoverPreprocessor) WeselyLandUseFractions() NextData {
+	return landCoverFractions(p.Preprocessor.WeselyLandUse(), p.wesely)
+}
+
+// ncfReaders returns the readers used by the wrapped preprocessor.
+func (p *LandCoverPreprocessor) ncfReaders() []*ncfReader {
+	return readersOf(p.Preprocessor)
+}
+
+// landCoverFractions returns the land cover fractions, filling in
+// grid cells without land cover data with the categories from catFunc.
+func landCoverFractions(catFunc NextData, fractions *sparse.DenseArray) NextData {
+	catFrac := landUseCategoryFractions(catFunc, fractions.Shape[0])
+	return func() (*sparse.DenseArray, error) {
+		cat, err := catFrac()
+		if err != nil {
+			return nil, err
+		}
+		if cat.Shape[1] != fractions.Shape[1] || cat.Shape[2] != fractions.Shape[2] {
+			return nil, fmt.Errorf("inmap: land cover fractions have shape %v but land use categories have shape %v",
+				fractions.Shape[1:], cat.Shape[1:])
+		}
+		o := fractions.Copy()
+		for j := 0; j < o.Shape[1]; j++ {
+			for i := 0; i < o.Shape[2]; i++ {
+				var sum float64
+				for c := 0; c < o.Shape[0]; c++ {
+					sum += o.Get(c, j, i)
+				}
+				if sum != 0 {
+					continue
+				}
+				for c := 0; c < o.Shape[0]; c++ {
+					o.Set(cat.Get(c, j, i), c, j, i)
+				}
+			}
+		}
+		return o, nil
+	}
+}
+
+// landUseCategoryFractions converts the land use category indices from f
+// to land use fractions with dimensions [category, y, x], where
+// n is the number of categories.
+func landUseCategoryFractions(f NextData, n int) NextData {
+	return func() (*sparse.DenseArray, error) {
+		lu, err := f()
+		if err != nil {
+			return nil, err
+		}
+		o := sparse.ZerosDense(n, lu.Shape[0], lu.Shape[1])
+		for j := 0; j < lu.Shape[0]; j++ {
+			for i := 0; i < lu.Shape[1]; i++ {
+				c := f2i(lu.Get(j, i))
+				if c < 0 || c >= n {
+					return nil, fmt.Errorf("inmap: invalid land use category %d", c)
+				}
+				o.Set(1, c, j, i)
+			}
+		}
+		return o, nil
+	}
+}
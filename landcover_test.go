@@ -0,0 +1,196 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+import (
+	"math"
+	"os"
+	"testing"
+
+	"github.com/ctessum/atmos/seinfeld"
+	"github.com/ctessum/atmos/wesely1989"
+	"github.com/ctessum/geom/proj"
+	"github.com/evookelj/inmap/internal/geotiff"
+)
+
+const (
+	landCoverTestFile = "cmd/inmap/testdata/preproc/landcover.tif"
+	nlcdMappingFile   = "cmd/inmap/landCoverNLCD.toml"
+	wrfGridProj       = "+proj=lcc +lat_1=33.000000 +lat_2=45.000000 +lat_0=40.000000 +lon_0=-97.000000 +x_0=0 +y_0=0 +a=6370997.000000 +b=6370997.000000 +to_meter=1"
+)
+
+func TestLandCover(t *testing.T) {
+	// The raster covers a grid with two 1°x1° cells. Cell 0 is
+	// 30% cultivated crops (82) and 70% deciduous forest (41), and cell 1
+	// is all deciduous forest, plus a missing value and an unmapped category.
+	const file = "tempLandCover.tif"
+	g := geotiff.TestFile{Width: 20, Height: 10, X0: 0, Y0: 1, Dx: 0.1, Dy: 0.1, NoData: "-9999"}
+	band := make([]float32, g.Width*g.Height)
+	for y := 0; y < g.Height; y++ {
+		for x := 0; x < g.Width; x++ {
+			if x < 3 {
+				band[y*g.Width+x] = 82
+			} else {
+				band[y*g.Width+x] = 41
+			}
+		}
+	}
+	band[15], band[16] = -9999, 255
+	g.Bands = [][]float32{band}
+	if err := g.Write(file); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file)
+
+	lc, err := NewLandCover(file, nlcdMappingFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ll, err := proj.Parse("+proj=longlat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, w, err := lc.Fractions(CTMGrid{Xo: 0, Yo: 0, Dx: 1, Dy: 1}, 2, 1, ll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name       string
+		have, want float64
+	}{
+		{"seinfeld grass 0", s.Get(int(seinfeld.Grass), 0, 0), 0.3},
+		{"seinfeld deciduous 0", s.Get(int(seinfeld.Deciduous), 0, 0), 0.7},
+		{"seinfeld deciduous 1", s.Get(int(seinfeld.Deciduous), 0, 1), 1},
+		{"wesely agricultural 0", w.Get(int(wesely1989.Agricultural), 0, 0), 0.3},
+		{"wesely deciduous 0", w.Get(int(wesely1989.Deciduous), 0, 0), 0.7},
+		{"wesely deciduous 1", w.Get(int(wesely1989.Deciduous), 0, 1), 1},
+		{"seinfeld sum", s.Sum(), 2},
+		{"wesely sum", w.Sum(), 2},
+	} {
+		if math.Abs(test.have-test.want) > 1.0e-10 {
+			t.Errorf("%s: have %g, want %g", test.name, test.have, test.want)
+		}
+	}
+
+	t.Run("projected", func(t *testing.T) {
+		g.Projected = true
+		if err := g.Write(file); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewLandCover(file, nlcdMappingFile, ""); err == nil {
+			t.Error("projected raster without projection should cause an error")
+		}
+		if _, err := NewLandCover(file, nlcdMappingFile, "+proj=longlat"); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("mapping", func(t *testing.T) {
+		const mapping = "tempLandCoverMapping.toml"
+		defer os.Remove(mapping)
+		for _, m := range []string{
+			"[Categories.x]\nSeinfeld = \"Grass\"\nWesely = \"Range\"\n",
+			"[Categories.1]\nSeinfeld = \"Lawn\"\nWesely = \"Range\"\n",
+			"[Categories.1]\nSeinfeld = \"Grass\"\nWesely = \"Lawn\"\n",
+			"",
+		} {
+			if err := os.WriteFile(mapping, []byte(m), 0666); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := NewLandCover(file, mapping, "+proj=longlat"); err == nil {
+				t.Errorf("mapping %q should cause an error", m)
+			}
+		}
+		if _, err := NewLandCover(file, "cmd/inmap/landCoverMODIS.toml", "+proj=longlat"); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestLandCoverPreprocessor(t *testing.T) {
+	if regenGoldenFiles {
+		// The raster covers the WRF-Chem test domain; it is cultivated
+		// crops in the western column of grid cells and unmapped elsewhere.
+		g := geotiff.TestFile{Width: 40, Height: 40, X0: -118.8, Y0: 33.3, Dx: 0.01, Dy: 0.01}
+		band := make([]float32, g.Width*g.Height)
+		for y := 0; y < g.Height; y++ {
+			for x := 0; x < g.Width; x++ {
+				if g.X0+(float64(x)+0.5)*g.Dx < -118.62 {
+					band[y*g.Width+x] = 82
+				}
+			}
+		}
+		g.Bands = [][]float32{band}
+		if err := g.Write(landCoverTestFile); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wrf, err := NewWRFChem("cmd/inmap/testdata/preproc/wrfout_d01_[DATE]", "20050101", "20050103", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc, err := NewLandCover(landCoverTestFile, nlcdMappingFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gridSR, err := proj.Parse(wrfGridProj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grid := CTMGrid{Xo: -2004000, Yo: -540000, Dx: 12000, Dy: 12000}
+	p, err := NewLandCoverPreprocessor(wrf, lc, grid, gridSR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for j := 0; j < 2; j++ {
+		if f := p.wesely.Get(int(wesely1989.Agricultural), j, 0); f != 1 {
+			t.Errorf("row %d, column 0 agricultural fraction: have %g, want 1", j, f)
+		}
+		if f := p.wesely.Get(int(wesely1989.Agricultural), j, 1); f != 0 {
+			t.Errorf("row %d, column 1 agricultural fraction: have %g, want 0", j, f)
+		}
+	}
+
+	data, err := Preprocess(p, grid.Xo, grid.Yo, grid.Dx, grid.Dy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open("cmd/inmap/testdata/preproc/inmapData_WRFChem_golden.ncf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	golden, err := (&VarGridConfig{}).LoadCTMData(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"NH3DryDep", "SO2DryDep", "ParticleDryDep"} {
+		have, want := data.Data[v].Data, golden.Data[v].Data
+		for j := 0; j < 2; j++ {
+			// Cells without land cover data should be unchanged.
+			if different(have.Get(0, j, 1), want.Get(0, j, 1), 1.0e-6) {
+				t.Errorf("%s (%d, 1): have %g, want %g", v, j, have.Get(0, j, 1), want.Get(0, j, 1))
+			}
+			if have.Get(0, j, 0) == want.Get(0, j, 0) {
+				t.Errorf("%s (%d, 0) should be affected by land cover", v, j)
+			}
+		}
+	}
+}
@@ -54,3 +54,19 @@ type Mechanism interface {
 	// Len returns the number of pollutants in the chemical mechanism.
 	Len() int
 }
+
+// TaggedMechanism is an interface for chemical mechanisms that track
+// the concentrations resulting from separate groups of emissions, or
+// "tags", in separate sets of concentration array elements.
+// If a TaggedMechanism is used, the Tag field of each
+// EmisRecord specifies which group its emissions belong to.
+type TaggedMechanism interface {
+	Mechanism
+
+	// AddTaggedEmisFlux is the same as AddEmisFlux except that the
+	// emissions are added to the given tag.
+	AddTaggedEmisFlux(c *Cell, name string, tag int, val float64) error
+
+	// Tags returns the number of tags.
+	Tags() int
+}
@@ -0,0 +1,384 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/ctessum/sparse"
+)
+
+// CTMGrid specifies the location of a regular chemical transport
+// model grid. Xo and Yo are the coordinates of the lower-left
+// corner of the grid, and Dx and Dy are the grid cell edge lengths,
+// as in the arguments to Preprocess.
+type CTMGrid struct {
+	Xo, Yo, Dx, Dy float64
+}
+
+// MixedPreprocessor is an InMAP preprocessor that combines meteorology
+// data from one source, such as a meteorological reanalysis, with
+// chemistry data from another source, such as a chemical transport model run
+// on a coarser grid. The chemistry data are regridded to the meteorology grid.
+type MixedPreprocessor struct {
+	met, chem Preprocessor
+
+	metRecordDelta, chemRecordDelta time.Duration
+
+	nx, ny int
+
+	// cnx and cny are the chemistry grid dimensions.
+	cnx, cny int
+
+	// xWeights and yWeights hold the interpolation
+	// weights for each column and row of the meteorology grid.
+	xWeights, yWeights []interpWeight
+}
+
+// ncfReaders returns the readers used by the meteorology and
+// chemistry preprocessors.
+func (m *MixedPreprocessor) ncfReaders() []*ncfReader {
+	return append(readersOf(m.met), readersOf(m.chem)...)
+}
+
+// interpWeight specifies a linear interpolation between two grid
+// cells, where the value is a weighted average of the value at
+// index i0 and the value at index i1, with weight w given to the latter.
+type interpWeight struct {
+	i0, i1 int
+	w      float64
+}
+
+// NewMixedPreprocessor returns a preprocessor that reads meteorology data
+// from met and chemistry data (AVOC, BVOC, ASOA, BSOA, NOx, PNO, SOx, PS,
+// NH3, PNH, TotalPM25, HO, and H2O2) from chem. The chemistry
+// data are bilinearly interpolated from chemGrid to metGrid in the
+// horizontal direction and linearly interpolated between the layer
+// heights of the two preprocessors in the vertical direction.
+// The grids must be in the same spatial reference, each meteorology
+// grid cell center must be within the chemistry grid, and metGrid must be
+// the same as the grid passed to Preprocess.
+// metRecordInterval and chemRecordInterval are the amounts of
+// time between the records provided by met and chem, respectively,
+// and one must be a whole multiple of the other. Chemistry records
+// are repeated or skipped to match the meteorology records.
+func NewMixedPreprocessor(met, chem Preprocessor, metGrid, chemGrid CTMGrid, metRecordInterval, chemRecordInterval time.Duration) (*MixedPreprocessor, error) {
+	m := &MixedPreprocessor{
+		met:             met,
+		chem:            chem,
+		metRecordDelta:  metRecordInterval,
+		chemRecordDelta: chemRecordInterval,
+	}
+	if _, err := nextDataResample(nil, chemRecordInterval, metRecordInterval); err != nil {
+		return nil, fmt.Errorf("inmap: mixed preprocessor: %v", err)
+	}
+	var err error
+	if m.nx, err = met.Nx(); err != nil {
+		return nil, err
+	}
+	if m.ny, err = met.Ny(); err != nil {
+		return nil, err
+	}
+	if m.cnx, err = chem.Nx(); err != nil {
+		return nil, err
+	}
+	if m.cny, err = chem.Ny(); err != nil {
+		return nil, err
+	}
+	if m.xWeights, err = interpWeights(m.nx, metGrid.Xo, metGrid.Dx, m.cnx, chemGrid.Xo, chemGrid.Dx); err != nil {
+		return nil, fmt.Errorf("inmap: mixed preprocessor: x direction: %v", err)
+	}
+	if m.yWeights, err = interpWeights(m.ny, metGrid.Yo, metGrid.Dy, m.cny, chemGrid.Yo, chemGrid.Dy); err != nil {
+		return nil, fmt.Errorf("inmap: mixed preprocessor: y direction: %v", err)
+	}
+	return m, nil
+}
+
+// interpWeights calculates the weights for linearly interpolating
+// from the centers of the n2 cells of a grid starting at o2 with
+// spacing d2 to the centers of the n cells of a grid starting
+// at o with spacing d.
+func interpWeights(n int, o, d float64, n2 int, o2, d2 float64) ([]interpWeight, error) {
+	if d <= 0 || d2 <= 0 {
+		return nil, fmt.Errorf("grid spacings must be positive")
+	}
+	weights := make([]interpWeight, n)
+	for i := range weights {
+		x := o + (float64(i)+0.5)*d
+		if x < o2 || x > o2+float64(n2)*d2 {
+			return nil, fmt.Errorf("meteorology grid cell center %g is outside of the chemistry grid (%g to %g)",
+				x, o2, o2+float64(n2)*d2)
+		}
+		// Fractional index of x among the chemistry grid cell centers.
+		f := math.Max(0, math.Min(float64(n2-1), (x-o2)/d2-0.5))
+		i0 := int(f)
+		if i0 >= n2-1 {
+			weights[i] = interpWeight{i0: n2 - 1, i1: n2 - 1}
+			continue
+		}
+		weights[i] = interpWeight{i0: i0, i1: i0 + 1, w: f - float64(i0)}
+	}
+	return weights, nil
+}
+
+// horizontal interpolates the three-dimensional
+// chemistry data returned by f to the meteorology grid.
+func (m *MixedPreprocessor) horizontal(f NextData) NextData {
+	return func() (*sparse.DenseArray, error) {
+		in, err := f()
+		if err != nil {
+			return nil, err
+		}
+		if len(in.Shape) != 3 || in.Shape[1] != m.cny || in.Shape[2] != m.cnx {
+			return nil, fmt.Errorf("inmap: mixed preprocessor: chemistry data have shape %v but should have %d rows and %d columns",
+				in.Shape, m.cny, m.cnx)
+		}
+		out := sparse.ZerosDense(in.Shape[0], m.ny, m.nx)
+		for k := 0; k < in.Shape[0]; k++ {
+			for j, wy := range m.yWeights {
+				for i, wx := range m.xWeights {
+					v := (1-wy.w)*((1-wx.w)*in.Get(k, wy.i0, wx.i0)+wx.w*in.Get(k, wy.i0, wx.i1)) +
+						wy.w*((1-wx.w)*in.Get(k, wy.i1, wx.i0)+wx.w*in.Get(k, wy.i1, wx.i1))
+					out.Set(v, k, j, i)
+				}
+			}
+		}
+		return out, nil
+	}
+}
+
+// regrid returns the chemistry data returned by f interpolated
+// to the meteorology grid and records.
+func (m *MixedPreprocessor) regrid(f NextData) NextData {
+	dataFunc, _ := nextDataResample(m.horizontal(f), m.chemRecordDelta, m.metRecordDelta)
+	chemHeightFunc, _ := nextDataResample(m.horizontal(m.chem.Height()), m.chemRecordDelta, m.metRecordDelta)
+	metHeightFunc := m.met.Height()
+	return func() (*sparse.DenseArray, error) {
+		data, err := dataFunc()
+		if err != nil {
+			return nil, err
+		}
+		chemHeight, err := chemHeightFunc()
+		if err != nil {
+			return nil, err
+		}
+		metHeight, err := metHeightFunc()
+		if err != nil {
+			return nil, err
+		}
+		return verticalInterpolate(data, chemHeight, metHeight)
+	}
+}
+
+// verticalInterpolate linearly interpolates data from the layer center
+// heights corresponding to the layer interface heights in
+// fromHeight to the layer center heights corresponding to those in toHeight.
+// Values above or below the range of the original layers are set
+// to the values in the top or bottom original layer.
+func verticalInterpolate(data, fromHeight, toHeight *sparse.DenseArray) (*sparse.DenseArray, error) {
+	nz, ny, nx := data.Shape[0], data.Shape[1], data.Shape[2]
+	if fromHeight.Shape[0] != nz+1 || fromHeight.Shape[1] != ny || fromHeight.Shape[2] != nx {
+		return nil, fmt.Errorf("inmap: mixed preprocessor: chemistry layer heights have shape %v but data have shape %v",
+			fromHeight.Shape, data.Shape)
+	}
+	if toHeight.Shape[1] != ny || toHeight.Shape[2] != nx {
+		return nil, fmt.Errorf("inmap: mixed preprocessor: meteorology layer heights have shape %v but chemistry data have shape %v",
+			toHeight.Shape, data.Shape)
+	}
+	nzOut := toHeight.Shape[0] - 1
+	out := sparse.ZerosDense(nzOut, ny, nx)
+	from := make([]float64, nz)
+	for j := 0; j < ny; j++ {
+		for i := 0; i < nx; i++ {
+			for k := range from {
+				from[k] = (fromHeight.Get(k, j, i) + fromHeight.Get(k+1, j, i)) / 2
+			}
+			kk := 0
+			for k := 0; k < nzOut; k++ {
+				z := (toHeight.Get(k, j, i) + toHeight.Get(k+1, j, i)) / 2
+				for kk < nz-2 && from[kk+1] < z {
+					kk++
+				}
+				var v float64
+				switch {
+				case nz == 1 || z <= from[0]:
+					v = data.Get(0, j, i)
+				case z >= from[nz-1]:
+					v = data.Get(nz-1, j, i)
+				default:
+					w := (z - from[kk]) / (from[kk+1] - from[kk])
+					v = (1-w)*data.Get(kk, j, i) + w*data.Get(kk+1, j, i)
+				}
+				out.Set(v, k, j, i)
+			}
+		}
+	}
+	return out, nil
+}
+
+// nextDataResample converts the data returned by f, which has
+// records at the given interval from, to data with records at interval
+// to by skipping records if to is longer than from or repeating them if to
+// is shorter. One of the intervals must be a whole multiple of the other.
+func nextDataResample(f NextData, from, to time.Duration) (NextData, error) {
+	switch {
+	case from <= 0 || to <= 0:
+		return nil, fmt.Errorf("record intervals %v and %v must be positive", from, to)
+	case from == to:
+		return f, nil
+	case from < to:
+		if to%from != 0 {
+			return nil, fmt.Errorf("record interval %v is not a whole multiple of %v", to, from)
+		}
+		n := int(to / from)
+		first := true
+		return func() (*sparse.DenseArray, error) {
+			if !first {
+				for i := 1; i < n; i++ {
+					if _, err := f(); err != nil {
+						return nil, err
+					}
+				}
+			}
+			first = false
+			return f()
+		}, nil
+	default:
+		if from%to != 0 {
+			return nil, fmt.Errorf("record interval %v is not a whole multiple of %v", from, to)
+		}
+		n := int(from / to)
+		var data *sparse.DenseArray
+		var i int
+		return func() (*sparse.DenseArray, error) {
+			if i%n == 0 {
+				var err error
+				if data, err = f(); err != nil {
+					return nil, err
+				}
+			}
+			i++
+			return data.Copy(), nil
+		}, nil
+	}
+}
+
+// Nx helps fulfill the Preprocessor interface by returning
+// the number of grid cells in the West-East direction.
+func (m *MixedPreprocessor) Nx() (int, error) { return m.met.Nx() }
+
+// Ny helps fulfill the Preprocessor interface by returning
+// the number of grid cells in the South-North direction.
+func (m *MixedPreprocessor) Ny() (int, error) { return m.met.Ny() }
+
+// Nz helps fulfill the Preprocessor interface by returning
+// the number of grid cells in the below-above direction.
+func (m *MixedPreprocessor) Nz() (int, error) { return m.met.Nz() }
+
+// PBLH helps fulfill the Preprocessor interface.
+func (m *MixedPreprocessor) PBLH() NextData { return m.met.PBLH() }
+
+// Height helps fulfill the Preprocessor interface.
+func (m *MixedPreprocessor) Height() NextData { return m.met.Height() }
+
+// ALT helps fulfill the Preprocessor interface.
+func (m *MixedPreprocessor) ALT() NextData { return m.met.ALT() }
+
+// T helps fulfill the Preprocessor interface.
+func (m *MixedPreprocessor) T() NextData { return m.met.T() }
+
+// P helps fulfill the Preprocessor interface.
+func (m *MixedPreprocessor) P() NextData { return m.met.P() }
+
+// UStar helps fulfill the Preprocessor interface.
+func (m *MixedPreprocessor) UStar() NextData { return m.met.UStar() }
+
+// SeinfeldLandUse helps fulfill the Preprocessor interface.
+func (m *MixedPreprocessor) SeinfeldLandUse() NextData { return m.met.SeinfeldLandUse() }
+
+// WeselyLandUse helps fulfill the Preprocessor interface.
+func (m *MixedPreprocessor) WeselyLandUse() NextData { return m.met.WeselyLandUse() }
+
+// Z0 helps fulfill the Preprocessor interface.
+func (m *MixedPreprocessor) Z0() NextData { return m.met.Z0() }
+
+// QRain helps fulfill the Preprocessor interface.
+func (m *MixedPreprocessor) QRain() NextData { return m.met.QRain() }
+
+// QCloud helps fulfill the Preprocessor interface.
+func (m *MixedPreprocessor) QCloud() NextData { return m.met.QCloud() }
+
+// CloudFrac helps fulfill the Preprocessor interface.
+func (m *MixedPreprocessor) CloudFrac() NextData { return m.met.CloudFrac() }
+
+// SurfaceHeatFlux helps fulfill the Preprocessor interface.
+func (m *MixedPreprocessor) SurfaceHeatFlux() NextData { return m.met.SurfaceHeatFlux() }
+
+// RadiationDown helps fulfill the Preprocessor interface.
+func (m *MixedPreprocessor) RadiationDown() NextData { return m.met.RadiationDown() }
+
+// U helps fulfill the Preprocessor interface.
+func (m *MixedPreprocessor) U() NextData { return m.met.U() }
+
+// V helps fulfill the Preprocessor interface.
+func (m *MixedPreprocessor) V() NextData { return m.met.V() }
+
+// W helps fulfill the Preprocessor interface.
+func (m *MixedPreprocessor) W() NextData { return m.met.W() }
+
+// AVOC helps fulfill the Preprocessor interface.
+func (m *MixedPreprocessor) AVOC() NextData { return m.regrid(m.chem.AVOC()) }
+
+// BVOC helps fulfill the Preprocessor interface.
+func (m *MixedPreprocessor) BVOC() NextData { return m.regrid(m.chem.BVOC()) }
+
+// ASOA helps fulfill the Preprocessor interface.
+func (m *MixedPreprocessor) ASOA() NextData { return m.regrid(m.chem.ASOA()) }
+
+// BSOA helps fulfill the Preprocessor interface.
+func (m *MixedPreprocessor) BSOA() NextData { return m.regrid(m.chem.BSOA()) }
+
+// NOx helps fulfill the Preprocessor interface.
+func (m *MixedPreprocessor) NOx() NextData { return m.regrid(m.chem.NOx()) }
+
+// PNO helps fulfill the Preprocessor interface.
+func (m *MixedPreprocessor) PNO() NextData { return m.regrid(m.chem.PNO()) }
+
+// SOx helps fulfill the Preprocessor interface.
+func (m *MixedPreprocessor) SOx() NextData { return m.regrid(m.chem.SOx()) }
+
+// PS helps fulfill the Preprocessor interface.
+func (m *MixedPreprocessor) PS() NextData { return m.regrid(m.chem.PS()) }
+
+// NH3 helps fulfill the Preprocessor interface.
+func (m *MixedPreprocessor) NH3() NextData { return m.regrid(m.chem.NH3()) }
+
+// PNH helps fulfill the Preprocessor interface.
+func (m *MixedPreprocessor) PNH() NextData { return m.regrid(m.chem.PNH()) }
+
+// TotalPM25 helps fulfill the Preprocessor interface.
+func (m *MixedPreprocessor) TotalPM25() NextData { return m.regrid(m.chem.TotalPM25()) }
+
+// HO helps fulfill the Preprocessor interface.
+func (m *MixedPreprocessor) HO() NextData { return m.regrid(m.chem.HO()) }
+
+// H2O2 helps fulfill the Preprocessor interface.
+func (m *MixedPreprocessor) H2O2() NextData { return m.regrid(m.chem.H2O2()) }
@@ -0,0 +1,281 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+import (
+	"io"
+	"math"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/ctessum/cdf"
+	"github.com/ctessum/sparse"
+)
+
+// Combining meteorology and chemistry from the same files on the
+// same grid should give the same results as using the files directly.
+func TestMixedPreprocessor(t *testing.T) {
+	const tolerance = 1.0e-6
+	os.Setenv("INMAP_ROOT_DIR", ".")
+
+	var cfg GenericNCFConfig
+	if _, err := toml.DecodeFile(netCDFMappingFile, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	cfg.FileTemplate = os.ExpandEnv(cfg.FileTemplate)
+	cfg.Role = "meteorology"
+	for method := range genericNCFChemMethods {
+		delete(cfg.Variables, method)
+	}
+	met, err := NewGenericNCFFromConfig(&cfg, "20050101", "20050103", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = met.NOx()(); err == nil {
+		t.Error("unspecified variables should cause an error")
+	}
+	chem, err := NewWRFChem("cmd/inmap/testdata/preproc/wrfout_d01_[DATE]", "20050101", "20050103", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grid := CTMGrid{Xo: -2004000, Yo: -540000, Dx: 12000, Dy: 12000}
+	m, err := NewMixedPreprocessor(met, chem, grid, grid, met.RecordInterval(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newData, err := Preprocess(m, grid.Xo, grid.Yo, grid.Dx, grid.Dy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open("cmd/inmap/testdata/preproc/inmapData_WRFChem_golden.ncf")
+	if err != nil {
+		t.Fatalf("opening golden file: %v", err)
+	}
+	defer f.Close()
+	goldenData, err := (&VarGridConfig{}).LoadCTMData(f)
+	if err != nil {
+		t.Fatalf("reading golden file: %v", err)
+	}
+	compareCTMData(goldenData, newData, tolerance, t)
+
+	if _, err = NewMixedPreprocessor(met, chem, grid, grid, met.RecordInterval(), 90*time.Minute); err == nil {
+		t.Error("incompatible record intervals should cause an error")
+	}
+	shifted := grid
+	shifted.Xo += 1.0e6
+	if _, err = NewMixedPreprocessor(met, chem, grid, shifted, met.RecordInterval(), time.Hour); err == nil {
+		t.Error("meteorology outside of the chemistry grid should cause an error")
+	}
+}
+
+func TestMixedPreprocessorRegrid(t *testing.T) {
+	t.Run("horizontal", func(t *testing.T) {
+		// The chemistry grid has 3x2 cells of size 2, and the meteorology grid
+		// has 6x4 cells of size 1 covering the same area.
+		m := &MixedPreprocessor{nx: 6, ny: 4, cnx: 3, cny: 2}
+		var err error
+		if m.xWeights, err = interpWeights(6, 0, 1, 3, 0, 2); err != nil {
+			t.Fatal(err)
+		}
+		if m.yWeights, err = interpWeights(4, 0, 1, 2, 0, 2); err != nil {
+			t.Fatal(err)
+		}
+		// The chemistry data are a linear function of the location.
+		in := sparse.ZerosDense(1, 2, 3)
+		for j := 0; j < 2; j++ {
+			for i := 0; i < 3; i++ {
+				x, y := 2*float64(i)+1, 2*float64(j)+1
+				in.Set(x+10*y, 0, j, i)
+			}
+		}
+		out, err := m.horizontal(testNextData([]*sparse.DenseArray{in}))()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j := 0; j < 4; j++ {
+			for i := 0; i < 6; i++ {
+				// Values beyond the outermost chemistry grid
+				// cell centers are not extrapolated.
+				x := math.Max(1, math.Min(5, float64(i)+0.5))
+				y := math.Max(1, math.Min(3, float64(j)+0.5))
+				if have, want := out.Get(0, j, i), x+10*y; math.Abs(have-want) > 1.0e-10 {
+					t.Errorf("(%d, %d): have %g, want %g", i, j, have, want)
+				}
+			}
+		}
+		if _, err := interpWeights(6, -1, 1, 3, 0, 2); err == nil {
+			t.Error("meteorology outside of the chemistry grid should cause an error")
+		}
+	})
+
+	t.Run("vertical", func(t *testing.T) {
+		data := sparse.ZerosDense(2, 1, 1)
+		data.Elements = []float64{1, 4}
+		from := sparse.ZerosDense(3, 1, 1)
+		from.Elements = []float64{0, 10, 30} // centers at 5 and 20
+		to := sparse.ZerosDense(5, 1, 1)
+		to.Elements = []float64{0, 5, 10, 20, 40} // centers at 2.5, 7.5, 15, and 30
+		have, err := verticalInterpolate(data, from, to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []float64{1, 1.5, 3, 4}
+		arrayCompare(have, &sparse.DenseArray{Shape: []int{4, 1, 1}, Elements: want}, 1.0e-10, "vertical", t)
+		if _, err := verticalInterpolate(data, to, to); err == nil {
+			t.Error("mismatched shapes should cause an error")
+		}
+	})
+}
+
+// counter returns n one-element records containing 0, 1, ..., n-1.
+func counter(n int) NextData {
+	var i int
+	return func() (*sparse.DenseArray, error) {
+		if i == n {
+			return nil, io.EOF
+		}
+		d := sparse.ZerosDense(1)
+		d.Elements[0] = float64(i)
+		i++
+		return d, nil
+	}
+}
+
+func TestNextDataResample(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		n        int
+		from, to time.Duration
+		want     []float64
+	}{
+		{name: "same", n: 3, from: time.Hour, to: time.Hour, want: []float64{0, 1, 2}},
+		{name: "repeat", n: 2, from: 3 * time.Hour, to: time.Hour, want: []float64{0, 0, 0, 1, 1, 1}},
+		{name: "skip", n: 7, from: time.Hour, to: 3 * time.Hour, want: []float64{0, 3, 6}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := nextDataResample(counter(test.n), test.from, test.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var have []float64
+			for {
+				d, err := f()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				have = append(have, d.Elements[0])
+			}
+			if !reflect.DeepEqual(have, test.want) {
+				t.Errorf("have %v, want %v", have, test.want)
+			}
+		})
+	}
+	if _, err := nextDataResample(counter(1), 2*time.Hour, 3*time.Hour); err == nil {
+		t.Error("incompatible intervals should cause an error")
+	}
+}
+
+func TestGenericNCFReader(t *testing.T) {
+	h := cdf.NewHeader([]string{"time", "level", "y"}, []int{0, 2, 1})
+	h.AddVariable("packed", []string{"time", "level", "y"}, []int16{})
+	h.AddAttribute("packed", "scale_factor", []float64{0.5})
+	h.AddAttribute("packed", "add_offset", []float64{10})
+	h.AddVariable("level", []string{"level"}, []float64{})
+	h.Define()
+	const file = "tempGenericNCF.ncf"
+	w, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file)
+	ff, err := cdf.Create(w, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rec, data := range [][]int16{{1, 2}, {3, 4}} {
+		for k, val := range data {
+			if _, err = ff.Writer("packed", []int{rec, k, 0}, []int{rec, k, 1}).Write([]int16{val}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err = ff.Writer("level", []int{0}, []int{2}).Write([]float64{1000, 850}); err != nil {
+		t.Fatal(err)
+	}
+	if err = cdf.UpdateNumRecs(w); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	r, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	ff, err = cdf.Open(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := genericNCFReader(false)("packed", ff, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	arrayCompare(data, &sparse.DenseArray{Shape: []int{2, 1}, Elements: []float64{11.5, 12}}, 0, "packed", t)
+
+	data, err = genericNCFReader(true)("level", ff, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = genericNCFBroadcast(testNextData([]*sparse.DenseArray{data}), 1, 2)()
+	if err != nil {
+		t.Fatal(err)
+	}
+	arrayCompare(data, &sparse.DenseArray{Shape: []int{2, 1, 2}, Elements: []float64{1000, 1000, 850, 850}}, 0, "level", t)
+}
+
+// The example reanalysis mappings should be valid.
+func TestGenericNCFMappingExamples(t *testing.T) {
+	for _, file := range []string{"cmd/inmap/netcdfMappingERA5.toml", "cmd/inmap/netcdfMappingMERRA2.toml"} {
+		t.Run(file, func(t *testing.T) {
+			gn := &GenericNCF{}
+			if _, err := toml.DecodeFile(file, &gn.cfg); err != nil {
+				t.Fatal(err)
+			}
+			var err error
+			if gn.recordDelta, err = time.ParseDuration(gn.cfg.RecordInterval); err != nil {
+				t.Fatal(err)
+			}
+			if gn.fileDelta, err = time.ParseDuration(gn.cfg.FileInterval); err != nil {
+				t.Fatal(err)
+			}
+			if gn.cfg.Role != "meteorology" {
+				t.Errorf("role: %s", gn.cfg.Role)
+			}
+			if err = gn.checkVariables(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
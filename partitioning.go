@@ -0,0 +1,52 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+// partitioningMass gives the gas- and particle-phase concentration variables
+// in CTMData that each gas/particle partitioning variable is calculated from.
+var partitioningMass = []struct {
+	partitioning, gas, particle string
+	cellVar                     func(c *Cell) *float64
+}{
+	{"aOrgPartitioning", "aVOC", "aSOA", func(c *Cell) *float64 { return &c.AOrgPartitioning }},
+	{"bOrgPartitioning", "bVOC", "bSOA", func(c *Cell) *float64 { return &c.BOrgPartitioning }},
+	{"NOPartitioning", "gNO", "pNO", func(c *Cell) *float64 { return &c.NOPartitioning }},
+	{"SPartitioning", "gS", "pS", func(c *Cell) *float64 { return &c.SPartitioning }},
+	{"NHPartitioning", "gNH", "pNH", func(c *Cell) *float64 { return &c.NHPartitioning }},
+}
+
+// massWeightPartitioning sets the gas/particle partitioning fractions in c
+// to averages of the values in ctmcells weighted by their area fractions
+// times their total gas- plus particle-phase mass. Partitioning fractions
+// in cells with no mass remain area-weighted.
+func (c *Cell) massWeightPartitioning(data *CTMData, k int, ctmcells []*gridCellLight, fractions []float64) {
+	for _, pm := range partitioningMass {
+		var sum, weight float64
+		for i, cc := range ctmcells {
+			mass := data.Data[pm.gas].Data.Get(k, cc.Row, cc.Col) +
+				data.Data[pm.particle].Data.Get(k, cc.Row, cc.Col)
+			w := fractions[i] * mass
+			sum += data.Data[pm.partitioning].Data.Get(k, cc.Row, cc.Col) * w
+			weight += w
+		}
+		if weight > 0 {
+			*pm.cellVar(c) = sum / weight
+		}
+	}
+}
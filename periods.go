@@ -0,0 +1,156 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimePeriod is a portion of the time window that chemical transport
+// model data are preprocessed for.
+type TimePeriod struct {
+	// Name identifies the time period. It is in the format YYYYMM for
+	// months and YYYYSSS for seasons, where SSS is DJF, MAM, JJA, or SON
+	// and YYYY is the year of the first month of the season.
+	Name string
+
+	// Start and End are the beginning and end of the period. The
+	// period includes Start but not End.
+	Start, End time.Time
+}
+
+var seasonNames = [4]string{"DJF", "MAM", "JJA", "SON"}
+
+// SplitTimePeriods splits the time window between start and end
+// into periods. Valid options for periodType are "all" for a
+// single period that spans the whole window, "seasonal" for
+// meteorological seasons (December–February, March–May,
+// June–August, and September–November), and "monthly" for calendar months.
+// The first and last periods are truncated to the time window.
+func SplitTimePeriods(start, end time.Time, periodType string) ([]TimePeriod, error) {
+	if !end.After(start) {
+		return nil, fmt.Errorf("inmap: time period end %v is not after start %v", end, start)
+	}
+	var next func(t time.Time) (name string, periodEnd time.Time)
+	switch periodType {
+	case "all":
+		return []TimePeriod{{Name: "all", Start: start, End: end}}, nil
+	case "monthly":
+		next = func(t time.Time) (string, time.Time) {
+			return t.Format("200601"), time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		}
+	case "seasonal":
+		next = func(t time.Time) (string, time.Time) {
+			// Shift the months so that December is the first month
+			// of the first season.
+			m := int(t.Month()) % 12 // December = 0
+			year := t.Year()
+			if t.Month() == time.December {
+				year++
+			}
+			first := year*12 + (m/3)*3 - 1 // months since year 0 of the first month of the season
+			endMonth := first + 3
+			name := fmt.Sprintf("%d%s", first/12, seasonNames[m/3])
+			return name, time.Date(endMonth/12, time.Month(endMonth%12+1), 1, 0, 0, 0, 0, t.Location())
+		}
+	default:
+		return nil, fmt.Errorf("inmap: invalid time period type '%s'; valid options are 'all', 'seasonal', and 'monthly'", periodType)
+	}
+	var periods []TimePeriod
+	for t := start; t.Before(end); {
+		name, periodEnd := next(t)
+		if periodEnd.After(end) {
+			periodEnd = end
+		}
+		periods = append(periods, TimePeriod{Name: name, Start: t, End: periodEnd})
+		t = periodEnd
+	}
+	return periods, nil
+}
+
+// PeriodAverager combines the results of steady-state simulations of
+// several time periods into results for the whole time window by
+// averaging them, weighted by the length of each period. All of the
+// simulations must use the same grid.
+type PeriodAverager struct {
+	sum    map[string][]float64
+	weight time.Duration
+}
+
+// Add returns a function that adds the results of a simulation,
+// calculated using o, for a period with the given length to the
+// average. It should be run after the simulation is complete.
+func (p *PeriodAverager) Add(o *Outputter, length time.Duration) DomainManipulator {
+	return func(d *InMAP) error {
+		if length <= 0 {
+			return fmt.Errorf("inmap: averaging time periods: invalid period length %v", length)
+		}
+		results, err := d.Results(o)
+		if err != nil {
+			return err
+		}
+		if p.sum == nil {
+			p.sum = make(map[string][]float64)
+			for k, v := range results {
+				p.sum[k] = make([]float64, len(v))
+			}
+		}
+		w := length.Hours()
+		for k, v := range results {
+			sum, ok := p.sum[k]
+			if !ok || len(sum) != len(v) {
+				return fmt.Errorf("inmap: averaging time periods: results for variable %s do not match those of the previous periods; "+
+					"all periods must have the same grid and output variables", k)
+			}
+			for i, val := range v {
+				sum[i] += val * w
+			}
+		}
+		p.weight += length
+		return nil
+	}
+}
+
+// Results returns the period-weighted average results
+// in the form of map[variable][row]value.
+func (p *PeriodAverager) Results() map[string][]float64 {
+	o := make(map[string][]float64, len(p.sum))
+	w := p.weight.Hours()
+	for k, sum := range p.sum {
+		o[k] = make([]float64, len(sum))
+		for i, v := range sum {
+			o[k][i] = v / w
+		}
+	}
+	return o
+}
+
+// SetResults returns a function that causes o to use the period-weighted
+// average results rather than the results of the current simulation,
+// for example when writing output with o.Output or o.DisparityOutput.
+func (p *PeriodAverager) SetResults(o *Outputter) DomainManipulator {
+	return func(d *InMAP) error {
+		if p.weight == 0 {
+			return fmt.Errorf("inmap: averaging time periods: no results have been added")
+		}
+		o.results = p.Results()
+		return nil
+	}
+}
@@ -0,0 +1,173 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSplitTimePeriods(t *testing.T) {
+	date := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		periodType string
+		start, end time.Time
+		names      []string
+		ends       []time.Time
+	}{
+		{
+			periodType: "all",
+			start:      date(2016, 1, 1), end: date(2017, 1, 1),
+			names: []string{"all"},
+			ends:  []time.Time{date(2017, 1, 1)},
+		},
+		{
+			periodType: "monthly",
+			start:      date(2016, 1, 15), end: date(2016, 3, 10),
+			names: []string{"201601", "201602", "201603"},
+			ends:  []time.Time{date(2016, 2, 1), date(2016, 3, 1), date(2016, 3, 10)},
+		},
+		{
+			periodType: "seasonal",
+			start:      date(2016, 1, 1), end: date(2017, 1, 1),
+			names: []string{"2015DJF", "2016MAM", "2016JJA", "2016SON", "2016DJF"},
+			ends:  []time.Time{date(2016, 3, 1), date(2016, 6, 1), date(2016, 9, 1), date(2016, 12, 1), date(2017, 1, 1)},
+		},
+		{
+			periodType: "seasonal",
+			start:      date(2016, 12, 5), end: date(2017, 3, 2),
+			names: []string{"2016DJF", "2017MAM"},
+			ends:  []time.Time{date(2017, 3, 1), date(2017, 3, 2)},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.periodType, func(t *testing.T) {
+			periods, err := SplitTimePeriods(test.start, test.end, test.periodType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(periods) != len(test.names) {
+				t.Fatalf("have %d periods, want %d: %+v", len(periods), len(test.names), periods)
+			}
+			start := test.start
+			for i, p := range periods {
+				if p.Name != test.names[i] || !p.Start.Equal(start) || !p.End.Equal(test.ends[i]) {
+					t.Errorf("period %d: have %+v, want {%s %v %v}", i, p, test.names[i], start, test.ends[i])
+				}
+				start = p.End
+			}
+		})
+	}
+
+	if _, err := SplitTimePeriods(date(2016, 1, 1), date(2017, 1, 1), "weekly"); err == nil {
+		t.Error("invalid period type should cause an error")
+	}
+	if _, err := SplitTimePeriods(date(2016, 1, 1), date(2016, 1, 1), "monthly"); err == nil {
+		t.Error("empty time window should cause an error")
+	}
+}
+
+func TestCTMDataTimePeriod(t *testing.T) {
+	cfg, data := CreateTestCTMData()
+	data.Start = time.Date(2016, time.June, 1, 0, 0, 0, 0, time.UTC)
+	data.End = time.Date(2016, time.September, 1, 0, 0, 0, 0, time.UTC)
+	const file = "tempCTMDataPeriod.ncf"
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file)
+	if err = data.Write(f); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	data2, err := cfg.LoadCTMData(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if !data2.Start.Equal(data.Start) || !data2.End.Equal(data.End) {
+		t.Errorf("have %v–%v, want %v–%v", data2.Start, data2.End, data.Start, data.End)
+	}
+}
+
+func TestPeriodAverager(t *testing.T) {
+	cfg, ctmdata, pop, popIndices, mr, mortIndices := VarGridTestData()
+	m := Mech{}
+	d := &InMAP{
+		InitFuncs: []DomainManipulator{
+			cfg.RegularGrid(ctmdata, pop, popIndices, mr, mortIndices, NewEmissions(), m),
+		},
+	}
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+	newOutputter := func() *Outputter {
+		o, err := NewOutputter("", false, map[string]string{"TotalPM25": "TotalPM25"}, nil, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return o
+	}
+
+	var avg PeriodAverager
+	if err := avg.SetResults(newOutputter())(d); err == nil {
+		t.Error("setting results with no periods should cause an error")
+	}
+	// The concentrations are 1 for a 30-day period and 4 for a 10-day period.
+	for _, p := range []struct {
+		conc float64
+		days time.Duration
+	}{{1, 30}, {4, 10}} {
+		for _, c := range d.cells.array() {
+			c.Cf[iPM2_5] = p.conc
+		}
+		if err := avg.Add(newOutputter(), p.days*24*time.Hour)(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	o := newOutputter()
+	if err := avg.SetResults(o)(d); err != nil {
+		t.Fatal(err)
+	}
+	// Results should be returned even though the
+	// concentrations have changed.
+	for _, c := range d.cells.array() {
+		c.Cf[iPM2_5] = 100
+	}
+	r, err := d.Results(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = (1*30 + 4*10) / 40.
+	for i, v := range r["TotalPM25"] {
+		if different(v, want, 1.e-10) {
+			t.Errorf("cell %d: have %g, want %g", i, v, want)
+		}
+	}
+
+	if err := avg.Add(newOutputter(), 0)(d); err == nil {
+		t.Error("zero period length should cause an error")
+	}
+}
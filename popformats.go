@@ -0,0 +1,466 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ctessum/geom"
+	"github.com/ctessum/geom/encoding/geojson"
+	"github.com/ctessum/geom/index/rtree"
+	"github.com/ctessum/geom/proj"
+	"github.com/evookelj/inmap/internal/geotiff"
+)
+
+// loadPopulationGeoTIFF loads population information from a GeoTIFF
+// file with geographic (longitude-latitude) coordinates, converting it
+// to spatial reference sr and discarding any pixels that do not overlap
+// with bounds. Each of the CensusPopColumns must be in a separate band of
+// the file; the bands are matched to the columns by their descriptions or,
+// if the bands do not have descriptions, they are assumed to be in the
+// same order as the columns. The function outputs an index holding the
+// population information and a map giving the array index of each
+// population type.
+func (config *VarGridConfig) loadPopulationGeoTIFF(sr *proj.SR, bounds *geom.Bounds) (*rtree.Rtree, map[string]int, error) {
+	polys, data, err := readGeoTIFFPolygons(config.CensusFile, config.CensusPopColumns, sr, bounds)
+	if err != nil {
+		return nil, nil, fmt.Errorf("inmap: reading GeoTIFF CensusFile: %v", err)
+	}
+	index := rtree.NewTree(25, 50)
+	for i, g := range polys {
+		pops := make([]float64, len(config.CensusPopColumns))
+		var nonZero bool
+		for j := range config.CensusPopColumns {
+			v := data[j][i]
+			if math.IsNaN(v) || v == 0 {
+				continue
+			}
+			nonZero = true
+			pops[j] = v
+		}
+		if nonZero {
+			index.Insert(&population{Polygonal: g, PopData: pops})
+		}
+	}
+	return index, config.popIndices(), nil
+}
+
+// loadMortalityGeoTIFF loads mortality rate information from a GeoTIFF
+// file with geographic (longitude-latitude) coordinates, converting it
+// to spatial reference sr and discarding any pixels that do not overlap
+// with bounds or that are missing data. The bands are matched to the
+// MortalityRateColumns in the same way as in loadPopulationGeoTIFF, with
+// bands without descriptions assumed to be in the alphabetical order of
+// the column names. The function outputs an index holding the mortality
+// rate information and a map giving the array index of each mortality rate.
+func (config *VarGridConfig) loadMortalityGeoTIFF(sr *proj.SR, bounds *geom.Bounds) (*rtree.Rtree, map[string]int, error) {
+	mortRateColumns, mortIndices := config.mortalityColumns()
+	polys, data, err := readGeoTIFFPolygons(config.MortalityRateFile, mortRateColumns, sr, bounds)
+	if err != nil {
+		return nil, nil, fmt.Errorf("inmap: reading GeoTIFF MortalityRateFile: %v", err)
+	}
+	index := rtree.NewTree(25, 50)
+pixels:
+	for i, g := range polys {
+		m := &mortality{Polygonal: g, MortData: make([]float64, len(mortRateColumns)), weight: 1, region: i}
+		for j := range mortRateColumns {
+			if math.IsNaN(data[j][i]) {
+				continue pixels
+			}
+			m.MortData[j] = data[j][i]
+		}
+		index.Insert(m)
+	}
+	return index, mortIndices, nil
+}
+
+// readGeoTIFFPolygons reads the bands matching names from the GeoTIFF
+// file fname and returns the pixels that overlap bounds, converted to
+// polygons in spatial reference sr, as well as the data for each
+// band and pixel.
+func readGeoTIFFPolygons(fname string, names []string, sr *proj.SR, bounds *geom.Bounds) ([]geom.Polygonal, [][]float64, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	g, err := geotiff.Read(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	if g.Projected {
+		return nil, nil, fmt.Errorf("inmap: only GeoTIFF files with geographic (longitude-latitude) " +
+			"coordinates are supported")
+	}
+	bands, err := g.BandIndices(names)
+	if err != nil {
+		return nil, nil, err
+	}
+	llSR, err := proj.Parse("+proj=longlat")
+	if err != nil {
+		return nil, nil, err
+	}
+	ct, err := llSR.NewTransform(sr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating GeoTIFF transform: %v", err)
+	}
+
+	// Only read the part of the file that overlaps bounds.
+	llBounds, err := geographicBounds(sr, llSR, bounds)
+	if err != nil {
+		return nil, nil, err
+	}
+	if llBounds != nil {
+		// Add a buffer of one pixel.
+		llBounds.Min.X -= g.Dx
+		llBounds.Min.Y -= g.Dy
+		llBounds.Max.X += g.Dx
+		llBounds.Max.Y += g.Dy
+	}
+	x0, y0, x1, y1 := g.Window(llBounds)
+	data, err := g.ReadBands(bands, x0, y0, x1, y1)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var polys []geom.Polygonal
+	o := make([][]float64, len(bands))
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			i := (y-y0)*(x1-x0) + x - x0
+			allMissing := true
+			for b := range bands {
+				if !math.IsNaN(data[b][i]) {
+					allMissing = false
+				}
+			}
+			if allMissing {
+				continue
+			}
+			p, err := g.Pixel(x, y).Transform(ct)
+			if err != nil {
+				return nil, nil, err
+			}
+			if bounds != nil && !bounds.Overlaps(p.Bounds()) {
+				continue
+			}
+			polys = append(polys, p.(geom.Polygonal))
+			for b := range bands {
+				o[b] = append(o[b], data[b][i])
+			}
+		}
+	}
+	return polys, o, nil
+}
+
+// geographicBounds returns the geographic (longitude-latitude)
+// bounding box of b, which is in spatial reference sr. It returns nil if b is nil.
+// It can also be used to get the bounding box in other spatial references
+// by passing them as llSR.
+func geographicBounds(sr, llSR *proj.SR, b *geom.Bounds) (*geom.Bounds, error) {
+	if b == nil {
+		return nil, nil
+	}
+	ct, err := sr.NewTransform(llSR)
+	if err != nil {
+		return nil, fmt.Errorf("creating geographic transform: %v", err)
+	}
+	if ct == nil { // The spatial references are the same.
+		o := *b
+		return &o, nil
+	}
+	// Sample points along the edges of b, because straight lines
+	// may become curved in the new spatial reference.
+	const n = 20
+	o := geom.NewBounds()
+	for i := 0; i <= n; i++ {
+		fx := b.Min.X + (b.Max.X-b.Min.X)*float64(i)/n
+		fy := b.Min.Y + (b.Max.Y-b.Min.Y)*float64(i)/n
+		for _, p := range []geom.Point{{X: fx, Y: b.Min.Y}, {X: fx, Y: b.Max.Y}, {X: b.Min.X, Y: fy}, {X: b.Max.X, Y: fy}} {
+			x, y, err := ct(p.X, p.Y)
+			if err != nil {
+				return nil, err
+			}
+			o.Extend(geom.Point{X: x, Y: y}.Bounds())
+		}
+	}
+	return o, nil
+}
+
+// loadPopulationFeatures loads population information from a CSV file of
+// points or a GeoJSON file of points or polygons, converting it
+// to spatial reference sr and then discarding any geometries that do not
+// overlap with bounds. The function outputs an index holding the population
+// information and a map giving the array index of each population type.
+func (config *VarGridConfig) loadPopulationFeatures(sr *proj.SR, bounds *geom.Bounds) (*rtree.Rtree, map[string]int, error) {
+	geoms, data, err := readFeatures(config.CensusFile, config.CensusPopColumns, sr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("inmap: reading CensusFile: %v", err)
+	}
+	index := rtree.NewTree(25, 50)
+	for i, g := range geoms {
+		p := &population{PopData: data[i]}
+		for _, v := range p.PopData {
+			if math.IsNaN(v) {
+				return nil, nil, fmt.Errorf("inmap: loadPopulation: NaN population value")
+			}
+		}
+		switch gg := g.(type) {
+		case geom.Point:
+			p.Polygonal = config.pointPolygon(gg)
+			p.point = true
+		case geom.Polygonal:
+			p.Polygonal = gg
+		default:
+			return nil, nil, fmt.Errorf("inmap: loadPopulation: population shapes need to be points or polygons")
+		}
+		if bounds == nil || bounds.Overlaps(p.Bounds()) {
+			index.Insert(p)
+		}
+	}
+	return index, config.popIndices(), nil
+}
+
+// loadMortalityFeatures loads mortality rate information from a CSV file of
+// points or a GeoJSON file of points or polygons, converting it
+// to spatial reference sr. The function outputs an index holding the mortality
+// rate information and a map giving the array index of each mortality rate.
+func (config *VarGridConfig) loadMortalityFeatures(sr *proj.SR) (*rtree.Rtree, map[string]int, error) {
+	mortRateColumns, mortIndices := config.mortalityColumns()
+	geoms, data, err := readFeatures(config.MortalityRateFile, mortRateColumns, sr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("inmap: reading MortalityRateFile: %v", err)
+	}
+	index := rtree.NewTree(25, 50)
+	for i, g := range geoms {
+		m := &mortality{MortData: data[i], weight: 1, region: i}
+		for _, v := range m.MortData {
+			if math.IsNaN(v) {
+				return nil, nil, fmt.Errorf("inmap: loadMortality: NaN mortality rate")
+			}
+		}
+		switch gg := g.(type) {
+		case geom.Point:
+			m.Polygonal = config.pointPolygon(gg)
+			m.point = true
+		case geom.Polygonal:
+			m.Polygonal = gg
+		default:
+			return nil, nil, fmt.Errorf("inmap: loadMortality: mortality rate shapes need to be points or polygons")
+		}
+		index.Insert(m)
+	}
+	return index, mortIndices, nil
+}
+
+// popIndices returns a map giving the array index of each population type.
+func (config *VarGridConfig) popIndices() map[string]int {
+	popIndices := make(map[string]int)
+	for i, p := range config.CensusPopColumns {
+		popIndices[p] = i
+	}
+	return popIndices
+}
+
+// pointPolygon returns a very small square centered on p, which
+// allows data located at points to be allocated to grid cells in the same
+// way as data in polygons.
+func (config *VarGridConfig) pointPolygon(p geom.Point) geom.Polygon {
+	d := config.VariableGridDx * 1.e-6
+	return geom.Polygon{{
+		{X: p.X - d, Y: p.Y - d},
+		{X: p.X + d, Y: p.Y - d},
+		{X: p.X + d, Y: p.Y + d},
+		{X: p.X - d, Y: p.Y + d},
+		{X: p.X - d, Y: p.Y - d},
+	}}
+}
+
+// readFeatures reads geometries and the data in the given columns from
+// a CSV or GeoJSON file (determined by file extension), converting them to
+// spatial reference sr.
+//
+// CSV files must have a header row and contain point locations in columns
+// named "x" and "y", "lon" and "lat", or "longitude" and "latitude"
+// (case insensitive). If a file with the same name as the CSV file but
+// ending in ".prj" exists, it specifies the spatial reference of the points;
+// otherwise they are assumed to be longitude-latitude coordinates.
+//
+// GeoJSON files must contain a FeatureCollection of Point, Polygon, or
+// MultiPolygon features with the data in their properties, and are assumed
+// to be in longitude-latitude coordinates.
+func readFeatures(fname string, columns []string, sr *proj.SR) ([]geom.Geom, [][]float64, error) {
+	var geoms []geom.Geom
+	var data [][]float64
+	var inSR *proj.SR
+	var err error
+	if strings.ToLower(filepath.Ext(fname)) == ".csv" {
+		geoms, data, err = readPointsCSV(fname, columns)
+		if err != nil {
+			return nil, nil, err
+		}
+		prj, err := ioutil.ReadFile(strings.TrimSuffix(fname, filepath.Ext(fname)) + ".prj")
+		if err == nil {
+			if inSR, err = proj.Parse(string(prj)); err != nil {
+				return nil, nil, fmt.Errorf("parsing .prj file: %v", err)
+			}
+		} else if !os.IsNotExist(err) {
+			return nil, nil, err
+		}
+	} else {
+		geoms, data, err = readGeoJSONFeatures(fname, columns)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if inSR == nil {
+		if inSR, err = proj.Parse("+proj=longlat"); err != nil {
+			return nil, nil, err
+		}
+	}
+	ct, err := inSR.NewTransform(sr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating transform: %v", err)
+	}
+	for i, g := range geoms {
+		if geoms[i], err = g.Transform(ct); err != nil {
+			return nil, nil, err
+		}
+	}
+	return geoms, data, nil
+}
+
+// readPointsCSV reads point locations and the data in the given columns
+// from a CSV file.
+func readPointsCSV(fname string, columns []string) ([]geom.Geom, [][]float64, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	header, err := r.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading CSV header: %v", err)
+	}
+	colIndex := make(map[string]int)
+	for i, h := range header {
+		colIndex[strings.TrimSpace(h)] = i
+	}
+	xi, yi := -1, -1
+	for i, h := range header {
+		switch strings.ToLower(strings.TrimSpace(h)) {
+		case "x", "lon", "longitude":
+			xi = i
+		case "y", "lat", "latitude":
+			yi = i
+		}
+	}
+	if xi < 0 || yi < 0 {
+		return nil, nil, fmt.Errorf("CSV file must have columns named x and y, lon and lat, or longitude and latitude")
+	}
+	dataIndex := make([]int, len(columns))
+	for i, c := range columns {
+		j, ok := colIndex[c]
+		if !ok {
+			return nil, nil, fmt.Errorf("missing CSV column %s", c)
+		}
+		dataIndex[i] = j
+	}
+	var geoms []geom.Geom
+	var data [][]float64
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("reading CSV file: %v", err)
+		}
+		x, err := s2f(rec[xi])
+		if err != nil {
+			return nil, nil, err
+		}
+		y, err := s2f(rec[yi])
+		if err != nil {
+			return nil, nil, err
+		}
+		d := make([]float64, len(columns))
+		for i, j := range dataIndex {
+			if d[i], err = s2f(rec[j]); err != nil {
+				return nil, nil, err
+			}
+		}
+		geoms = append(geoms, geom.Point{X: x, Y: y})
+		data = append(data, d)
+	}
+	return geoms, data, nil
+}
+
+// readGeoJSONFeatures reads geometries and the data in the given columns
+// from a GeoJSON FeatureCollection file.
+func readGeoJSONFeatures(fname string, columns []string) ([]geom.Geom, [][]float64, error) {
+	b, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, nil, err
+	}
+	var fc struct {
+		Type     string
+		Features []struct {
+			Geometry   geojson.Geometry
+			Properties map[string]interface{}
+		}
+	}
+	if err := json.Unmarshal(b, &fc); err != nil {
+		return nil, nil, fmt.Errorf("decoding GeoJSON: %v", err)
+	}
+	if fc.Type != "FeatureCollection" {
+		return nil, nil, fmt.Errorf("GeoJSON file must contain a FeatureCollection but it contains a %s", fc.Type)
+	}
+	geoms := make([]geom.Geom, len(fc.Features))
+	data := make([][]float64, len(fc.Features))
+	for i, f := range fc.Features {
+		if geoms[i], err = geojson.FromGeoJSON(&f.Geometry); err != nil {
+			return nil, nil, err
+		}
+		data[i] = make([]float64, len(columns))
+		for j, c := range columns {
+			switch v := f.Properties[c].(type) {
+			case float64:
+				data[i][j] = v
+			case string:
+				if data[i][j], err = s2f(v); err != nil {
+					return nil, nil, err
+				}
+			case nil:
+				return nil, nil, fmt.Errorf("missing GeoJSON property %s", c)
+			default:
+				return nil, nil, fmt.Errorf("invalid value for GeoJSON property %s: %v", c, v)
+			}
+		}
+	}
+	return geoms, data, nil
+}
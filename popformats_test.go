@@ -0,0 +1,261 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ctessum/geom/proj"
+	"github.com/evookelj/inmap/internal/geotiff"
+)
+
+// popMortGrid creates a grid from the test CTM data and the given
+// population and mortality rate files.
+func popMortGrid(censusFile, mortalityFile string) ([]*Cell, error) {
+	cfg, ctmdata := CreateTestCTMData()
+	cfg.CensusFile = censusFile
+	cfg.MortalityRateFile = mortalityFile
+	pop, popIndices, mr, mortIndices, err := cfg.LoadPopMort()
+	if err != nil {
+		return nil, err
+	}
+	d := &InMAP{
+		InitFuncs: []DomainManipulator{
+			cfg.RegularGrid(ctmdata, pop, popIndices, mr, mortIndices, NewEmissions(), Mech{}),
+		},
+	}
+	if err := d.Init(); err != nil {
+		return nil, err
+	}
+	var cells []*Cell
+	for _, c := range d.cells.array() {
+		if c.Layer == 0 {
+			cells = append(cells, c)
+		}
+	}
+	return cells, nil
+}
+
+func TestLoadPopulationPoints(t *testing.T) {
+	WriteTestMortalityShapefile()
+	defer DeleteShapefile(TestMortalityShapefile)
+
+	// Write the points in the grid spatial reference.
+	const csvFile = "tempPopulation.csv"
+	if err := ioutil.WriteFile(csvFile, []byte(`x,y,TotalPop,WhiteNoLat,Black,Native,Asian,Latino
+-3950,-3950,100000,50000,20000,2000,8000,20000
+-3500,-3000,0,0,0,0,0,10000
+2000,2000,10,10,0,0,0,0
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(csvFile)
+	if err := ioutil.WriteFile("tempPopulation.prj", []byte(TestGridSR), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove("tempPopulation.prj")
+
+	// Write the same points in longitude-latitude coordinates.
+	cfg, _ := CreateTestCTMData()
+	gridSR, err := proj.Parse(cfg.GridProj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	llSR, err := proj.Parse("+proj=longlat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct, err := gridSR.NewTransform(llSR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var features []string
+	for _, p := range [][3]float64{{-3950, -3950, 100000}, {2000, 2000, 10}} {
+		x, y, err := ct(p[0], p[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		features = append(features, fmt.Sprintf(`{"type":"Feature","geometry":{"type":"Point","coordinates":[%.12f,%.12f]},`+
+			`"properties":{"TotalPop":%g,"WhiteNoLat":0,"Black":0,"Native":0,"Asian":0,"Latino":"0"}}`, x, y, p[2]))
+	}
+	const geojsonFile = "tempPopulation.geojson"
+	if err := ioutil.WriteFile(geojsonFile, []byte(`{"type":"FeatureCollection","features":[`+
+		strings.Join(features, ",")+`]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(geojsonFile)
+
+	for _, f := range []string{csvFile, geojsonFile} {
+		t.Run(f, func(t *testing.T) {
+			cells, err := popMortGrid(f, TestMortalityShapefile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var totalPop float64
+			for _, c := range cells {
+				totalPop += c.PopData[0]
+				b := c.Bounds()
+				switch {
+				case b.Min.X == -4000 && b.Min.Y == -4000:
+					if different(c.PopData[0], 100000, 1.e-8) {
+						t.Errorf("lower left cell population: %g", c.PopData[0])
+					}
+					if !c.AboveDensityThreshold {
+						t.Error("lower left cell should be above the density threshold")
+					}
+				case b.Min.X == 0 && b.Min.Y == 0:
+					if different(c.PopData[0], 10, 1.e-8) {
+						t.Errorf("upper right cell population: %g", c.PopData[0])
+					}
+					if c.AboveDensityThreshold {
+						t.Error("upper right cell should not be above the density threshold")
+					}
+				}
+			}
+			if different(totalPop, 100010, 1.e-8) {
+				t.Errorf("total population: %g", totalPop)
+			}
+		})
+	}
+}
+
+func TestLoadMortalityPoints(t *testing.T) {
+	WriteTestPopShapefile()
+	defer DeleteShapefile(TestPopulationShapefile)
+	const csvFile = "tempMortality.csv"
+	if err := ioutil.WriteFile(csvFile, []byte(`x,y,AllCause,WhNoLMort,BlackMort,NativeMort,AsianMort,LatinoMort
+-3950,-3950,500,500,500,500,500,500
+-3500,-2900,900,900,900,900,900,900
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(csvFile)
+	if err := ioutil.WriteFile("tempMortality.prj", []byte(TestGridSR), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove("tempMortality.prj")
+
+	cells, err := popMortGrid(TestPopulationShapefile, csvFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, _ := CreateTestCTMData()
+	_, mortIndices := cfg.mortalityColumns()
+	for _, c := range cells {
+		if c.PopData[0] > 0 && different(c.MortData[mortIndices["AllCause"]], 500, 1.e-8) {
+			t.Errorf("AllCause mortality rate: have %g, want 500", c.MortData[mortIndices["AllCause"]])
+		}
+		if c.PopData[5] > 0 && c.PopData[0] == 0 && different(c.MortData[mortIndices["LatinoMort"]], 900, 1.e-8) {
+			t.Errorf("LatinoMort mortality rate: have %g, want 900", c.MortData[mortIndices["LatinoMort"]])
+		}
+	}
+
+	cfg.MortalityRateFile = csvFile
+	cfg.BaselineHR = "NasariACS"
+	pop, popIndices, mr, mortIndices2, err := cfg.LoadPopMort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := new(Cell)
+	c.Polygonal = cfg.cellGeometry([][2]int{{0, 0}})
+	if err := c.loadPopMortalityRate(&cfg, nil, mr, mortIndices2, pop, popIndices); err == nil {
+		t.Error("point mortality rates with BaselineHR should cause an error")
+	}
+}
+
+func TestLoadPopulationGeoTIFF(t *testing.T) {
+	WriteTestMortalityShapefile()
+	defer DeleteShapefile(TestMortalityShapefile)
+
+	// Write a raster that covers the test grid, which spans about
+	// ±0.047° longitude and ±0.036° latitude around -97°, 40°.
+	const (
+		fname = "tempPopulation.tif"
+		n     = 20
+		pop   = 1000
+	)
+	tg := geotiff.TestFile{
+		Width: n, Height: n,
+		X0: -97.1, Y0: 40.1, Dx: 0.01, Dy: 0.01,
+		Names: []string{"Latino", "TotalPop"},
+	}
+	tg.Bands = make([][]float32, 2)
+	for i := range tg.Bands {
+		tg.Bands[i] = make([]float32, n*n)
+		for j := range tg.Bands[i] {
+			tg.Bands[i][j] = pop
+		}
+	}
+	if err := tg.Write(fname); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fname)
+
+	cfg, _ := CreateTestCTMData()
+	cfg.CensusFile = fname
+	if _, _, _, _, err := cfg.LoadPopMort(); err == nil {
+		t.Error("missing bands should cause an error")
+	}
+	cfg.CensusPopColumns = []string{"TotalPop", "Latino"}
+	gridSR, err := proj.Parse(cfg.GridProj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	popTree, popIndex, err := cfg.loadPopulation(gridSR, cfg.bounds())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the pixels that overlap the grid should be loaded.
+	if size := popTree.Size(); size == 0 || size >= n*n {
+		t.Errorf("number of pixels: %d", size)
+	}
+
+	cfg.MortalityRateFile = TestMortalityShapefile
+	_, ctmdata := CreateTestCTMData()
+	pp, _, mr, mortIndices, err := cfg.LoadPopMort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &InMAP{
+		InitFuncs: []DomainManipulator{
+			cfg.RegularGrid(ctmdata, pp, popIndex, mr, mortIndices, NewEmissions(), Mech{}),
+		},
+	}
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+	var totalPop float64
+	for _, c := range d.cells.array() {
+		totalPop += c.PopData[popIndex["TotalPop"]]
+		if c.PopData[popIndex["TotalPop"]] != c.PopData[popIndex["Latino"]] {
+			t.Errorf("populations should be the same: %v", c.PopData)
+		}
+	}
+	// The pixels are about 0.94 km² and the grid is 64 km².
+	pixelArea := 0.01 * math.Pi / 180 * 6370997 * math.Cos(40*math.Pi/180) * 0.01 * math.Pi / 180 * 6370997
+	want := pop * 64.e6 / pixelArea
+	if different(totalPop, want, 0.05) {
+		t.Errorf("total population: have %g, want about %g", totalPop, want)
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"log"
 	"math"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
@@ -157,12 +158,45 @@ type Preprocessor interface {
 	H2O2() NextData
 }
 
+// LandUseFractioner can optionally be implemented by a Preprocessor
+// to specify the fraction of each grid cell covered by each land use
+// category. When it is implemented, dry deposition velocities are
+// the area-weighted averages of the velocities for each category,
+// and the SeinfeldLandUse and WeselyLandUse methods are not used.
+type LandUseFractioner interface {
+	// SeinfeldLandUseFractions is the fraction of each grid cell covered
+	// by each of the land use categories specified in
+	// github.com/ctessum/atmos/seinfeld, with dimensions [category, y, x].
+	SeinfeldLandUseFractions() NextData
+	// WeselyLandUseFractions is the fraction of each grid cell covered
+	// by each of the land use categories specified in
+	// github.com/ctessum/atmos/wesely1989, with dimensions [category, y, x].
+	WeselyLandUseFractions() NextData
+}
+
+// landUseFractions returns the land use fractions from p, converting
+// the land use categories of p to fractions if p does not implement
+// LandUseFractioner.
+func landUseFractions(p Preprocessor) (seinfeldFunc, weselyFunc NextData) {
+	if f, ok := p.(LandUseFractioner); ok {
+		return f.SeinfeldLandUseFractions(), f.WeselyLandUseFractions()
+	}
+	return landUseCategoryFractions(p.SeinfeldLandUse(), nSeinfeldLandUse),
+		landUseCategoryFractions(p.WeselyLandUse(), nWeselyLandUse)
+}
+
 // Preprocess returns preprocessed InMAP input data
 // based on the information available from the given
 // preprocessor. x0 and y0 are the left and y coordinates of the
 // lower-left corner of the domain, and dx and dy are the x and y edge
 // lengths of the grid cells, respectively.
 func Preprocess(p Preprocessor, xo, yo, dx, dy float64) (*CTMData, error) {
+	defer func() {
+		for _, r := range readersOf(p) {
+			r.release()
+		}
+	}()
+
 	var pblh, layerHeights, windSpeed, windSpeedInverse, windSpeedMinusThird, windSpeedMinusOnePointFour, uAvg, vAvg, wAvg *sparse.DenseArray
 
 	errChan := make(chan error)
@@ -270,10 +304,11 @@ func Preprocess(p Preprocessor, xo, yo, dx, dy float64) (*CTMData, error) {
 		var err error
 		// Calculate stability for plume rise, vertical mixing,
 		// and chemical reaction rates.
+		seinfeldLandUse, weselyLandUse := landUseFractions(p)
 		Sclass, S1, Kzz, M2u, M2d, SO2oxidation, particleDryDep, SO2DryDep,
 			NOxDryDep, NH3DryDep, VOCDryDep, Kxxyy, err = stabilityMixingChemistry(layerHeights, p.PBLH(),
 			p.UStar(), p.ALT(), p.T(), p.P(), p.SurfaceHeatFlux(), p.HO(), p.H2O2(),
-			p.Z0(), p.SeinfeldLandUse(), p.WeselyLandUse(), p.QCloud(), p.RadiationDown(), p.QRain())
+			p.Z0(), seinfeldLandUse, weselyLandUse, p.QCloud(), p.RadiationDown(), p.QRain())
 		errChan <- err
 	}()
 
@@ -646,8 +681,9 @@ func minInt(vals ...int) int {
 // Inputs include layer heights (m), friction velocity (ustar, m/s),
 // planetary boundary layer height (pblh [m]), inverse density (alt, [m3/kg]),
 // temperature (T [K]), Pressure (P [Pa]),
-// surface heat flux [W/m2], HO mixing ratio [ppmv], and USGS land use index
-// (luIndex).
+// surface heat flux [W/m2], HO mixing ratio [ppmv], and the fractions
+// of each grid cell covered by each Seinfeld and Wesely land use category
+// ([category, y, x]).
 func stabilityMixingChemistry(LayerHeights *sparse.DenseArray, pblhFunc, ustarFunc, altFunc, TFunc, PFunc, surfaceHeatFluxFunc, hoFunc, h2o2Func, z0Func, seinfeldLandUseFunc, weselyLandUseFunc,
 	qCloudFunc, radiationDownFunc, qrainFunc NextData) (Sclass, S1, KzzUnstaggered, M2u, M2d, SO2oxidation, particleDryDep, SO2DryDep, NOxDryDep, NH3DryDep, VOCDryDep, Kyy *sparse.DenseArray, err error) {
 	const (
@@ -721,11 +757,11 @@ func stabilityMixingChemistry(LayerHeights *sparse.DenseArray, pblhFunc, ustarFu
 		if err != nil {
 			return nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, err
 		}
-		seinfeldLandUse, err := seinfeldLandUseFunc() // seinfeld land use index
+		seinfeldLandUse, err := seinfeldLandUseFunc() // seinfeld land use fractions
 		if err != nil {
 			return nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, err
 		}
-		weselyLandUse, err := weselyLandUseFunc() // wesely land use index
+		weselyLandUse, err := weselyLandUseFunc() // wesely land use fractions
 		if err != nil {
 			return nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, err
 		}
@@ -804,8 +840,6 @@ func stabilityMixingChemistry(LayerHeights *sparse.DenseArray, pblhFunc, ustarFu
 					p := P.Get(0, j, i) // Pressure [Pa]
 					//z: [m] surface layer; assumed to be 10% of boundary layer.
 					z := h / 10.
-					seinfeldLU := seinfeld.LandUseCategory(f2i(seinfeldLandUse.Get(j, i)))
-					weselyLU := wesely1989.LandUseCategory(f2i(weselyLandUse.Get(j, i)))
 					zo := z0.Get(j, i)       // roughness length [m]
 					const dParticle = 0.3e-6 // [m], Seinfeld & Pandis fig 8.11
 					const ρparticle = 1830.  // [kg/m3] Jacobson (2005) Ex. 13.5
@@ -832,32 +866,51 @@ func stabilityMixingChemistry(LayerHeights *sparse.DenseArray, pblhFunc, ustarFu
 					rain := qrain.Get(0, j, i) > 1.e-6
 
 					G := radiationDown.Get(j, i) // irradiation [W/m2]
-					particleDryDep.AddVal(
+
+					// Dry deposition velocities are averages of the
+					// velocities for each land use category, weighted
+					// by the fraction of the grid cell covered by each category.
+					var vdParticle, vdSO2, vdNOx, vdNH3, vdVOC float64
+					for c := 0; c < seinfeldLandUse.Shape[0]; c++ {
+						f := seinfeldLandUse.Get(c, j, i)
+						if f == 0 {
+							continue
+						}
+						seinfeldLU := seinfeld.LandUseCategory(c)
 						//gocart.ParticleDryDep(gocartObk, u, To, h,
-						//	zo, dParticle/2., ρparticle, p), 0, j, i)
-						seinfeld.DryDepParticle(z, zo, u, L, dParticle,
+						//	zo, dParticle/2., ρparticle, p)
+						vdParticle += f * seinfeld.DryDepParticle(z, zo, u, L, dParticle,
 							To, p, ρparticle,
-							ρ, iSeasonP, seinfeldLU), 0, j, i)
-					SO2DryDep.AddVal(
-						seinfeld.DryDepGas(z, zo, u, L, To, ρ,
+							ρ, iSeasonP, seinfeldLU)
+					}
+					for c := 0; c < weselyLandUse.Shape[0]; c++ {
+						f := weselyLandUse.Get(c, j, i)
+						if f == 0 {
+							continue
+						}
+						weselyLU := wesely1989.LandUseCategory(c)
+						vdSO2 += f * seinfeld.DryDepGas(z, zo, u, L, To, ρ,
 							G, Θsurface,
 							wesely1989.So2Data, iSeasonG,
-							weselyLU, rain, dew, true, false), 0, j, i)
-					NOxDryDep.AddVal(
-						seinfeld.DryDepGas(z, zo, u, L, To, ρ,
+							weselyLU, rain, dew, true, false)
+						vdNOx += f * seinfeld.DryDepGas(z, zo, u, L, To, ρ,
 							G, Θsurface,
 							wesely1989.No2Data, iSeasonG,
-							weselyLU, rain, dew, false, false), 0, j, i)
-					NH3DryDep.AddVal(
-						seinfeld.DryDepGas(z, zo, u, L, To, ρ,
+							weselyLU, rain, dew, false, false)
+						vdNH3 += f * seinfeld.DryDepGas(z, zo, u, L, To, ρ,
 							G, Θsurface,
 							wesely1989.Nh3Data, iSeasonG,
-							weselyLU, rain, dew, false, false), 0, j, i)
-					VOCDryDep.AddVal(
-						seinfeld.DryDepGas(z, zo, u, L, To, ρ,
+							weselyLU, rain, dew, false, false)
+						vdVOC += f * seinfeld.DryDepGas(z, zo, u, L, To, ρ,
 							G, Θsurface,
 							wesely1989.OraData, iSeasonG,
-							weselyLU, rain, dew, false, false), 0, j, i)
+							weselyLU, rain, dew, false, false)
+					}
+					particleDryDep.AddVal(vdParticle, 0, j, i)
+					SO2DryDep.AddVal(vdSO2, 0, j, i)
+					NOxDryDep.AddVal(vdNOx, 0, j, i)
+					NH3DryDep.AddVal(vdNH3, 0, j, i)
+					VOCDryDep.AddVal(vdVOC, 0, j, i)
 
 					for k := 0; k < T.Shape[0]; k++ {
 						p := P.Get(k, j, i) // Pa
@@ -988,21 +1041,32 @@ func arrayAverage(s *sparse.DenseArray, numTsteps int) *sparse.DenseArray {
 // with the given file name template between the given start and end times.
 // recordDelta and fileDelta specify the length of time between each file
 // and each record within a file, respectively. dateFormat is the format
-// in which dates appear in the filename.
-func nextDataNCF(fileTemplate string, dateFormat string, varName string, start, end time.Time, recordDelta, fileDelta time.Duration, readFunc readNCFFunc, msgChan chan string) NextData {
+// in which dates appear in the filename. Upcoming records are read
+// ahead of time as allowed by the limits set by SetPreprocessLimits.
+func (r *ncfReader) nextDataNCF(fileTemplate string, dateFormat string, varName string, start, end time.Time, recordDelta, fileDelta time.Duration, readFunc readNCFFunc, msgChan chan string) NextData {
 	recordsPerFile := int(fileDelta / recordDelta)
-	var i int
-	date := start
-	return func() (*sparse.DenseArray, error) {
-		if !date.Before(end) {
-			return nil, io.EOF
+	if recordsPerFile < 1 {
+		return func() (*sparse.DenseArray, error) {
+			return nil, fmt.Errorf("inmap: preprocessor file interval %v is shorter than record interval %v", fileDelta, recordDelta)
 		}
-		f, ff, err := ncfFromTemplate(fileTemplate, dateFormat, date)
+	}
+	var nFiles int
+	for date := start; date.Before(end); date = date.Add(fileDelta) {
+		nFiles++
+	}
+	next := r.pool.stream(nFiles*recordsPerFile, func(n int) (*sparse.DenseArray, error) {
+		date := start.Add(time.Duration(n/recordsPerFile) * fileDelta)
+		f, ff, err := r.ncfFromTemplate(fileTemplate, dateFormat, date)
 		if err != nil {
 			return nil, err
 		}
 		defer f.Close()
-		data, err := readFunc(varName, ff, i)
+		return readFunc(varName, ff, n%recordsPerFile)
+	})
+	var i int
+	date := start
+	return func() (*sparse.DenseArray, error) {
+		data, err := next()
 		if err != nil {
 			return nil, err
 		}
@@ -1015,7 +1079,7 @@ func nextDataNCF(fileTemplate string, dateFormat string, varName string, start,
 			i = 0
 			date = date.Add(fileDelta)
 		}
-		return data, err
+		return data, nil
 	}
 }
 
@@ -1090,15 +1154,28 @@ func nextDataConstantNCF(pol, filename string) func() (*sparse.DenseArray, error
 
 // nextDataGroupNCF reads a group of variables, mulitplies each by the
 // factors that are the values given in varNames.
-func nextDataGroupNCF(fileTemplate string, dateFormat string, varNames map[string]float64, start, end time.Time, recordDelta, fileDelta time.Duration, readFunc readNCFFunc, msgChan chan string) NextData {
+func (r *ncfReader) nextDataGroupNCF(fileTemplate string, dateFormat string, varNames map[string]float64, start, end time.Time, recordDelta, fileDelta time.Duration, readFunc readNCFFunc, msgChan chan string) NextData {
 	dataFuncs := make(map[string]NextData)
 	for v := range varNames {
-		dataFuncs[v] = nextDataNCF(fileTemplate, dateFormat, v, start, end, recordDelta, fileDelta, readFunc, msgChan)
+		dataFuncs[v] = r.nextDataNCF(fileTemplate, dateFormat, v, start, end, recordDelta, fileDelta, readFunc, msgChan)
 	}
+	return nextDataGroup(dataFuncs, varNames)
+}
+
+// nextDataGroup sums the data returned by each of the given dataFuncs,
+// multiplied by the factors in varNames. The variables are summed in
+// alphabetical order so that the results are reproducible.
+func nextDataGroup(dataFuncs map[string]NextData, varNames map[string]float64) NextData {
+	names := make([]string, 0, len(dataFuncs))
+	for varName := range dataFuncs {
+		names = append(names, varName)
+	}
+	sort.Strings(names)
 	return func() (*sparse.DenseArray, error) {
 		var out *sparse.DenseArray
 		firstData := true
-		for varName, f := range dataFuncs {
+		for _, varName := range names {
+			f := dataFuncs[varName]
 			data, err := f()
 			if err != nil {
 				if err == io.EOF {
@@ -1122,8 +1199,14 @@ func nextDataGroupNCF(fileTemplate string, dateFormat string, varNames map[strin
 
 // nextDataGroupAltNCF reads a group of variables using nextDataGroupNCF
 // and divides the result by inverse density (alt), as specified by altVar.
-func nextDataGroupAltNCF(fileTemplate string, dateFormat string, varNames map[string]float64, altFunc NextData, start, end time.Time, recordDelta, fileDelta time.Duration, readFunc readNCFFunc, msgChan chan string) NextData {
-	f := nextDataGroupNCF(fileTemplate, dateFormat, varNames, start, end, recordDelta, fileDelta, readFunc, msgChan)
+func (r *ncfReader) nextDataGroupAltNCF(fileTemplate string, dateFormat string, varNames map[string]float64, altFunc NextData, start, end time.Time, recordDelta, fileDelta time.Duration, readFunc readNCFFunc, msgChan chan string) NextData {
+	f := r.nextDataGroupNCF(fileTemplate, dateFormat, varNames, start, end, recordDelta, fileDelta, readFunc, msgChan)
+	return nextDataDivideAlt(f, altFunc)
+}
+
+// nextDataDivideAlt divides the data returned by f by inverse
+// density (alt).
+func nextDataDivideAlt(f, altFunc NextData) NextData {
 	return func() (*sparse.DenseArray, error) {
 		alt, err := altFunc()
 		if err != nil {
@@ -1143,19 +1226,13 @@ func nextDataGroupAltNCF(fileTemplate string, dateFormat string, varNames map[st
 
 // ncfFromTemplate opens a NetCDF file from the given template, where
 // the [DATE] wildcard in the given fileTemplate is replaced by the given
-// date, formatted as the given dateFormat.
-func ncfFromTemplate(fileTemplate, dateFormat string, date time.Time) (*os.File, *cdf.File, error) {
+// date, formatted as the given dateFormat. Open files are shared among
+// all of the variables that are read from them by r, and the returned
+// file should be closed when it is no longer needed.
+func (r *ncfReader) ncfFromTemplate(fileTemplate, dateFormat string, date time.Time) (*ncfFile, *cdf.File, error) {
 	d := date.Format(dateFormat)
 	file := strings.Replace(fileTemplate, "[DATE]", d, -1)
-	f, err := os.Open(file)
-	if err != nil {
-		return nil, nil, err
-	}
-	ff, err := cdf.Open(f)
-	if err != nil {
-		return nil, nil, err
-	}
-	return f, ff, err
+	return r.files.open(file)
 }
 
 // stagger converts an unstaggered grid to a grid that
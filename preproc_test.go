@@ -27,6 +27,8 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/BurntSushi/toml"
+	"github.com/ctessum/atmos/wesely1989"
 	"github.com/ctessum/sparse"
 )
 
@@ -93,6 +95,400 @@ func BenchmarkWRFChemToInMAP(b *testing.B) {
 	}
 }
 
+const cmaqTestDir = "cmd/inmap/testdata/preproc/cmaq/"
+
+func newTestCMAQ() (*CMAQ, error) {
+	return NewCMAQ(
+		cmaqTestDir+"METCRO3D_[DATE].nc",
+		cmaqTestDir+"METCRO2D_[DATE].nc",
+		cmaqTestDir+"METDOT3D_[DATE].nc",
+		cmaqTestDir+"GRIDCRO2D.nc",
+		cmaqTestDir+"CCTM_ACONC_[DATE].nc",
+		"20160701",
+		"20160703",
+		nil,
+	)
+}
+
+func TestCMAQToInMAP(t *testing.T) {
+	flag.Parse()
+	const tolerance = 1.0e-6
+
+	c, err := newTestCMAQ()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newData, err := Preprocess(c, -18000, -12000, 12000, 12000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	goldenFileName := cmaqTestDir + "inmapData_CMAQ_golden.ncf"
+
+	if regenGoldenFiles {
+		err := regenGoldenFile(newData, goldenFileName)
+		if err != nil {
+			t.Errorf("regenerating golden file: %v", err)
+		}
+	}
+
+	cfg := VarGridConfig{}
+	f2, err := os.Open(goldenFileName)
+	if err != nil {
+		t.Fatalf("opening golden file: %v", err)
+	}
+	goldenData, err := cfg.LoadCTMData(f2)
+	if err != nil {
+		t.Fatalf("reading golden file: %v", err)
+	}
+	compareCTMData(goldenData, newData, tolerance, t)
+}
+
+func TestCMAQ(t *testing.T) {
+	c, err := newTestCMAQ()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.nx != 3 || c.ny != 2 || c.nz != 7 {
+		t.Errorf("grid dimensions: %d, %d, %d", c.nx, c.ny, c.nz)
+	}
+	// Species that are not in the concentration file should be ignored.
+	if _, ok := c.aVOC["XYL"]; ok {
+		t.Error("aVOC should not contain XYL")
+	}
+	if _, ok := c.totalPM25["AH2OJ"]; ok {
+		t.Error("total PM2.5 should not include aerosol water")
+	}
+	if len(c.totalPM25) != 17 {
+		t.Errorf("total PM2.5 has %d species", len(c.totalPM25))
+	}
+
+	t.Run("records", func(t *testing.T) {
+		// The meteorology files have 25 records per day and the
+		// concentration files have 24, but there should be 48 time steps
+		// for both.
+		for _, f := range []NextData{c.T(), c.HO()} {
+			var n int
+			for {
+				if _, err := f(); err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				n++
+			}
+			if n != 48 {
+				t.Errorf("number of records: %d", n)
+			}
+		}
+	})
+
+	t.Run("BGrid", func(t *testing.T) {
+		// The test winds vary linearly, so interpolating the B grid winds
+		// should give the same result as the C grid winds.
+		cGridU, cGridV := c.U(), c.V()
+		c.cGrid = false
+		bGridU, bGridV := c.U(), c.V()
+		c.cGrid = true
+		for i := 0; i < 3; i++ {
+			u1, err := cGridU()
+			if err != nil {
+				t.Fatal(err)
+			}
+			u2, err := bGridU()
+			if err != nil {
+				t.Fatal(err)
+			}
+			arrayCompare(u2, u1, 1.e-6, "U", t)
+			v1, err := cGridV()
+			if err != nil {
+				t.Fatal(err)
+			}
+			v2, err := bGridV()
+			if err != nil {
+				t.Fatal(err)
+			}
+			arrayCompare(v2, v1, 1.e-6, "V", t)
+		}
+	})
+
+	t.Run("landUse", func(t *testing.T) {
+		lu, err := c.WeselyLandUse()()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []float64{float64(wesely1989.Urban), float64(wesely1989.Range), float64(wesely1989.Deciduous),
+			float64(wesely1989.Coniferous), float64(wesely1989.Water), float64(wesely1989.RangeAg)}
+		if !reflect.DeepEqual(lu.Elements, want) {
+			t.Errorf("land use: have %v, want %v", lu.Elements, want)
+		}
+	})
+}
+
+const camxTestDir = "cmd/inmap/testdata/preproc/camx/"
+
+func newTestCAMx() (*CAMx, error) {
+	return NewCAMx(
+		camxTestDir+"camx.3d.[DATE].nc",
+		camxTestDir+"camx.2d.[DATE].nc",
+		camxTestDir+"camx.kv.[DATE].nc",
+		camxTestDir+"camx.avrg.[DATE].nc",
+		"20160701",
+		"20160703",
+		nil,
+	)
+}
+
+func TestCAMxToInMAP(t *testing.T) {
+	flag.Parse()
+	const tolerance = 1.0e-6
+
+	c, err := newTestCAMx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newData, err := Preprocess(c, -18000, -12000, 12000, 12000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	goldenFileName := camxTestDir + "inmapData_CAMx_golden.ncf"
+
+	if regenGoldenFiles {
+		err := regenGoldenFile(newData, goldenFileName)
+		if err != nil {
+			t.Errorf("regenerating golden file: %v", err)
+		}
+	}
+
+	cfg := VarGridConfig{}
+	f2, err := os.Open(goldenFileName)
+	if err != nil {
+		t.Fatalf("opening golden file: %v", err)
+	}
+	goldenData, err := cfg.LoadCTMData(f2)
+	if err != nil {
+		t.Fatalf("reading golden file: %v", err)
+	}
+	compareCTMData(goldenData, newData, tolerance, t)
+}
+
+func TestCAMx(t *testing.T) {
+	c, err := newTestCAMx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.nx != 3 || c.ny != 2 || c.nz != 7 {
+		t.Errorf("grid dimensions: %d, %d, %d", c.nx, c.ny, c.nz)
+	}
+	// Species that are not in the concentration file should be ignored.
+	if _, ok := c.totalPM25["FCRS"]; ok {
+		t.Error("total PM2.5 should not contain FCRS")
+	}
+	if len(c.totalPM25) != 14 {
+		t.Errorf("total PM2.5 has %d species", len(c.totalPM25))
+	}
+
+	t.Run("landUse", func(t *testing.T) {
+		lu, err := c.WeselyLandUse()()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []float64{float64(wesely1989.Urban), float64(wesely1989.Water), float64(wesely1989.Deciduous),
+			float64(wesely1989.Agricultural), float64(wesely1989.Barren), float64(wesely1989.Wetland)}
+		if !reflect.DeepEqual(lu.Elements, want) {
+			t.Errorf("land use: have %v, want %v", lu.Elements, want)
+		}
+		z0, err := c.Z0()()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Urban (70%) and agricultural (30%) land.
+		if want := math.Pow(1.0, 0.7) * math.Pow(0.15, 0.3); different(z0.Get(0, 0), want, 1.e-6) {
+			t.Errorf("z0: have %g, want %g", z0.Get(0, 0), want)
+		}
+	})
+
+	t.Run("W", func(t *testing.T) {
+		// The test winds have a constant horizontal divergence of
+		// 0.1 / 12000 s-1 away from the West and South boundaries.
+		wFunc, hFunc := c.W(), c.Height()
+		w, err := wFunc()
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, err := hFunc()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k := 0; k < h.Shape[0]; k++ {
+			want := -0.1 / 12000 * h.Get(k, 1, 2)
+			if different(w.Get(k, 1, 2), want, 1.e-4) {
+				t.Errorf("w layer %d: have %g, want %g", k, w.Get(k, 1, 2), want)
+			}
+		}
+	})
+
+	t.Run("PBLH", func(t *testing.T) {
+		pblhFunc, hFunc := c.PBLH(), c.Height()
+		for i := 0; i < 24; i++ {
+			pblh, err := pblhFunc()
+			if err != nil {
+				t.Fatal(err)
+			}
+			h, err := hFunc()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The boundary layer top should be at the first layer
+			// interface above the height where the test diffusivity drops,
+			// which is 300 m at night and 1500 m at local noon.
+			var low float64
+			switch i {
+			case 6:
+				low = 300
+			case 18:
+				low = 1500
+			default:
+				continue
+			}
+			var want float64
+			for k := 0; k < h.Shape[0]; k++ {
+				if want = h.Get(k, 0, 0); want > low {
+					break
+				}
+			}
+			if pblh.Get(0, 0) != want {
+				t.Errorf("hour %d PBLH: have %g, want %g", i, pblh.Get(0, 0), want)
+			}
+		}
+	})
+}
+
+const netCDFMappingFile = "cmd/inmap/testdata/preproc/netcdf/wrfchem.toml"
+
+// The WRF-Chem variable mapping should give the same results as
+// the WRF-Chem preprocessor.
+func TestGenericNCFToInMAP(t *testing.T) {
+	const tolerance = 1.0e-6
+	os.Setenv("INMAP_ROOT_DIR", ".")
+
+	gn, err := NewGenericNCF(netCDFMappingFile, "20050101", "20050103", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newData, err := Preprocess(gn, -2004000, -540000, 12000, 12000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := VarGridConfig{}
+	f2, err := os.Open("cmd/inmap/testdata/preproc/inmapData_WRFChem_golden.ncf")
+	if err != nil {
+		t.Fatalf("opening golden file: %v", err)
+	}
+	goldenData, err := cfg.LoadCTMData(f2)
+	if err != nil {
+		t.Fatalf("reading golden file: %v", err)
+	}
+	compareCTMData(goldenData, newData, tolerance, t)
+}
+
+func TestGenericNCF(t *testing.T) {
+	os.Setenv("INMAP_ROOT_DIR", ".")
+	loadConfig := func() *GenericNCFConfig {
+		var cfg GenericNCFConfig
+		if _, err := toml.DecodeFile(netCDFMappingFile, &cfg); err != nil {
+			t.Fatal(err)
+		}
+		cfg.FileTemplate = os.ExpandEnv(cfg.FileTemplate)
+		return &cfg
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		one := 1.0
+		for _, test := range []struct {
+			name   string
+			modify func(cfg *GenericNCFConfig)
+		}{
+			{name: "missing", modify: func(cfg *GenericNCFConfig) { delete(cfg.Variables, "NOx") }},
+			{name: "unknown", modify: func(cfg *GenericNCFConfig) { cfg.Variables["NO2"] = GenericNCFVariable{Name: "no2"} }},
+			{name: "no source", modify: func(cfg *GenericNCFConfig) { cfg.Variables["PBLH"] = GenericNCFVariable{} }},
+			{name: "two sources", modify: func(cfg *GenericNCFConfig) { cfg.Variables["PBLH"] = GenericNCFVariable{Name: "PBLH", Value: &one} }},
+			{name: "not in file", modify: func(cfg *GenericNCFConfig) { cfg.Variables["PBLH"] = GenericNCFVariable{Name: "xxx"} }},
+			{name: "stagger", modify: func(cfg *GenericNCFConfig) { cfg.Variables["U"] = GenericNCFVariable{Name: "U", Stagger: "y"} }},
+			{name: "categories", modify: func(cfg *GenericNCFConfig) {
+				cfg.Variables["PBLH"] = GenericNCFVariable{Name: "LU_INDEX", Categories: "USGS"}
+			}},
+			{name: "potential temperature", modify: func(cfg *GenericNCFConfig) {
+				cfg.Variables["P"] = GenericNCFVariable{Name: "P", PotentialTemperature: true}
+			}},
+			{name: "vertical coordinate", modify: func(cfg *GenericNCFConfig) { cfg.VerticalCoordinate = "sigma" }},
+			{name: "interval", modify: func(cfg *GenericNCFConfig) { cfg.RecordInterval = "48h" }},
+		} {
+			t.Run(test.name, func(t *testing.T) {
+				cfg := loadConfig()
+				test.modify(cfg)
+				if _, err := NewGenericNCFFromConfig(cfg, "20050101", "20050103", nil); err == nil {
+					t.Error("should have caused an error")
+				}
+			})
+		}
+	})
+
+	t.Run("shape", func(t *testing.T) {
+		// U is staggered in the files, so staggering it again
+		// results in the wrong shape.
+		cfg := loadConfig()
+		cfg.Variables["QRain"] = GenericNCFVariable{Name: "U"}
+		gn, err := NewGenericNCFFromConfig(cfg, "20050101", "20050103", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := gn.QRain()(); err == nil {
+			t.Error("data with the wrong shape should cause an error")
+		}
+	})
+
+	t.Run("value", func(t *testing.T) {
+		cfg := loadConfig()
+		v := 0.5
+		cfg.Variables["CloudFrac"] = GenericNCFVariable{Value: &v}
+		gn, err := NewGenericNCFFromConfig(cfg, "20050101", "20050103", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := gn.CloudFrac()
+		for i := 0; i < 48; i++ {
+			d, err := f()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []int{gn.nz, gn.ny, gn.nx}; !reflect.DeepEqual(d.Shape, want) {
+				t.Fatalf("shape: have %v, want %v", d.Shape, want)
+			}
+			if d.Elements[0] != v {
+				t.Errorf("have %g, want %g", d.Elements[0], v)
+			}
+		}
+		if _, err := f(); err != io.EOF {
+			t.Errorf("have error %v, want io.EOF", err)
+		}
+	})
+
+	t.Run("flip", func(t *testing.T) {
+		d := sparse.ZerosDense(2, 2, 1)
+		d.Elements = []float64{1, 2, 3, 4}
+		have, err := genericNCFFlip(testNextData([]*sparse.DenseArray{d}), true, true)()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []float64{4, 3, 2, 1}; !reflect.DeepEqual(have.Elements, want) {
+			t.Errorf("have %v, want %v", have.Elements, want)
+		}
+	})
+}
+
 func TestGEOSChemToInMAP(t *testing.T) {
 	flag.Parse()
 	const tolerance = 1.0e-6
@@ -220,6 +616,95 @@ func TestGEOSChemToInMAP_new(t *testing.T) {
 	compareCTMData(goldenData, newData, tolerance, t)
 }
 
+// dominantLandUse specifies the land use of the wrapped Preprocessor
+// as fractions, where the dominant land use category of each grid cell
+// has a fraction of 1.
+type dominantLandUse struct {
+	Preprocessor
+}
+
+func (p dominantLandUse) SeinfeldLandUseFractions() NextData {
+	return landUseCategoryFractions(p.SeinfeldLandUse(), nSeinfeldLandUse)
+}
+
+func (p dominantLandUse) WeselyLandUseFractions() NextData {
+	return landUseCategoryFractions(p.WeselyLandUse(), nWeselyLandUse)
+}
+
+// Dry deposition should be the same as in the golden files, which were created
+// before land use fractions were supported, when the dominant land use
+// category of each grid cell has a fraction of 1.
+func TestDominantLandUseFraction(t *testing.T) {
+	const tolerance = 1.0e-6
+	for _, test := range []struct {
+		name, golden   string
+		p              func() (Preprocessor, error)
+		xo, yo, dx, dy float64
+	}{
+		{
+			name:   "WRF-Chem",
+			golden: "cmd/inmap/testdata/preproc/inmapData_WRFChem_golden.ncf",
+			p: func() (Preprocessor, error) {
+				return NewWRFChem("cmd/inmap/testdata/preproc/wrfout_d01_[DATE]", "20050101", "20050103", nil)
+			},
+			xo: -2004000, yo: -540000, dx: 12000, dy: 12000,
+		},
+		{
+			name:   "CMAQ",
+			golden: cmaqTestDir + "inmapData_CMAQ_golden.ncf",
+			p:      func() (Preprocessor, error) { return newTestCMAQ() },
+			xo:     -18000, yo: -12000, dx: 12000, dy: 12000,
+		},
+		{
+			name:   "GEOS-Chem",
+			golden: "cmd/inmap/testdata/preproc/inmapData_GEOSChem_golden.ncf",
+			p: func() (Preprocessor, error) {
+				return NewGEOSChem(
+					"cmd/inmap/testdata/preproc/GEOSFP.[DATE].A1.2x25.nc",
+					"cmd/inmap/testdata/preproc/GEOSFP.[DATE].A3cld.2x25.nc",
+					"cmd/inmap/testdata/preproc/GEOSFP.[DATE].A3dyn.2x25.nc",
+					"cmd/inmap/testdata/preproc/GEOSFP.[DATE].I3.2x25.nc",
+					"cmd/inmap/testdata/preproc/GEOSFP.[DATE].A3mstE.2x25.nc",
+					"",
+					"cmd/inmap/testdata/preproc/gc_output.[DATE].nc",
+					"cmd/inmap/testdata/preproc/geoschem-new/Olson_2001_Land_Map.025x025.generic.nc",
+					"20130102",
+					"20130104",
+					true,
+					"3h",
+					"3h",
+					true,
+					nil,
+				)
+			},
+			xo: -2.5, yo: 50, dx: 2.5, dy: 2,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := test.p()
+			if err != nil {
+				t.Fatal(err)
+			}
+			newData, err := Preprocess(dominantLandUse{p}, test.xo, test.yo, test.dx, test.dy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.Open(test.golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			goldenData, err := (&VarGridConfig{}).LoadCTMData(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, v := range []string{"ParticleDryDep", "SO2DryDep", "NOxDryDep", "NH3DryDep", "VOCDryDep"} {
+				arrayCompare(newData.Data[v].Data, goldenData.Data[v].Data, tolerance, v, t)
+			}
+		})
+	}
+}
+
 func compareCTMData(goldenData, newData *CTMData, tolerance float64, t *testing.T) {
 	if len(goldenData.Data) != len(newData.Data) {
 		t.Errorf("new and old ctmdata have different number of variables (%d vs. %d)",
@@ -417,8 +902,8 @@ func TestStabilityMixingChemistry(t *testing.T) {
 	radiationDownFunc := wrfRadiationDown(testNextData(SWDOWN), testNextData(GLW))
 
 	z0Func := wrfZ0(testNextData(LUIndex))
-	seinfeldLandUseFunc := wrfSeinfeldLandUse(testNextData(LUIndex))
-	weselyLandUseFunc := wrfWeselyLandUse(testNextData(LUIndex))
+	seinfeldLandUseFunc := landUseCategoryFractions(wrfSeinfeldLandUse(testNextData(LUIndex)), nSeinfeldLandUse)
+	weselyLandUseFunc := landUseCategoryFractions(wrfWeselyLandUse(testNextData(LUIndex)), nWeselyLandUse)
 
 	Sclass, S1, KzzUnstaggered, M2u, M2d, SO2oxidation, particleDryDep, SO2DryDep, NOxDryDep, NH3DryDep, VOCDryDep, Kyy, err := stabilityMixingChemistry(layerHeights, pblhFunc, ustarFunc, altFunc, tempFunc,
 		pFunc, surfaceHeatFluxFunc, hoFunc, h2o2Func, z0Func, seinfeldLandUseFunc, weselyLandUseFunc, qCloudFunc, radiationDownFunc, qrainFunc)
@@ -0,0 +1,384 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/ctessum/cdf"
+	"github.com/ctessum/sparse"
+)
+
+// DefaultPreprocessMaxResidentFields is the default maximum number of
+// fields that are read ahead of time during preprocessing.
+const DefaultPreprocessMaxResidentFields = 16
+
+// maxIdleNCF is the maximum number of NetCDF files that are kept
+// open while they are not being read.
+const maxIdleNCF = 64
+
+// ncfReader reads NetCDF chemical transport model output for a
+// preprocessor. It limits the resources used for reading as specified by
+// its read pool and shares open files among the variables being read.
+type ncfReader struct {
+	pool  *readPool
+	files *ncfCache
+}
+
+// newNCFReader returns a new reader with the default
+// preprocessing limits.
+func newNCFReader() *ncfReader {
+	return &ncfReader{
+		pool:  newReadPool(0, DefaultPreprocessMaxResidentFields),
+		files: newNCFCache(),
+	}
+}
+
+// ncfReaders returns r. It allows preprocessors that
+// embed an ncfReader to implement preprocReaders.
+func (r *ncfReader) ncfReaders() []*ncfReader { return []*ncfReader{r} }
+
+// release releases the resources held by r after preprocessing: it
+// discards any data that were read ahead of time for streams that
+// were not read to the end, and closes the files that are not being read.
+func (r *ncfReader) release() error {
+	r.pool.abandon()
+	return r.files.close()
+}
+
+// preprocReaders is implemented by preprocessors that read their input
+// data using ncfReaders.
+type preprocReaders interface {
+	ncfReaders() []*ncfReader
+}
+
+// readersOf returns the ncfReaders used by p, if any.
+func readersOf(p Preprocessor) []*ncfReader {
+	if r, ok := p.(preprocReaders); ok {
+		return r.ncfReaders()
+	}
+	return nil
+}
+
+// SetPreprocessLimits sets the resources used by preprocessor p to read
+// chemical transport model output. workers is the maximum number of
+// fields that can be read at the same time; if it is less than one,
+// the number of available CPUs is used. maxResidentFields is the maximum
+// number of fields that can be read ahead of when they are needed
+// and held in memory; if it is zero, fields are only read when they are needed.
+// If p combines data from several sources, the limits apply to all of them
+// together. SetPreprocessLimits should not be called while p is being
+// used by Preprocess, and it has no effect on preprocessors that are not
+// part of this package.
+// Changing the limits does not change the preprocessing results.
+func SetPreprocessLimits(p Preprocessor, workers, maxResidentFields int) error {
+	if maxResidentFields < 0 {
+		return fmt.Errorf("inmap: preprocessing maximum resident fields must not be negative, but is %d", maxResidentFields)
+	}
+	pool := newReadPool(workers, maxResidentFields)
+	for _, r := range readersOf(p) {
+		r.pool = pool
+	}
+	return nil
+}
+
+// readPool limits the number of concurrent reads and the number of
+// fields that are read ahead of time across all of the data streams
+// that share it.
+type readPool struct {
+	workers chan struct{} // holds a token for each read in progress.
+	fields  chan struct{} // holds a token for each field that has been read ahead.
+
+	mu      sync.Mutex
+	streams map[*readStream]struct{} // streams that have not been read to the end.
+}
+
+func newReadPool(workers, maxResidentFields int) *readPool {
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	return &readPool{
+		workers: make(chan struct{}, workers),
+		fields:  make(chan struct{}, maxResidentFields),
+		streams: make(map[*readStream]struct{}),
+	}
+}
+
+// reserveField reserves memory for a field to be read ahead of time,
+// returning false if the memory budget has been used up.
+func (p *readPool) reserveField() bool {
+	select {
+	case p.fields <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *readPool) releaseField() { <-p.fields }
+
+// abandon closes all of the streams that have not been read to the end,
+// waiting for the reads that are in progress to finish and releasing
+// the memory reserved for the records that they have read ahead of time.
+func (p *readPool) abandon() {
+	p.mu.Lock()
+	streams := p.streams
+	p.streams = make(map[*readStream]struct{})
+	p.mu.Unlock()
+	for s := range streams {
+		s.mu.Lock()
+		for _, f := range s.queue {
+			s.discard(f)
+		}
+		s.queue = nil
+		s.err = errStreamAbandoned
+		s.mu.Unlock()
+	}
+}
+
+func (p *readPool) done(s *readStream) {
+	p.mu.Lock()
+	delete(p.streams, s)
+	p.mu.Unlock()
+}
+
+// errStreamAbandoned is returned when reading from a stream after
+// its resources have been released.
+var errStreamAbandoned = fmt.Errorf("inmap: preprocessor data stream has been closed")
+
+type fetchResult struct {
+	data *sparse.DenseArray
+	err  error
+}
+
+type pendingFetch struct {
+	index    int
+	result   chan fetchResult
+	reserved bool
+}
+
+// readStream holds the state of a stream created by readPool.stream.
+type readStream struct {
+	p    *readPool
+	n    int
+	read func(i int) (*sparse.DenseArray, error)
+
+	mu    sync.Mutex
+	queue []pendingFetch
+	next  int   // the next record to schedule
+	err   error // set when the stream has been abandoned.
+}
+
+// stream returns a function that sequentially returns the results of
+// read(0), read(1), ..., read(n-1) and then io.EOF. Upcoming records
+// are read concurrently ahead of time as allowed by the limits of p.
+// The record that is currently needed never waits for memory to be
+// reserved, so streams that are read in lockstep with each other
+// cannot deadlock. If read returns an error, the error is returned
+// and the same record will be read again the next time the function is called.
+// Streams that are not read to the end hold on to the memory reserved for
+// the records they have read ahead of time until p.abandon is called.
+func (p *readPool) stream(n int, read func(i int) (*sparse.DenseArray, error)) NextData {
+	s := &readStream{p: p, n: n, read: read}
+	p.mu.Lock()
+	p.streams[s] = struct{}{}
+	p.mu.Unlock()
+	return s.nextData
+}
+
+func (s *readStream) schedule(reserved bool) {
+	f := pendingFetch{index: s.next, result: make(chan fetchResult, 1), reserved: reserved}
+	s.next++
+	go func() {
+		s.p.workers <- struct{}{}
+		data, err := s.read(f.index)
+		<-s.p.workers
+		f.result <- fetchResult{data: data, err: err}
+	}()
+	s.queue = append(s.queue, f)
+}
+
+// discard waits for fetch f to finish and releases the memory
+// reserved for it.
+func (s *readStream) discard(f pendingFetch) {
+	<-f.result
+	if f.reserved {
+		s.p.releaseField()
+	}
+}
+
+func (s *readStream) nextData() (*sparse.DenseArray, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	if len(s.queue) == 0 {
+		if s.next >= s.n {
+			s.p.done(s)
+			return nil, io.EOF
+		}
+		s.schedule(false)
+	}
+	// Read ahead no more records than there are workers.
+	for s.next < s.n && len(s.queue) <= cap(s.p.workers) && s.p.reserveField() {
+		s.schedule(true)
+	}
+	f := s.queue[0]
+	s.queue = s.queue[1:]
+	r := <-f.result
+	if f.reserved {
+		s.p.releaseField()
+	}
+	if r.err != nil {
+		// Discard the records that have been read ahead so that
+		// reading can restart from the failed record.
+		for _, f := range s.queue {
+			go s.discard(f)
+		}
+		s.queue = nil
+		s.next = f.index
+	}
+	return r.data, r.err
+}
+
+// ncfFile is a NetCDF file that is shared among all of the
+// variables being read from it.
+type ncfFile struct {
+	name string
+	c    *ncfCache
+	e    *ncfEntry
+}
+
+// Name returns the name of the file.
+func (f *ncfFile) Name() string { return f.name }
+
+// Close releases the file. The underlying file is closed once
+// it is no longer being used and has not been used recently.
+func (f *ncfFile) Close() error { return f.c.release(f.e) }
+
+type ncfEntry struct {
+	name    string
+	f       *os.File
+	ff      *cdf.File
+	refs    int
+	modTime time.Time
+	size    int64
+	stale   bool // stale entries are closed as soon as they are unused.
+	lastUse int64
+}
+
+// ncfCache keeps NetCDF files open so that multiple variables
+// can be read from them without the files being repeatedly
+// opened and their headers repeatedly parsed. Files that have changed
+// since they were opened are reopened.
+type ncfCache struct {
+	mu      sync.Mutex
+	entries map[string]*ncfEntry
+	clock   int64
+}
+
+func newNCFCache() *ncfCache {
+	return &ncfCache{entries: make(map[string]*ncfEntry)}
+}
+
+// open returns the named file, opening it if necessary.
+func (c *ncfCache) open(name string) (*ncfFile, *cdf.File, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clock++
+	e, ok := c.entries[name]
+	if ok && (!e.modTime.Equal(info.ModTime()) || e.size != info.Size()) {
+		e.stale = true
+		delete(c.entries, name)
+		if e.refs == 0 {
+			e.f.Close()
+		}
+		ok = false
+	}
+	if !ok {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		ff, err := cdf.Open(f)
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		e = &ncfEntry{name: name, f: f, ff: ff, modTime: info.ModTime(), size: info.Size()}
+		c.entries[name] = e
+	}
+	e.refs++
+	e.lastUse = c.clock
+	c.closeIdle(maxIdleNCF)
+	return &ncfFile{name: name, c: c, e: e}, e.ff, nil
+}
+
+func (c *ncfCache) release(e *ncfEntry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e.refs--
+	if e.refs == 0 && e.stale {
+		return e.f.Close()
+	}
+	return c.closeIdle(maxIdleNCF)
+}
+
+// closeIdle closes the least recently used files that are not
+// being read until there are no more than max of them.
+// The caller must hold c.mu.
+func (c *ncfCache) closeIdle(max int) error {
+	var idle int
+	for _, e := range c.entries {
+		if e.refs == 0 {
+			idle++
+		}
+	}
+	var err error
+	for ; idle > max; idle-- {
+		var oldest *ncfEntry
+		for _, e := range c.entries {
+			if e.refs == 0 && (oldest == nil || e.lastUse < oldest.lastUse) {
+				oldest = e
+			}
+		}
+		delete(c.entries, oldest.name)
+		if err2 := oldest.f.Close(); err2 != nil && err == nil {
+			err = err2
+		}
+	}
+	return err
+}
+
+// close closes all of the files that are not currently being read.
+func (c *ncfCache) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closeIdle(0)
+}
@@ -0,0 +1,204 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ctessum/sparse"
+)
+
+func TestReadPoolStream(t *testing.T) {
+	const n = 20
+	for _, limits := range [][2]int{{1, 0}, {2, 1}, {4, 16}} {
+		t.Run(fmt.Sprintf("%d_%d", limits[0], limits[1]), func(t *testing.T) {
+			p := newReadPool(limits[0], limits[1])
+			var active, maxActive int32
+			failed := false
+			next := p.stream(n, func(i int) (*sparse.DenseArray, error) {
+				a := atomic.AddInt32(&active, 1)
+				defer atomic.AddInt32(&active, -1)
+				for {
+					m := atomic.LoadInt32(&maxActive)
+					if a <= m || atomic.CompareAndSwapInt32(&maxActive, m, a) {
+						break
+					}
+				}
+				time.Sleep(time.Millisecond)
+				if i == 5 && !failed {
+					failed = true
+					return nil, fmt.Errorf("read error")
+				}
+				d := sparse.ZerosDense(1)
+				d.Elements[0] = float64(i)
+				return d, nil
+			})
+			var sawError bool
+			for i := 0; i < n; i++ {
+				d, err := next()
+				if err != nil {
+					if i != 5 || sawError {
+						t.Fatalf("record %d: %v", i, err)
+					}
+					// The failed record should be read again.
+					sawError = true
+					i--
+					continue
+				}
+				if d.Elements[0] != float64(i) {
+					t.Fatalf("have record %g, want %d", d.Elements[0], i)
+				}
+			}
+			if _, err := next(); err != io.EOF {
+				t.Errorf("have error %v, want io.EOF", err)
+			}
+			if !sawError {
+				t.Error("the read error should have been returned")
+			}
+			if int(maxActive) > limits[0] {
+				t.Errorf("%d concurrent reads is more than %d workers", maxActive, limits[0])
+			}
+			// Wait for any discarded reads to finish.
+			time.Sleep(50 * time.Millisecond)
+			if len(p.fields) != 0 {
+				t.Errorf("%d fields are still reserved", len(p.fields))
+			}
+		})
+	}
+}
+
+// Abandoning streams that haven't been read to the end
+// should release the memory reserved for them.
+func TestReadPoolAbandon(t *testing.T) {
+	p := newReadPool(2, 4)
+	read := func(i int) (*sparse.DenseArray, error) {
+		time.Sleep(time.Millisecond)
+		return sparse.ZerosDense(1), nil
+	}
+	next1, next2 := p.stream(20, read), p.stream(20, read)
+	for i := 0; i < 3; i++ {
+		if _, err := next1(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := next2(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(p.fields) == 0 {
+		t.Fatal("some fields should have been read ahead")
+	}
+	p.abandon()
+	if len(p.fields) != 0 {
+		t.Errorf("%d fields are still reserved", len(p.fields))
+	}
+	if len(p.streams) != 0 {
+		t.Errorf("%d streams are still open", len(p.streams))
+	}
+	if _, err := next1(); err != errStreamAbandoned {
+		t.Errorf("have error %v, want %v", err, errStreamAbandoned)
+	}
+}
+
+// Preprocessing results should not depend on the preprocessing limits.
+func TestSetPreprocessLimits(t *testing.T) {
+	c, err := newTestCMAQ()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := Preprocess(c, -18000, -12000, 12000, 12000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, limits := range [][2]int{{1, 0}, {3, 2}, {8, 64}} {
+		c, err := newTestCMAQ()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := SetPreprocessLimits(c, limits[0], limits[1]); err != nil {
+			t.Fatal(err)
+		}
+		have, err := Preprocess(c, -18000, -12000, 12000, 12000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compareCTMData(want, have, 0, t)
+	}
+	if err := SetPreprocessLimits(c, 1, -1); err == nil {
+		t.Error("negative maximum resident fields should cause an error")
+	}
+}
+
+func TestNCFCache(t *testing.T) {
+	b, err := ioutil.ReadFile(cmaqTestDir + "GRIDCRO2D.nc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const file = "tempNCFCache.nc"
+	if err = ioutil.WriteFile(file, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file)
+
+	c := &ncfCache{entries: make(map[string]*ncfEntry)}
+	f1, ff1, err := c.open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f2, ff2, err := c.open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ff1 != ff2 {
+		t.Error("the file should be shared")
+	}
+	f1.Close()
+	f2.Close()
+
+	// Changed files should be reopened.
+	later := time.Now().Add(time.Hour)
+	if err = os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	f3, ff3, err := c.open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ff3 == ff1 {
+		t.Error("the changed file should have been reopened")
+	}
+	if err = c.close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.entries) != 1 {
+		t.Errorf("files in use should not be closed: have %d open files", len(c.entries))
+	}
+	f3.Close()
+	if err = c.close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.entries) != 0 {
+		t.Errorf("have %d open files after closing", len(c.entries))
+	}
+}
@@ -75,6 +75,25 @@ func ResetCells() DomainManipulator {
 	}
 }
 
+// ResetConcentrations clears concentration and emissions information from
+// all of the grid cells and boundary cells and resizes the concentration
+// arrays to match mechanism m. It allows multiple simulations, possibly
+// with different chemical mechanisms, to be run using the same grid.
+// Emissions can be added afterwards using InMAP.SetEmissionsFlux.
+func ResetConcentrations(m Mechanism) DomainManipulator {
+	return func(d *InMAP) error {
+		for _, g := range []*cellList{d.cells, d.westBoundary, d.eastBoundary,
+			d.northBoundary, d.southBoundary, d.topBoundary} {
+			for _, c := range *g {
+				c.Ci = make([]float64, m.Len())
+				c.Cf = make([]float64, m.Len())
+				c.EmisFlux = nil
+			}
+		}
+		return nil
+	}
+}
+
 // Calculations returns a function that concurrently runs a series of calculations
 // on all of the model grid cells.
 func Calculations(calculators ...CellManipulator) DomainManipulator {
@@ -107,6 +107,7 @@ func (d *InMAP) initFromCells(cells []*Cell, emis *Emissions, config *VarGridCon
 	for i, m := range mortRateColumns {
 		d.mortIndices[m] = i
 	}
+	d.baselineHR = config.BaselineHR
 	for _, c := range cells {
 		d.InsertCell(c, m)
 	}
@@ -232,24 +232,30 @@ func (m Mechanism) Units(variable string) (string, error) {
 // The function arguments represent the array indices of each chemical species.
 func (m Mechanism) Chemistry() inmap.CellManipulator {
 	return func(c *inmap.Cell, Δt float64) {
-		// All SO4 forms particles, so sulfur particle formation is limited by the
-		// SO2 -> SO4 reaction.
-		ΔS := c.Cf[igS] - c.Cf[igS]*math.Exp(-c.SO2oxidation*Δt)
-		c.Cf[ipS] += ΔS
-		c.Cf[igS] -= ΔS
-		// NH3 / pNH4 partitioning
-		totalNH := c.Cf[igNH] + c.Cf[ipNH]
-		c.Cf[ipNH] = totalNH * c.NHPartitioning
-		c.Cf[igNH] = totalNH * (1 - c.NHPartitioning)
-
-		// NOx / pN0 partitioning
-		totalNO := c.Cf[igNO] + c.Cf[ipNO]
-		c.Cf[ipNO] = totalNO * c.NOPartitioning
-		c.Cf[igNO] = totalNO * (1 - c.NOPartitioning)
-
-		// VOC/SOA partitioning
-		totalOrg := c.Cf[igOrg] + c.Cf[ipOrg]
-		c.Cf[ipOrg] = totalOrg * c.AOrgPartitioning
-		c.Cf[igOrg] = totalOrg * (1 - c.AOrgPartitioning)
+		react(c, c.Cf, Δt)
 	}
 }
+
+// react carries out the chemical reactions described by Chemistry
+// on concentration array cf, using the chemical properties of Cell c.
+func react(c *inmap.Cell, cf []float64, Δt float64) {
+	// All SO4 forms particles, so sulfur particle formation is limited by the
+	// SO2 -> SO4 reaction.
+	ΔS := cf[igS] - cf[igS]*math.Exp(-c.SO2oxidation*Δt)
+	cf[ipS] += ΔS
+	cf[igS] -= ΔS
+	// NH3 / pNH4 partitioning
+	totalNH := cf[igNH] + cf[ipNH]
+	cf[ipNH] = totalNH * c.NHPartitioning
+	cf[igNH] = totalNH * (1 - c.NHPartitioning)
+
+	// NOx / pN0 partitioning
+	totalNO := cf[igNO] + cf[ipNO]
+	cf[ipNO] = totalNO * c.NOPartitioning
+	cf[igNO] = totalNO * (1 - c.NOPartitioning)
+
+	// VOC/SOA partitioning
+	totalOrg := cf[igOrg] + cf[ipOrg]
+	cf[ipOrg] = totalOrg * c.AOrgPartitioning
+	cf[igOrg] = totalOrg * (1 - c.AOrgPartitioning)
+}
@@ -0,0 +1,200 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package simplechem
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/evookelj/inmap"
+	"github.com/evookelj/inmap/science/drydep/simpledrydep"
+	"github.com/evookelj/inmap/science/wetdep/emepwetdep"
+)
+
+// Tagged fulfils the github.com/evookelj/inmap.TaggedMechanism
+// interface. It is the same as Mechanism except that it tracks the
+// concentrations resulting from N separate groups of emissions ("tags").
+// Because Mechanism is linear, the concentrations for each tag
+// are the same as the concentrations that would result from a separate
+// simulation containing only the emissions in that tag, which allows
+// the impacts of many emissions sources to be calculated in a single
+// simulation.
+//
+// The concentrations for tag t are stored in elements [t*9, (t+1)*9)
+// of the concentration arrays, and variable names for
+// tag t have the suffix "_t", e.g., "PrimaryPM25_3".
+type Tagged struct {
+	// N is the number of tags.
+	N int
+}
+
+// Len returns the number of chemical species in this mechanism (9*N).
+func (m Tagged) Len() int {
+	return Mechanism{}.Len() * m.N
+}
+
+// Tags returns the number of tags (N).
+func (m Tagged) Tags() int { return m.N }
+
+// offset returns the first concentration array index for the given tag.
+func (m Tagged) offset(tag int) int { return tag * Mechanism{}.Len() }
+
+// AddEmisFlux adds emissions flux to the first tag in Cell c.
+func (m Tagged) AddEmisFlux(c *inmap.Cell, name string, val float64) error {
+	return m.AddTaggedEmisFlux(c, name, 0, val)
+}
+
+// AddTaggedEmisFlux adds emissions flux to Cell c based on the given
+// pollutant name, tag, and amount in units of μg/s. The units of
+// the resulting flux are μg/m3/s.
+func (m Tagged) AddTaggedEmisFlux(c *inmap.Cell, name string, tag int, val float64) error {
+	if tag < 0 || tag >= m.N {
+		return fmt.Errorf("simplechem: invalid emissions tag %d; there are %d tags", tag, m.N)
+	}
+	fluxScale := 1. / c.Dx / c.Dy / c.Dz // μg/s /m/m/m = μg/m3/s
+	conv, ok := emisConv[name]
+	if !ok {
+		return fmt.Errorf("simplechem: '%s' is not a valid emissions species; valid options are VOC, NOx, NH3, SOx, and PM2_5", name)
+	}
+	if c.EmisFlux == nil {
+		c.EmisFlux = make([]float64, m.Len())
+	}
+	c.EmisFlux[m.offset(tag)+conv.i] += val * conv.conv * fluxScale
+	return nil
+}
+
+// tagIndices returns the array indices of the given species indices
+// for all tags.
+func (m Tagged) tagIndices(indices ...int) []int {
+	o := make([]int, 0, len(indices)*m.N)
+	for t := 0; t < m.N; t++ {
+		for _, i := range indices {
+			o = append(o, m.offset(t)+i)
+		}
+	}
+	return o
+}
+
+// DryDep returns a dry deposition function of the type indicated by
+// name that is compatible with this chemical mechanism.
+// Currently, the only valid option is "simple".
+func (m Tagged) DryDep(name string) (inmap.CellManipulator, error) {
+	if name != "simple" {
+		return nil, fmt.Errorf("simplechem: invalid dry deposition option %s; 'simple' is the only valid option", name)
+	}
+	return simpledrydep.DryDeposition(func() (simpledrydep.SOx, simpledrydep.NH3, simpledrydep.NOx, simpledrydep.VOC, simpledrydep.PM25) {
+		sox, nh3, nox, voc, pm25 := simpleDryDepIndices()
+		return m.tagIndices(sox...), m.tagIndices(nh3...), m.tagIndices(nox...), m.tagIndices(voc...), m.tagIndices(pm25...)
+	}), nil
+}
+
+// WetDep returns a wet deposition function of the type indicated by
+// name that is compatible with this chemical mechanism.
+// Currently, the only valid option is "emep".
+func (m Tagged) WetDep(name string) (inmap.CellManipulator, error) {
+	if name != "emep" {
+		return nil, fmt.Errorf("simplechem: invalid wet deposition option %s; 'emep' is the only valid option", name)
+	}
+	return emepwetdep.WetDeposition(func() (emepwetdep.SO2, emepwetdep.OtherGas, emepwetdep.PM25) {
+		so2, otherGas, pm25 := emepWetDepIndices()
+		return m.tagIndices(so2...), m.tagIndices(otherGas...), m.tagIndices(pm25...)
+	}), nil
+}
+
+// Species returns the names of the concentration pollutant
+// species that are used by this chemical mechanism, for each tag.
+func (m Tagged) Species() []string {
+	species := Mechanism{}.Species()
+	o := make([]string, 0, len(species)*m.N)
+	for t := 0; t < m.N; t++ {
+		for _, s := range species {
+			o = append(o, TagName(s, t))
+		}
+	}
+	return o
+}
+
+// TagName returns the name of the given variable for the given tag.
+func TagName(variable string, tag int) string {
+	return fmt.Sprintf("%s_%d", variable, tag)
+}
+
+// splitTag splits a variable name created by TagName into
+// the untagged variable name and the tag.
+func (m Tagged) splitTag(variable string) (string, int, error) {
+	i := strings.LastIndex(variable, "_")
+	if i < 0 {
+		return "", -1, fmt.Errorf("simplechem: variable name %s is missing a tag suffix", variable)
+	}
+	tag, err := strconv.Atoi(variable[i+1:])
+	if err != nil || tag < 0 || tag >= m.N {
+		return "", -1, fmt.Errorf("simplechem: invalid tag in variable name %s; there are %d tags", variable, m.N)
+	}
+	return variable[:i], tag, nil
+}
+
+// Value returns the concentration or emissions value of
+// the given tagged variable (e.g., "PrimaryPM25_3") in the given Cell.
+// It returns an error if given an invalid variable name.
+func (m Tagged) Value(c *inmap.Cell, variable string) (float64, error) {
+	name, tag, err := m.splitTag(variable)
+	if err != nil {
+		return math.NaN(), err
+	}
+	o := m.offset(tag)
+	if i, ok := emisLabels[name]; ok {
+		if c.EmisFlux != nil {
+			return c.EmisFlux[o+i], nil
+		}
+		return 0, nil
+	}
+	conv, ok := polLabels[name]
+	if !ok {
+		return math.NaN(), fmt.Errorf("simplechem: invalid variable name %s; valid names are %v", variable, m.Species())
+	}
+	var val float64
+	for ii, i := range conv.index {
+		val += c.Cf[o+i] * conv.conversion[ii]
+	}
+	return val, nil
+}
+
+// Units returns the units of the given tagged variable, or an
+// error if the variable name is invalid.
+func (m Tagged) Units(variable string) (string, error) {
+	name, _, err := m.splitTag(variable)
+	if err != nil {
+		return "", err
+	}
+	return Mechanism{}.Units(name)
+}
+
+// Chemistry returns a function that calculates the secondary formation of
+// PM2.5 separately for each tag, as described for Mechanism.Chemistry.
+func (m Tagged) Chemistry() inmap.CellManipulator {
+	n := Mechanism{}.Len()
+	return func(c *inmap.Cell, Δt float64) {
+		for t := 0; t < m.N; t++ {
+			o := m.offset(t)
+			react(c, c.Cf[o:o+n], Δt)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package simplechem
+
+import (
+	"testing"
+
+	"github.com/ctessum/geom"
+	"github.com/evookelj/inmap"
+)
+
+// runTestModel runs the test model with the given emissions and mechanism
+// and returns the grid cells.
+func runTestModel(t *testing.T, emis *inmap.Emissions, m inmap.Mechanism) []*inmap.Cell {
+	cfg, ctmdata, pop, popIndices, mr, mortIndices := inmap.VarGridTestData()
+	mutator, err := inmap.PopulationMutator(cfg, popIndices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	drydep, err := m.DryDep("simple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wetdep, err := m.WetDep("emep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &inmap.InMAP{
+		InitFuncs: []inmap.DomainManipulator{
+			cfg.RegularGrid(ctmdata, pop, popIndices, mr, mortIndices, emis, m),
+			cfg.MutateGrid(mutator, ctmdata, pop, mr, emis, m, nil),
+			inmap.SetTimestepCFL(),
+		},
+		RunFuncs: []inmap.DomainManipulator{
+			inmap.Calculations(inmap.AddEmissionsFlux()),
+			inmap.Calculations(inmap.UpwindAdvection(), inmap.Mixing(),
+				inmap.MeanderMixing(), drydep, wetdep, m.Chemistry()),
+			inmap.SteadyStateConvergenceCheck(10, cfg.PopGridColumn, m, nil),
+		},
+	}
+	if err = d.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return d.Cells()
+}
+
+// Test whether the concentrations of each tag are the same as
+// for separate simulations.
+func TestTagged(t *testing.T) {
+	const testTolerance = 1.e-8
+	sources := []*inmap.EmisRecord{
+		{SOx: E, NOx: E, PM25: E, VOC: E, NH3: E, Geom: geom.Point{X: -3999, Y: -3999.}},
+		{SOx: E / 2, NOx: E, PM25: E * 2, VOC: E, NH3: E, Geom: geom.Point{X: 1000, Y: 2000.}, Height: 200},
+	}
+	m := Tagged{N: 3} // The last tag has no emissions.
+	taggedEmis := inmap.NewEmissions()
+	for i, s := range sources {
+		s2 := *s
+		s2.Tag = i
+		taggedEmis.Add(&s2)
+	}
+	tagged := runTestModel(t, taggedEmis, m)
+
+	for tag, s := range sources {
+		emis := inmap.NewEmissions()
+		emis.Add(s)
+		separate := runTestModel(t, emis, Mechanism{})
+		if len(separate) != len(tagged) {
+			t.Fatalf("tag %d: different numbers of cells: %d != %d", tag, len(tagged), len(separate))
+		}
+		for _, v := range []string{"TotalPM25", "SOA", "pNH4", "pSO4", "pNO3", "SOx", "SOxEmissions"} {
+			var sum float64
+			for i, c := range separate {
+				want, err := Mechanism{}.Value(c, v)
+				if err != nil {
+					t.Fatal(err)
+				}
+				have, err := m.Value(tagged[i], TagName(v, tag))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if (want != 0 || have != 0) && different(have, want, testTolerance) {
+					t.Errorf("tag %d, %s, cell %d: %g != %g", tag, v, i, have, want)
+				}
+				sum += want
+			}
+			if sum == 0 {
+				t.Errorf("tag %d, %s: no concentrations", tag, v)
+			}
+		}
+	}
+	for i, c := range tagged {
+		v, err := m.Value(c, "TotalPM25_2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != 0 {
+			t.Errorf("cell %d: empty tag should have zero concentration but has %g", i, v)
+		}
+	}
+}
+
+func TestTagged_names(t *testing.T) {
+	m := Tagged{N: 2}
+	if len(m.Species()) != m.Len() {
+		t.Errorf("species and length don't match: %d != %d", len(m.Species()), m.Len())
+	}
+	if s := m.Species()[9]; s != "VOC_1" {
+		t.Errorf("species name: %s != VOC_1", s)
+	}
+	u, err := m.Units("SOA_1")
+	if err != nil {
+		t.Error(err)
+	}
+	if u != "μg/m³" {
+		t.Errorf("want: 'μg/m³'; have '%s'", u)
+	}
+	for _, v := range []string{"SOA", "SOA_2", "SOA_x", "xxxx_0"} {
+		if _, err := m.Units(v); err == nil {
+			t.Errorf("%s: should be an error", v)
+		}
+	}
+	c := &inmap.Cell{Dx: 1, Dy: 1, Dz: 1}
+	if err := m.AddTaggedEmisFlux(c, "VOC", 2, 1); err == nil {
+		t.Error("invalid tag should be an error")
+	}
+}
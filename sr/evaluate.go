@@ -0,0 +1,76 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sr
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/evookelj/inmap"
+	"github.com/evookelj/inmap/science/chem/simplechem"
+)
+
+// Concentrations runs a full InMAP simulation on the local computer
+// for the emissions specified by emis, using the grid that has already
+// been loaded by the receiver, and returns the resulting ground-level
+// concentrations. The results can be compared to those of
+// Reader.Concentrations to evaluate the accuracy of an SR matrix created
+// using the same grid. As specified in the EmisRecord documentation,
+// emission units should be in μg/s.
+//
+// numIterations specifies the number of iterations to run the simulation
+// for; if it is zero, the simulation is run until it converges.
+func (sr *SR) Concentrations(ctx context.Context, numIterations int, emis ...*inmap.EmisRecord) (*Concentrations, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var m simplechem.Mechanism
+	e := inmap.NewEmissions()
+	for _, r := range emis {
+		e.Add(r)
+	}
+
+	if err := sr.runLocal(m, e, numIterations); err != nil {
+		return nil, fmt.Errorf("sr: %v", err)
+	}
+
+	out := new(Concentrations)
+	for _, v := range []struct {
+		species string
+		data    *[]float64
+	}{
+		{"pNH4", &out.PNH4},
+		{"pNO3", &out.PNO3},
+		{"pSO4", &out.PSO4},
+		{"SOA", &out.SOA},
+		{"PrimaryPM25", &out.PrimaryPM25},
+	} {
+		for _, c := range sr.d.Cells() {
+			if c.Layer != 0 {
+				break
+			}
+			val, err := m.Value(c, v.species)
+			if err != nil {
+				return nil, err
+			}
+			*v.data = append(*v.data, val)
+		}
+	}
+	return out, nil
+}
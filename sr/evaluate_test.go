@@ -0,0 +1,94 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sr_test
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/evookelj/inmap"
+	"github.com/evookelj/inmap/sr"
+	"github.com/gonum/floats"
+)
+
+// TestSR_Concentrations checks whether the concentrations from a full
+// simulation match those predicted by an SR matrix created on the same grid
+// for emissions at the location of an SR matrix source.
+func TestSR_Concentrations(t *testing.T) {
+	config, err := loadConfig("../cmd/inmap/configExample.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	varGridFile := strings.TrimSuffix(config.VariableGridData, ".gob") + "_evaluate.gob"
+	saveTaggedSRGrid(t, varGridFile)
+	defer os.Remove(varGridFile)
+
+	const numIterations = 20
+	ctx := context.Background()
+	srFile := "../cmd/inmap/testdata/testSR_evaluate.ncf"
+	defer os.Remove(srFile)
+	varGridReader, err := os.Open(varGridFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := sr.NewSR(varGridReader, &config.VarGrid, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.RunTagged(ctx, srFile, []int{0}, 0, 10, 10, numIterations); err != nil {
+		t.Fatal(err)
+	}
+	r := openSRReader(t, srFile)
+
+	e := &inmap.EmisRecord{
+		Geom: r.Geometry()[3].Centroid(),
+		PM25: 1, NH3: 2, SOx: 3, NOx: 4, VOC: 5,
+	}
+	want, err := r.Concentrations(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	have, err := s.Concentrations(ctx, numIterations, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for pol, v := range map[string][2][]float64{
+		"PNH4":        {have.PNH4, want.PNH4},
+		"PNO3":        {have.PNO3, want.PNO3},
+		"PSO4":        {have.PSO4, want.PSO4},
+		"SOA":         {have.SOA, want.SOA},
+		"PrimaryPM25": {have.PrimaryPM25, want.PrimaryPM25},
+	} {
+		if len(v[0]) != len(v[1]) {
+			t.Fatalf("%s: length %d != %d", pol, len(v[0]), len(v[1]))
+		}
+		if floats.Max(v[1]) == 0 {
+			t.Errorf("%s: no concentrations", pol)
+		}
+		// The SR matrix values are stored with single precision.
+		tol := floats.Max(v[1]) * 1.e-6
+		for i, w := range v[1] {
+			if !floats.EqualWithinAbsOrRel(v[0][i], w, tol, 1.e-6) {
+				t.Errorf("%s %d: %g != %g", pol, i, v[0][i], w)
+			}
+		}
+	}
+}
@@ -0,0 +1,297 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sr
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+
+	"github.com/ctessum/cdf"
+	"github.com/ctessum/geom"
+	"github.com/evookelj/inmap"
+)
+
+// Merge combines the SR matrices in readers, which must have been created
+// using the same grid but can contain different layers or ranges of
+// source grid cells (for example, from runs with different begin and end
+// indices), and writes the result to w in the dense SR matrix format.
+// The merged SR matrix contains all of the layers in any of the inputs.
+// A source is considered to be present in an input if its PrimaryPM25
+// values are not all zero; if a source is present in more than one input,
+// the values from the last of those inputs are used, so that a
+// part of an SR matrix that has been recreated can replace the original.
+// Grid cell data such as population are copied from the first input.
+// The inputs can be in either the dense or sparse format.
+func Merge(w cdf.ReaderWriterAt, readers ...*Reader) error {
+	if len(readers) == 0 {
+		return fmt.Errorf("sr: no SR matrices to merge")
+	}
+	first := readers[0]
+	for i, r := range readers[1:] {
+		if err := checkSameGrid(first, r); err != nil {
+			return fmt.Errorf("sr: SR matrix %d can't be merged with SR matrix 0: %v", i+1, err)
+		}
+	}
+
+	// Find all of the layers, and the index of each layer in each input.
+	layerIndices := make(map[int][]int)
+	for i, r := range readers {
+		for j, l := range r.layers {
+			if _, ok := layerIndices[l]; !ok {
+				layerIndices[l] = make([]int, len(readers))
+				for k := range layerIndices[l] {
+					layerIndices[l][k] = -1
+				}
+			}
+			layerIndices[l][i] = j
+		}
+	}
+	layers := make([]int, 0, len(layerIndices))
+	for l := range layerIndices {
+		layers = append(layers, l)
+	}
+	sort.Ints(layers)
+
+	nCells := first.nCellsGroundLevel
+	h := newDenseHeader(len(layers), nCells, len(first.d.Cells()))
+	if err := addNonSRVars(h, first.File.Header); err != nil {
+		return err
+	}
+	addDenseSRVars(h)
+	h.Define()
+	for _, err := range h.Check() {
+		return fmt.Errorf("sr: creating merged SR file: %v", err)
+	}
+	f, err := cdf.Create(w, h)
+	if err != nil {
+		return fmt.Errorf("sr: creating merged SR file: %v", err)
+	}
+	if err = writeLayers(f, layers); err != nil {
+		return err
+	}
+	if err = copyCellVars(f, &first.File, nil); err != nil {
+		return err
+	}
+
+	for li, l := range layers {
+		// Figure out which input to use for each source.
+		owners := make([]int, nCells)
+		var nMissing int
+		for i := range owners {
+			owners[i] = -1
+			for j := len(readers) - 1; j >= 0; j-- {
+				rl := layerIndices[l][j]
+				if rl < 0 {
+					continue
+				}
+				v, err := readers[j].source("PrimaryPM25", rl, i)
+				if err != nil {
+					return err
+				}
+				if !allZero(v) {
+					owners[i] = j
+					break
+				}
+			}
+			if owners[i] < 0 {
+				nMissing++
+			}
+		}
+		if nMissing > 0 {
+			log.Printf("sr: %d of %d sources in layer %d are not present in any of the merged SR matrices", nMissing, nCells, l)
+		}
+		log.Printf("sr: merging layer %d", l)
+		for i, owner := range owners {
+			if owner < 0 {
+				continue
+			}
+			if err := copySource(f, readers[owner], layerIndices[l][owner], i, li, i, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Subset extracts the part of the SR matrix in r within region, which
+// should be in the SR matrix grid projection, and writes it to w as a
+// standalone SR matrix in the dense format. The subset contains the
+// ground-level grid cells whose centers are within region---which are used
+// as both sources and receptors---and the grid cells in the upper layers
+// above them.
+func Subset(w cdf.ReaderWriterAt, r *Reader, region geom.Polygonal) error {
+	cells := r.d.Cells()
+	var ground []int
+	groundIndex := make(map[int]int)
+	for i, g := range r.Geometry() {
+		if g.Centroid().Within(region) != geom.Outside {
+			groundIndex[i] = len(ground)
+			ground = append(ground, i)
+		}
+	}
+	if len(ground) == 0 {
+		return fmt.Errorf("sr: the subset region does not contain the center of any SR matrix grid cells")
+	}
+
+	// Find the grid cells in the upper layers that overlap the
+	// ground-level cells in the subset.
+	keepUpper := make(map[int]bool)
+	cellIndex := make(map[*inmap.Cell]int)
+	for i, c := range cells {
+		cellIndex[c] = i
+	}
+	for _, i := range ground {
+		above, _ := r.d.CellIntersections(cells[i].Polygonal)
+		for _, c := range above {
+			if c.Layer != 0 {
+				keepUpper[cellIndex[c]] = true
+			}
+		}
+	}
+	// The grid cell data are stored in the same order as the cells.
+	var keep []int
+	for i, c := range cells {
+		if c.Layer == 0 {
+			if _, ok := groundIndex[i]; ok {
+				keep = append(keep, i)
+			}
+		} else if keepUpper[i] {
+			keep = append(keep, i)
+		}
+	}
+
+	h := newDenseHeader(len(r.layers), len(ground), len(keep))
+	if err := addNonSRVars(h, r.File.Header); err != nil {
+		return err
+	}
+	addDenseSRVars(h)
+	h.Define()
+	for _, err := range h.Check() {
+		return fmt.Errorf("sr: creating subset SR file: %v", err)
+	}
+	f, err := cdf.Create(w, h)
+	if err != nil {
+		return fmt.Errorf("sr: creating subset SR file: %v", err)
+	}
+	if err = writeLayers(f, r.layers); err != nil {
+		return err
+	}
+	if err = copyCellVars(f, &r.File, keep); err != nil {
+		return err
+	}
+	for l := range r.layers {
+		log.Printf("sr: extracting subset of layer %d", r.layers[l])
+		for i, src := range ground {
+			if err := copySource(f, r, l, src, l, i, ground); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkSameGrid returns an error if the grids of SR matrices r1 and r2
+// don't match.
+func checkSameGrid(r1, r2 *Reader) error {
+	if n1, n2 := len(r1.d.Cells()), len(r2.d.Cells()); n1 != n2 {
+		return fmt.Errorf("numbers of grid cells don't match: %d != %d", n1, n2)
+	}
+	g1, g2 := r1.Geometry(), r2.Geometry()
+	if len(g1) != len(g2) {
+		return fmt.Errorf("numbers of ground-level grid cells don't match: %d != %d", len(g1), len(g2))
+	}
+	for i, g := range g1 {
+		if b1, b2 := g.Bounds(), g2[i].Bounds(); *b1 != *b2 {
+			return fmt.Errorf("ground-level grid cell %d geometry doesn't match: %v != %v", i, b1, b2)
+		}
+	}
+	return nil
+}
+
+// copySource copies all pollutant values for source index src in
+// SR layer index srcLayer of r to source index dst in SR layer
+// index dstLayer of f. If receptors is not nil, only the values at the
+// given receptor indices are copied.
+func copySource(f *cdf.File, r *Reader, srcLayer, src, dstLayer, dst int, receptors []int) error {
+	for _, pol := range polNames {
+		v, err := r.source(pol, srcLayer, src)
+		if err != nil {
+			return err
+		}
+		if receptors != nil {
+			vv := make([]float64, len(receptors))
+			for i, rec := range receptors {
+				vv[i] = v[rec]
+			}
+			v = vv
+		}
+		data32 := make([]float32, len(v))
+		for i, val := range v {
+			data32[i] = float32(val)
+		}
+		begin := []int{dstLayer, dst, 0}
+		end := []int{dstLayer, dst, len(data32)}
+		if _, err := f.Writer(pol, begin, end).Write(data32); err != nil {
+			return fmt.Errorf("sr: writing %s for layer %d source %d: %v", pol, dstLayer, dst, err)
+		}
+	}
+	return nil
+}
+
+// copyCellVars copies the grid cell data---i.e., the data for the
+// variables added by addNonSRVars other than the layers---from src to dst.
+// If cells is not nil, only the data for the cells with the given
+// indices are copied.
+func copyCellVars(dst, src *cdf.File, cells []int) error {
+	for _, v := range src.Header.Variables() {
+		if isSRVar(v) || v == "layers" {
+			continue
+		}
+		r := src.Reader(v, nil, nil)
+		buf := r.Zero(-1)
+		if _, err := r.Read(buf); err != nil {
+			return fmt.Errorf("sr: reading %s: %v", v, err)
+		}
+		if cells != nil {
+			in := reflect.ValueOf(buf)
+			out := reflect.MakeSlice(in.Type(), len(cells), len(cells))
+			for i, c := range cells {
+				out.Index(i).Set(in.Index(c))
+			}
+			buf = out.Interface()
+		}
+		n := reflect.ValueOf(buf).Len()
+		if _, err := dst.Writer(v, []int{0}, []int{n}).Write(buf); err != nil {
+			return fmt.Errorf("sr: writing %s: %v", v, err)
+		}
+	}
+	return nil
+}
+
+// allZero returns whether all of the values in v are zero.
+func allZero(v []float64) bool {
+	for _, vv := range v {
+		if vv != 0 {
+			return false
+		}
+	}
+	return true
+}
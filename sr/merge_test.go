@@ -0,0 +1,308 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sr
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/ctessum/cdf"
+	"github.com/ctessum/geom"
+	"github.com/evookelj/inmap"
+)
+
+// openGolden returns a reader for the golden SR matrix.
+func openGolden(t *testing.T) *Reader {
+	f, err := os.Open("../cmd/inmap/testdata/testSR_golden.ncf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// savePartialSR saves the sources with indices between begin and end
+// in SR layer index layer of r to file, as if they had been created by
+// a separate run, and returns a reader for the result.
+func savePartialSR(t *testing.T, r *Reader, file string, layer, begin, end int) *Reader {
+	w, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newDenseHeader(1, r.nCellsGroundLevel, len(r.d.Cells()))
+	if err = addNonSRVars(h, r.File.Header); err != nil {
+		t.Fatal(err)
+	}
+	addDenseSRVars(h)
+	h.Define()
+	f, err := cdf.Create(w, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = writeLayers(f, []int{r.layers[layer]}); err != nil {
+		t.Fatal(err)
+	}
+	if err = copyCellVars(f, &r.File, nil); err != nil {
+		t.Fatal(err)
+	}
+	for i := begin; i < end; i++ {
+		if err = copySource(f, r, layer, i, 0, i, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rr, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := NewReader(rr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func TestMerge(t *testing.T) {
+	golden := openGolden(t)
+	const (
+		partA      = "../cmd/inmap/testdata/testSR_partA.ncf"
+		partB      = "../cmd/inmap/testdata/testSR_partB.ncf"
+		partC      = "../cmd/inmap/testdata/testSR_partC.ncf"
+		partD      = "../cmd/inmap/testdata/testSR_partD.ncf"
+		mergedFile = "../cmd/inmap/testdata/testSR_merged.ncf"
+	)
+	defer os.Remove(partA)
+	defer os.Remove(partB)
+	defer os.Remove(partC)
+	defer os.Remove(partD)
+	defer os.Remove(mergedFile)
+	n := golden.nCellsGroundLevel
+	// The upper layers are first, and the first layer is split into
+	// overlapping index ranges.
+	readers := []*Reader{
+		savePartialSR(t, golden, partD, 2, 0, n),
+		savePartialSR(t, golden, partC, 1, 0, n),
+		savePartialSR(t, golden, partA, 0, 0, 6),
+		savePartialSR(t, golden, partB, 0, 4, n),
+	}
+	w, err := os.Create(mergedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Merge(w, readers...); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(mergedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	merged, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(merged.layers, golden.layers) {
+		t.Errorf("layers: %v != %v", merged.layers, golden.layers)
+	}
+	for _, pol := range polNames {
+		for l := range golden.layers {
+			for i := 0; i < n; i++ {
+				want, err := golden.Source(pol, l, i)
+				if err != nil {
+					t.Fatal(err)
+				}
+				have, err := merged.Source(pol, l, i)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(have, want) {
+					t.Errorf("%s layer %d source %d: %v != %v", pol, l, i, have, want)
+				}
+			}
+		}
+	}
+	wantVars, err := golden.Variables("TotalPop", "BaselineTotalPM25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	haveVars, err := merged.Variables("TotalPop", "BaselineTotalPM25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(haveVars, wantVars) {
+		t.Errorf("InMAP variables don't match")
+	}
+}
+
+func TestMerge_gridMismatch(t *testing.T) {
+	golden := openGolden(t)
+	const subsetFile = "../cmd/inmap/testdata/testSR_subset.ncf"
+	defer os.Remove(subsetFile)
+	w, err := os.Create(subsetFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	region := geom.Polygon{{
+		{X: -4000, Y: -4000}, {X: -2500, Y: -4000}, {X: -2500, Y: -3000}, {X: -4000, Y: -3000},
+	}}
+	if err = Subset(w, golden, region); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	f, err := os.Open(subsetFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	subset, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Merge(nil, golden, subset); err == nil {
+		t.Errorf("merging SR matrices with different grids should cause an error")
+	}
+}
+
+func TestSubset(t *testing.T) {
+	golden := openGolden(t)
+	const subsetFile = "../cmd/inmap/testdata/testSR_subset.ncf"
+	defer os.Remove(subsetFile)
+	region := geom.Polygon{{
+		{X: -4000, Y: -4000}, {X: -2500, Y: -4000}, {X: -2500, Y: -3000}, {X: -4000, Y: -3000},
+	}}
+	w, err := os.Create(subsetFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Subset(w, golden, region); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(subsetFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	subset, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Find the ground-level cells that should be in the subset.
+	var kept []int
+	for i, g := range golden.Geometry() {
+		if g.Centroid().Within(region) != geom.Outside {
+			kept = append(kept, i)
+		}
+	}
+	if len(kept) == 0 || len(kept) == golden.nCellsGroundLevel {
+		t.Fatalf("the test region should include some but not all grid cells; it includes %d", len(kept))
+	}
+	if subset.nCellsGroundLevel != len(kept) {
+		t.Fatalf("ground-level cells: %d != %d", subset.nCellsGroundLevel, len(kept))
+	}
+	g := subset.Geometry()
+	for i, k := range kept {
+		if have, want := g[i].Bounds(), golden.Geometry()[k].Bounds(); *have != *want {
+			t.Errorf("cell %d bounds: %v != %v", i, have, want)
+		}
+	}
+	if !reflect.DeepEqual(subset.layers, golden.layers) {
+		t.Errorf("layers: %v != %v", subset.layers, golden.layers)
+	}
+
+	for _, pol := range polNames {
+		for l := range golden.layers {
+			for i, k := range kept {
+				v, err := golden.Source(pol, l, k)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := make([]float64, len(kept))
+				for j, kk := range kept {
+					want[j] = v[kk]
+				}
+				have, err := subset.Source(pol, l, i)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(have, want) {
+					t.Errorf("%s layer %d source %d: %v != %v", pol, l, i, have, want)
+				}
+			}
+		}
+	}
+
+	wantVars, err := golden.Variables("TotalPop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	haveVars, err := subset.Variables("TotalPop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, k := range kept {
+		if haveVars["TotalPop"][i] != wantVars["TotalPop"][k] {
+			t.Errorf("TotalPop %d: %g != %g", i, haveVars["TotalPop"][i], wantVars["TotalPop"][k])
+		}
+	}
+
+	// Elevated emissions within the region should have the same impacts
+	// as in the full SR matrix.
+	e := &inmap.EmisRecord{
+		Geom: golden.Geometry()[kept[0]].Centroid(),
+		PM25: 1, NH3: 1, SOx: 1, NOx: 1, VOC: 1,
+		Height: 20,
+	}
+	wantConc, err := golden.Concentrations(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	haveConc, err := subset.Concentrations(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, have := wantConc.TotalPM25(), haveConc.TotalPM25()
+	if allZero(have) {
+		t.Errorf("no concentrations")
+	}
+	for i, k := range kept {
+		if have[i] != want[k] {
+			t.Errorf("TotalPM25 %d: %g != %g", i, have[i], want[k])
+		}
+	}
+
+	if err = Subset(nil, golden, geom.Polygon{{
+		{X: 1.e6, Y: 1.e6}, {X: 2.e6, Y: 1.e6}, {X: 2.e6, Y: 2.e6}, {X: 1.e6, Y: 2.e6},
+	}}); err == nil {
+		t.Errorf("a region outside of the grid should cause an error")
+	}
+}
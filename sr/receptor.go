@@ -0,0 +1,205 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sr
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ctessum/geom"
+)
+
+// receptorChunkGap is the largest number of unrequested receptors
+// between two requested receptors for which the two receptors will be read
+// in the same chunk.
+const receptorChunkGap = 1024
+
+// Receptor returns concentrations in μg m-3 at the receptor grid cells
+// with the given horizontal indices caused by emissions in μg s-1 of
+// pollutant pol in each horizontal grid cell in SR layer index 'layer'.
+// The returned value o[j][i] is the concentration at receptor
+// receptorIndices[j] caused by emissions at source i.
+//
+// Rather than reading the concentrations at all receptors for every
+// source, only the chunks of each source's data that contain the requested
+// receptors are read, so this function is fastest when the requested
+// receptors are close together in the grid. Unlike Source, the results
+// are not cached.
+func (sr *Reader) Receptor(pol string, layer int, receptorIndices []int) ([][]float64, error) {
+	if err := sr.checkRequest(pol, layer); err != nil {
+		return nil, err
+	}
+	for _, r := range receptorIndices {
+		if r < 0 || r >= sr.nCellsGroundLevel {
+			return nil, fmt.Errorf("sr: requested receptor %d is not between 0 and the number of grid cells (%d)", r, sr.nCellsGroundLevel)
+		}
+	}
+	o := make([][]float64, len(receptorIndices))
+	for j := range o {
+		o[j] = make([]float64, sr.nCellsGroundLevel)
+	}
+	if len(receptorIndices) == 0 {
+		return o, nil
+	}
+	if sr.sparse {
+		return o, sr.receptorSparse(o, pol, layer, receptorIndices)
+	}
+	return o, sr.receptorDense(o, pol, layer, receptorIndices)
+}
+
+// receptorChunk is a contiguous range of receptors that
+// contains at least one requested receptor.
+type receptorChunk struct {
+	begin, end int // end is inclusive.
+	// positions holds the index in the original request of each
+	// requested receptor in the chunk.
+	positions map[int][]int
+}
+
+// receptorChunks groups the given receptor indices into chunks.
+func receptorChunks(receptorIndices []int) []*receptorChunk {
+	sorted := append([]int{}, receptorIndices...)
+	sort.Ints(sorted)
+	var chunks []*receptorChunk
+	var c *receptorChunk
+	for _, r := range sorted {
+		if c == nil || r-c.end > receptorChunkGap {
+			c = &receptorChunk{begin: r, positions: make(map[int][]int)}
+			chunks = append(chunks, c)
+		}
+		c.end = r
+	}
+	for j, r := range receptorIndices {
+		for _, c := range chunks {
+			if r >= c.begin && r <= c.end {
+				c.positions[r] = append(c.positions[r], j)
+				break
+			}
+		}
+	}
+	return chunks
+}
+
+// receptorDense fills in o for a dense SR matrix.
+func (sr *Reader) receptorDense(o [][]float64, pol string, layer int, receptorIndices []int) error {
+	for _, c := range receptorChunks(receptorIndices) {
+		for i := 0; i < sr.nCellsGroundLevel; i++ {
+			v, err := sr.get(pol, []int{layer, i, c.begin}, []int{layer, i, c.end})
+			if err != nil {
+				return err
+			}
+			for r, positions := range c.positions {
+				for _, j := range positions {
+					o[j][i] = v[r-c.begin]
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// receptorSparse fills in o for a sparse SR matrix.
+func (sr *Reader) receptorSparse(o [][]float64, pol string, layer int, receptorIndices []int) error {
+	offsetVar, receptorVar, valueVar := sparseVarNames(pol)
+	positions := make(map[int32][]int)
+	for j, r := range receptorIndices {
+		positions[int32(r)] = append(positions[int32(r)], j)
+	}
+
+	// Read the offsets for the whole layer at once.
+	start := layer * sr.nCellsGroundLevel
+	r := sr.File.Reader(offsetVar, []int{start}, []int{start + sr.nCellsGroundLevel})
+	buf := r.Zero(-1)
+	if _, err := r.Read(buf); err != nil {
+		return err
+	}
+	offsets := buf.([]int32)
+
+	for i := 0; i < sr.nCellsGroundLevel; i++ {
+		if offsets[i] == offsets[i+1] {
+			continue
+		}
+		r := sr.File.Reader(receptorVar, []int{int(offsets[i])}, []int{int(offsets[i+1]) - 1})
+		buf := r.Zero(-1)
+		if _, err := r.Read(buf); err != nil {
+			return err
+		}
+		// Only read the values between the first and
+		// last matching receptors.
+		first, last := -1, -1
+		for k, rr := range buf.([]int32) {
+			if _, ok := positions[rr]; ok {
+				if first < 0 {
+					first = k
+				}
+				last = k
+			}
+		}
+		if first < 0 {
+			continue
+		}
+		receptors := buf.([]int32)[first : last+1]
+		begin := int(offsets[i]) + first
+		r = sr.File.Reader(valueVar, []int{begin}, []int{begin + last - first})
+		buf = r.Zero(-1)
+		if _, err := r.Read(buf); err != nil {
+			return err
+		}
+		for k, v := range buf.([]float32) {
+			for _, j := range positions[receptors[k]] {
+				o[j][i] = float64(v)
+			}
+		}
+	}
+	return nil
+}
+
+// ReceptorRegion returns the area-weighted average concentrations in
+// μg m-3 within the receptor region g caused by emissions in μg s-1
+// of pollutant pol in each horizontal grid cell in SR layer index 'layer'.
+// The portions of g that are outside of the SR matrix grid are ignored,
+// and an error is returned if g does not overlap the grid.
+func (sr *Reader) ReceptorRegion(pol string, layer int, g geom.Polygonal) ([]float64, error) {
+	cells, fractions := sr.d.CellIntersections(g)
+	var receptors []int
+	var weights []float64
+	var totalWeight float64
+	for i, c := range cells {
+		if c.Layer != 0 {
+			continue
+		}
+		receptors = append(receptors, sr.indices[c])
+		weights = append(weights, fractions[i])
+		totalWeight += fractions[i]
+	}
+	if len(receptors) == 0 {
+		return nil, fmt.Errorf("sr: receptor region does not overlap the SR matrix grid")
+	}
+	v, err := sr.Receptor(pol, layer, receptors)
+	if err != nil {
+		return nil, err
+	}
+	o := make([]float64, sr.nCellsGroundLevel)
+	for j, w := range weights {
+		for i, vv := range v[j] {
+			o[i] += vv * w / totalWeight
+		}
+	}
+	return o, nil
+}
@@ -0,0 +1,168 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sr
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/ctessum/geom"
+	"github.com/gonum/floats"
+)
+
+func TestReceptor(t *testing.T) {
+	const goldenFile = "../cmd/inmap/testdata/testSR_golden.ncf"
+	f, err := os.Open(goldenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	dense, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const sparseFile = "../cmd/inmap/testdata/testSR_receptor_sparse.ncf"
+	defer os.Remove(sparseFile)
+	sparse := convertSparseFile(t, goldenFile, sparseFile, 0)
+
+	// Receptors are out of order and repeated.
+	receptors := []int{5, 0, 5, dense.nCellsGroundLevel - 1, 2}
+	for name, r := range map[string]*Reader{"dense": dense, "sparse": sparse} {
+		t.Run(name, func(t *testing.T) {
+			for _, pol := range polNames {
+				for l := range r.layers {
+					have, err := r.Receptor(pol, l, receptors)
+					if err != nil {
+						t.Fatal(err)
+					}
+					for i := 0; i < r.nCellsGroundLevel; i++ {
+						want, err := dense.Source(pol, l, i)
+						if err != nil {
+							t.Fatal(err)
+						}
+						for j, rr := range receptors {
+							if have[j][i] != want[rr] {
+								t.Errorf("%s layer %d source %d receptor %d: %g != %g", pol, l, i, rr, have[j][i], want[rr])
+							}
+						}
+					}
+				}
+			}
+			if _, err := r.Receptor("PrimaryPM25", 0, []int{r.nCellsGroundLevel}); err == nil {
+				t.Error("invalid receptor should cause an error")
+			}
+			if _, err := r.Receptor("xxx", 0, []int{0}); err == nil {
+				t.Error("invalid pollutant should cause an error")
+			}
+		})
+	}
+}
+
+func TestReceptorChunks(t *testing.T) {
+	chunks := receptorChunks([]int{5000, 3, 1000, 3})
+	want := []*receptorChunk{
+		{begin: 3, end: 1000, positions: map[int][]int{3: {1, 3}, 1000: {2}}},
+		{begin: 5000, end: 5000, positions: map[int][]int{5000: {0}}},
+	}
+	if !reflect.DeepEqual(chunks, want) {
+		t.Errorf("have %+v, want %+v", chunks, want)
+	}
+}
+
+func TestReceptorRegion(t *testing.T) {
+	f, err := os.Open("../cmd/inmap/testdata/testSR_golden.ncf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := r.Geometry()
+
+	// A region that is the same as a grid cell should have the
+	// same concentrations as that grid cell.
+	have, err := r.ReceptorRegion("pSO4", 1, g[4])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := r.Receptor("pSO4", 1, []int{4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(have, want[0]) {
+		t.Errorf("single cell: %v != %v", have, want[0])
+	}
+
+	// A region that covers one quarter of a cell and also extends
+	// outside of the grid should have the same concentrations as that cell.
+	b := g[0].Bounds()
+	dx, dy := b.Max.X-b.Min.X, b.Max.Y-b.Min.Y
+	region := geom.Polygon{{
+		{X: b.Min.X - dx, Y: b.Min.Y - dy},
+		{X: b.Min.X + dx/2, Y: b.Min.Y - dy},
+		{X: b.Min.X + dx/2, Y: b.Min.Y + dy/2},
+		{X: b.Min.X - dx, Y: b.Min.Y + dy/2},
+	}}
+	have, err = r.ReceptorRegion("pSO4", 1, region)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err = r.Receptor("pSO4", 1, []int{0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, w := range want[0] {
+		if !floats.EqualWithinRel(have[i], w, 1.e-10) {
+			t.Errorf("partial cell %d: %g != %g", i, have[i], w)
+		}
+	}
+
+	// A region that covers all of cell 0 and half of cell 3, which
+	// are the same size, should weight them 2:1.
+	region = geom.Polygon{{
+		{X: -4000, Y: -4000}, {X: -2500, Y: -4000}, {X: -2500, Y: -3000}, {X: -4000, Y: -3000},
+	}}
+	have, err = r.ReceptorRegion("pSO4", 1, region)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err = r.Receptor("pSO4", 1, []int{0, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range have {
+		w := want[0][i]*2/3 + want[1][i]/3
+		if !floats.EqualWithinRel(have[i], w, 1.e-10) {
+			t.Errorf("two cells %d: %g != %g", i, have[i], w)
+		}
+	}
+
+	outside := geom.Polygon{{
+		{X: b.Min.X - 3*dx, Y: b.Min.Y - 3*dy},
+		{X: b.Min.X - 2*dx, Y: b.Min.Y - 3*dy},
+		{X: b.Min.X - 2*dx, Y: b.Min.Y - 2*dy},
+		{X: b.Min.X - 3*dx, Y: b.Min.Y - 2*dy},
+	}}
+	if _, err = r.ReceptorRegion("pSO4", 1, outside); err == nil {
+		t.Error("region outside the grid should cause an error")
+	}
+}
@@ -0,0 +1,219 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sr
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync"
+
+	"github.com/ctessum/requestcache"
+	"gocloud.dev/blob"
+)
+
+// RemoteReader is a read-only cdf.ReaderWriterAt for a file that is stored
+// remotely, which allows an SR matrix that is too large to download to be
+// used by passing a RemoteReader to NewReader. Data are requested from the
+// remote location in blocks, and recently used blocks are held in a
+// memory cache. A RemoteReader should be closed with Close when it is no
+// longer needed.
+type RemoteReader struct {
+	size int64
+
+	// ctx is the context for requests to the remote location.
+	ctx context.Context
+
+	// readRange reads length bytes starting at offset from
+	// the remote file.
+	readRange func(ctx context.Context, offset, length int64) ([]byte, error)
+
+	// BlockSize specifies the number of bytes in each request to the
+	// remote location. The default is 1 MiB.
+	// BlockSize can only be changed before the RemoteReader has been used
+	// to read data for the first time.
+	BlockSize int64
+
+	// CacheSize specifies the number of blocks to be held in the memory
+	// cache. The default is 256.
+	// CacheSize can only be changed before the RemoteReader has been used
+	// to read data for the first time.
+	CacheSize int
+
+	// blockCache is a cache for blocks of data. It is created the
+	// first time data are read.
+	blockCache *requestcache.Cache
+	// closed is whether Close has been called.
+	closed bool
+	// mu protects blockCache and closed.
+	mu sync.Mutex
+}
+
+func newRemoteReader(ctx context.Context, size int64, readRange func(ctx context.Context, offset, length int64) ([]byte, error)) *RemoteReader {
+	return &RemoteReader{
+		ctx:       ctx,
+		size:      size,
+		readRange: readRange,
+		BlockSize: 1 << 20,
+		CacheSize: 256,
+	}
+}
+
+// NewHTTPReader returns a RemoteReader for the file at the given URL,
+// which must be served by a server that supports HTTP range requests.
+// If client is nil, http.DefaultClient is used. ctx is used for all
+// requests to the server, so cancelling it causes later reads to fail.
+func NewHTTPReader(ctx context.Context, url string, client *http.Client) (*RemoteReader, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequest(http.MethodHead, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("sr: opening remote file: %v", err)
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("sr: opening remote file: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("sr: opening remote file %s: %s", url, resp.Status)
+	}
+	if resp.ContentLength < 0 {
+		return nil, fmt.Errorf("sr: opening remote file %s: unknown file size", url)
+	}
+	return newRemoteReader(ctx, resp.ContentLength, func(ctx context.Context, offset, length int64) ([]byte, error) {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-"+strconv.FormatInt(offset+length-1, 10))
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusPartialContent {
+			return nil, fmt.Errorf("sr: reading remote file %s: server responded to range request with %s", url, resp.Status)
+		}
+		return ioutil.ReadAll(resp.Body)
+	}), nil
+}
+
+// NewBlobReader returns a RemoteReader for the file with the
+// given key in bucket. ctx is used for all requests to the bucket,
+// so cancelling it causes later reads to fail.
+func NewBlobReader(ctx context.Context, bucket *blob.Bucket, key string) (*RemoteReader, error) {
+	attrs, err := bucket.Attributes(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("sr: opening remote file: %v", err)
+	}
+	return newRemoteReader(ctx, attrs.Size, func(ctx context.Context, offset, length int64) ([]byte, error) {
+		r, err := bucket.NewRangeReader(ctx, key, offset, length, nil)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	}), nil
+}
+
+// Size returns the size of the remote file in bytes.
+func (r *RemoteReader) Size() int64 { return r.size }
+
+// ReadAt implements the io.ReaderAt interface, using the context
+// the RemoteReader was created with for any requests to the remote location.
+// It is concurrency-safe.
+func (r *RemoteReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("sr: negative offset %d", off)
+	}
+	cache, err := r.cache()
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	for n < len(p) && off+int64(n) < r.size {
+		pos := off + int64(n)
+		block := pos / r.BlockSize
+		req := cache.NewRequest(r.ctx, block, strconv.FormatInt(block, 10))
+		result, err := req.Result()
+		if err != nil {
+			return n, fmt.Errorf("sr: reading remote file: %v", err)
+		}
+		n += copy(p[n:], result.([]byte)[pos-block*r.BlockSize:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// cache returns the block cache, creating it if necessary.
+func (r *RemoteReader) cache() (*requestcache.Cache, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil, fmt.Errorf("sr: reading remote file: RemoteReader is closed")
+	}
+	if r.blockCache == nil {
+		r.blockCache = requestcache.NewCache(func(ctx context.Context, request interface{}) (interface{}, error) {
+			return r.readBlock(ctx, request.(int64))
+		}, runtime.GOMAXPROCS(-1),
+			requestcache.Deduplicate(), requestcache.Memory(r.CacheSize))
+	}
+	return r.blockCache, nil
+}
+
+// Close releases the memory cache. Reads after Close return an error.
+func (r *RemoteReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	r.blockCache = nil
+	return nil
+}
+
+// readBlock reads the block with the given index from the remote file.
+func (r *RemoteReader) readBlock(ctx context.Context, block int64) ([]byte, error) {
+	offset := block * r.BlockSize
+	length := r.BlockSize
+	if offset+length > r.size {
+		length = r.size - offset
+	}
+	b, err := r.readRange(ctx, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) != length {
+		return nil, fmt.Errorf("sr: reading block %d: received %d bytes rather than %d", block, len(b), length)
+	}
+	return b, nil
+}
+
+// WriteAt implements the io.WriterAt interface. RemoteReaders are
+// read-only, so it always returns an error.
+func (r *RemoteReader) WriteAt(p []byte, off int64) (int, error) {
+	return 0, fmt.Errorf("sr: remote files are read-only")
+}
@@ -0,0 +1,177 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sr
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/ctessum/geom"
+	"github.com/evookelj/inmap"
+	"gocloud.dev/blob/fileblob"
+)
+
+func TestRemoteReader(t *testing.T) {
+	const goldenFile = "../cmd/inmap/testdata/testSR_golden.ncf"
+	data, err := ioutil.ReadFile(goldenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(goldenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	local, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(http.FileServer(http.Dir("../cmd/inmap/testdata")))
+	defer ts.Close()
+	httpReader, err := NewHTTPReader(context.Background(), ts.URL+"/testSR_golden.ncf", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket, err := fileblob.OpenBucket("../cmd/inmap/testdata", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobReader, err := NewBlobReader(context.Background(), bucket, "testSR_golden.ncf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, rr := range map[string]*RemoteReader{"http": httpReader, "blob": blobReader} {
+		t.Run(name, func(t *testing.T) {
+			// Use small blocks and a small cache so that reads span
+			// multiple blocks and blocks are evicted from the cache.
+			rr.BlockSize = 1000
+			rr.CacheSize = 4
+			if rr.Size() != int64(len(data)) {
+				t.Fatalf("size: %d != %d", rr.Size(), len(data))
+			}
+
+			b := make([]byte, 2500)
+			n, err := rr.ReadAt(b, 900)
+			if err != nil || n != len(b) {
+				t.Errorf("n=%d, err=%v", n, err)
+			}
+			if !bytes.Equal(b, data[900:3400]) {
+				t.Error("data don't match")
+			}
+			n, err = rr.ReadAt(b, int64(len(data)-10))
+			if err != io.EOF || n != 10 {
+				t.Errorf("reading past end: n=%d, err=%v", n, err)
+			}
+			if !bytes.Equal(b[:n], data[len(data)-10:]) {
+				t.Error("data at end don't match")
+			}
+			if _, err = rr.WriteAt(b, 0); err == nil {
+				t.Error("writing should cause an error")
+			}
+
+			remote, err := NewReader(rr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, pol := range polNames {
+				for l := range local.layers {
+					for i := 0; i < local.nCellsGroundLevel; i++ {
+						want, err := local.Source(pol, l, i)
+						if err != nil {
+							t.Fatal(err)
+						}
+						have, err := remote.Source(pol, l, i)
+						if err != nil {
+							t.Fatal(err)
+						}
+						if !reflect.DeepEqual(have, want) {
+							t.Errorf("%s layer %d source %d: %v != %v", pol, l, i, have, want)
+						}
+					}
+				}
+			}
+			e := &inmap.EmisRecord{
+				Geom: geom.Point{X: -3999, Y: -3999},
+				PM25: 1, NH3: 1, SOx: 1, NOx: 1, VOC: 1,
+			}
+			want, err := local.Concentrations(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			have, err := remote.Concentrations(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(have, want) {
+				t.Errorf("concentrations: %v != %v", have, want)
+			}
+
+			if err = rr.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = rr.ReadAt(b, 0); err == nil {
+				t.Error("reading after closing should cause an error")
+			}
+			if err = rr.Close(); err != nil {
+				t.Errorf("closing twice: %v", err)
+			}
+		})
+	}
+}
+
+func TestRemoteReader_noRange(t *testing.T) {
+	// This server ignores range requests.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "10")
+		w.Write(make([]byte, 10))
+	}))
+	defer ts.Close()
+	rr, err := NewHTTPReader(context.Background(), ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = rr.ReadAt(make([]byte, 5), 0); err == nil {
+		t.Error("a server that doesn't support range requests should cause an error")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rr, err = NewHTTPReader(ctx, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err = rr.ReadAt(make([]byte, 5), 0); err == nil {
+		t.Error("reading with a cancelled context should cause an error")
+	}
+
+	ts404 := httptest.NewServer(http.NotFoundHandler())
+	defer ts404.Close()
+	if _, err = NewHTTPReader(context.Background(), ts404.URL, nil); err == nil {
+		t.Error("a missing file should cause an error")
+	}
+}
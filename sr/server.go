@@ -0,0 +1,164 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sr
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/ctessum/geom/encoding/geojson"
+	"github.com/evookelj/inmap"
+)
+
+// EmissionsJSON is the JSON representation of an inmap.EmisRecord used
+// by the handler created by NewHandler. Geometry is a GeoJSON geometry in the
+// SR matrix grid projection.
+type EmissionsJSON struct {
+	Geometry           *geojson.Geometry
+	VOC, NOx, NH3, SOx float64 // emissions [μg/s]
+	PM25               float64 // emissions [μg/s]
+	Height, Diam, Temp float64 // stack height [m], diameter [m], and temperature [K]
+	Velocity           float64 // stack velocity [m/s]
+}
+
+// ConcentrationsJSON is the response to a concentrations request to
+// the handler created by NewHandler. If the emissions were above the top
+// layer of the SR matrix, Warning holds the AboveTopErr message.
+type ConcentrationsJSON struct {
+	*Concentrations
+	Warning string `json:",omitempty"`
+}
+
+// NewHandler returns an HTTP handler that provides a JSON API for
+// the SR matrix in r. It responds to the following requests:
+//
+// GET /geometry returns the SR matrix grid cell geometry as an array of
+// GeoJSON geometries in the native grid projection.
+//
+// GET /source?pollutant=<pol>&layer=<layer>&index=<index> returns the
+// array of concentrations returned by r.Source.
+//
+// POST /concentrations, with a request body containing an array of
+// EmissionsJSON, returns the concentrations caused by the emissions
+// as a ConcentrationsJSON.
+func NewHandler(r *Reader) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/geometry", r.geometryHandler)
+	mux.HandleFunc("/source", r.sourceHandler)
+	mux.HandleFunc("/concentrations", r.concentrationsHandler)
+	return mux
+}
+
+func (sr *Reader) geometryHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "sr: geometry requests must use the GET method", http.StatusMethodNotAllowed)
+		return
+	}
+	g := sr.Geometry()
+	o := make([]*geojson.Geometry, len(g))
+	for i, gg := range g {
+		var err error
+		if o[i], err = geojson.ToGeoJSON(gg); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	writeJSON(w, o)
+}
+
+func (sr *Reader) sourceHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "sr: source requests must use the GET method", http.StatusMethodNotAllowed)
+		return
+	}
+	pol := req.FormValue("pollutant")
+	layer, err := strconv.Atoi(req.FormValue("layer"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("sr: invalid layer: %v", err), http.StatusBadRequest)
+		return
+	}
+	index, err := strconv.Atoi(req.FormValue("index"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("sr: invalid index: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err = sr.checkRequest(pol, layer); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if index < 0 || index >= sr.nCellsGroundLevel {
+		http.Error(w, fmt.Sprintf("sr: index %d is not between 0 and the number of grid cells (%d)", index, sr.nCellsGroundLevel), http.StatusBadRequest)
+		return
+	}
+	v, err := sr.Source(pol, layer, index)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, v)
+}
+
+func (sr *Reader) concentrationsHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "sr: concentrations requests must use the POST method", http.StatusMethodNotAllowed)
+		return
+	}
+	var ej []EmissionsJSON
+	if err := json.NewDecoder(req.Body).Decode(&ej); err != nil {
+		http.Error(w, fmt.Sprintf("sr: decoding emissions: %v", err), http.StatusBadRequest)
+		return
+	}
+	emis := make([]*inmap.EmisRecord, len(ej))
+	for i, e := range ej {
+		if e.Geometry == nil {
+			http.Error(w, fmt.Sprintf("sr: emissions record %d is missing its geometry", i), http.StatusBadRequest)
+			return
+		}
+		g, err := geojson.FromGeoJSON(e.Geometry)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("sr: decoding emissions record %d geometry: %v", i, err), http.StatusBadRequest)
+			return
+		}
+		emis[i] = &inmap.EmisRecord{
+			Geom: g,
+			VOC:  e.VOC, NOx: e.NOx, NH3: e.NH3, SOx: e.SOx, PM25: e.PM25,
+			Height: e.Height, Diam: e.Diam, Temp: e.Temp, Velocity: e.Velocity,
+		}
+	}
+	conc, err := sr.Concentrations(emis...)
+	o := ConcentrationsJSON{Concentrations: conc}
+	if err != nil {
+		if _, ok := err.(AboveTopErr); !ok {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		o.Warning = err.Error()
+	}
+	writeJSON(w, o)
+}
+
+// writeJSON writes v to w in JSON format.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
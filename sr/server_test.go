@@ -0,0 +1,161 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sr
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ctessum/geom"
+	"github.com/ctessum/geom/encoding/geojson"
+	"github.com/evookelj/inmap"
+)
+
+func TestHandler(t *testing.T) {
+	f, err := os.Open("../cmd/inmap/testdata/testSR_golden.ncf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(NewHandler(r))
+	defer ts.Close()
+
+	get := func(t *testing.T, path string, v interface{}) {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: %s", path, resp.Status)
+		}
+		if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("geometry", func(t *testing.T) {
+		var have []*geojson.Geometry
+		get(t, "/geometry", &have)
+		want := r.Geometry()
+		if len(have) != len(want) {
+			t.Fatalf("length: %d != %d", len(have), len(want))
+		}
+		for i, h := range have {
+			g, err := geojson.FromGeoJSON(h)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(g.Bounds(), want[i].Bounds()) {
+				t.Errorf("cell %d: %v != %v", i, g.Bounds(), want[i].Bounds())
+			}
+		}
+	})
+
+	t.Run("source", func(t *testing.T) {
+		var have []float64
+		get(t, "/source?pollutant=pSO4&layer=1&index=3", &have)
+		want, err := r.Source("pSO4", 1, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("%v != %v", have, want)
+		}
+	})
+
+	t.Run("concentrations", func(t *testing.T) {
+		g, err := geojson.ToGeoJSON(geom.Point{X: -3999, Y: -3999})
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := json.Marshal([]EmissionsJSON{{Geometry: g, PM25: 1, SOx: 2, NOx: 3, NH3: 4, VOC: 5}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.Post(ts.URL+"/concentrations", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatal(resp.Status)
+		}
+		var have ConcentrationsJSON
+		if err = json.NewDecoder(resp.Body).Decode(&have); err != nil {
+			t.Fatal(err)
+		}
+		want, err := r.Concentrations(&inmap.EmisRecord{
+			Geom: geom.Point{X: -3999, Y: -3999},
+			PM25: 1, SOx: 2, NOx: 3, NH3: 4, VOC: 5,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(have.Concentrations, want) || have.Warning != "" {
+			t.Errorf("%+v != %+v", have, want)
+		}
+	})
+
+	t.Run("bad requests", func(t *testing.T) {
+		for _, path := range []string{
+			"/source?pollutant=xxx&layer=0&index=0",
+			"/source?pollutant=pSO4&layer=5&index=0",
+			"/source?pollutant=pSO4&layer=0&index=100",
+			"/source?pollutant=pSO4&layer=a&index=0",
+		} {
+			resp, err := http.Get(ts.URL + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("%s: status %s", path, resp.Status)
+			}
+		}
+		for _, body := range []string{"xxx", `[{"PM25": 1}]`} {
+			resp, err := http.Post(ts.URL+"/concentrations", "application/json", strings.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("%s: status %s", body, resp.Status)
+			}
+		}
+		resp, err := http.Get(ts.URL + "/concentrations")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusMethodNotAllowed {
+			t.Errorf("GET concentrations: status %s", resp.Status)
+		}
+	})
+}
@@ -0,0 +1,290 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sr
+
+import (
+	"fmt"
+	"log"
+	"math"
+
+	"github.com/ctessum/cdf"
+)
+
+// The sparse SR matrix format stores the same information as the
+// dense format created by SR.Save, except that instead of storing the
+// full layer × source × receptor array for each pollutant, it stores
+// only the receptor values of each source that are at least as large as a
+// relative threshold multiplied by the largest absolute value for that source.
+//
+// The rows (combinations of layer and source) of each pollutant are stored
+// in compressed sparse row format using three variables:
+// "<pollutant>_offsets" holds, for each row, the index of the first value
+// of that row in the other two variables, plus a final element holding
+// the total number of stored values; "<pollutant>_receptors" holds
+// the receptor index of each stored value; and "<pollutant>_values"
+// holds the values themselves.
+//
+// Sparse files can be identified by the global attribute
+// sparseThresholdAttr, which holds the threshold that was used to create them.
+const sparseThresholdAttr = "sparse_threshold"
+
+// sparseVarNames returns the names of the offset, receptor, and value
+// variables for pollutant pol in a sparse SR matrix.
+func sparseVarNames(pol string) (offsets, receptors, values string) {
+	return pol + "_offsets", pol + "_receptors", pol + "_values"
+}
+
+// ConvertSparse writes the SR matrix in r to w in the sparse SR
+// matrix format. For each source, receptor values with an absolute value
+// less than threshold multiplied by the largest absolute value for that
+// source are not stored, so that the values returned by Reader.Source
+// for the sparse matrix differ from those for r by no more than
+// that amount. A threshold of zero means that only values that are exactly
+// zero are not stored. r can be in either the dense or sparse format.
+// Because a receptor index is stored along with each value, the sparse
+// file will only be smaller than the dense file if fewer than
+// about half of the values are stored. To limit memory use, only the
+// values for one source are held in memory at a time, so r is read twice:
+// once to count the values to store and once to write them.
+func ConvertSparse(w cdf.ReaderWriterAt, r *Reader, threshold float64) error {
+	if threshold < 0 || threshold >= 1 {
+		return fmt.Errorf("sr: sparse threshold must be >= 0 and < 1 but is %g", threshold)
+	}
+	nRows := len(r.layers) * r.nCellsGroundLevel
+	if nRows+1 > math.MaxInt32 {
+		return fmt.Errorf("sr: too many sources (%d) for sparse format", nRows)
+	}
+
+	// First, figure out how many values need to be stored for each row.
+	offsets := make(map[string][]int32, len(polNames))
+	for _, pol := range polNames {
+		log.Printf("sr: counting values to keep for %s", pol)
+		o := make([]int32, nRows+1)
+		var n int
+		for l := range r.layers {
+			for i := 0; i < r.nCellsGroundLevel; i++ {
+				row := l*r.nCellsGroundLevel + i
+				o[row] = int32(n)
+				v, err := r.source(pol, l, i)
+				if err != nil {
+					return err
+				}
+				n += len(sparseKeep(v, threshold))
+				if n > math.MaxInt32 {
+					return fmt.Errorf("sr: too many values to store for %s in sparse format; "+
+						"try increasing the threshold", pol)
+				}
+			}
+		}
+		o[nRows] = int32(n)
+		offsets[pol] = o
+	}
+
+	h, err := sparseHeader(r, offsets, threshold)
+	if err != nil {
+		return err
+	}
+	f, err := cdf.Create(w, h)
+	if err != nil {
+		return fmt.Errorf("sr: creating sparse SR file: %v", err)
+	}
+	if err = copyNonSRVars(f, &r.File); err != nil {
+		return err
+	}
+
+	// Now, write out the values.
+	for _, pol := range polNames {
+		log.Printf("sr: writing %s", pol)
+		offsetVar, receptorVar, valueVar := sparseVarNames(pol)
+		o := offsets[pol]
+		if _, err = f.Writer(offsetVar, []int{0}, []int{len(o)}).Write(o); err != nil {
+			return fmt.Errorf("sr: writing %s: %v", offsetVar, err)
+		}
+		for l := range r.layers {
+			for i := 0; i < r.nCellsGroundLevel; i++ {
+				row := l*r.nCellsGroundLevel + i
+				if o[row] == o[row+1] {
+					continue
+				}
+				v, err := r.source(pol, l, i)
+				if err != nil {
+					return err
+				}
+				keep := sparseKeep(v, threshold)
+				if len(keep) != int(o[row+1]-o[row]) {
+					return fmt.Errorf("sr: number of %s values to keep for layer %d source %d changed "+
+						"from %d to %d while converting", pol, l, i, o[row+1]-o[row], len(keep))
+				}
+				vals := make([]float32, len(keep))
+				for j, k := range keep {
+					vals[j] = float32(v[k])
+				}
+				begin, end := []int{int(o[row])}, []int{int(o[row+1])}
+				if _, err = f.Writer(receptorVar, begin, end).Write(keep); err != nil {
+					return fmt.Errorf("sr: writing %s: %v", receptorVar, err)
+				}
+				if _, err = f.Writer(valueVar, begin, end).Write(vals); err != nil {
+					return fmt.Errorf("sr: writing %s: %v", valueVar, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// sparseKeep returns the indices of the values in v that should
+// be stored in a sparse SR matrix with the given threshold.
+func sparseKeep(v []float64, threshold float64) []int32 {
+	var max float64
+	for _, vv := range v {
+		max = math.Max(max, math.Abs(vv))
+	}
+	min := max * threshold
+	var keep []int32
+	for i, vv := range v {
+		if vv != 0 && math.Abs(vv) >= min {
+			keep = append(keep, int32(i))
+		}
+	}
+	return keep
+}
+
+// sparseHeader creates the header for a sparse version of the
+// SR matrix in r, where offsets holds the row offsets for each pollutant.
+func sparseHeader(r *Reader, offsets map[string][]int32, threshold float64) (*cdf.Header, error) {
+	dims := []string{"layers", "allcells", "rows"}
+	lengths := []int{len(r.layers), len(r.d.Cells()), len(r.layers)*r.nCellsGroundLevel + 1}
+	for _, pol := range polNames {
+		o := offsets[pol]
+		n := int(o[len(o)-1])
+		if n == 0 {
+			n = 1 // A length of zero would indicate a record dimension.
+		}
+		dims = append(dims, pol+"_nonzero")
+		lengths = append(lengths, n)
+	}
+	h := cdf.NewHeader(dims, lengths)
+	h.AddAttribute("", sparseThresholdAttr, []float64{threshold})
+
+	if err := addNonSRVars(h, r.File.Header); err != nil {
+		return nil, err
+	}
+
+	for _, pol := range polNames {
+		offsetVar, receptorVar, valueVar := sparseVarNames(pol)
+		h.AddVariable(offsetVar, []string{"rows"}, []int32{0})
+		h.AddAttribute(offsetVar, "description", fmt.Sprintf("Index of the first stored %s value for each layer and source, "+
+			"in layer-major order, followed by the total number of stored values", pol))
+		h.AddVariable(receptorVar, []string{pol + "_nonzero"}, []int32{0})
+		h.AddAttribute(receptorVar, "description", fmt.Sprintf("Receptor index of each stored %s value", pol))
+		h.AddVariable(valueVar, []string{pol + "_nonzero"}, []float32{0})
+		h.AddAttribute(valueVar, "description", fmt.Sprintf("%s source-receptor relationships", pol))
+		h.AddAttribute(valueVar, "units", "μg m-3 concentration at receptor location per μg s-1 emissions at source location")
+	}
+	h.Define()
+	for _, err := range h.Check() {
+		return nil, fmt.Errorf("sr: creating sparse SR file: %v", err)
+	}
+	return h, nil
+}
+
+// isSRVar returns whether v is one of the pollutant variables
+// in a dense or sparse SR matrix.
+func isSRVar(v string) bool {
+	for _, pol := range polNames {
+		offsetVar, receptorVar, valueVar := sparseVarNames(pol)
+		if v == pol || v == offsetVar || v == receptorVar || v == valueVar {
+			return true
+		}
+	}
+	return false
+}
+
+// addNonSRVars adds the variables and their attributes in src that
+// are not pollutant variables---i.e., the layers, grid geometry,
+// and InMAP data---to dst.
+func addNonSRVars(dst, src *cdf.Header) error {
+	for _, v := range src.Variables() {
+		if isSRVar(v) {
+			continue
+		}
+		dims := src.Dimensions(v)
+		if len(dims) != 1 || (dims[0] != "layers" && dims[0] != "allcells") {
+			return fmt.Errorf("sr: variable %s has unexpected dimensions %v", v, dims)
+		}
+		dst.AddVariable(v, dims, src.ZeroValue(v, 0))
+		for _, a := range src.Attributes(v) {
+			dst.AddAttribute(v, a, src.GetAttribute(v, a))
+		}
+	}
+	return nil
+}
+
+// copyNonSRVars copies the data for the variables added by addNonSRVars
+// from src to dst.
+func copyNonSRVars(dst, src *cdf.File) error {
+	for _, v := range src.Header.Variables() {
+		if isSRVar(v) {
+			continue
+		}
+		r := src.Reader(v, nil, nil)
+		buf := r.Zero(-1)
+		if _, err := r.Read(buf); err != nil {
+			return fmt.Errorf("sr: reading %s: %v", v, err)
+		}
+		// The end index is past the end of the data to avoid an EOF error.
+		if _, err := dst.Writer(v, []int{0}, src.Header.Lengths(v)).Write(buf); err != nil {
+			return fmt.Errorf("sr: writing %s: %v", v, err)
+		}
+	}
+	return nil
+}
+
+// getSparse returns the data for pollutant pol, layer index
+// layer, and source index index from a sparse SR matrix.
+func (sr *Reader) getSparse(pol string, layer, index int) ([]float64, error) {
+	offsetVar, receptorVar, valueVar := sparseVarNames(pol)
+	row := layer*sr.nCellsGroundLevel + index
+	r := sr.File.Reader(offsetVar, []int{row}, []int{row + 1})
+	buf := r.Zero(-1)
+	if _, err := r.Read(buf); err != nil {
+		return nil, err
+	}
+	o := buf.([]int32)
+	out := make([]float64, sr.nCellsGroundLevel)
+	if o[0] == o[1] {
+		return out, nil
+	}
+	begin, end := []int{int(o[0])}, []int{int(o[1]) - 1}
+	r = sr.File.Reader(receptorVar, begin, end)
+	buf = r.Zero(-1)
+	if _, err := r.Read(buf); err != nil {
+		return nil, err
+	}
+	receptors := buf.([]int32)
+	r = sr.File.Reader(valueVar, begin, end)
+	buf = r.Zero(-1)
+	if _, err := r.Read(buf); err != nil {
+		return nil, err
+	}
+	for i, v := range buf.([]float32) {
+		out[receptors[i]] = float64(v)
+	}
+	return out, nil
+}
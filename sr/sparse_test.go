@@ -0,0 +1,184 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sr
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/ctessum/geom"
+	"github.com/evookelj/inmap"
+)
+
+// convertSparseFile converts the SR matrix in the file infile to the
+// sparse format and returns a reader for the sparse version.
+func convertSparseFile(t *testing.T, infile, outfile string, threshold float64) *Reader {
+	f, err := os.Open(infile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	dense, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := os.Create(outfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ConvertSparse(w, dense, threshold); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := os.Open(outfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sparse, err := NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sparse
+}
+
+func TestConvertSparse(t *testing.T) {
+	const goldenFile = "../cmd/inmap/testdata/testSR_golden.ncf"
+	f, err := os.Open(goldenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	dense, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, threshold := range []float64{0, 0.01} {
+		t.Run(fmt.Sprint(threshold), func(t *testing.T) {
+			const sparseFile = "../cmd/inmap/testdata/testSR_sparse.ncf"
+			defer os.Remove(sparseFile)
+			sparse := convertSparseFile(t, goldenFile, sparseFile, threshold)
+
+			if !sparse.sparse || dense.sparse {
+				t.Fatalf("wrong format detected: sparse=%v, dense=%v", sparse.sparse, dense.sparse)
+			}
+			if !reflect.DeepEqual(sparse.layers, dense.layers) {
+				t.Errorf("layers: %v != %v", sparse.layers, dense.layers)
+			}
+			if sparse.nCellsGroundLevel != dense.nCellsGroundLevel {
+				t.Errorf("ground level cells: %d != %d", sparse.nCellsGroundLevel, dense.nCellsGroundLevel)
+			}
+			wantVars, err := dense.Variables("TotalPop", "BaselineTotalPM25")
+			if err != nil {
+				t.Fatal(err)
+			}
+			haveVars, err := sparse.Variables("TotalPop", "BaselineTotalPM25")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(haveVars, wantVars) {
+				t.Errorf("InMAP variables don't match")
+			}
+
+			var nDropped int
+			for _, pol := range polNames {
+				for l := range dense.layers {
+					for i := 0; i < dense.nCellsGroundLevel; i++ {
+						want, err := dense.Source(pol, l, i)
+						if err != nil {
+							t.Fatal(err)
+						}
+						have, err := sparse.Source(pol, l, i)
+						if err != nil {
+							t.Fatal(err)
+						}
+						var max float64
+						for _, v := range want {
+							max = math.Max(max, math.Abs(v))
+						}
+						for j, w := range want {
+							if have[j] == w {
+								continue
+							}
+							nDropped++
+							// Values that are stored should be identical, and
+							// values that are not stored should be small.
+							if have[j] != 0 || math.Abs(w) >= max*threshold {
+								t.Errorf("%s layer %d source %d receptor %d: %g != %g", pol, l, i, j, have[j], w)
+							}
+						}
+					}
+				}
+			}
+			if threshold == 0 && nDropped != 0 {
+				t.Errorf("no values should be dropped with a zero threshold but %d were", nDropped)
+			} else if threshold > 0 && nDropped == 0 {
+				t.Errorf("some values should be dropped with threshold %g", threshold)
+			}
+
+			// Concentrations should be the same as for the dense format.
+			e := &inmap.EmisRecord{
+				Geom: geom.Point{X: -3999, Y: -3999},
+				PM25: 1, NH3: 1, SOx: 1, NOx: 1, VOC: 1,
+			}
+			wantConc, err := dense.Concentrations(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			haveConc, err := sparse.Concentrations(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, have := wantConc.TotalPM25(), haveConc.TotalPM25()
+			var max float64
+			for _, v := range want {
+				max = math.Max(max, v)
+			}
+			for i, w := range want {
+				// There are five pollutants, each of which could
+				// be affected by the threshold.
+				if math.Abs(have[i]-w) > 5*max*threshold {
+					t.Errorf("TotalPM25 %d: %g != %g", i, have[i], w)
+				}
+			}
+		})
+	}
+}
+
+func TestConvertSparse_threshold(t *testing.T) {
+	f, err := os.Open("../cmd/inmap/testdata/testSR_golden.ncf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	dense, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, threshold := range []float64{-0.1, 1} {
+		if err := ConvertSparse(nil, dense, threshold); err == nil {
+			t.Errorf("threshold %g should cause an error", threshold)
+		}
+	}
+}
@@ -57,6 +57,10 @@ type SR struct {
 	// prj is the grid projection.
 	prj string
 
+	// popGridColumn is the population type used to check for
+	// simulation convergence, as in VarGridConfig.PopGridColumn.
+	popGridColumn string
+
 	// tempDir is a temporary directory for staging input and output files.
 	tempDir string
 }
@@ -82,6 +86,8 @@ func NewSR(varGridData io.Reader, varGridConfig *inmap.VarGridConfig, client clo
 		m:       m,
 		tempDir: tempDir,
 		prj:     varGridConfig.GridProj,
+
+		popGridColumn: varGridConfig.PopGridColumn,
 	}
 
 	if err = sr.d.Init(); err != nil {
@@ -274,22 +280,7 @@ func (sr *SR) Save(ctx context.Context, outfile, jobName string, layers []int, b
 	}
 	cells := sr.d.Cells()
 
-	// Figure out the starting index for each layer.
-	layerStarts := make(map[int]int)
-	var il = -1
-	for i, c := range cells {
-		l := c.Layer
-		if il != l {
-			il = l
-			layerStarts[l] = i
-		}
-	}
-
-	// Make a map between the model layers and the SR layers.
-	layerMap := make(map[int]int)
-	for i, l := range layers {
-		layerMap[l] = i
-	}
+	layerStarts, layerMap := sr.layerIndices(layers)
 	if l := len(cells); end < 0 || end > l {
 		end = l
 	}
@@ -308,33 +299,11 @@ func (sr *SR) Save(ctx context.Context, outfile, jobName string, layers []int, b
 				if err != nil {
 					errChan <- err
 				}
-				for name, species := range outputVars {
-					data, ok := result[name]
-					if !ok {
-						errChan <- fmt.Errorf("sr: missing result variable %v from simulation %d layer %d", name, i, cell.Layer)
-					}
-					if len(data) != layerStarts[1] {
-						errChan <- fmt.Errorf("sr: wrong number of records in variable %v from simulation %d layer %d: %d != %d", name, i, cell.Layer, len(data), layerStarts[1])
-					}
-					data32 := make([]float32, len(data))
-					for j, val := range data {
-						data32[j] = float32(val)
-					}
-					l, ok := layerMap[cell.Layer]
-					if !ok {
-						panic(fmt.Errorf("sr: missing layer %d from %v", cell.Layer, layerMap))
-					}
-					row := i - layerStarts[cell.Layer]
-					begin := []int{l, row, 0}
-					end := []int{l, row, len(data32)}
-					lock.Lock()
-					w := f.Writer(species, begin, end)
-					if _, err := w.Write(data32); err != nil {
-						lock.Unlock()
-						errChan <- fmt.Errorf("sr: writing results for for row=%v, layer=%v: %v", i, cell.Layer, err)
-					}
-					lock.Unlock()
+				lock.Lock()
+				if err := writeResult(f, result, i, cell, layerStarts, layerMap); err != nil {
+					errChan <- err
 				}
+				lock.Unlock()
 			}
 			errChan <- nil
 		}()
@@ -365,6 +334,58 @@ func (sr *SR) Save(ctx context.Context, outfile, jobName string, layers []int, b
 	return nil
 }
 
+// layerIndices returns the starting cell index of each model layer
+// and a map between the model layers and the SR layers.
+func (sr *SR) layerIndices(layers []int) (layerStarts, layerMap map[int]int) {
+	// Figure out the starting index for each layer.
+	layerStarts = make(map[int]int)
+	var il = -1
+	for i, c := range sr.d.Cells() {
+		l := c.Layer
+		if il != l {
+			il = l
+			layerStarts[l] = i
+		}
+	}
+
+	// Make a map between the model layers and the SR layers.
+	layerMap = make(map[int]int)
+	for i, l := range layers {
+		layerMap[l] = i
+	}
+	return layerStarts, layerMap
+}
+
+// writeResult writes the result of the simulation for source cell i
+// to the SR matrix file f.
+func writeResult(f *cdf.File, result map[string][]float64, i int, cell *inmap.Cell, layerStarts, layerMap map[int]int) error {
+	for name, species := range outputVars {
+		data, ok := result[name]
+		if !ok {
+			return fmt.Errorf("sr: missing result variable %v from simulation %d layer %d", name, i, cell.Layer)
+		}
+		if len(data) != layerStarts[1] {
+			return fmt.Errorf("sr: wrong number of records in variable %v from simulation %d layer %d: %d != %d", name, i, cell.Layer, len(data), layerStarts[1])
+		}
+		data32 := make([]float32, len(data))
+		for j, val := range data {
+			data32[j] = float32(val)
+		}
+		l, ok := layerMap[cell.Layer]
+		if !ok {
+			panic(fmt.Errorf("sr: missing layer %d from %v", cell.Layer, layerMap))
+		}
+		row := i - layerStarts[cell.Layer]
+		begin := []int{l, row, 0}
+		end := []int{l, row, len(data32)}
+		w := f.Writer(species, begin, end)
+		if _, err := w.Write(data32); err != nil {
+			return fmt.Errorf("sr: writing results for for row=%v, layer=%v: %v", i, cell.Layer, err)
+		}
+	}
+	return nil
+}
+
 // results gets the results of the simulation specified by the arguments
 // and regrids them to match the SR grid.
 func (sr *SR) results(ctx context.Context, jobName string, i int, cell *inmap.Cell) (map[string][]float64, error) {
@@ -487,6 +508,43 @@ func (sr *SR) Clean(ctx context.Context, jobName string, layers []int, begin, en
 	return nil
 }
 
+// kindAttr is the name of the attribute that specifies the kind of
+// data in population, mortality rate, and underlying incidence rate
+// variables in an SR matrix, so that they can be read back into the
+// right place. Its value is one of the kind constants below.
+const kindAttr = "kind"
+
+const (
+	populationKind          = "population"
+	mortalityRateKind       = "mortality rate"
+	underlyingIncidenceKind = "underlying incidence rate"
+)
+
+// hazardRatioAttr is the name of the attribute of underlying incidence
+// rate variables that specifies the hazard ratio function that was used
+// to calculate them.
+const hazardRatioAttr = "hazard_ratio"
+
+// ioSuffix is the suffix of the names of underlying incidence rate
+// variables, which inmap.InMAP forms by adding it to the name of the
+// corresponding mortality rate.
+const ioSuffix = "Io"
+
+// variableKind returns the kind of InMAP variable v, or an empty
+// string if it is not population, a mortality rate, or an underlying
+// incidence rate.
+func (sr *SR) variableKind(v string) string {
+	mortIndices := sr.d.MortIndices()
+	if _, ok := sr.d.PopIndices[v]; ok {
+		return populationKind
+	} else if _, ok := mortIndices[v]; ok {
+		return mortalityRateKind
+	} else if _, ok := mortIndices[strings.TrimSuffix(v, ioSuffix)]; ok && strings.HasSuffix(v, ioSuffix) {
+		return underlyingIncidenceKind
+	}
+	return ""
+}
+
 func (sr *SR) createOrOpenOutputFile(outfile string, layers []int) (*os.File, *cdf.File, error) {
 	nGridCells, err := sr.layerGridCells(layers)
 	if err != nil {
@@ -518,25 +576,25 @@ func (sr *SR) createOrOpenOutputFile(outfile string, layers []int) (*os.File, *c
 			inmapUnits[v] = units[i]
 		}
 
-		h := cdf.NewHeader([]string{"layer", "source", "receptor", "allcells", "layers"},
-			[]int{len(layers), nGridCells, nGridCells, len(sr.d.Cells()), len(layers)})
+		h := newDenseHeader(len(layers), nGridCells, len(sr.d.Cells()))
 
 		h.AddVariable("layers", []string{"layers"}, []int32{0})
 		h.AddAttribute("layers", "description", "Layer indices for which the SR calculation was performed")
 
-		for _, k := range sortKeys(outputVars) {
-			vs := outputVars[k]
-			h.AddVariable(vs, []string{"layer", "source", "receptor"},
-				[]float32{0})
-			h.AddAttribute(vs, "description", fmt.Sprintf("%s source-receptor relationships", vs))
-			h.AddAttribute(vs, "units", "μg m-3 concentration at receptor location per μg s-1 emissions at source location")
-		}
+		addDenseSRVars(h)
+
 		// InMAP data.
 		for _, i := range sortKeys(inmapVars) {
 			v := inmapVars[i]
 			h.AddVariable(v, []string{"allcells"}, []float64{0.})
 			h.AddAttribute(v, "description", inmapDescriptions[i])
 			h.AddAttribute(v, "units", inmapUnits[i])
+			if k := sr.variableKind(v); k != "" {
+				h.AddAttribute(v, kindAttr, k)
+				if k == underlyingIncidenceKind {
+					h.AddAttribute(v, hazardRatioAttr, sr.d.BaselineHR())
+				}
+			}
 		}
 		// Grid cell edges.
 		for _, v := range []string{"N", "S", "E", "W"} {
@@ -560,13 +618,8 @@ func (sr *SR) createOrOpenOutputFile(outfile string, layers []int) (*os.File, *c
 		}
 
 		// Add included layers
-		l := make([]int32, len(layers))
-		for i, ll := range layers {
-			l[i] = int32(ll)
-		}
-		w := f.Writer("layers", []int{0}, []int{len(l)})
-		if _, err = w.Write(l); err != nil {
-			return nil, nil, fmt.Errorf("writing SR netcdf layers: %v", err)
+		if err = writeLayers(f, layers); err != nil {
+			return nil, nil, err
 		}
 
 		// Add InMAP data
@@ -617,3 +670,36 @@ func (sr *SR) createOrOpenOutputFile(outfile string, layers []int) (*os.File, *c
 	}
 	return ff, f, nil
 }
+
+// newDenseHeader returns a header for a dense SR matrix with nLayers
+// layers, nGridCells sources and receptors in each layer, and nCells
+// grid cells in total, without any variables.
+func newDenseHeader(nLayers, nGridCells, nCells int) *cdf.Header {
+	return cdf.NewHeader([]string{"layer", "source", "receptor", "allcells", "layers"},
+		[]int{nLayers, nGridCells, nGridCells, nCells, nLayers})
+}
+
+// addDenseSRVars adds the pollutant variables of a dense SR matrix to h.
+func addDenseSRVars(h *cdf.Header) {
+	for _, k := range sortKeys(outputVars) {
+		vs := outputVars[k]
+		h.AddVariable(vs, []string{"layer", "source", "receptor"},
+			[]float32{0})
+		h.AddAttribute(vs, "description", fmt.Sprintf("%s source-receptor relationships", vs))
+		h.AddAttribute(vs, "units", "μg m-3 concentration at receptor location per μg s-1 emissions at source location")
+	}
+}
+
+// writeLayers writes the indices of the layers included in an
+// SR matrix to f.
+func writeLayers(f *cdf.File, layers []int) error {
+	l := make([]int32, len(layers))
+	for i, ll := range layers {
+		l[i] = int32(ll)
+	}
+	w := f.Writer("layers", []int{0}, []int{len(l)})
+	if _, err := w.Write(l); err != nil {
+		return fmt.Errorf("writing SR netcdf layers: %v", err)
+	}
+	return nil
+}
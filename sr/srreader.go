@@ -43,6 +43,7 @@ type Reader struct {
 	indices           map[*inmap.Cell]int
 	layers            []int // layers are the vertical layers that are represented in the SR matrix.
 	nCellsGroundLevel int   // number of cells in the lowest model layer
+	sparse            bool  // sparse specifies whether the SR matrix is in the sparse format.
 
 	// CacheSize specifies the number of records to be held in the memory cache.
 	// Larger numbers lead to faster operation but greater memory use.
@@ -59,7 +60,9 @@ type Reader struct {
 	sourceInit sync.Once
 }
 
-// NewReader creates a new SR reader from the netcdf database specified by r.
+// NewReader creates a new SR reader from the netcdf database specified by r,
+// which can be in either the dense format created by SR.Save or the sparse
+// format created by ConvertSparse.
 func NewReader(r cdf.ReaderWriterAt) (*Reader, error) {
 	cf, err := cdf.Open(r)
 	if err != nil {
@@ -71,7 +74,7 @@ func NewReader(r cdf.ReaderWriterAt) (*Reader, error) {
 	}
 	nCells := sr.Header.Lengths("N")[0] // number of InMAP cells.
 	cells := make([]*inmap.Cell, nCells)
-	sr.nCellsGroundLevel = sr.Header.Lengths("PrimaryPM25")[1]
+	sr.sparse = sr.Header.GetAttribute("", sparseThresholdAttr) != nil
 
 	// Get the grid cell geometry
 	g := make([][]float64, 4)
@@ -103,6 +106,12 @@ func NewReader(r cdf.ReaderWriterAt) (*Reader, error) {
 	for i, ll := range l {
 		sr.layers[i] = int(ll)
 	}
+	if sr.sparse {
+		offsetVar, _, _ := sparseVarNames("PrimaryPM25")
+		sr.nCellsGroundLevel = (sr.Header.Lengths(offsetVar)[0] - 1) / len(sr.layers)
+	} else {
+		sr.nCellsGroundLevel = sr.Header.Lengths("PrimaryPM25")[1]
+	}
 
 	// Get InMAP data
 	varMap := make(map[string]string)
@@ -133,35 +142,85 @@ func NewReader(r cdf.ReaderWriterAt) (*Reader, error) {
 			}
 		}
 	}
+	if _, ok := cellVarMap["MortRegion"]; !ok {
+		// SR matrices created before mortality rate regions were stored
+		// don't have them, so treat each grid cell as its own region.
+		for i, c := range cells {
+			c.MortRegion = i
+		}
+	}
 	var m simplechem.Mechanism
 	for _, cell := range cells {
 		sr.d.InsertCell(cell, m)
 	}
 
 	// Read in extra data that wasn't yet able to be saved into the cells,
-	// and save it as PopData.
+	// and save it as MortData if it is a mortality rate, as IoData if
+	// it is an underlying incidence rate, or otherwise as PopData.
 	sr.d.PopIndices = make(map[string]int)
-	var popI int
+	mortIndices := make(inmap.MortIndices)
+	var ioVars []string
 	for _, v := range sr.File.Header.Variables() {
 		if sr.File.Header.Dimensions(v)[0] != "allcells" {
 			continue // We're only interested in the InMAP variables.
 		}
-		if _, ok := cellVarMap[v]; !ok {
-			sr.d.PopIndices[v] = popI
-			popI++
+		if _, ok := cellVarMap[v]; ok {
+			continue
+		}
+		switch sr.variableKind(v) {
+		case mortalityRateKind:
+			mortIndices[v] = len(mortIndices)
+		case underlyingIncidenceKind:
+			ioVars = append(ioVars, v)
+		default:
+			sr.d.PopIndices[v] = len(sr.d.PopIndices)
 		}
 	}
+	sr.d.SetMortIndices(mortIndices)
 	for _, c := range cells {
 		c.PopData = make([]float64, len(sr.d.PopIndices))
+		c.MortData = make([]float64, len(mortIndices))
+		if len(ioVars) > 0 {
+			c.IoData = make([]float64, len(mortIndices))
+		}
 	}
 
-	for v, popI := range sr.d.PopIndices {
+	for v, i := range sr.d.PopIndices {
 		data, err := sr.readFullVar64(v)
 		if err != nil {
 			return nil, err
 		}
-		for i, c := range cells {
-			c.PopData[popI] = data[i]
+		for j, c := range cells {
+			c.PopData[i] = data[j]
+		}
+	}
+	for v, i := range mortIndices {
+		data, err := sr.readFullVar64(v)
+		if err != nil {
+			return nil, err
+		}
+		for j, c := range cells {
+			c.MortData[i] = data[j]
+		}
+	}
+	for _, v := range ioVars {
+		i, ok := mortIndices[strings.TrimSuffix(v, ioSuffix)]
+		if !ok {
+			return nil, fmt.Errorf("sr: underlying incidence rate %s does not have a matching mortality rate", v)
+		}
+		hr, _ := sr.File.Header.GetAttribute(v, hazardRatioAttr).(string)
+		if hr == "" {
+			return nil, fmt.Errorf("sr: underlying incidence rate %s does not specify a hazard ratio function", v)
+		} else if b := sr.d.BaselineHR(); b != "" && b != hr {
+			return nil, fmt.Errorf("sr: underlying incidence rates were calculated with different hazard ratio functions: %s and %s", b, hr)
+		}
+		sr.d.SetBaselineHR(hr)
+		data, err := sr.readFullVar64(v)
+		if err != nil {
+			return nil, err
+		}
+		for j, c := range cells {
+			c.IoData[i] = data[j]
 		}
 	}
 
@@ -181,6 +240,21 @@ func NewReader(r cdf.ReaderWriterAt) (*Reader, error) {
 	return sr, nil
 }
 
+// variableKind returns the kind of InMAP variable v in the SR matrix,
+// as specified by its kind attribute. In SR matrices created before the
+// attribute was added, mortality rates are identified by the description
+// given to them by inmap.InMAP.OutputOptions and all other variables,
+// including any underlying incidence rates, are treated as population.
+func (sr *Reader) variableKind(v string) string {
+	if k, ok := sr.File.Header.GetAttribute(v, kindAttr).(string); ok {
+		return k
+	}
+	if d, ok := sr.File.Header.GetAttribute(v, "description").(string); ok && strings.HasSuffix(d, "MortalityRate") {
+		return mortalityRateKind
+	}
+	return populationKind
+}
+
 // readFullVar reads a full float64 variable and returns it as a
 // []float64.
 func (sr *Reader) readFullVar64(varName string) ([]float64, error) {
@@ -213,6 +287,13 @@ func (sr *Reader) Variables(names ...string) (map[string][]float64, error) {
 				o[j] = cells[j].PopData[i]
 			}
 			r[name] = o // only return ground-level data.
+		} else if i, ok := sr.d.MortIndices()[name]; ok {
+			o := make([]float64, sr.nCellsGroundLevel)
+			cells := sr.d.Cells()
+			for j := 0; j < sr.nCellsGroundLevel; j++ {
+				o[j] = cells[j].MortData[i]
+			}
+			r[name] = o
 		} else {
 			o, err := inmap.NewOutputter("", false, n, nil, m)
 			if err != nil {
@@ -399,6 +480,108 @@ func (sr *Reader) Output(shapefilePath string, variables map[string]string, func
 	return nil
 }
 
+// Results returns the ground-level values of the output variables
+// specified by variables.
+// See the documenation for inmap.Outputter for more information.
+// This function assumes that concentrations have already been set using
+// SetConcentrations. variables is not modified.
+func (sr *Reader) Results(variables map[string]string, funcs map[string]govaluate.ExpressionFunction) (map[string][]float64, error) {
+	v := make(map[string]string, len(variables))
+	for k, e := range variables {
+		v[k] = e
+	}
+	m := simplechem.Mechanism{}
+	o, err := inmap.NewOutputter("", false, v, funcs, m)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.CheckOutputVars(m)(&sr.d); err != nil {
+		return nil, err
+	}
+	return sr.d.Results(o)
+}
+
+// Disparities calculates exposure disparity metrics for the output
+// variables disparityVars, which must be included in variables, for the
+// given population groups relative to population group totalGroup.
+// See the documentation for inmap.InMAP.Disparities for more information.
+// This function assumes that concentrations have already been set using
+// the SetConcentrations method.
+func (sr *Reader) Disparities(variables map[string]string, funcs map[string]govaluate.ExpressionFunction, groups []string, totalGroup string, disparityVars ...string) ([]*inmap.Disparity, error) {
+	m := simplechem.Mechanism{}
+	o, err := inmap.NewOutputter("", false, variables, funcs, m)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.CheckOutputVars(m)(&sr.d); err != nil {
+		return nil, err
+	}
+	return sr.d.Disparities(o, groups, totalGroup, disparityVars...)
+}
+
+// SetPopMort replaces the population and mortality rate data stored in
+// the SR matrix with the data in pop and mortRates, allocated to the SR
+// grid as specified by config, for example to calculate health impacts
+// for a different analysis year. Population and mortality rate variables
+// stored in the SR matrix that are not in pop or mortRates are
+// left unchanged, but any underlying incidence rates stored in the SR
+// matrix are discarded because they may no longer match the mortality
+// rates.
+func (sr *Reader) SetPopMort(config *inmap.VarGridConfig, pop *inmap.Population, popIndices inmap.PopIndices, mortRates *inmap.MortalityRates, mortIndices inmap.MortIndices) error {
+	cells := sr.d.Cells()
+	oldPop := make([][]float64, len(cells))
+	oldMort := make([][]float64, len(cells))
+	for i, c := range cells {
+		oldPop[i], oldMort[i] = c.PopData, c.MortData
+	}
+	oldPopIndices, oldMortIndices := sr.d.PopIndices, sr.d.MortIndices()
+
+	// Underlying incidence rates can't be calculated without CTM data.
+	c := *config
+	c.BaselineHR = ""
+	if err := c.SetPopMort(nil, pop, popIndices, mortRates, mortIndices)(&sr.d); err != nil {
+		return err
+	}
+
+	// Keep the old variables that haven't been replaced.
+	newPop, newPopIndices := mergePopMort(oldPop, cells, oldPopIndices, popIndices,
+		func(c *inmap.Cell) []float64 { return c.PopData })
+	newMort, newMortIndices := mergePopMort(oldMort, cells, oldMortIndices, mortIndices,
+		func(c *inmap.Cell) []float64 { return c.MortData })
+	for i, c := range cells {
+		c.PopData, c.MortData = newPop[i], newMort[i]
+	}
+	sr.d.PopIndices = newPopIndices
+	sr.d.SetMortIndices(newMortIndices)
+	return nil
+}
+
+// mergePopMort combines the old population or mortality rate data
+// in oldData with the new data in cells, returning the combined data
+// for each cell and the combined indices. Variables in both the old
+// and new data take their values from the new data.
+func mergePopMort(oldData [][]float64, cells []*inmap.Cell, oldIndices, newIndices map[string]int, newData func(*inmap.Cell) []float64) ([][]float64, map[string]int) {
+	indices := make(map[string]int, len(oldIndices)+len(newIndices))
+	for v, i := range oldIndices {
+		indices[v] = i
+	}
+	for v := range newIndices {
+		if _, ok := indices[v]; !ok {
+			indices[v] = len(indices)
+		}
+	}
+	o := make([][]float64, len(cells))
+	for i, c := range cells {
+		o[i] = make([]float64, len(indices))
+		copy(o[i], oldData[i])
+		nd := newData(c)
+		for v, j := range newIndices {
+			o[i][indices[v]] = nd[j]
+		}
+	}
+	return o, indices
+}
+
 // polNames lists the pollutant names.
 var polNames = []string{"pNH4", "pNO3", "pSO4", "SOA", "PrimaryPM25"}
 
@@ -476,27 +659,34 @@ type sourceRequest struct {
 // pollutant pol in SR layer index 'layer' and horizontal grid cell index
 // 'index'.
 func (sr *Reader) source(pol string, layer, index int) ([]float64, error) {
-	if layer >= len(sr.layers) {
-		return nil, fmt.Errorf("sr: requested layer %d >= number of layers (%d)", layer, len(sr.layers))
+	if err := sr.checkRequest(pol, layer); err != nil {
+		return nil, err
 	}
 	if index >= sr.nCellsGroundLevel {
 		return nil, fmt.Errorf("sr: requested index %d >= number of grid cells (%d)", index, sr.nCellsGroundLevel)
 	}
-	foundPol := false
-	for _, p := range polNames {
-		if p == pol {
-			foundPol = true
-			break
-		}
-	}
-	if !foundPol {
-		return nil, fmt.Errorf("sr: requested pollutant %s not one of valid pollutants (%+v)", pol, polNames)
+	if sr.sparse {
+		return sr.getSparse(pol, layer, index)
 	}
 	start := []int{layer, index, 0}
 	end := []int{layer, index, sr.nCellsGroundLevel - 1}
 	return sr.get(pol, start, end)
 }
 
+// checkRequest returns an error if pol is not a valid pollutant
+// or layer is not a valid SR layer index.
+func (sr *Reader) checkRequest(pol string, layer int) error {
+	if layer >= len(sr.layers) {
+		return fmt.Errorf("sr: requested layer %d >= number of layers (%d)", layer, len(sr.layers))
+	}
+	for _, p := range polNames {
+		if p == pol {
+			return nil
+		}
+	}
+	return fmt.Errorf("sr: requested pollutant %s not one of valid pollutants (%+v)", pol, polNames)
+}
+
 // get returns data from a variable starting and ending at the given indices.
 func (sr *Reader) get(pol string, start, end []int) ([]float64, error) {
 	// indices: layer, source, receptor.
@@ -27,10 +27,12 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/ctessum/cdf"
 	"github.com/ctessum/geom"
 	"github.com/ctessum/geom/encoding/shp"
 	"github.com/ctessum/geom/proj"
 	"github.com/evookelj/inmap"
+	"github.com/evookelj/inmap/epi"
 )
 
 func TestLayerFracs(t *testing.T) {
@@ -501,3 +503,226 @@ func TestOutput(t *testing.T) {
 	dec.Close()
 	inmap.DeleteShapefile(TestOutputFilename)
 }
+
+func TestSetPopMort(t *testing.T) {
+	r, err := os.Open("../cmd/inmap/testdata/testSR_golden.ncf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr, err := NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, _, _, _, _, _ := inmap.VarGridTestData()
+	inmap.WriteTestPopShapefile()
+	inmap.WriteTestMortalityShapefile()
+	defer func() {
+		for _, fname := range []string{inmap.TestPopulationShapefile, inmap.TestMortalityShapefile} {
+			inmap.DeleteShapefile(fname)
+		}
+	}()
+	cfg.CensusProjections = map[string]string{"2030": inmap.TestPopulationShapefile}
+	cfg.MortalityRateProjections = map[string]string{"2030": inmap.TestMortalityShapefile}
+	pop, popIndices, mr, mortIndices, err := cfg.LoadPopMortYear(2030)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sr.SetPopMort(cfg, pop, popIndices, mr, mortIndices); err != nil {
+		t.Fatal(err)
+	}
+	vars, err := sr.Variables("TotalPop", "AllCause", "allcause")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]float64{
+		"TotalPop": {100000, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		"AllCause": {800, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		"allcause": {800, 0, 0, 0, 0, 0, 0, 0, 0, 0}, // unchanged
+	}
+	for v, d := range want {
+		if !reflect.DeepEqual(d, vars[v]) {
+			t.Errorf("%s: want %v but have %v", v, d, vars[v])
+		}
+	}
+}
+
+func TestNewReader_variableKind(t *testing.T) {
+	golden := openGolden(t)
+	if _, ok := golden.d.MortIndices()["allcause"]; !ok {
+		t.Fatalf("the golden SR matrix mortality rates should be identified by their descriptions")
+	}
+
+	// Add an underlying incidence rate to a copy of the golden SR matrix.
+	const file = "../cmd/inmap/testdata/testSR_kind.ncf"
+	defer os.Remove(file)
+	w, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nCells := len(golden.d.Cells())
+	h := newDenseHeader(len(golden.layers), golden.nCellsGroundLevel, nCells)
+	if err = addNonSRVars(h, golden.File.Header); err != nil {
+		t.Fatal(err)
+	}
+	h.AddVariable("allcauseIo", []string{"allcells"}, []float64{0})
+	h.AddAttribute("allcauseIo", "description", "allcauseUnderlyingIncidenceRate")
+	h.AddAttribute("allcauseIo", kindAttr, underlyingIncidenceKind)
+	h.AddAttribute("allcauseIo", hazardRatioAttr, "NasariACS")
+	addDenseSRVars(h)
+	h.Define()
+	f, err := cdf.Create(w, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = writeLayers(f, golden.layers); err != nil {
+		t.Fatal(err)
+	}
+	if err = copyCellVars(f, &golden.File, nil); err != nil {
+		t.Fatal(err)
+	}
+	io := make([]float64, nCells)
+	for i := range io {
+		io[i] = float64(i)
+	}
+	if _, err = f.Writer("allcauseIo", []int{0}, []int{nCells}).Write(io); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rr, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rr.Close()
+	r, err := NewReader(rr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.d.PopIndices["allcauseIo"]; ok {
+		t.Errorf("underlying incidence rate should not be read as population")
+	}
+	if hr := r.d.BaselineHR(); hr != "NasariACS" {
+		t.Errorf("baseline hazard ratio: %s != NasariACS", hr)
+	}
+	i := r.d.MortIndices()["allcause"]
+	for j, c := range r.d.Cells() {
+		if c.IoData[i] != io[j] {
+			t.Errorf("cell %d allcauseIo: %g != %g", j, c.IoData[i], io[j])
+		}
+	}
+	vars, err := r.Variables("allcauseIo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(vars["allcauseIo"], io[:r.nCellsGroundLevel]) {
+		t.Errorf("allcauseIo: %v != %v", vars["allcauseIo"], io[:r.nCellsGroundLevel])
+	}
+}
+
+func TestOutput_deaths(t *testing.T) {
+	r, err := os.Open("../cmd/inmap/testdata/testSR_golden.ncf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr, err := NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sr.d.MortIndices()["allcause"]; !ok {
+		t.Fatalf("mortality rates should be read from the SR matrix: %v", sr.d.MortIndices())
+	}
+	// Put the grid cells into regions of three cells each.
+	for i, c := range sr.d.Cells() {
+		c.MortRegion = i / 3
+	}
+
+	c, err := sr.Concentrations(&inmap.EmisRecord{
+		Geom: geom.Point{X: -3500, Y: -3500},
+		PM25: 1.e6,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sr.SetConcentrations(c); err != nil {
+		t.Fatal(err)
+	}
+	sRef, err := proj.Parse("+proj=lcc +lat_1=33.000000 +lat_2=45.000000 +lat_0=40.000000 +lon_0=-97.000000 +x_0=0 +y_0=0 +a=6370997.000000 +b=6370997.000000 +to_meter=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const outputFile = "testOutput_deaths.shp"
+	if err = sr.Output(outputFile, map[string]string{
+		"Deaths":    "Deaths:NasariACS:TotalPop:allcause",
+		"TotalPM25": "PrimaryPM25 + pNH4 + pSO4 + pNO3 + SOA",
+		"BasePM25":  "BaselineTotalPM25",
+		"TotalPop":  "TotalPop",
+		"allcause":  "allcause",
+		"Region":    "MortRegion",
+	}, nil, sRef); err != nil {
+		t.Fatal(err)
+	}
+	defer inmap.DeleteShapefile(outputFile)
+
+	dec, err := shp.NewDecoder(outputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	type outData struct {
+		Deaths, TotalPM25, BasePM25, TotalPop float64
+		AllCause                              float64 `shp:"allcause"`
+		Region                                float64
+	}
+	var recs []outData
+	for {
+		var rec outData
+		if more := dec.DecodeRow(&rec); !more {
+			break
+		}
+		recs = append(recs, rec)
+	}
+	if err := dec.Error(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cells in the same mortality rate region are treated as a single
+	// region with the population-weighted average mortality rate.
+	type region struct {
+		p, z           []float64
+		deaths, pTotal float64
+	}
+	regions := make(map[int]*region)
+	for _, rec := range recs {
+		id := int(rec.Region) // Region indices are written as floating point numbers.
+		if _, ok := regions[id]; !ok {
+			regions[id] = new(region)
+		}
+		reg := regions[id]
+		reg.p = append(reg.p, rec.TotalPop)
+		reg.z = append(reg.z, rec.BasePM25)
+		reg.deaths += rec.TotalPop * rec.AllCause
+		reg.pTotal += rec.TotalPop
+	}
+	if len(regions) < 2 {
+		t.Fatalf("there should be more than one region: %d", len(regions))
+	}
+	var total float64
+	for i, rec := range recs {
+		reg := regions[int(rec.Region)]
+		var io float64
+		if reg.pTotal > 0 {
+			io = epi.IoRegional(reg.p, reg.z, epi.NasariACS, reg.deaths/reg.pTotal/100000)
+		}
+		want := epi.Outcome(rec.TotalPop, rec.BasePM25+rec.TotalPM25, io, epi.NasariACS) -
+			epi.Outcome(rec.TotalPop, rec.BasePM25, io, epi.NasariACS)
+		if math.Abs(rec.Deaths-want) > 1.e-6*math.Abs(want) {
+			t.Errorf("cell %d: want %g deaths but have %g", i, want, rec.Deaths)
+		}
+		total += rec.Deaths
+	}
+	if total <= 0 {
+		t.Errorf("there should be some deaths")
+	}
+}
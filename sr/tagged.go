@@ -0,0 +1,190 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sr
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/ctessum/cdf"
+	"github.com/evookelj/inmap"
+	"github.com/evookelj/inmap/science/chem/simplechem"
+)
+
+// RunTagged calculates the SR relationships for the source grid cells
+// specified by layers, begin, and end by running InMAP simulations on the
+// local computer and saves them to outfile, in the same format as Save.
+//
+// Instead of running a separate simulation for each source, each simulation
+// includes batchSize unit emissions sources, with the concentrations
+// resulting from each source tracked separately using the
+// simplechem.Tagged chemical mechanism. The simulations reuse the
+// grid that has already been loaded by the receiver, which makes
+// this much faster than running the simulations separately using Start.
+//
+// numIterations specifies the number of iterations to run each simulation
+// for; if it is zero, each simulation is run until it converges.
+// If outfile already exists, the results will be written to the existing file;
+// otherwise a new file will be created.
+func (sr *SR) RunTagged(ctx context.Context, outfile string, layers []int, begin, end, batchSize, numIterations int) error {
+	if batchSize < 1 {
+		return fmt.Errorf("sr: batch size must be at least 1 but is %d", batchSize)
+	}
+	ff, f, err := sr.createOrOpenOutputFile(outfile, layers)
+	if err != nil {
+		return err
+	}
+	defer ff.Close()
+	defer os.RemoveAll(sr.tempDir)
+
+	var maxLayer int
+	for _, l := range layers {
+		if l > maxLayer {
+			maxLayer = l
+		}
+	}
+	cells := sr.d.Cells()
+	layerStarts, layerMap := sr.layerIndices(layers)
+	if l := len(cells); end < 0 || end > l {
+		end = l
+	}
+
+	var batch []int
+	for i := 0; i < len(cells); i++ {
+		cell := cells[i]
+		_, layerok := layerMap[cell.Layer]
+		if i >= end || cell.Layer > maxLayer {
+			break
+		} else if i < begin || !layerok {
+			continue
+		}
+		batch = append(batch, i)
+		if len(batch) == batchSize {
+			if err := sr.runBatch(ctx, f, batch, numIterations, layerStarts, layerMap); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		if err := sr.runBatch(ctx, f, batch, numIterations, layerStarts, layerMap); err != nil {
+			return err
+		}
+	}
+	if err := cdf.UpdateNumRecs(ff); err != nil {
+		return fmt.Errorf("sr: finalizing output NetCDF file: %v", err)
+	}
+	return nil
+}
+
+// runBatch runs a single simulation with a unit emissions source in each
+// of the grid cells with the given indices and writes the results to f.
+func (sr *SR) runBatch(ctx context.Context, f *cdf.File, indices []int, numIterations int, layerStarts, layerMap map[int]int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	log.Printf("running %d sources starting at %d", len(indices), indices[0])
+	cells := sr.d.Cells()
+	m := simplechem.Tagged{N: len(indices)}
+
+	emis := inmap.NewEmissions()
+	for tag, i := range indices {
+		cell := cells[i]
+		emis.Add(&inmap.EmisRecord{
+			Geom:   cell.Centroid(),
+			Height: cell.LayerHeight + cell.Dz/2,
+			VOC:    1, // all units = μg/s
+			NOx:    1,
+			NH3:    1,
+			SOx:    1,
+			PM25:   1,
+			Tag:    tag,
+		})
+	}
+
+	if err := sr.runLocal(m, emis, numIterations); err != nil {
+		return fmt.Errorf("sr: sources starting at %d: %v", indices[0], err)
+	}
+
+	for tag, i := range indices {
+		result := make(map[string][]float64, len(outputVars))
+		for name, species := range outputVars {
+			data := make([]float64, 0, layerStarts[1])
+			for _, c := range cells {
+				if c.Layer != 0 {
+					break
+				}
+				v, err := m.Value(c, simplechem.TagName(species, tag))
+				if err != nil {
+					return err
+				}
+				data = append(data, v)
+			}
+			result[name] = data
+		}
+		if err := writeResult(f, result, i, cells[i], layerStarts, layerMap); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runLocal runs an InMAP simulation of emis using chemical mechanism m
+// on the SR matrix grid for numIterations iterations, or until it converges
+// if numIterations is zero. The grid is reset rather than reloaded
+// before the simulation starts, so that it can be reused for multiple
+// simulations.
+func (sr *SR) runLocal(m inmap.Mechanism, emis *inmap.Emissions, numIterations int) error {
+	drydep, err := m.DryDep("simple")
+	if err != nil {
+		return err
+	}
+	wetdep, err := m.WetDep("emep")
+	if err != nil {
+		return err
+	}
+	for _, f := range []inmap.DomainManipulator{
+		inmap.ResetConcentrations(m),
+		func(d *inmap.InMAP) error { return d.SetEmissionsFlux(emis, m) },
+		inmap.SetTimestepCFL(),
+	} {
+		if err := f(sr.d); err != nil {
+			return fmt.Errorf("initializing simulation: %v", err)
+		}
+	}
+	sr.d.RunFuncs = []inmap.DomainManipulator{
+		inmap.Calculations(inmap.AddEmissionsFlux()),
+		inmap.Calculations(
+			inmap.UpwindAdvection(),
+			inmap.Mixing(),
+			inmap.MeanderMixing(),
+			drydep,
+			wetdep,
+			m.Chemistry(),
+		),
+		inmap.SteadyStateConvergenceCheck(numIterations, sr.popGridColumn, m, nil),
+	}
+	sr.d.Done = false
+	if err := sr.d.Run(); err != nil {
+		return fmt.Errorf("running simulation: %v", err)
+	}
+	return nil
+}
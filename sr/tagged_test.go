@@ -0,0 +1,177 @@
+/*
+Copyright © 2020 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sr_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/evookelj/inmap"
+	"github.com/evookelj/inmap/cloud"
+	"github.com/evookelj/inmap/inmaputil"
+	"github.com/evookelj/inmap/science/chem/simplechem"
+	"github.com/evookelj/inmap/sr"
+	"github.com/gonum/floats"
+)
+
+// saveTaggedSRGrid saves a grid file for the tagged SR matrix test.
+// Unlike saveSRGrid, the saved grid cells do not contain emissions, so
+// emissions can be added when the grid is used in static simulations.
+func saveTaggedSRGrid(t *testing.T, filename string) {
+	cfg, ctmdata, pop, popIndices, mr, mortIndices := inmap.VarGridTestData()
+	cfg.HiResLayers = 6
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var m simplechem.Mechanism
+	mutator, err := inmap.PopulationMutator(cfg, popIndices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &inmap.InMAP{
+		InitFuncs: []inmap.DomainManipulator{
+			cfg.RegularGrid(ctmdata, pop, popIndices, mr, mortIndices, nil, m),
+			cfg.MutateGrid(mutator, ctmdata, pop, mr, nil, m, nil),
+			inmap.ResetConcentrations(m),
+			inmap.Save(f),
+		},
+	}
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestSR_RunTagged checks whether an SR matrix created with tagged
+// simulations matches one created with separate simulations.
+func TestSR_RunTagged(t *testing.T) {
+	config, err := loadConfig("../cmd/inmap/configExample.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	varGridFile := strings.TrimSuffix(config.VariableGridData, ".gob") + "_tagged.gob"
+	saveTaggedSRGrid(t, varGridFile)
+	defer os.Remove(varGridFile)
+
+	const numIterations = 20
+	layers := []int{0, 2}
+	begin, end := 0, 22 // layers 0 and 2.
+	ctx := context.Background()
+
+	taggedFile := "../cmd/inmap/testdata/testSR_tagged.ncf"
+	defer os.Remove(taggedFile)
+	varGridReader, err := os.Open(varGridFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := sr.NewSR(varGridReader, &config.VarGrid, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Use a batch size that doesn't evenly divide the number of sources.
+	if err = s.RunTagged(ctx, taggedFile, layers, begin, end, 3, numIterations); err != nil {
+		t.Fatal(err)
+	}
+
+	// Run the same simulations separately.
+	cfg := inmaputil.InitializeConfig()
+	cfg.Set("static", true)
+	cfg.Set("VariableGridData", varGridFile)
+	cfg.Set("NumIterations", numIterations)
+	const localDir = "test_tagged"
+	defer os.RemoveAll(localDir)
+	client, err := cloud.NewLocalClient(localDir, cfg.Root, cfg.OutputFiles(), 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Exec = func(ctx context.Context, dir string, cmd []string) ([]byte, error) {
+		cfg := inmaputil.InitializeConfig()
+		var b bytes.Buffer
+		cfg.Root.SetOutput(&b)
+		cfg.Root.SetArgs(cmd[1:])
+		err := cfg.Root.Execute()
+		return b.Bytes(), err
+	}
+	separateFile := "../cmd/inmap/testdata/testSR_separate.ncf"
+	defer os.Remove(separateFile)
+	varGridReader, err = os.Open(varGridFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = sr.NewSR(varGridReader, &config.VarGrid, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Start(ctx, "sr_tagged", layers, begin, end, cfg.Root, cfg.Viper, []string{"run", "steady"}, cfg.InputFiles(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if err = client.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Save(ctx, separateFile, "sr_tagged", layers, begin, end); err != nil {
+		t.Fatal(err)
+	}
+
+	// The concentrations are too small to compare using ncfWithinTol.
+	tagged := openSRReader(t, taggedFile)
+	separate := openSRReader(t, separateFile)
+	for _, pol := range []string{"PrimaryPM25", "SOA", "pNH4", "pSO4", "pNO3"} {
+		// There are 10 grid cells in each layer.
+		for l, nSources := range []int{10, 2} {
+			layer := layers[l]
+			for i := 0; i < nSources; i++ {
+				have, err := tagged.Source(pol, l, i)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want, err := separate.Source(pol, l, i)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if floats.Max(want) == 0 {
+					t.Fatalf("%s layer %d source %d: no concentrations", pol, layer, i)
+				}
+				// Allow for rounding of the values in the simulation
+				// output shapefiles.
+				tol := floats.Max(want) * 1.e-5
+				for j, w := range want {
+					if !floats.EqualWithinAbsOrRel(have[j], w, tol, 1.e-5) {
+						t.Errorf("%s layer %d source %d receptor %d: %g != %g", pol, layer, i, j, have[j], w)
+					}
+				}
+			}
+		}
+	}
+}
+
+func openSRReader(t *testing.T, filename string) *sr.Reader {
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := sr.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
@@ -29,11 +29,13 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ctessum/cdf"
 	"github.com/ctessum/sparse"
 	"github.com/evookelj/inmap/emissions/aep"
+	"github.com/evookelj/inmap/epi"
 
 	"github.com/ctessum/geom"
 	"github.com/ctessum/geom/encoding/shp"
@@ -61,16 +63,38 @@ type VarGridConfig struct {
 	// See the documentation for PopConcMutator for more information.
 	PopConcThreshold float64
 
-	CensusFile        string   // Path to census shapefile or COARDS-compliant NetCDF file
+	CensusFile        string   // Path to census shapefile, COARDS-compliant NetCDF, GeoTIFF, CSV, or GeoJSON file
 	CensusPopColumns  []string // Shapefile fields containing populations for multiple demographics
 	PopGridColumn     string   // Name of field in shapefile to be used for determining variable grid resolution
-	MortalityRateFile string   // Path to the mortality rate shapefile
+	MortalityRateFile string   // Path to the mortality rate shapefile, GeoTIFF, CSV, or GeoJSON file
 
 	// MortalityRateColumns give the columns in the mortality rate
 	// shapefile containing mortality rates, and the population groups that
 	// should be used for population-weighting each mortality rate.
 	MortalityRateColumns map[string]string
 
+	// BaselineHR is the name of the hazard ratio function from the epi package
+	// (e.g., "NasariACS") that is used to calculate the underlying incidence
+	// rate in each mortality rate polygon from the reported mortality rate
+	// and the baseline total PM2.5 concentration. If BaselineHR is empty,
+	// underlying incidence rates are not calculated.
+	BaselineHR string
+
+	// CensusProjections and MortalityRateProjections give the paths to
+	// population and mortality rate files, in the same formats as
+	// CensusFile and MortalityRateFile, for future (or past) years, with
+	// the keys being the years (e.g., "2030"). They are used by LoadPopMortYear
+	// to get data for a specific analysis year.
+	CensusProjections        map[string]string
+	MortalityRateProjections map[string]string
+
+	// MassWeightedPartitioning specifies that the gas/particle partitioning
+	// fractions in each grid cell should be averaged over the CTM grid cells
+	// that intersect it weighted by total gas- plus particle-phase mass
+	// rather than by area alone. This is intended for CTM data whose grid
+	// cells are no larger than the smallest variable grid cells.
+	MassWeightedPartitioning bool
+
 	GridProj string // projection info for CTM grid; Proj4 format
 }
 
@@ -95,6 +119,10 @@ type CTMData struct {
 	nx int
 	ny int
 
+	// Start and End are the beginning and end of the time period
+	// that the data represent. They are zero if the time period is unknown.
+	Start, End time.Time
+
 	// Data is a map of information about processed CTM variables,
 	// with the keys being the variable names.
 	Data map[string]struct {
@@ -145,6 +173,17 @@ func (config *VarGridConfig) LoadCTMData(rw cdf.ReaderWriterAt) (*CTMData, error
 	o.xo = f.Header.GetAttribute("", "x0").([]float64)[0]
 	o.yo = f.Header.GetAttribute("", "y0").([]float64)[0]
 
+	for _, a := range []struct {
+		name string
+		t    *time.Time
+	}{{"start_date", &o.Start}, {"end_date", &o.End}} {
+		if v, ok := f.Header.GetAttribute("", a.name).(string); ok && v != "" {
+			if *a.t, err = time.Parse(inDateFormat, v); err != nil {
+				return nil, fmt.Errorf("inmap.LoadCTMData: parsing %s: %v", a.name, err)
+			}
+		}
+	}
+
 	dataVersion := f.Header.GetAttribute("", "data_version").(string)
 
 	if dataVersion != InMAPDataVersion {
@@ -218,6 +257,10 @@ func (d *CTMData) Write(w *os.File) error {
 	h.AddAttribute("", "ny", []int32{int32(windSpeed.Shape[1])})
 
 	h.AddAttribute("", "data_version", InMAPDataVersion)
+	if !d.Start.IsZero() || !d.End.IsZero() {
+		h.AddAttribute("", "start_date", d.Start.Format(inDateFormat))
+		h.AddAttribute("", "end_date", d.End.Format(inDateFormat))
+	}
 
 	// Sort the names so they write in the same order every time.
 	names := make([]string, 0, len(d.Data))
@@ -264,52 +307,48 @@ func (d *CTMData) Write(w *os.File) error {
 // If the nests do not all have the same number of layers, an
 // error will be returned.
 func CombineCTMData(nests ...*CTMData) (*CTMData, error) {
+	return CombineCTMDataBlend(0, nests...)
+}
+
+// CombineCTMDataBlend is the same as CombineCTMData, except that
+// values within buffer grid cells (in units of the overlaid nest's grid cells)
+// of the edge of each nest after the first are linearly blended with the
+// values from the previous nest(s), to avoid discontinuities at nest boundaries.
+// The weight given to the overlaid nest increases from zero at the nest edge
+// to one at buffer cells from the edge. Nest edges that coincide with the
+// edge of the output domain are not blended. A buffer of zero
+// results in the same output as CombineCTMData.
+func CombineCTMDataBlend(buffer float64, nests ...*CTMData) (*CTMData, error) {
 	if len(nests) == 0 {
 		return nil, nil
 	}
-
-	o := new(CTMData)
-
-	// Get extent and resolution of resulting grid.
-	o.xo, o.yo = nests[0].xo, nests[0].yo
-	o.dx, o.dy = math.Inf(1), math.Inf(1)
-	var nz int
-	for i, nest := range nests {
-		if _, ok := nest.Data["Dz"]; !ok {
-			return nil, errors.New("inmap: CTM data is missing variable `Dz`")
-		}
-		nestNz := nest.Data["Dz"].Data.Shape[0]
-		if i == 0 {
-			nz = nestNz
-		} else if nz != nestNz {
-			return nil, errors.New("inmap: inconsistent number of layers when combining CTM data files")
-		}
-		if nest.dx < o.dx {
-			o.dx = nest.dx
-		}
-		if nest.dy < o.dy {
-			o.dy = nest.dy
-		}
+	if buffer < 0 {
+		return nil, fmt.Errorf("inmap: negative nest blending buffer %g", buffer)
 	}
-	o.nx = nests[0].nx * round(nests[0].dx/o.dx)
-	o.ny = nests[0].ny * round(nests[0].dy/o.dy)
+	o, err := combinedGrid(nests)
+	if err != nil {
+		return nil, err
+	}
+	nz := nests[0].Data["Dz"].Data.Shape[0]
 
 	// Copy data.
 	for _, nest := range nests {
-		xNestFac := round(nest.dx / o.dx)        // nesting ratio in x-direction
-		yNestFac := round(nest.dy / o.dy)        // nesting ratio in y-direction
-		nestio := round((nest.xo - o.xo) / o.dx) // x-index in output grid of nest ll corner.
-		nestjo := round((nest.yo - o.yo) / o.dy) // y-index in output grid of nest ll corner.
+		p := o.placement(nest)
+		weight := p.blendWeight(o, buffer)
 
 		// Closure for copying one layer
-		copyLayer := func(get func(j, i int) float64, set func(v float64, j, i int)) {
+		copyLayer := func(get func(j, i int) float64, out func(j, i int) float64, set func(v float64, j, i int)) {
 			for nj := 0; nj < nest.ny; nj++ {
 				for ni := 0; ni < nest.nx; ni++ {
 					v := get(nj, ni)
-					for oj := nestjo + nj*yNestFac; oj < nestjo+(nj+1)*yNestFac; oj++ {
-						for oi := nestio + ni*xNestFac; oi < nestio+(ni+1)*xNestFac; oi++ {
+					for oj := p.jo + nj*p.yFac; oj < p.jo+(nj+1)*p.yFac; oj++ {
+						for oi := p.io + ni*p.xFac; oi < p.io+(ni+1)*p.xFac; oi++ {
 							if oi >= 0 && oj >= 0 && oi < o.nx && oj < o.ny {
-								set(v, oj, oi)
+								if w := weight(oj, oi); w < 1 {
+									set(w*v+(1-w)*out(oj, oi), oj, oi)
+								} else {
+									set(v, oj, oi)
+								}
 							}
 						}
 					}
@@ -326,8 +365,9 @@ func CombineCTMData(nests ...*CTMData) (*CTMData, error) {
 				od := o.Data[name]
 				for k := 0; k < nz; k++ {
 					get := func(j, i int) float64 { return data.Data.Get(k, j, i) }
+					out := func(j, i int) float64 { return od.Data.Get(k, j, i) }
 					set := func(v float64, j, i int) { od.Data.Set(v, k, j, i) }
-					copyLayer(get, set)
+					copyLayer(get, out, set)
 				}
 			case 2:
 				if _, ok := o.Data[name]; !ok {
@@ -335,8 +375,9 @@ func CombineCTMData(nests ...*CTMData) (*CTMData, error) {
 				}
 				od := o.Data[name]
 				get := func(j, i int) float64 { return data.Data.Get(j, i) }
+				out := func(j, i int) float64 { return od.Data.Get(j, i) }
 				set := func(v float64, j, i int) { od.Data.Set(v, j, i) }
-				copyLayer(get, set)
+				copyLayer(get, out, set)
 			default:
 				return nil, fmt.Errorf("inmap: invalid number of dimensions (%d) when combining CTM data", len(data.Dims))
 			}
@@ -345,6 +386,157 @@ func CombineCTMData(nests ...*CTMData) (*CTMData, error) {
 	return o, nil
 }
 
+// combinedGrid returns an empty CTMData with the extent of the
+// first nest and the resolution of the highest resolution nest.
+func combinedGrid(nests []*CTMData) (*CTMData, error) {
+	o := new(CTMData)
+
+	// Get extent and resolution of resulting grid.
+	o.xo, o.yo = nests[0].xo, nests[0].yo
+	o.Start, o.End = nests[0].Start, nests[0].End
+	o.dx, o.dy = math.Inf(1), math.Inf(1)
+	var nz int
+	for i, nest := range nests {
+		if _, ok := nest.Data["Dz"]; !ok {
+			return nil, errors.New("inmap: CTM data is missing variable `Dz`")
+		}
+		nestNz := nest.Data["Dz"].Data.Shape[0]
+		if i == 0 {
+			nz = nestNz
+		} else if nz != nestNz {
+			return nil, errors.New("inmap: inconsistent number of layers when combining CTM data files")
+		}
+		if nest.dx < o.dx {
+			o.dx = nest.dx
+		}
+		if nest.dy < o.dy {
+			o.dy = nest.dy
+		}
+	}
+	o.nx = nests[0].nx * round(nests[0].dx/o.dx)
+	o.ny = nests[0].ny * round(nests[0].dy/o.dy)
+	return o, nil
+}
+
+// nestPlacement describes the location of a nest within a combined grid.
+type nestPlacement struct {
+	xFac, yFac int // nesting ratios in the x- and y-directions
+	io, jo     int // indices in the combined grid of the nest lower-left corner
+	nx, ny     int // nest size in combined grid cells
+}
+
+// placement returns the location of nest within d.
+func (d *CTMData) placement(nest *CTMData) nestPlacement {
+	p := nestPlacement{
+		xFac: round(nest.dx / d.dx),
+		yFac: round(nest.dy / d.dy),
+		io:   round((nest.xo - d.xo) / d.dx),
+		jo:   round((nest.yo - d.yo) / d.dy),
+	}
+	p.nx, p.ny = nest.nx*p.xFac, nest.ny*p.yFac
+	return p
+}
+
+// contains returns whether combined grid cell (j, i) is within the nest.
+func (p nestPlacement) contains(j, i int) bool {
+	return i >= p.io && j >= p.jo && i < p.io+p.nx && j < p.jo+p.ny
+}
+
+// blendWeight returns a function that returns the weight of the nest
+// at combined grid cell (j, i) of d, given a blending buffer width
+// in nest grid cells.
+func (p nestPlacement) blendWeight(d *CTMData, buffer float64) func(j, i int) float64 {
+	if buffer == 0 {
+		return func(j, i int) float64 { return 1 }
+	}
+	return func(j, i int) float64 {
+		// Distances from the cell center to the nest edges in nest grid cells.
+		x := (float64(i-p.io) + 0.5) / float64(p.xFac)
+		y := (float64(j-p.jo) + 0.5) / float64(p.yFac)
+		dist := math.Inf(1)
+		if p.io > 0 {
+			dist = math.Min(dist, x)
+		}
+		if p.io+p.nx < d.nx {
+			dist = math.Min(dist, float64(p.nx/p.xFac)-x)
+		}
+		if p.jo > 0 {
+			dist = math.Min(dist, y)
+		}
+		if p.jo+p.ny < d.ny {
+			dist = math.Min(dist, float64(p.ny/p.yFac)-y)
+		}
+		return math.Min(1, dist/buffer)
+	}
+}
+
+// NestBoundaryJumps returns diagnostic data describing the discontinuities
+// in combined, which should be the output of CombineCTMData or
+// CombineCTMDataBlend, at the boundaries of each nest after the first.
+// For each variable in combined, the returned data contain the magnitude
+// of the largest difference between each grid cell just inside a nest
+// boundary and its neighbors just outside of the boundary. All other
+// grid cells are zero.
+func NestBoundaryJumps(combined *CTMData, nests ...*CTMData) (*CTMData, error) {
+	o := &CTMData{
+		xo: combined.xo, yo: combined.yo,
+		dx: combined.dx, dy: combined.dy,
+		nx: combined.nx, ny: combined.ny,
+		Start: combined.Start, End: combined.End,
+	}
+	for name, data := range combined.Data {
+		o.AddVariable(name, data.Dims,
+			fmt.Sprintf("Magnitude of jump in %s across nest boundaries", name),
+			data.Units, sparse.ZerosDense(data.Data.Shape...))
+	}
+	if len(nests) < 2 {
+		return o, nil
+	}
+	neighbors := [][2]int{{-1, 0}, {1, 0}, {0, -1}, {0, 1}}
+	for _, nest := range nests[1:] {
+		p := combined.placement(nest)
+		for name, data := range combined.Data {
+			var nz int
+			switch len(data.Dims) {
+			case 3:
+				nz = data.Data.Shape[0]
+			case 2:
+				nz = 1
+			default:
+				return nil, fmt.Errorf("inmap: invalid number of dimensions (%d) when calculating nest boundary jumps", len(data.Dims))
+			}
+			od := o.Data[name].Data
+			for k := 0; k < nz; k++ {
+				index := func(j, i int) []int {
+					if len(data.Dims) == 3 {
+						return []int{k, j, i}
+					}
+					return []int{j, i}
+				}
+				for j := p.jo; j < p.jo+p.ny; j++ {
+					for i := p.io; i < p.io+p.nx; i++ {
+						if i < 0 || j < 0 || i >= combined.nx || j >= combined.ny {
+							continue
+						}
+						v := data.Data.Get(index(j, i)...)
+						for _, n := range neighbors {
+							jj, ii := j+n[0], i+n[1]
+							if ii < 0 || jj < 0 || ii >= combined.nx || jj >= combined.ny || p.contains(jj, ii) {
+								continue
+							}
+							jump := math.Abs(v - data.Data.Get(index(jj, ii)...))
+							if jump > od.Get(index(j, i)...) {
+								od.Set(jump, index(j, i)...)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return o, nil
+}
+
 func round(v float64) int { return int(v + 0.5) }
 
 func writeNCF(f *cdf.File, Var string, data *sparse.DenseArray) error {
@@ -382,6 +574,36 @@ type Population struct {
 // model domain.
 type MortalityRates struct {
 	tree *rtree.Rtree
+
+	// points holds mortality rates that are located at points rather
+	// than within polygons. Each point applies to the population that
+	// is closer to it than to any other point. There is one index for each
+	// set of points that is being interpolated between.
+	points []*rtree.Rtree
+}
+
+// add adds the mortality rates in t to mr.
+func (mr *MortalityRates) add(t *rtree.Rtree) error {
+	if mr.tree == nil {
+		mr.tree = rtree.NewTree(25, 50)
+	}
+	var points *rtree.Rtree
+	for _, mI := range t.SearchIntersect(everywhere) {
+		m := mI.(*mortality)
+		if !m.point {
+			mr.tree.Insert(m)
+			continue
+		}
+		if points == nil {
+			points = rtree.NewTree(25, 50)
+			mr.points = append(mr.points, points)
+		}
+		points.Insert(m)
+	}
+	if mr.tree.Size() > 0 && len(mr.points) > 0 {
+		return fmt.Errorf("inmap: mortality rates must either all be located at points or all be within polygons")
+	}
+	return nil
 }
 
 // PopIndices gives the array indices of each
@@ -404,11 +626,176 @@ func (config *VarGridConfig) LoadPopMort() (*Population, PopIndices, *MortalityR
 	if err != nil {
 		return nil, nil, nil, nil, fmt.Errorf("inmap: while loading population: %v", err)
 	}
-	mort, mortIndex, err := config.loadMortality(gridSR)
+	mort, mortIndex, err := config.loadMortality(gridSR, config.bounds())
 	if err != nil {
 		return nil, nil, nil, nil, fmt.Errorf("inmap: while loading mortality rate: %v", err)
 	}
-	return &Population{tree: pop}, PopIndices(popIndex), &MortalityRates{tree: mort}, MortIndices(mortIndex), nil
+	mr := new(MortalityRates)
+	if err = mr.add(mort); err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return &Population{tree: pop}, PopIndices(popIndex), mr, MortIndices(mortIndex), nil
+}
+
+// LoadPopMortYear loads the population and mortality rate data for
+// analysis year year from the files specified in config.CensusProjections
+// and config.MortalityRateProjections. If there is no file for year,
+// the data are linearly interpolated between the files for the closest
+// earlier and later years. Years outside of the range of available files
+// cause an error. If either set of projections is empty, config.CensusFile or
+// config.MortalityRateFile, respectively, is used for all years.
+func (config *VarGridConfig) LoadPopMortYear(year int) (*Population, PopIndices, *MortalityRates, MortIndices, error) {
+	gridSR, err := proj.Parse(config.GridProj)
+	if err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("inmap: while parsing GridProj: %v", err)
+	}
+
+	popFiles, popWeights, err := projectionFiles("CensusProjections", config.CensusFile, config.CensusProjections, year)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	pop := rtree.NewTree(25, 50)
+	var popIndex map[string]int
+	for i, f := range popFiles {
+		c := *config
+		c.CensusFile = f
+		var p *rtree.Rtree
+		p, popIndex, err = c.loadPopulation(gridSR, config.bounds())
+		if err != nil {
+			return nil, nil, nil, nil, fmt.Errorf("inmap: while loading population for year %d: %v", year, err)
+		}
+		for _, pI := range p.SearchIntersect(everywhere) {
+			pp := pI.(*population)
+			floats.Scale(popWeights[i], pp.PopData)
+			pop.Insert(pp)
+		}
+	}
+
+	mortFiles, mortWeights, err := projectionFiles("MortalityRateProjections", config.MortalityRateFile, config.MortalityRateProjections, year)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	mr := new(MortalityRates)
+	var mortIndex map[string]int
+	for i, f := range mortFiles {
+		c := *config
+		c.MortalityRateFile = f
+		var m *rtree.Rtree
+		m, mortIndex, err = c.loadMortality(gridSR, config.bounds())
+		if err != nil {
+			return nil, nil, nil, nil, fmt.Errorf("inmap: while loading mortality rate for year %d: %v", year, err)
+		}
+		for _, mI := range m.SearchIntersect(everywhere) {
+			mI.(*mortality).weight = mortWeights[i]
+		}
+		if err = mr.add(m); err != nil {
+			return nil, nil, nil, nil, err
+		}
+	}
+	return &Population{tree: pop}, PopIndices(popIndex), mr, MortIndices(mortIndex), nil
+}
+
+// everywhere is a bounding box that overlaps everything.
+var everywhere = &geom.Bounds{
+	Min: geom.Point{X: math.Inf(-1), Y: math.Inf(-1)},
+	Max: geom.Point{X: math.Inf(1), Y: math.Inf(1)},
+}
+
+// projectionFiles returns the files in projections, which are keyed by year,
+// and the weights that should be used to linearly interpolate among them
+// to get data for year. name is the name of the projections for use in
+// error messages. If projections is empty, defaultFile is returned with
+// a weight of 1.
+func projectionFiles(name, defaultFile string, projections map[string]string, year int) ([]string, []float64, error) {
+	if len(projections) == 0 {
+		return []string{defaultFile}, []float64{1}, nil
+	}
+	files := make(map[int]string, len(projections))
+	years := make([]int, 0, len(projections))
+	for yStr, f := range projections {
+		y, err := strconv.ParseInt(yStr, 10, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("inmap: VarGrid.%s: invalid year '%s'", name, yStr)
+		}
+		files[int(y)] = f
+		years = append(years, int(y))
+	}
+	sort.Ints(years)
+	if f, ok := files[year]; ok {
+		return []string{f}, []float64{1}, nil
+	}
+	if year < years[0] || year > years[len(years)-1] {
+		return nil, nil, fmt.Errorf("inmap: VarGrid.%s: year %d is outside of the range of available years (%d-%d)",
+			name, year, years[0], years[len(years)-1])
+	}
+	i := sort.SearchInts(years, year)
+	y0, y1 := years[i-1], years[i]
+	frac := float64(year-y0) / float64(y1-y0)
+	return []string{files[y0], files[y1]}, []float64{1 - frac, frac}, nil
+}
+
+// SetPopMort returns a function that replaces the population and
+// mortality rate data in the grid cells of an existing grid, for example
+// to evaluate a grid loaded using Load for a different analysis year.
+// data is only used to calculate underlying incidence rates if
+// config.BaselineHR is specified. Whether each cell is above the population
+// density threshold is not changed, so the grid structure is left intact.
+func (config *VarGridConfig) SetPopMort(data *CTMData, pop *Population, popIndices PopIndices, mortRates *MortalityRates, mortIndices MortIndices) DomainManipulator {
+	return func(d *InMAP) error {
+		if config.BaselineHR != "" && data == nil {
+			return fmt.Errorf("inmap: setting population and mortality: CTM data are required to calculate underlying incidence rates")
+		}
+		d.PopIndices = (map[string]int)(popIndices)
+		d.mortIndices = (map[string]int)(mortIndices)
+		d.baselineHR = config.BaselineHR
+
+		for _, c := range d.cells.array() {
+			c.PopData = make([]float64, len(popIndices))
+			c.MortData = make([]float64, len(mortIndices))
+			c.IoData = nil
+			if config.BaselineHR != "" {
+				c.IoData = make([]float64, len(mortIndices))
+			}
+			if c.Layer != 0 {
+				continue // only ground level grid cells have people
+			}
+			aboveThreshold := c.AboveDensityThreshold
+			if err := c.loadPopMortalityRate(config, data, mortRates, mortIndices, pop, popIndices); err != nil {
+				return err
+			}
+			c.AboveDensityThreshold = aboveThreshold
+		}
+		return nil
+	}
+}
+
+// MortIndices returns the array index of each mortality rate in the
+// MortData field in each Cell.
+func (d *InMAP) MortIndices() MortIndices {
+	return MortIndices(d.mortIndices)
+}
+
+// SetMortIndices specifies the array index of each mortality rate in the
+// MortData field in each Cell. It is only needed when the grid cell data
+// are set directly rather than with SetPopMort, for example when they
+// are read from a source-receptor matrix.
+func (d *InMAP) SetMortIndices(mortIndices MortIndices) {
+	d.mortIndices = (map[string]int)(mortIndices)
+}
+
+// BaselineHR returns the name of the hazard ratio function that was used
+// to calculate the underlying incidence rates in the IoData field in each
+// Cell, or an empty string if there are no underlying incidence rates.
+func (d *InMAP) BaselineHR() string {
+	return d.baselineHR
+}
+
+// SetBaselineHR specifies the name of the hazard ratio function that was
+// used to calculate the underlying incidence rates in the IoData field
+// in each Cell. Like SetMortIndices, it is only needed when the grid cell
+// data are set directly rather than with SetPopMort.
+func (d *InMAP) SetBaselineHR(hr string) {
+	d.baselineHR = hr
 }
 
 // getCells returns all the grid cells in cellTree that are within box
@@ -461,6 +848,7 @@ func (config *VarGridConfig) RegularGrid(data *CTMData, pop *Population, popInde
 
 		d.PopIndices = (map[string]int)(popIndex)
 		d.mortIndices = (map[string]int)(mortIndex)
+		d.baselineHR = config.BaselineHR
 
 		nz := data.Data["UAvg"].Data.Shape[0]
 		d.nlayers = nz
@@ -806,13 +1194,18 @@ func (config *VarGridConfig) createCell(data *CTMData, pop *Population, popIndic
 	cell := new(Cell)
 	cell.PopData = make([]float64, len(popIndices))
 	cell.MortData = make([]float64, len(mortIndices))
+	if config.BaselineHR != "" {
+		cell.IoData = make([]float64, len(mortIndices))
+	}
 
 	cell.Index = index
 	// Polygon must go counter-clockwise
 	cell.Polygonal = config.cellGeometry(index)
 	if layer == 0 {
 		// only ground level grid cells have people
-		cell.loadPopMortalityRate(config, mortRates, mortIndices, pop, popIndices)
+		if err := cell.loadPopMortalityRate(config, data, mortRates, mortIndices, pop, popIndices); err != nil {
+			return nil, err
+		}
 	}
 
 	gg, err := cell.Polygonal.Transform(webMapTrans)
@@ -831,7 +1224,7 @@ func (config *VarGridConfig) createCell(data *CTMData, pop *Population, popIndic
 	cell.Dy = bounds.Max.Y - bounds.Min.Y
 
 	cell.make(m)
-	if err := cell.loadData(data, layer); err != nil {
+	if err := cell.loadData(data, layer, config.MassWeightedPartitioning); err != nil {
 		return nil, err
 	}
 	cell.Volume = cell.Dx * cell.Dy * cell.Dz
@@ -850,7 +1243,21 @@ func (config *VarGridConfig) createCell(data *CTMData, pop *Population, popIndic
 // multiple mortality rate polygons overlap or lie within a single population
 // polygon, the mortality rate in each cell is equal to the population-weighted
 // average of: the area-weighted average of mortality rates within each population polygon.
-func (c *Cell) loadPopMortalityRate(config *VarGridConfig, mortRates *MortalityRates, mortIndices MortIndices, pop *Population, popIndices PopIndices) {
+// If config.BaselineHR is specified, the underlying incidence rate in each cell
+// is calculated in the same way as the mortality rate, from the underlying
+// incidence rates of the mortality rate polygons.
+func (c *Cell) loadPopMortalityRate(config *VarGridConfig, data *CTMData, mortRates *MortalityRates, mortIndices MortIndices, pop *Population, popIndices PopIndices) error {
+	var hr epi.HRer
+	if config.BaselineHR != "" {
+		var err error
+		if hr, err = epi.HRByName(config.BaselineHR); err != nil {
+			return fmt.Errorf("inmap: VarGrid.BaselineHR: %v", err)
+		}
+		if len(mortRates.points) > 0 {
+			return fmt.Errorf("inmap: VarGrid.BaselineHR: underlying incidence rates can't be calculated from point mortality rate data")
+		}
+	}
+
 	// First, prepare mortality rates for later processing.
 	cellMortI := mortRates.tree.SearchIntersect(c.Bounds())
 	cellMort := make([]*mortality, len(cellMortI))
@@ -859,8 +1266,14 @@ func (c *Cell) loadPopMortalityRate(config *VarGridConfig, mortRates *MortalityR
 		cellMort[i] = &mortality{
 			Polygonal: c.Polygonal.Intersection(m.Polygonal),
 			MortData:  m.MortData,
+			weight:    m.weight,
+			region:    m.region,
 		}
 	}
+	c.MortRegion = mortalityRegion(c, cellMort, mortRates)
+
+	// pointPop is the population in this cell from point population data.
+	var pointPop float64
 
 	// Second, intersect each grid cell with population polygons
 	for _, pInterface := range pop.tree.SearchIntersect(c.Bounds()) {
@@ -881,10 +1294,25 @@ func (c *Cell) loadPopMortalityRate(config *VarGridConfig, mortRates *MortalityR
 		for popType, pop := range p.PopData {
 			c.PopData[popType] += pop * pAreaFrac
 		}
-		// Check if this census shape is above the density threshold.
-		pDensity := p.PopData[popIndices[config.PopGridColumn]] / pArea
-		if pDensity > config.PopDensityThreshold {
-			c.AboveDensityThreshold = true
+		if p.point {
+			pointPop += p.PopData[popIndices[config.PopGridColumn]] * pAreaFrac
+		} else {
+			// Check if this census shape is above the density threshold.
+			pDensity := p.PopData[popIndices[config.PopGridColumn]] / pArea
+			if pDensity > config.PopDensityThreshold {
+				c.AboveDensityThreshold = true
+			}
+		}
+		if len(mortRates.points) > 0 {
+			// Use the mortality rates of the closest points.
+			center := pIntersection.Centroid()
+			for _, points := range mortRates.points {
+				m := points.NearestNeighbor(center).(*mortality)
+				for mortType, popType := range config.MortalityRateColumns {
+					c.MortData[mortIndices[mortType]] += p.PopData[popIndices[popType]] * pAreaFrac * m.MortData[mortIndices[mortType]] * m.weight
+				}
+			}
+			continue
 		}
 		var mAreaTotal float64
 		// Third, intersect each intersection from first step with
@@ -899,7 +1327,7 @@ func (c *Cell) loadPopMortalityRate(config *VarGridConfig, mortRates *MortalityR
 				continue
 			}
 			// Sum areas of intersecting mortality rate polygons for use in area-weighting.
-			mAreaTotal += mAreaIntersect
+			mAreaTotal += mAreaIntersect * m.weight
 		}
 		for _, mInterface := range mortRates.tree.SearchIntersect(pIntersection.Bounds()) {
 			m := mInterface.(*mortality)
@@ -910,15 +1338,57 @@ func (c *Cell) loadPopMortalityRate(config *VarGridConfig, mortRates *MortalityR
 			}
 			// Perform population-weighted average of area-weighted average mortality rates.
 			for mortType, popType := range config.MortalityRateColumns {
-				c.MortData[mortIndices[mortType]] += p.PopData[popIndices[popType]] * pAreaFrac * m.MortData[mortIndices[mortType]] * (mAreaIntersect / mAreaTotal)
+				c.MortData[mortIndices[mortType]] += p.PopData[popIndices[popType]] * pAreaFrac * m.MortData[mortIndices[mortType]] * (mAreaIntersect * m.weight / mAreaTotal)
+			}
+			if hr == nil {
+				continue
+			}
+			io := m.underlyingIncidence(config, data, pop, popIndices, mortIndices, hr)
+			for mortType, popType := range config.MortalityRateColumns {
+				c.IoData[mortIndices[mortType]] += p.PopData[popIndices[popType]] * pAreaFrac * io[mortIndices[mortType]] * (mAreaIntersect * m.weight / mAreaTotal)
 			}
 		}
 	}
+	// Points don't have a population density, so we check the
+	// density of the point population within the cell.
+	if pointPop/c.Area() > config.PopDensityThreshold {
+		c.AboveDensityThreshold = true
+	}
 	for mortType, popType := range config.MortalityRateColumns {
 		if c.PopData[popIndices[popType]] > 0 {
 			c.MortData[mortIndices[mortType]] = c.MortData[mortIndices[mortType]] / c.PopData[popIndices[popType]]
+			if hr != nil {
+				c.IoData[mortIndices[mortType]] = c.IoData[mortIndices[mortType]] / c.PopData[popIndices[popType]]
+			}
 		}
 	}
+	return nil
+}
+
+// mortalityRegion returns the region index of the mortality rate polygons
+// in cellMort---the mortality rate polygons that overlap cell c, clipped
+// to c---that have the largest weighted area of overlap with c, or of the
+// mortality rate point closest to the center of c if the mortality rates
+// are located at points. It returns -1 if c doesn't overlap any
+// mortality rate polygons.
+func mortalityRegion(c *Cell, cellMort []*mortality, mortRates *MortalityRates) int {
+	if len(mortRates.points) > 0 {
+		return mortRates.points[0].NearestNeighbor(c.Centroid()).(*mortality).region
+	}
+	areas := make(map[int]float64)
+	for _, m := range cellMort {
+		if m.Polygonal != nil {
+			areas[m.region] += m.Area() * m.weight
+		}
+	}
+	region := -1
+	var maxArea float64
+	for r, a := range areas {
+		if a > maxArea || (a == maxArea && r < region) {
+			region, maxArea = r, a
+		}
+	}
+	return region
 }
 
 type population struct {
@@ -926,6 +1396,10 @@ type population struct {
 
 	// PopData holds the number of people in each population category
 	PopData []float64
+
+	// point specifies whether the population is located at a point,
+	// in which case Polygonal is a very small square around the point.
+	point bool
 }
 
 type mortality struct {
@@ -933,21 +1407,85 @@ type mortality struct {
 
 	// MortData holds the mortality rate for each population category
 	MortData []float64 // Deaths per 100,000 people per year
+
+	// weight is the weight of this polygon when averaging it with other
+	// mortality rate polygons. It is 1 unless the mortality rates are
+	// interpolated between years.
+	weight float64
+
+	// point specifies whether the mortality rate is located at a point,
+	// in which case Polygonal is a very small square around the point.
+	point bool
+
+	// region is the index of this polygon or point in the mortality rate
+	// file it was loaded from. Mortality rates in files for different
+	// years with the same index are assumed to be for the same region.
+	region int
+
+	// io holds the underlying incidence rate for each population category.
+	io     []float64 // Deaths per 100,000 people per year
+	ioOnce sync.Once
+}
+
+// underlyingIncidence returns the underlying incidence rate for each
+// population category in m, calculated using epi.IoRegional from the
+// population of each population polygon that intersects m and the baseline
+// total PM2.5 concentration where they intersect. The result is only
+// calculated the first time this method is called.
+func (m *mortality) underlyingIncidence(config *VarGridConfig, data *CTMData, pop *Population, popIndices PopIndices, mortIndices MortIndices, hr epi.HRer) []float64 {
+	m.ioOnce.Do(func() {
+		var p [][]float64 // [population polygon][population type]
+		var z []float64
+		for _, pInterface := range pop.tree.SearchIntersect(m.Bounds()) {
+			pp := pInterface.(*population)
+			isect := m.Polygonal.Intersection(pp.Polygonal)
+			if isect == nil {
+				continue
+			}
+			aIsect := isect.Area()
+			if aIsect == 0 {
+				continue
+			}
+			pAreaFrac := aIsect / pp.Area()
+			pi := make([]float64, len(pp.PopData))
+			for i, v := range pp.PopData {
+				pi[i] = v * pAreaFrac
+			}
+			p = append(p, pi)
+			z = append(z, data.groundLevelTotalPM25(isect))
+		}
+		m.io = make([]float64, len(m.MortData))
+		for mortType, popType := range config.MortalityRateColumns {
+			pType := make([]float64, len(p))
+			for i, pi := range p {
+				pType[i] = pi[popIndices[popType]]
+			}
+			i := mortIndices[mortType]
+			m.io[i] = epi.IoRegional(pType, z, hr, m.MortData[i])
+		}
+	})
+	return m.io
 }
 
-// loadPopulation loads population information from a shapefile or
-// COARDS-compliant NetCDF file (determined by file extension), converting it
+// loadPopulation loads population information from a shapefile,
+// COARDS-compliant NetCDF file, GeoTIFF file, or CSV or GeoJSON
+// file (determined by file extension), converting it
 // to spatial reference sr and then discarding any geometries that do not
 // overlap with bounds. The function outputs an index holding the population
 // information and a map giving the array index of each population type.
 func (config *VarGridConfig) loadPopulation(sr *proj.SR, bounds *geom.Bounds) (*rtree.Rtree, map[string]int, error) {
-	x := filepath.Ext(config.CensusFile)
-	if x == ".shp" {
+	switch x := strings.ToLower(filepath.Ext(config.CensusFile)); x {
+	case ".shp":
 		return config.loadPopulationShapefile(sr, bounds)
-	} else if x == ".ncf" || x == ".nc" {
+	case ".ncf", ".nc":
 		return config.loadPopulationCOARDS(sr, bounds)
+	case ".tif", ".tiff":
+		return config.loadPopulationGeoTIFF(sr, bounds)
+	case ".csv", ".geojson", ".json":
+		return config.loadPopulationFeatures(sr, bounds)
+	default:
+		return nil, nil, fmt.Errorf("inmap: invalid CensusFile type %s; valid types are .shp, .nc, .ncf, .tif, .tiff, .csv, .geojson, and .json", x)
 	}
-	return nil, nil, fmt.Errorf("inmap: invalid CensusFile type %s; valid types are .shp, .nc and .ncf", x)
 }
 
 // loadPopulationShapefile loads population information from a shapefile, converting it
@@ -1101,7 +1639,41 @@ func s2f(s string) (float64, error) {
 	return f, err
 }
 
-func (config *VarGridConfig) loadMortality(sr *proj.SR) (*rtree.Rtree, map[string]int, error) {
+// loadMortality loads mortality rate information from a shapefile,
+// GeoTIFF file, or CSV or GeoJSON file (determined by file extension),
+// converting it to spatial reference sr. Data in GeoTIFF files that do not
+// overlap with bounds are discarded. The function outputs an index holding
+// the mortality rate information and a map giving the array index of each
+// mortality rate.
+func (config *VarGridConfig) loadMortality(sr *proj.SR, bounds *geom.Bounds) (*rtree.Rtree, map[string]int, error) {
+	switch x := strings.ToLower(filepath.Ext(config.MortalityRateFile)); x {
+	case ".shp":
+		return config.loadMortalityShapefile(sr)
+	case ".tif", ".tiff":
+		return config.loadMortalityGeoTIFF(sr, bounds)
+	case ".csv", ".geojson", ".json":
+		return config.loadMortalityFeatures(sr)
+	default:
+		return nil, nil, fmt.Errorf("inmap: invalid MortalityRateFile type %s; valid types are .shp, .tif, .tiff, .csv, .geojson, and .json", x)
+	}
+}
+
+// mortalityColumns returns the names of the mortality rate columns in
+// sorted order and a map giving the array index of each one.
+func (config *VarGridConfig) mortalityColumns() ([]string, map[string]int) {
+	mortIndices := make(map[string]int)
+	mortRateColumns := make([]string, 0, len(config.MortalityRateColumns))
+	for m := range config.MortalityRateColumns {
+		mortRateColumns = append(mortRateColumns, m)
+	}
+	sort.Strings(mortRateColumns)
+	for i, m := range mortRateColumns {
+		mortIndices[m] = i
+	}
+	return mortRateColumns, mortIndices
+}
+
+func (config *VarGridConfig) loadMortalityShapefile(sr *proj.SR) (*rtree.Rtree, map[string]int, error) {
 	mortshp, err := shp.NewDecoder(config.MortalityRateFile)
 	if err != nil {
 		return nil, nil, err
@@ -1116,27 +1688,14 @@ func (config *VarGridConfig) loadMortality(sr *proj.SR) (*rtree.Rtree, map[strin
 		return nil, nil, err
 	}
 
-	// Create a list of array indices for each mortality rate.
-	mortIndices := make(map[string]int)
-
-	// Extract mortality rate column names from map of population to mortality rates
-	mortRateColumns := make([]string, len(config.MortalityRateColumns))
-	i := 0
-	for m := range config.MortalityRateColumns {
-		mortRateColumns[i] = m
-		i++
-	}
-	sort.Strings(mortRateColumns)
-	for i, m := range mortRateColumns {
-		mortIndices[m] = i
-	}
+	mortRateColumns, mortIndices := config.mortalityColumns()
 	mortRates := rtree.NewTree(25, 50)
 	for {
 		g, fields, more := mortshp.DecodeRowFields(mortRateColumns...)
 		if !more {
 			break
 		}
-		m := new(mortality)
+		m := &mortality{weight: 1, region: mortRates.Size()}
 		m.MortData = make([]float64, len(mortRateColumns))
 		for i, mort := range mortRateColumns {
 			s, ok := fields[mort]
@@ -1170,9 +1729,34 @@ func (config *VarGridConfig) loadMortality(sr *proj.SR) (*rtree.Rtree, map[strin
 	return mortRates, mortIndices, nil
 }
 
+// groundLevelTotalPM25 returns the area-weighted average baseline total PM2.5
+// concentration in the ground-level CTM grid cells that overlap g.
+func (d *CTMData) groundLevelTotalPM25(g geom.Polygonal) float64 {
+	var sum, area float64
+	for _, cc := range d.gridTree.SearchIntersect(g.Bounds()) {
+		ccc := cc.(*gridCellLight)
+		if ccc.layer != 0 {
+			continue
+		}
+		isect := ccc.Intersection(g)
+		if isect == nil {
+			continue
+		}
+		a := isect.Area()
+		sum += d.Data["TotalPM25"].Data.Get(0, ccc.Row, ccc.Col) * a
+		area += a
+	}
+	if area == 0 {
+		return 0
+	}
+	return sum / area
+}
+
 // loadData allocates cell information from the CTM data to the Cell. If the
 // cell overlaps more than one CTM cells, weighted averaging is used.
-func (c *Cell) loadData(data *CTMData, k int) error {
+// If massWeightedPartitioning is true, gas/particle partitioning fractions
+// are weighted by mass as well as area.
+func (c *Cell) loadData(data *CTMData, k int, massWeightedPartitioning bool) error {
 	c.Layer = k
 	cellArea := c.Area()
 	ctmcellsAllLayers := data.gridTree.SearchIntersect(c.Bounds())
@@ -1285,6 +1869,9 @@ func (c *Cell) loadData(data *CTMData, k int) error {
 		c.CBaseline[ipOrg] += data.Data["aSOA"].Data.Get(
 			k, ctmrow, ctmcol) * frac
 	}
+	if massWeightedPartitioning {
+		c.massWeightPartitioning(data, k, ctmcells, fractions)
+	}
 	return nil
 }
 
@@ -20,6 +20,7 @@ package inmap
 
 import (
 	"flag"
+	"fmt"
 	"math"
 	"os"
 	"reflect"
@@ -868,6 +869,140 @@ func TestCombineCTMData(t *testing.T) {
 	compareCTMData(goldenData, combined, tolerance, t)
 }
 
+func TestCombineCTMDataBlend(t *testing.T) {
+	cfg := VarGridConfig{}
+	var nests []*CTMData
+	for _, file := range []string{
+		"cmd/inmap/testdata/inmapData_combine_outerNest.ncf",
+		"cmd/inmap/testdata/inmapData_combine_innerNest.ncf",
+	} {
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := cfg.LoadCTMData(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		nests = append(nests, d)
+	}
+
+	overlay, err := CombineCTMData(nests...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noBuffer, err := CombineCTMDataBlend(0, nests...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareCTMData(overlay, noBuffer, 0, t)
+
+	blended, err := CombineCTMDataBlend(2, nests...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p0, p := overlay.placement(nests[0]), overlay.placement(nests[1])
+	weight := p.blendWeight(overlay, 2)
+	for name, data := range overlay.Data {
+		if len(data.Dims) != 2 {
+			continue
+		}
+		for j := 0; j < overlay.ny; j++ {
+			for i := 0; i < overlay.nx; i++ {
+				have := blended.Data[name].Data.Get(j, i)
+				want := data.Data.Get(j, i)
+				if p.contains(j, i) {
+					// Reconstruct the blended value from the outer nest.
+					w := weight(j, i)
+					outer := nests[0].Data[name].Data.Get((j-p0.jo)/p0.yFac, (i-p0.io)/p0.xFac)
+					want = w*want + (1-w)*outer
+				}
+				if math.Abs(have-want) > 1.0e-10*math.Max(1, math.Abs(want)) {
+					t.Errorf("%s (%d, %d): have %g, want %g", name, j, i, have, want)
+				}
+			}
+		}
+	}
+
+	overlayJumps, err := NestBoundaryJumps(overlay, nests...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blendedJumps, err := NestBoundaryJumps(blended, nests...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Kzz", "UAvg", "SPartitioning"} {
+		o, b := overlayJumps.Data[name].Data.Max(), blendedJumps.Data[name].Data.Max()
+		if o == 0 {
+			t.Errorf("%s: there should be a jump at the nest boundary", name)
+		}
+		if b >= o {
+			t.Errorf("%s: blending should reduce the jump at the nest boundary: %g >= %g", name, b, o)
+		}
+	}
+
+	if _, err := CombineCTMDataBlend(-1, nests...); err == nil {
+		t.Error("a negative buffer should cause an error")
+	}
+}
+
+func TestMassWeightedPartitioning(t *testing.T) {
+	cfg, ctmdata, pop, popIndices, mr, mortIndices := VarGridTestData()
+	// The outermost grid cell covers all four CTM grid cells.
+	cfg.VariableGridXo, cfg.VariableGridYo = -12000, -12000
+	cfg.VariableGridDx, cfg.VariableGridDy = 24000, 24000
+	cfg.Xnests, cfg.Ynests = []int{1, 2}, []int{1, 2}
+
+	const k = 1
+	var part, massPart, mass, ws float64
+	for i := 0; i < 4; i++ {
+		mi := ctmdata.Data["gNH"].Data.Get(k, i/2, i%2) + ctmdata.Data["pNH"].Data.Get(k, i/2, i%2)
+		part += ctmdata.Data["NHPartitioning"].Data.Get(k, i/2, i%2) / 4
+		massPart += ctmdata.Data["NHPartitioning"].Data.Get(k, i/2, i%2) * mi
+		mass += mi
+		ws += ctmdata.Data["WindSpeedMinusOnePointFour"].Data.Get(k, i/2, i%2) / 4
+	}
+	massPart /= mass
+	if !different(part, massPart, 1.0e-10) {
+		t.Fatal("the test data should have different area- and mass-weighted partitioning")
+	}
+
+	for _, test := range []struct {
+		massWeighted bool
+		want         float64
+	}{
+		{massWeighted: false, want: part},
+		{massWeighted: true, want: massPart},
+	} {
+		t.Run(fmt.Sprint(test.massWeighted), func(t *testing.T) {
+			cfg.MassWeightedPartitioning = test.massWeighted
+			var m Mech
+			d := &InMAP{
+				InitFuncs: []DomainManipulator{
+					cfg.RegularGrid(ctmdata, pop, popIndices, mr, mortIndices, NewEmissions(), m),
+				},
+			}
+			if err := d.Init(); err != nil {
+				t.Fatal(err)
+			}
+			var cell *Cell
+			for _, c := range d.cells.array() {
+				if c.Layer == k {
+					cell = c
+				}
+			}
+			if different(cell.NHPartitioning, test.want, 1.0e-10) {
+				t.Errorf("NHPartitioning: have %g, want %g", cell.NHPartitioning, test.want)
+			}
+			if different(cell.WindSpeedMinusOnePointFour, ws, 1.0e-10) {
+				t.Errorf("WindSpeedMinusOnePointFour: have %g, want %g", cell.WindSpeedMinusOnePointFour, ws)
+			}
+		})
+	}
+}
+
 func different(a, b, tolerance float64) bool {
 	if 2*math.Abs(a-b)/math.Abs(a+b) > tolerance || math.IsNaN(a) || math.IsNaN(b) {
 		return true
@@ -922,3 +1057,119 @@ func TestLoadPopulationCOARDS(t *testing.T) {
 		t.Errorf("sum: %g != %g", popSum, wantSum)
 	}
 }
+
+func TestProjectionFiles(t *testing.T) {
+	projections := map[string]string{"2020": "a.shp", "2030": "b.shp", "2050": "c.shp"}
+	tests := []struct {
+		year    int
+		files   []string
+		weights []float64
+	}{
+		{year: 2020, files: []string{"a.shp"}, weights: []float64{1}},
+		{year: 2024, files: []string{"a.shp", "b.shp"}, weights: []float64{0.6, 0.4}},
+		{year: 2045, files: []string{"b.shp", "c.shp"}, weights: []float64{0.25, 0.75}},
+		{year: 2050, files: []string{"c.shp"}, weights: []float64{1}},
+	}
+	for _, test := range tests {
+		files, weights, err := projectionFiles("CensusProjections", "default.shp", projections, test.year)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(files, test.files) {
+			t.Errorf("%d: files: have %v, want %v", test.year, files, test.files)
+		}
+		if len(weights) != len(test.weights) {
+			t.Fatalf("%d: weights: have %v, want %v", test.year, weights, test.weights)
+		}
+		for i, w := range weights {
+			if different(w, test.weights[i], 1.e-10) {
+				t.Errorf("%d: weights: have %v, want %v", test.year, weights, test.weights)
+			}
+		}
+	}
+
+	for _, year := range []int{2019, 2051} {
+		if _, _, err := projectionFiles("CensusProjections", "default.shp", projections, year); err == nil {
+			t.Errorf("%d: years outside of the projection range should cause an error", year)
+		}
+	}
+	if _, _, err := projectionFiles("CensusProjections", "default.shp", map[string]string{"xxx": "a.shp"}, 2020); err == nil {
+		t.Error("invalid years should cause an error")
+	}
+	files, weights, err := projectionFiles("CensusProjections", "default.shp", nil, 2020)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(files, []string{"default.shp"}) || !reflect.DeepEqual(weights, []float64{1}) {
+		t.Errorf("empty projections: have %v and %v", files, weights)
+	}
+}
+
+func TestLoadPopMortYear(t *testing.T) {
+	cfg, ctmdata, pop, popIndices, mr, mortIndices := VarGridTestData()
+	WriteTestPopShapefile()
+	WriteTestMortalityShapefile()
+	defer func() {
+		for _, fname := range []string{TestPopulationShapefile, TestMortalityShapefile} {
+			DeleteShapefile(fname)
+		}
+	}()
+
+	m := Mech{}
+	d := &InMAP{
+		InitFuncs: []DomainManipulator{
+			cfg.RegularGrid(ctmdata, pop, popIndices, mr, mortIndices, NewEmissions(), m),
+		},
+	}
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+	cells := d.cells.array()
+	wantPop := make([][]float64, len(cells))
+	wantMort := make([][]float64, len(cells))
+	for i, c := range cells {
+		wantPop[i] = append([]float64{}, c.PopData...)
+		wantMort[i] = append([]float64{}, c.MortData...)
+	}
+
+	cfg.CensusProjections = map[string]string{"2020": TestPopulationShapefile, "2030": TestPopulationShapefile}
+	cfg.MortalityRateProjections = map[string]string{"2020": TestMortalityShapefile, "2030": TestMortalityShapefile}
+	popY, popIndicesY, mrY, mortIndicesY, err := cfg.LoadPopMortYear(2024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Double the 2030 mortality rates, so the 2024 mortality
+	// rates should be 1.4 times the original ones.
+	for _, mI := range mrY.tree.SearchIntersect(everywhere) {
+		mm := mI.(*mortality)
+		if mm.weight < 0.5 {
+			for i := range mm.MortData {
+				mm.MortData[i] *= 2
+			}
+		}
+	}
+
+	if err := cfg.SetPopMort(ctmdata, popY, popIndicesY, mrY, mortIndicesY)(d); err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range cells {
+		for j, v := range c.PopData {
+			if different(v, wantPop[i][j], 1.e-10) {
+				t.Errorf("cell %d population %d: have %g, want %g", i, j, v, wantPop[i][j])
+			}
+		}
+		for j, v := range c.MortData {
+			if different(v, wantMort[i][j]*1.4, 1.e-10) {
+				t.Errorf("cell %d mortality rate %d: have %g, want %g", i, j, v, wantMort[i][j]*1.4)
+			}
+		}
+	}
+
+	if _, _, _, _, err := cfg.LoadPopMortYear(2040); err == nil {
+		t.Error("years outside of the projection range should cause an error")
+	}
+	cfg.BaselineHR = "NasariACS"
+	if err := cfg.SetPopMort(nil, popY, popIndicesY, mrY, mortIndicesY)(d); err == nil {
+		t.Error("missing CTM data should cause an error when BaselineHR is set")
+	}
+}
@@ -49,6 +49,8 @@ type WRFChem struct {
 	recordDelta, fileDelta time.Duration
 
 	msgChan chan string
+
+	*ncfReader
 }
 
 // NewWRFChem initializes a WRF-Chem preprocessor from the given
@@ -112,8 +114,9 @@ func NewWRFChem(WRFOut, startDate, endDate string, msgChan chan string) (*WRFChe
 		// totalPM25 is total mass of PM2.5  [μg/m3].
 		totalPM25: map[string]float64{"PM2_5_DRY": 1.},
 
-		wrfOut:  WRFOut,
-		msgChan: msgChan,
+		wrfOut:    WRFOut,
+		msgChan:   msgChan,
+		ncfReader: newNCFReader(),
 	}
 
 	var err error
@@ -151,21 +154,21 @@ func ppmvToUgKg(mw float64) float64 {
 }
 
 func (w *WRFChem) read(varName string) NextData {
-	return nextDataNCF(w.wrfOut, wrfFormat, varName, w.start, w.end, w.recordDelta, w.fileDelta, readNCF, w.msgChan)
+	return w.nextDataNCF(w.wrfOut, wrfFormat, varName, w.start, w.end, w.recordDelta, w.fileDelta, readNCF, w.msgChan)
 }
 
 func (w *WRFChem) readGroupAlt(varGroup map[string]float64) NextData {
-	return nextDataGroupAltNCF(w.wrfOut, wrfFormat, varGroup, w.ALT(), w.start, w.end, w.recordDelta, w.fileDelta, readNCF, w.msgChan)
+	return w.nextDataGroupAltNCF(w.wrfOut, wrfFormat, varGroup, w.ALT(), w.start, w.end, w.recordDelta, w.fileDelta, readNCF, w.msgChan)
 }
 
 func (w *WRFChem) readGroup(varGroup map[string]float64) NextData {
-	return nextDataGroupNCF(w.wrfOut, wrfFormat, varGroup, w.start, w.end, w.recordDelta, w.fileDelta, readNCF, w.msgChan)
+	return w.nextDataGroupNCF(w.wrfOut, wrfFormat, varGroup, w.start, w.end, w.recordDelta, w.fileDelta, readNCF, w.msgChan)
 }
 
 // Nx helps fulfill the Preprocessor interface by returning
 // the number of grid cells in the West-East direction.
 func (w *WRFChem) Nx() (int, error) {
-	f, ff, err := ncfFromTemplate(w.wrfOut, wrfFormat, w.start)
+	f, ff, err := w.ncfFromTemplate(w.wrfOut, wrfFormat, w.start)
 	if err != nil {
 		return -1, fmt.Errorf("nx: %v", err)
 	}
@@ -176,7 +179,7 @@ func (w *WRFChem) Nx() (int, error) {
 // Ny helps fulfill the Preprocessor interface by returning
 // the number of grid cells in the South-North direction.
 func (w *WRFChem) Ny() (int, error) {
-	f, ff, err := ncfFromTemplate(w.wrfOut, wrfFormat, w.start)
+	f, ff, err := w.ncfFromTemplate(w.wrfOut, wrfFormat, w.start)
 	if err != nil {
 		return -1, fmt.Errorf("ny: %v", err)
 	}
@@ -187,7 +190,7 @@ func (w *WRFChem) Ny() (int, error) {
 // Nz helps fulfill the Preprocessor interface by returning
 // the number of grid cells in the below-above direction.
 func (w *WRFChem) Nz() (int, error) {
-	f, ff, err := ncfFromTemplate(w.wrfOut, wrfFormat, w.start)
+	f, ff, err := w.ncfFromTemplate(w.wrfOut, wrfFormat, w.start)
 	if err != nil {
 		return -1, fmt.Errorf("nz: %v", err)
 	}